@@ -0,0 +1,391 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+func init() {
+	register(&command{
+		name:  "analyze-rejection",
+		args:  "<game.sav>",
+		short: "list likely reasons the game refuses to load a save",
+		long: `
+Analyze-rejection runs every check mmse knows on a save file and lists the
+findings that could make the game refuse to load it, the most likely first:
+
+	header      the magic number and the version number
+	sizes       the size table against the length of the file
+	frames      decoding the frames
+	json        the validity of the documents
+	layout      padding, trailing bytes, and keys repeated within an object
+	schema      fields unknown to the catalog of the game version, and values
+	            of a type other than documented
+	references  IDs referenced by keys such as teamID that no entity defines
+	values      values beyond the range, or not among the values, documented
+
+Each finding has a score from 0 to 100, a rough likelihood that it is the
+reason. A damaged header or frame all but certainly is; trailing bytes rarely
+are. References are found by the naming of keys, after id, ID, Id, or the
+id_keys of the configuration file, and may include false alarms. The schema
+check needs a field catalog, and the value checks cover the documented fields;
+see "mmse help catalog" and "mmse help fields".
+
+The game may refuse a save for reasons no check covers. Analyze-rejection
+exits with status 1 when a finding scores 50 or more.`,
+		example: `
+mmse analyze-rejection game.sav
+mmse analyze-rejection -output json game.sav`,
+		flags: func(fs *flag.FlagSet) {
+			flagGameVersion(fs)
+			flagSaveDir(fs)
+			flagOutput(fs, outputTable)
+		},
+		nargs: exactly(1),
+		run:   runAnalyze,
+	})
+}
+
+// likely is the score from which a finding is a likely reason for the game to
+// refuse a save.
+const likely = 50
+
+// finding is a possible reason for the game to refuse a save.
+type finding struct {
+	Score int    `json:"score"`
+	Check string `json:"check"`
+	Msg   string `json:"finding"`
+}
+
+// analysis collects the findings about a save.
+type analysis struct {
+	findings []finding
+}
+
+// add adds a finding.
+func (a *analysis) add(score int, check, format string, v ...interface{}) {
+	a.findings = append(a.findings, finding{score, check, fmt.Sprintf(format, v...)})
+}
+
+// header checks the header and the size table of a save.
+func (a *analysis) header(b []byte) {
+	if len(b) < 8 {
+		a.add(100, "header", "the file is %d bytes long, too short for a save", len(b))
+		return
+	}
+
+	if m := mmse.DecodeLE[int32](b); m != mmse.Magic {
+		a.add(100, "header", "the magic number is %#x, expecting %#x", uint32(m), uint32(mmse.Magic))
+		return
+	}
+
+	v := mmse.DecodeLE[int32](b[4:])
+
+	if v != mmse.Ver {
+		a.add(60, "header", "the version number is %d, expecting %d", v, mmse.Ver)
+	}
+
+	for i := 0; i < mmse.FrameCount(v); i++ {
+		at := 8 + 8*i
+
+		if len(b) < at+8 {
+			break
+		}
+
+		com := mmse.DecodeLE[int32](b[at:])
+		raw := mmse.DecodeLE[int32](b[at+4:])
+
+		if com < 0 || raw < 0 {
+			a.add(95, "sizes", "the %s has negative sizes: %d encoded, %d decoded",
+				mmse.FrameRegion(i), com, raw)
+		}
+	}
+
+	for _, r := range mmse.Layout(b) {
+		if r.Missing > 0 {
+			a.add(95, "sizes", "the %s region is truncated, %d of %d bytes missing",
+				r.Name, r.Missing, r.Length+r.Missing)
+		}
+	}
+}
+
+// layout turns the warnings about the layout of a save into findings.
+func (a *analysis) layout(s *mmse.SaveFile) {
+	for _, w := range s.Warnings {
+		switch w.Region {
+		case "version":
+			// Reported by header.
+		case "trailing":
+			a.add(20, "layout", "%s", w)
+		case "padding":
+			a.add(10, "layout", "%s", w)
+		default:
+			a.add(30, "layout", "%s", w)
+		}
+	}
+}
+
+// fieldStats counts the values of a field failing a check, with an example.
+type fieldStats struct {
+	n       int
+	example string
+}
+
+// fieldChecks collects the values failing a check by field.
+type fieldChecks map[string]*fieldStats
+
+// add counts a failing value of a field.
+func (c fieldChecks) add(field, example string) {
+	if c[field] == nil {
+		c[field] = &fieldStats{example: example}
+	}
+
+	c[field].n++
+}
+
+// report adds a finding for every field with failing values.
+func (c fieldChecks) report(a *analysis, score int, check, what string) {
+	fs := make([]string, 0, len(c))
+
+	for f := range c {
+		fs = append(fs, f)
+	}
+
+	sort.Strings(fs)
+
+	for _, f := range fs {
+		a.add(score, check, "%s: values %s, such as %s, %d in all", f, what, c[f].example, c[f].n)
+	}
+}
+
+// documents checks the content of the documents of a save against the field
+// catalog, the field documentation, and the IDs defined in the save.
+func (a *analysis) documents(info, data []byte) {
+	u, v, err := unknownFields(info, data)
+
+	switch {
+	case err != nil:
+		a.add(90, "json", "%s", err)
+		return
+	case len(u) > 3:
+		a.add(50, "schema", "%d fields unknown to game version %s, such as %s",
+			len(u), v, strings.Join(u[:3], ", "))
+	case len(u) > 0:
+		a.add(50, "schema", "fields unknown to game version %s: %s", v, strings.Join(u, ", "))
+	}
+
+	byPath := make(map[string]field)
+
+	for _, f := range readFields() {
+		byPath[f.Path] = f
+	}
+
+	keys := idKeys()
+
+	var (
+		types, ranges = make(fieldChecks), make(fieldChecks)
+		enums         = make(fieldChecks)
+		defs          = make(map[string]bool)
+		refs          = make(map[string][]string)
+	)
+
+	for i, doc := range [][]byte{info, data} {
+		name := []string{"info", "data"}[i]
+
+		err := jsonpath.Walk(bytes.NewReader(doc), func(p jsonpath.Path, t json.Token) error {
+			if len(p) == 0 {
+				return nil
+			}
+
+			pat := p.Pattern()
+
+			if pat[0] != '[' {
+				pat = "." + pat
+			}
+
+			pat = name + pat
+			text := fmt.Sprint(t)
+
+			if f, ok := byPath[pat]; ok {
+				if typ := jsonType(t); f.Type != "" && typ != "null" && typ != f.Type {
+					types.add(pat, typ)
+				} else if n, err := strconv.ParseFloat(text, 64); err == nil && typ == "number" &&
+					(f.Min != nil && n < *f.Min || f.Max != nil && n > *f.Max) {
+					ranges.add(pat, text)
+				} else if raw, _ := json.Marshal(t); typ != "object" && typ != "array" &&
+					typ != "null" && !f.allows(raw) {
+					enums.add(pat, text)
+				}
+			}
+
+			switch t.(type) {
+			case string, json.Number:
+			default:
+				return nil
+			}
+
+			switch l := p[len(p)-1]; {
+			case l.IsIndex:
+				// An element of an array of references.
+				if len(p) > 1 && !p[len(p)-2].IsIndex && isRefKey(p[len(p)-2].Key, keys) {
+					refs[pat[:len(pat)-2]] = append(refs[pat[:len(pat)-2]], text)
+				}
+			case keys[l.Key]:
+				defs[text] = true
+			case isRefKey(l.Key, keys):
+				refs[pat] = append(refs[pat], text)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			a.add(90, "json", "the %s document: %s", name, err)
+			return
+		}
+	}
+
+	types.report(a, 70, "schema", "of another type than documented")
+	ranges.report(a, 80, "values", "beyond the documented range")
+	enums.report(a, 80, "values", "not among the documented values")
+
+	dangling := make(fieldChecks)
+
+	for pat, vs := range refs {
+		for _, v := range vs {
+			if !defs[v] && v != "" && v != "0" && v != "-1" {
+				dangling.add(pat, v)
+			}
+		}
+	}
+
+	dangling.report(a, 45, "references", "matching no entity ID")
+}
+
+// idKeys returns the keys holding the IDs of entities.
+func idKeys() map[string]bool {
+	keys := map[string]bool{"id": true, "ID": true, "Id": true}
+
+	if len(cfg.IDKeys) > 0 {
+		keys = make(map[string]bool)
+
+		for _, k := range cfg.IDKeys {
+			keys[k] = true
+		}
+	}
+
+	return keys
+}
+
+// isRefKey reports whether a key names a reference to an entity, such as
+// teamID, teamId, or team_id for the ID key id, or references, such as
+// sponsorIds.
+func isRefKey(k string, keys map[string]bool) bool {
+	k = strings.TrimSuffix(k, "s")
+
+	for id := range keys {
+		if id == "" || k == id {
+			continue
+		}
+
+		if strings.HasSuffix(k, strings.ToUpper(id[:1])+id[1:]) || strings.HasSuffix(k, "_"+id) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runAnalyze runs the analyze-rejection command.
+func runAnalyze(args []string) {
+	fn := findSave(args[0])
+
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	a := new(analysis)
+
+	a.header(b)
+
+	s, err := mmse.ReadSaveFile(bytes.NewReader(b))
+
+	var sizeErr *mmse.SizeMismatchError
+
+	switch {
+	case errors.Is(err, mmse.ErrBadMagic) || errors.As(err, &sizeErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		// Reported by header.
+	case err != nil:
+		a.add(95, "frames", "%s", err)
+	default:
+		valid := true
+
+		for i, f := range []*mmse.Frame{s.Info, s.Data} {
+			if !json.Valid(f.Bytes()) {
+				a.add(90, "json", "the %s is not valid JSON", mmse.FrameRegion(i))
+				valid = false
+			}
+		}
+
+		if valid {
+			if err := s.CheckKeys(); err != nil {
+				a.add(90, "json", "%s", err)
+			}
+
+			a.documents(s.Info.Bytes(), s.Data.Bytes())
+		}
+
+		a.layout(s)
+	}
+
+	sort.SliceStable(a.findings, func(i, j int) bool {
+		return a.findings[i].Score > a.findings[j].Score
+	})
+
+	if len(a.findings) == 0 && outputFormat == outputTable {
+		fmt.Printf("%s: no likely reasons found\n", fn)
+		return
+	}
+
+	l := &listing{cols: []string{"score", "check", "finding"}, right: map[string]bool{"score": true}}
+
+	for _, f := range a.findings {
+		l.addRecord(f, strconv.Itoa(f.Score), f.Check, f.Msg)
+	}
+
+	l.write()
+
+	if len(a.findings) > 0 && a.findings[0].Score >= likely {
+		fail()
+	}
+}
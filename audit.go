@@ -0,0 +1,216 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// auditCommand is the command recorded in the audit log.
+	auditCommand string
+	// auditSave, auditOf, and auditSince filter the records listed by audit.
+	auditSave, auditOf, auditSince string
+)
+
+func init() {
+	register(&command{
+		name:  "audit",
+		short: "list the changes recorded in the audit log",
+		long: `
+With audit: true in the configuration file, every command that writes a save,
+such as set, rebrand, replay, pack, session commit, backup restore, lock,
+unlock, dedupe -delete or -link, and cloud update, appends a record to
+audit.jsonl next to the configuration file. A record is a line of JSON holding
+the time, the command, the path of the save, the SHA-256 of the save before
+and after the change, and the operations performed, such as
+"set data.teams[0].budget 250000000". A deleted save has no hash after.
+
+Audit lists the records, oldest first. With -save, only the records of a
+save are listed; with -command, those of a command; with -since, those newer
+than an age such as 12h or 30d. The hashes tell whether a save was changed
+by another program between two records.`,
+		example: `
+mmse audit
+mmse audit -save game.sav -since 7d
+mmse audit -output json -command replay`,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&auditSave, "save", "", "list the records of `save`")
+			fs.StringVar(&auditOf, "command", "", "list the records of `command`")
+			fs.StringVar(&auditSince, "since", "", "list the records newer than `age`")
+			flagSaveDir(fs)
+			flagOutput(fs, outputTable)
+		},
+		nargs: exactly(0),
+		run:   runAudit,
+	})
+}
+
+// auditRecord is a record of the audit log.
+type auditRecord struct {
+	Time    time.Time `json:"time"`
+	Command string    `json:"command,omitempty"`
+	Save    string    `json:"save"`
+	Before  string    `json:"before,omitempty"`
+	After   string    `json:"after"`
+	Ops     []string  `json:"operations,omitempty"`
+}
+
+// auditPath returns the path of the audit log.
+func auditPath() string {
+	return filepath.Join(filepath.Dir(cfgPath), "audit.jsonl")
+}
+
+// hashBytes returns the SHA-256 of b in hex.
+func hashBytes(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// audit appends a record of a change to a save to the audit log when auditing
+// is on. before and after are the hashes of the save.
+func audit(fn, before, after string, ops []string) {
+	if !cfg.Audit {
+		return
+	}
+
+	if abs, err := filepath.Abs(fn); err == nil {
+		fn = abs
+	}
+
+	b, err := json.Marshal(auditRecord{time.Now(), auditCommand, fn, before, after, ops})
+	if err != nil {
+		log.Panicf("Unable to write the audit log: %s", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(auditPath()), 0755); err != nil {
+		log.Panicf("Unable to write the audit log: %s", err)
+	}
+
+	f, err := os.OpenFile(auditPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Panicf("Unable to write the audit log: %s", err)
+	}
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		log.Panicf("Unable to write the audit log: %s", err)
+	}
+
+	if err := f.Close(); err != nil {
+		log.Panicf("Unable to write the audit log: %s", err)
+	}
+}
+
+// shortHash shortens a hash for tables.
+func shortHash(h string) string {
+	if len(h) > 12 {
+		return h[:12]
+	}
+
+	return h
+}
+
+// runAudit runs the audit command.
+func runAudit([]string) {
+	var since time.Time
+
+	if auditSince != "" {
+		age, err := parseAge(auditSince)
+		if err != nil {
+			log.Panicf("Invalid age: %s", err)
+		}
+
+		since = time.Now().Add(-age)
+	}
+
+	save := ""
+
+	if auditSave != "" {
+		abs, err := filepath.Abs(findSave(auditSave))
+		if err != nil {
+			log.Panicf("%s", err)
+		}
+
+		save = abs
+	}
+
+	rs := readAudit()
+	l := &listing{cols: []string{"time", "command", "save", "before", "after", "operations"}}
+
+	for _, r := range rs {
+		if save != "" && r.Save != save || auditOf != "" && r.Command != auditOf ||
+			r.Time.Before(since) {
+			continue
+		}
+
+		l.addRecord(
+			r, r.Time.Format("2006-01-02 15:04:05"), r.Command, r.Save,
+			shortHash(r.Before), shortHash(r.After), strings.Join(r.Ops, "; "),
+		)
+	}
+
+	if l.n == 0 && outputFormat == outputTable {
+		fmt.Printf("No changes are recorded in %s\n", auditPath())
+		return
+	}
+
+	l.write()
+}
+
+// readAudit reads the records of the audit log. A missing log has no records.
+func readAudit() []auditRecord {
+	f, err := os.Open(auditPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		log.Panicf("Unable to open the audit log: %s", err)
+	}
+
+	defer f.Close()
+
+	var rs []auditRecord
+
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<24)
+
+	for n := 1; s.Scan(); n++ {
+		var r auditRecord
+
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			log.Panicf("%s:%d: %s", auditPath(), n, err)
+		}
+
+		rs = append(rs, r)
+	}
+
+	if err := s.Err(); err != nil {
+		log.Panicf("Unable to read the audit log: %s", err)
+	}
+
+	return rs
+}
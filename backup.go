@@ -0,0 +1,390 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stampLayout is the layout of the time stamps in backup names.
+const stampLayout = "20060102-150405"
+
+func init() {
+	register(&command{
+		name:  "backup",
+		args:  "list|create|restore|prune <game.sav> [n|label]",
+		short: "manage the backups of a save file",
+		long: `
+With the backup policy store, saves are copied to the backup store before they
+are overwritten. The store keeps compressed, time stamped copies of each save
+in a directory under backup_dir, by default the backups directory next to the
+configuration file. The directory is named after the save and a checksum of
+its path, such as game-1f2e3d4c, so saves of the same name in different
+directories keep separate backups.
+
+List prints the backups of a save, newest first, including .bak files left next
+to the save by the bak and timestamp policies. Create adds a backup of the save
+to the store. Restore copies backup n of the list, the newest by default, over
+the save, after storing the current save so that the restore can be undone;
+given a label instead of n, restore copies the backup recorded by the label;
+see "mmse help tag".
+Prune applies the retention policy to the store of the save.
+
+After every new backup, the store of the save is pruned to the newest
+backup_keep backups and to backups younger than backup_max_age, such as 720h
+or 30d. Either limit is off when unset. Pruning never removes .bak files or
+backups recorded by labels.`,
+		example: `
+mmse backup list game.sav
+mmse backup restore game.sav 2
+mmse backup restore game.sav before-engine-cheat`,
+		flags: func(fs *flag.FlagSet) {
+			flagSaveDir(fs)
+			flagForce(fs)
+			flagOutput(fs, outputTable)
+		},
+		nargs: func(n int) bool { return n == 2 || n == 3 },
+		run:   runBackup,
+	})
+}
+
+// backupDir returns the store directory of a save, named after the save and a
+// checksum of its absolute path, so that saves of the same name in different
+// directories keep their backups apart.
+func backupDir(fn string) string {
+	d := cfg.BackupDir
+
+	if d == "" {
+		d = filepath.Join(filepath.Dir(cfgPath), "backups")
+	}
+
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		abs = fn
+	}
+
+	return filepath.Join(d, split(filepath.Base(fn))+"-"+hashBytes([]byte(abs))[:8])
+}
+
+// maxAge returns the maximum age of stored backups, or 0.
+func maxAge() (time.Duration, error) {
+	if cfg.BackupMaxAge == "" {
+		return 0, nil
+	}
+
+	return parseAge(cfg.BackupMaxAge)
+}
+
+// parseAge parses an age given in days, such as 30d, or as a duration, such
+// as 12h.
+func parseAge(a string) (time.Duration, error) {
+	if strings.HasSuffix(a, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(a, "d"))
+		if err != nil {
+			return 0, err
+		}
+
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(a)
+}
+
+// storeBackup adds a compressed copy of a save to the store and prunes the
+// store.
+func storeBackup(fn string) string {
+	d := backupDir(fn)
+
+	if err := os.MkdirAll(d, 0755); err != nil {
+		log.Panicf("Unable to create %s: %s", d, err)
+	}
+
+	stamp := time.Now().Format(stampLayout)
+	dst := filepath.Join(d, stamp+".sav.gz")
+
+	for i := 2; fileExists(dst); i++ {
+		dst = filepath.Join(d, fmt.Sprintf("%s-%d.sav.gz", stamp, i))
+	}
+
+	if err := gzipFile(dst, fn); err != nil {
+		os.Remove(dst)
+		log.Panicf("Unable to back up %s: %s", fn, err)
+	}
+
+	pruneBackups(fn)
+
+	return dst
+}
+
+// gzipFile writes a compressed copy of src to dst.
+func gzipFile(dst, src string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	z := gzip.NewWriter(w)
+	z.Name = filepath.Base(src)
+
+	if _, err := io.Copy(z, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	if err := z.Close(); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// backupEntry is a backup of a save.
+type backupEntry struct {
+	path   string
+	time   time.Time
+	size   int64
+	stored bool
+}
+
+// backups returns the backups of a save, newest first.
+func backups(fn string) []backupEntry {
+	var es []backupEntry
+
+	stored, _ := filepath.Glob(filepath.Join(backupDir(fn), "*.sav.gz"))
+	adhoc, _ := filepath.Glob(fn + ".*bak")
+
+	for _, p := range append(stored, adhoc...) {
+		st, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+
+		es = append(es, backupEntry{
+			path:   p,
+			time:   st.ModTime(),
+			size:   st.Size(),
+			stored: strings.HasSuffix(p, ".sav.gz"),
+		})
+	}
+
+	sort.SliceStable(es, func(i, j int) bool { return es[i].time.After(es[j].time) })
+
+	return es
+}
+
+// pruneBackups removes stored backups of a save beyond the retention policy.
+func pruneBackups(fn string) int {
+	age, _ := maxAge()
+	now := time.Now()
+	tagged := taggedBackups(fn)
+
+	n, removed := 0, 0
+
+	for _, e := range backups(fn) {
+		if !e.stored || tagged[e.path] {
+			continue
+		}
+
+		n++
+
+		if cfg.BackupKeep > 0 && n > cfg.BackupKeep || age > 0 && now.Sub(e.time) > age {
+			if err := os.Remove(e.path); err != nil {
+				log.Printf("Warning: unable to remove %s: %s", e.path, err)
+				continue
+			}
+
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// restoreBackup copies a backup over a save.
+func restoreBackup(fn string, e backupEntry) error {
+	if !e.stored {
+		return copyFile(fn, e.path)
+	}
+
+	r, err := os.Open(e.path)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	z, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	tmp := fn + ".tmp"
+
+	w, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, z); err != nil {
+		w.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, fn)
+}
+
+// taggedBackup returns the number in the list of backups of the backup
+// recorded by a label of a save.
+func taggedBackup(fn, label string, es []backupEntry) int {
+	t, ok := findTag(fn, label)
+	if !ok {
+		log.Panicf("%s has no backup %s and no label %s", fn, label, label)
+	}
+
+	for i, e := range es {
+		if e.path == t.Backup {
+			return i + 1
+		}
+	}
+
+	log.Panicf("The backup %s of label %s is missing", t.Backup, label)
+
+	return 0
+}
+
+// runBackup runs the backup command.
+func runBackup(args []string) {
+	fn := findSave(args[1])
+
+	// A deleted save is restored to the save directory.
+	if !fileExists(fn) {
+		fn = savePath(args[1])
+	}
+
+	switch args[0] {
+	case "list":
+		es := backups(fn)
+
+		if len(es) == 0 && outputFormat == outputTable {
+			fmt.Printf("%s has no backups\n", fn)
+			return
+		}
+
+		type record struct {
+			N      int       `json:"n"`
+			Time   time.Time `json:"time"`
+			Size   int64     `json:"size"`
+			Path   string    `json:"path"`
+			Stored bool      `json:"stored"`
+		}
+
+		l := &listing{
+			cols:  []string{"n", "time", "size", "path"},
+			right: map[string]bool{"n": true, "size": true},
+		}
+
+		for i, e := range es {
+			l.addRecord(
+				record{i + 1, e.time, e.size, e.path, e.stored},
+				strconv.Itoa(i+1), e.time.Format("2006-01-02 15:04:05"),
+				strconv.FormatInt(e.size, 10), e.path,
+			)
+		}
+
+		l.write()
+	case "create":
+		if !fileExists(fn) {
+			log.Panicf("%s does not exist", fn)
+		}
+
+		fmt.Printf("Stored %s\n", storeBackup(fn))
+	case "restore":
+		es := backups(fn)
+
+		n := 1
+
+		if len(args) > 2 {
+			var err error
+
+			if n, err = strconv.Atoi(args[2]); err != nil {
+				n = taggedBackup(fn, args[2], es)
+			}
+		}
+
+		if n < 1 || n > len(es) {
+			log.Panicf("%s has no backup %d; see mmse backup list", fn, n)
+		}
+
+		checkInUse(fn)
+
+		before, _ := hashFile(fn)
+
+		// Restore next to the save first, since storing the current save
+		// prunes the store and may remove the backup being restored.
+		tmp := fn + ".restore"
+
+		if err := restoreBackup(tmp, es[n-1]); err != nil {
+			os.Remove(tmp)
+			log.Panicf("Unable to restore %s: %s", es[n-1].path, err)
+		}
+
+		if fileExists(fn) {
+			if !try(func() { fmt.Printf("Stored the current save as %s\n", storeBackup(fn)) }) {
+				os.Remove(tmp)
+				log.Panicf("Unable to restore %s: the current save could not be stored", fn)
+			}
+		}
+
+		if err := os.Rename(tmp, fn); err != nil {
+			os.Remove(tmp)
+			log.Panicf("Unable to restore %s: %s", es[n-1].path, err)
+		}
+
+		after, _ := hashFile(fn)
+		audit(fn, before, after, []string{"restore " + es[n-1].path})
+
+		fmt.Printf("Restored %s from %s\n", fn, es[n-1].path)
+	case "prune":
+		fmt.Printf("Removed %d backups of %s\n", pruneBackups(fn), fn)
+	default:
+		log.Panicf("Unknown backup action: %s", args[0])
+	}
+}
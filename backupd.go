@@ -0,0 +1,309 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// backupdSettle is how long a save must be left alone before backupd copies
+// it, so that saves being written are not copied.
+const backupdSettle = 10 * time.Second
+
+// backupdName names the service of backupd.
+const backupdName = "mmse-backupd"
+
+var (
+	// backupdInterval is how often backupd snapshots the saves.
+	backupdInterval time.Duration
+	// backupdRemove removes the service instead of installing it.
+	backupdRemove bool
+)
+
+func init() {
+	register(&command{
+		name:  "backupd",
+		args:  "[install] [savedir]",
+		short: "back up the saves of a directory on a schedule",
+		long: `
+Backupd copies every save of a directory, the save directory by default, to
+the backup store every -interval, skipping saves unchanged since their newest
+backup and saves written in the last few seconds. The store is the one of
+"mmse help backup": backup_dir, or -dest, and its retention policy,
+backup_keep and backup_max_age, apply. Backupd runs until it is stopped.
+
+Install sets backupd up to start with the session of the user: as a systemd
+user service on Linux, named mmse-backupd, and as a scheduled task run at
+logon on Windows. The service runs this mmse with the configuration file,
+interval, destination, and save directory given to install. With -remove,
+install stops and removes the service.`,
+		example: `
+mmse backupd -interval 30m -dest ~/mmse-backups
+mmse backupd -interval 1h install ~/saves
+mmse backupd -remove install`,
+		flags: func(fs *flag.FlagSet) {
+			fs.DurationVar(&backupdInterval, "interval", 30*time.Minute, "how often to back up the saves")
+			fs.StringVar(&cfg.BackupDir, "dest", cfg.BackupDir, "back up to the store in `dir`")
+			fs.BoolVar(&backupdRemove, "remove", false, "with install, remove the service")
+			flagSaveDir(fs)
+		},
+		nargs: atMost(2),
+		run:   runBackupd,
+	})
+}
+
+// gzipHash returns the SHA-256 checksum of the content of a compressed file
+// in hexadecimal.
+func gzipHash(fn string) (string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+
+	if _, err := io.Copy(h, z); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// snapshot backs up the changed saves of a directory. hashes caches the
+// checksums of stored backups by path.
+func snapshot(dir string, hashes map[string]string) {
+	for _, fn := range saveFiles(dir) {
+		st, err := os.Stat(fn)
+		if err != nil || time.Since(st.ModTime()) < backupdSettle {
+			continue
+		}
+
+		h, err := hashFile(fn)
+		if err != nil {
+			log.Printf("Warning: unable to read %s: %s", fn, err)
+			continue
+		}
+
+		for _, e := range backups(fn) {
+			if !e.stored {
+				continue
+			}
+
+			if _, ok := hashes[e.path]; !ok {
+				if hashes[e.path], err = gzipHash(e.path); err != nil {
+					log.Printf("Warning: unable to read %s: %s", e.path, err)
+				}
+			}
+
+			if hashes[e.path] == h {
+				h = ""
+			}
+
+			// Only the newest stored backup is compared.
+			break
+		}
+
+		if h == "" {
+			continue
+		}
+
+		ok := try(func() {
+			p := storeBackup(fn)
+			hashes[p] = h
+
+			log.Printf("Stored %s as %s", fn, p)
+		})
+
+		if !ok {
+			log.Printf("Warning: skipping %s until the next backup", fn)
+		}
+	}
+}
+
+// unitQuote quotes an argument of a systemd command line, escaping the
+// specifiers and environment variables that systemd expands.
+func unitQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(s)
+
+	return `"` + s + `"`
+}
+
+// installBackupd installs or removes the service running backupd with args.
+func installBackupd(args []string) {
+	run := func(name string, args ...string) {
+		if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+			log.Panicf("%s %s: %s: %s", name, args[0], err, out)
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Panicf("Unable to locate mmse: %s", err)
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		d, err := os.UserConfigDir()
+		if err != nil {
+			log.Panicf("%s", err)
+		}
+
+		unit := filepath.Join(d, "systemd", "user", backupdName+".service")
+
+		if backupdRemove {
+			run("systemctl", "--user", "disable", "--now", backupdName+".service")
+
+			if err := os.Remove(unit); err != nil {
+				log.Panicf("Unable to remove %s: %s", unit, err)
+			}
+
+			run("systemctl", "--user", "daemon-reload")
+			fmt.Printf("Removed %s\n", unit)
+
+			return
+		}
+
+		cmd := []string{unitQuote(exe)}
+
+		for _, a := range args {
+			cmd = append(cmd, unitQuote(a))
+		}
+
+		content := fmt.Sprintf(`[Unit]
+Description=Backups of Motorsport Manager saves
+
+[Service]
+ExecStart=%s
+Restart=on-failure
+
+[Install]
+WantedBy=default.target
+`, strings.Join(cmd, " "))
+
+		if err := os.MkdirAll(filepath.Dir(unit), 0755); err != nil {
+			log.Panicf("Unable to create %s: %s", filepath.Dir(unit), err)
+		}
+
+		if err := os.WriteFile(unit, []byte(content), 0644); err != nil {
+			log.Panicf("Unable to write %s: %s", unit, err)
+		}
+
+		run("systemctl", "--user", "daemon-reload")
+		run("systemctl", "--user", "enable", "--now", backupdName+".service")
+
+		fmt.Printf("Installed and started %s\n", unit)
+	case "windows":
+		if backupdRemove {
+			run("schtasks", "/Delete", "/F", "/TN", backupdName)
+			fmt.Printf("Removed the scheduled task %s\n", backupdName)
+
+			return
+		}
+
+		cmd := taskCommand(append([]string{exe}, args...))
+
+		run("schtasks", "/Create", "/F", "/SC", "ONLOGON", "/TN", backupdName, "/TR", cmd)
+		run("schtasks", "/Run", "/TN", backupdName)
+
+		fmt.Printf("Installed and started the scheduled task %s\n", backupdName)
+	default:
+		log.Panicf("Install is only available on Linux and Windows; start mmse backupd at login instead")
+	}
+}
+
+// runBackupd runs the backupd command.
+func runBackupd(args []string) {
+	install := len(args) > 0 && args[0] == "install"
+
+	if install {
+		args = args[1:]
+	} else if len(args) > 1 {
+		log.Panicf("Unknown backupd action: %s", args[0])
+	}
+
+	dir := cfg.SaveDir
+
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	if install && backupdRemove {
+		installBackupd(nil)
+		return
+	}
+
+	if dir == "" {
+		log.Panicf("No save directory given")
+	}
+
+	if backupdInterval <= 0 {
+		log.Panicf("Invalid interval: %s", backupdInterval)
+	}
+
+	if install {
+		abs := func(p string) string {
+			a, err := filepath.Abs(p)
+			if err != nil {
+				log.Panicf("%s", err)
+			}
+
+			return a
+		}
+
+		a := []string{"backupd", "-interval", backupdInterval.String()}
+
+		if cfgPath != "" {
+			a = append(a, "-config", abs(cfgPath))
+		}
+
+		if cfg.BackupDir != "" {
+			a = append(a, "-dest", abs(cfg.BackupDir))
+		}
+
+		installBackupd(append(a, abs(dir)))
+
+		return
+	}
+
+	log.Printf("Backing up %s every %s", dir, backupdInterval)
+
+	hashes := make(map[string]string)
+
+	for {
+		snapshot(dir, hashes)
+		time.Sleep(backupdInterval)
+	}
+}
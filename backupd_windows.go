@@ -0,0 +1,37 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"strings"
+	"syscall"
+)
+
+// taskCommand returns the command line of a scheduled task running args,
+// quoted as programs split their command line.
+func taskCommand(args []string) string {
+	q := make([]string, len(args))
+
+	for i, a := range args {
+		q[i] = syscall.EscapeArg(a)
+	}
+
+	return strings.Join(q, " ")
+}
@@ -0,0 +1,292 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+)
+
+func init() {
+	register(&command{
+		name:  "baseline",
+		args:  "list | capture <name> <game.sav> | diff <name> <game.sav>",
+		short: "compare the structure of saves against a baseline",
+		long: `
+A baseline records the structure of a save: every field, with array indices
+written as [], and the types of its values. Capture a baseline from a save
+made by a fresh career, and after a game update diff it against a fresh save
+of the new version to see which structures the update added, removed, renamed,
+or retyped. Values are ignored.
+
+Diff prints one line per change, marked + for added fields, - for removed
+fields, ~ for fields whose types changed, and > for a removed field that is
+likely renamed to an added one, having the same parent and types. The fields
+inside a renamed field are not listed. Diff exits with status 1 when the
+structures differ.
+
+Baselines are kept in the baselines directory next to the configuration file.
+List prints the names of the baselines.`,
+		example: `
+mmse baseline capture 1.52 fresh_career.sav
+mmse baseline diff 1.52 fresh_career_1.53.sav`,
+		flags: func(fs *flag.FlagSet) {
+			flagSaveDir(fs)
+			flagOutput(fs, outputTable)
+		},
+		nargs: func(n int) bool { return n == 1 || n == 3 },
+		run:   runBaseline,
+	})
+}
+
+// baselineDir returns the directory holding the baselines.
+func baselineDir() string {
+	return filepath.Join(filepath.Dir(cfgPath), "baselines")
+}
+
+// baselinePath returns the path of a baseline.
+func baselinePath(name string) string {
+	return filepath.Join(baselineDir(), name+".json")
+}
+
+// structure maps the fields of a save to the sorted types of their values.
+type structure map[string][]string
+
+// jsonType returns the type of the value starting with a token.
+func jsonType(t json.Token) string {
+	switch t.(type) {
+	case json.Delim:
+		if t == json.Delim('{') {
+			return "object"
+		}
+
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+
+	return "null"
+}
+
+// structureOf returns the structure of the documents of a save.
+func structureOf(info, data []byte) (structure, error) {
+	set := make(map[string]map[string]bool)
+
+	for _, d := range []struct {
+		name string
+		doc  []byte
+	}{{"info", info}, {"data", data}} {
+		err := jsonpath.Walk(bytes.NewReader(d.doc), func(p jsonpath.Path, t json.Token) error {
+			f := d.name
+
+			if pat := p.Pattern(); pat != "" && pat[0] == '[' {
+				f += pat
+			} else if pat != "" {
+				f += "." + pat
+			}
+
+			if set[f] == nil {
+				set[f] = make(map[string]bool)
+			}
+
+			set[f][jsonType(t)] = true
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("%s document: %s", d.name, err)
+		}
+	}
+
+	s := make(structure)
+
+	for f, ts := range set {
+		for t := range ts {
+			s[f] = append(s[f], t)
+		}
+
+		sort.Strings(s[f])
+	}
+
+	return s, nil
+}
+
+// saveStructure returns the structure of a save file.
+func saveStructure(fn string) structure {
+	sv := openSave(fn)
+
+	s, err := structureOf(sv.Info.Bytes(), sv.Data.Bytes())
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	return s
+}
+
+// diffStructures returns the lines describing the changes from structure a to
+// structure b.
+func diffStructures(a, b structure) []string {
+	var removed, added, ls []string
+
+	for f, ts := range a {
+		if us, ok := b[f]; !ok {
+			removed = append(removed, f)
+		} else if strings.Join(ts, ",") != strings.Join(us, ",") {
+			ls = append(ls, fmt.Sprintf(
+				"~ %s: %s to %s", f, strings.Join(ts, " or "), strings.Join(us, " or "),
+			))
+		}
+	}
+
+	for f := range b {
+		if _, ok := a[f]; !ok {
+			added = append(added, f)
+		}
+	}
+
+	sort.Strings(removed)
+	sort.Strings(added)
+
+	renamed := make(map[string]string)
+	gone := make(map[string]bool)
+
+	for _, r := range removed {
+		if under(r, renamed) != "" {
+			continue
+		}
+
+		var cands []string
+
+		for _, f := range added {
+			if !gone[f] && parentPattern(f) == parentPattern(r) &&
+				strings.Join(a[r], ",") == strings.Join(b[f], ",") {
+				cands = append(cands, f)
+			}
+		}
+
+		if len(cands) == 1 {
+			renamed[r] = cands[0]
+			gone[cands[0]] = true
+			ls = append(ls, fmt.Sprintf("> %s renamed to %s", r, cands[0]))
+		}
+	}
+
+	for _, r := range removed {
+		if _, ok := renamed[r]; ok {
+			continue
+		}
+
+		if p := under(r, renamed); p != "" {
+			// The field moved with its renamed parent.
+			if f := renamed[p] + r[len(p):]; b[f] != nil {
+				gone[f] = true
+				continue
+			}
+		}
+
+		ls = append(ls, fmt.Sprintf("- %s (%s)", r, strings.Join(a[r], " or ")))
+	}
+
+	for _, f := range added {
+		if !gone[f] {
+			ls = append(ls, fmt.Sprintf("+ %s (%s)", f, strings.Join(b[f], " or ")))
+		}
+	}
+
+	sort.SliceStable(ls, func(i, j int) bool { return ls[i][2:] < ls[j][2:] })
+
+	return ls
+}
+
+// under returns the renamed field containing field f, or an empty string.
+func under(f string, renamed map[string]string) string {
+	for p := parentPattern(f); p != ""; p = parentPattern(p) {
+		if _, ok := renamed[p]; ok {
+			return p
+		}
+	}
+
+	return ""
+}
+
+// runBaseline runs the baseline command.
+func runBaseline(args []string) {
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		fs, _ := filepath.Glob(filepath.Join(baselineDir(), "*.json"))
+
+		l := &listing{cols: []string{"name"}}
+
+		for _, f := range fs {
+			l.add(strings.TrimSuffix(filepath.Base(f), ".json"))
+		}
+
+		l.write()
+	case args[0] == "capture" && len(args) == 3:
+		b, err := json.MarshalIndent(saveStructure(args[2]), "", "  ")
+		if err != nil {
+			log.Panicf("Unable to encode baseline: %s", err)
+		}
+
+		if err := os.MkdirAll(baselineDir(), 0755); err != nil {
+			log.Panicf("Unable to create baseline directory: %s", err)
+		}
+
+		if err := os.WriteFile(baselinePath(args[1]), append(b, '\n'), 0644); err != nil {
+			log.Panicf("Unable to write baseline: %s", err)
+		}
+
+		fmt.Printf("Captured baseline %s from %s\n", args[1], args[2])
+	case args[0] == "diff" && len(args) == 3:
+		b, err := os.ReadFile(baselinePath(args[1]))
+		if err != nil {
+			log.Panicf("Unable to read baseline %s: %s", args[1], err)
+		}
+
+		var base structure
+
+		if err := json.Unmarshal(b, &base); err != nil {
+			log.Panicf("Unable to parse baseline %s: %s", args[1], err)
+		}
+
+		ls := diffStructures(base, saveStructure(args[2]))
+
+		for _, l := range ls {
+			fmt.Println(l)
+		}
+
+		if len(ls) > 0 {
+			fail()
+		}
+	default:
+		log.Panicf("Usage: mmse baseline %s", commands["baseline"].args)
+	}
+}
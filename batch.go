@@ -0,0 +1,256 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonconv"
+)
+
+// flagJobs registers the flag selecting the number of saves processed at
+// once.
+func flagJobs(fs *flag.FlagSet) {
+	fs.IntVar(
+		&cfg.Jobs, "jobs", cfg.Jobs,
+		"number of saves processed at once; 0 uses every CPU",
+	)
+}
+
+// jobs returns the number of saves processed at once.
+func jobs() int {
+	if cfg.Jobs > 0 {
+		return cfg.Jobs
+	}
+
+	return runtime.GOMAXPROCS(0)
+}
+
+// parallel calls fn with the indices from 0 to n-1 on up to jobs() goroutines
+// and returns the sorted indices of the calls that failed with log.Panicf,
+// which has already logged their message. Other panics crash as in main.
+func parallel(n int, fn func(i int)) []int {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []int
+	)
+
+	next := make(chan int)
+
+	for w := 0; w < jobs() && w < n; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range next {
+				func() {
+					defer func() {
+						r := recover()
+						if r == nil {
+							return
+						}
+
+						// Only errors from log.Panicf are failures of a
+						// call; other panics are bugs and crash.
+						if _, ok := r.(string); !ok {
+							panic(r)
+						}
+
+						mu.Lock()
+						failed = append(failed, i)
+						mu.Unlock()
+					}()
+
+					fn(i)
+				}()
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+
+	close(next)
+	wg.Wait()
+
+	sort.Ints(failed)
+
+	return failed
+}
+
+// stateFile records the saves unpacked by unpack -all.
+const stateFile = ".mmse-unpack.json"
+
+// unpackAll unpacks the changed saves of the save directory.
+var unpackAll bool
+
+// unpackState is the content of the state file.
+type unpackState struct {
+	// Options identifies the flags deciding the documents written.
+	Options string `json:"options"`
+	// Saves maps the absolute paths of the saves unpacked to their SHA-256
+	// checksums.
+	Saves map[string]string `json:"saves"`
+}
+
+// readState reads the state file, returning an empty state when it is missing
+// or was written with other options.
+func readState(opts string) *unpackState {
+	st := &unpackState{Options: opts, Saves: make(map[string]string)}
+
+	b, err := os.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return st
+	} else if err != nil {
+		log.Panicf("Unable to read %s: %s", stateFile, err)
+	}
+
+	var old unpackState
+
+	if err := json.Unmarshal(b, &old); err != nil {
+		log.Printf("Warning: ignoring %s: %s", stateFile, err)
+		return st
+	}
+
+	if old.Options == opts && old.Saves != nil {
+		st.Saves = old.Saves
+	}
+
+	return st
+}
+
+// writeState writes the state file.
+func writeState(st *unpackState) {
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		log.Panicf("Unable to encode %s: %s", stateFile, err)
+	}
+
+	tmp := stateFile + ".tmp"
+
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		log.Panicf("Unable to write %s: %s", stateFile, err)
+	}
+
+	if err := os.Rename(tmp, stateFile); err != nil {
+		log.Panicf("Unable to write %s: %s", stateFile, err)
+	}
+}
+
+// hashFile returns the SHA-256 checksum of a file in hexadecimal.
+func hashFile(fn string) (string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	h := sha256.New()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// unpackChanged unpacks the saves of the save directory that changed since
+// they were last unpacked by unpackChanged.
+func unpackChanged(ft jsonconv.Format) {
+	dir := cfg.SaveDir
+	if dir == "" {
+		dir = "."
+	}
+
+	opts := fmt.Sprintf(
+		"format=%s pretty=%t gzip=%t info=%s data=%s",
+		ft, cfg.Pretty, cfg.GzipOutput, cfg.Info, cfg.Data,
+	)
+
+	st := readState(opts)
+	fs := saveFiles(dir)
+	sums := make([]string, len(fs))
+
+	var skipped int32
+
+	failed := parallel(len(fs), func(i int) {
+		fn := fs[i]
+
+		abs, err := filepath.Abs(fn)
+		if err != nil {
+			log.Panicf("%s", err)
+		}
+
+		sum, err := hashFile(fn)
+		if err != nil {
+			log.Panicf("Unable to read %s: %s", fn, err)
+		}
+
+		n := names{Name: split(filepath.Base(fn)), Ext: outputExt(ft)}
+
+		if st.Saves[abs] == sum &&
+			fileExists(unpackName(cfg.Info, n)) && fileExists(unpackName(cfg.Data, n)) {
+			atomic.AddInt32(&skipped, 1)
+			return
+		}
+
+		unpack(fn, ft)
+
+		sums[i] = sum
+	})
+
+	for i, fn := range fs {
+		if sums[i] == "" {
+			continue
+		}
+
+		if abs, err := filepath.Abs(fn); err == nil {
+			st.Saves[abs] = sums[i]
+		}
+	}
+
+	for _, i := range failed {
+		log.Printf("Unable to unpack %s", fs[i])
+	}
+
+	writeState(st)
+
+	fmt.Printf(
+		"Unpacked %d saves, skipped %d unchanged, %d failed\n",
+		len(fs)-int(skipped)-len(failed), skipped, len(failed),
+	)
+
+	if len(failed) > 0 {
+		fail()
+	}
+}
@@ -0,0 +1,137 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+)
+
+const (
+	// blobExt is the extension of the directory holding the blobs extracted
+	// from a document.
+	blobExt = ".blobs"
+	// blobRef starts the strings referring to an extracted blob.
+	blobRef = "mmse-blob:"
+	// blobMinLen is the length from which base64 strings are extracted.
+	blobMinLen = 4096
+)
+
+// flagExtractBlobs registers the flag extracting blobs on unpack.
+func flagExtractBlobs(fs *flag.FlagSet) {
+	fs.BoolVar(
+		&cfg.ExtractBlobs, "extractblobs", cfg.ExtractBlobs,
+		"write long base64 strings to separate files",
+	)
+}
+
+// blobKind returns the file extension of the content of a blob.
+func blobKind(b []byte) string {
+	for magic, ext := range map[string]string{
+		"\x89PNG\r\n\x1a\n": ".png",
+		"\xff\xd8\xff":      ".jpg",
+		"GIF8":              ".gif",
+	} {
+		if bytes.HasPrefix(b, []byte(magic)) {
+			return ext
+		}
+	}
+
+	return ".bin"
+}
+
+// blobDir returns the blob directory of a document.
+func blobDir(doc string) string {
+	return filepath.Join(filepath.Dir(doc), split(filepath.Base(doc))+blobExt)
+}
+
+// extractBlobs writes the long base64 strings of a document to files in dir,
+// replacing any earlier blobs, and returns the document referring to the
+// files instead and the number of blobs. Only strings that encode back to
+// themselves are extracted, so that pack restores them exactly.
+func extractBlobs(doc []byte, dir string) ([]byte, int) {
+	if err := os.RemoveAll(dir); err != nil {
+		log.Panicf("Unable to remove %s: %s", dir, err)
+	}
+
+	b, n, err := jsonpath.ReplaceStrings(doc, func(s string) (string, bool) {
+		if len(s) < blobMinLen {
+			return "", false
+		}
+
+		d, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || base64.StdEncoding.EncodeToString(d) != s {
+			return "", false
+		}
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Panicf("Unable to create %s: %s", dir, err)
+		}
+
+		fs, _ := os.ReadDir(dir)
+		name := fmt.Sprintf("%d%s", len(fs)+1, blobKind(d))
+
+		if err := os.WriteFile(filepath.Join(dir, name), d, 0644); err != nil {
+			log.Panicf("Unable to write blob: %s", err)
+		}
+
+		return blobRef + name, true
+	})
+
+	if err != nil {
+		log.Panicf("Unable to read document: %s", err)
+	}
+
+	return b, n
+}
+
+// embedBlobs returns a document with the references to blobs in dir replaced
+// by the blobs encoded in base64.
+func embedBlobs(doc []byte, dir string) []byte {
+	b, _, err := jsonpath.ReplaceStrings(doc, func(s string) (string, bool) {
+		if !strings.HasPrefix(s, blobRef) {
+			return "", false
+		}
+
+		name := strings.TrimPrefix(s, blobRef)
+
+		if name == "" || filepath.Base(name) != name {
+			log.Panicf("Invalid blob reference %q", s)
+		}
+
+		d, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			log.Panicf("Unable to read blob: %s", err)
+		}
+
+		return base64.StdEncoding.EncodeToString(d), true
+	})
+
+	if err != nil {
+		log.Panicf("Unable to read document: %s", err)
+	}
+
+	return b
+}
@@ -0,0 +1,210 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a subcommand of mmse.
+type command struct {
+	// name is the word invoking the command.
+	name string
+	// args is the synopsis of the arguments.
+	args string
+	// short is a one line description.
+	short string
+	// long is the full description shown by help.
+	long string
+	// example holds example invocations, one per line.
+	example string
+	// flags registers the flags of the command.
+	flags func(fs *flag.FlagSet)
+	// nargs checks the number of arguments.
+	nargs func(n int) bool
+	// run runs the command with the arguments left after the flags.
+	run func(args []string)
+}
+
+// topic is a help topic that is not a command.
+type topic struct {
+	name  string
+	short string
+	long  string
+}
+
+var (
+	commands = make(map[string]*command)
+	topics   = make(map[string]*topic)
+)
+
+// register adds a command.
+func register(c *command) {
+	commands[c.name] = c
+}
+
+// registerTopic adds a help topic.
+func registerTopic(t *topic) {
+	topics[t.name] = t
+}
+
+// sortedCommands returns the commands sorted by name.
+func sortedCommands() []*command {
+	cs := make([]*command, 0, len(commands))
+
+	for _, c := range commands {
+		cs = append(cs, c)
+	}
+
+	sort.Slice(cs, func(i, j int) bool { return cs[i].name < cs[j].name })
+
+	return cs
+}
+
+// sortedTopics returns the help topics sorted by name.
+func sortedTopics() []*topic {
+	ts := make([]*topic, 0, len(topics))
+
+	for _, t := range topics {
+		ts = append(ts, t)
+	}
+
+	sort.Slice(ts, func(i, j int) bool { return ts[i].name < ts[j].name })
+
+	return ts
+}
+
+// exactly returns a check for n arguments.
+func exactly(n int) func(int) bool {
+	return func(m int) bool { return m == n }
+}
+
+// atLeast returns a check for at least n arguments.
+func atLeast(n int) func(int) bool {
+	return func(m int) bool { return m >= n }
+}
+
+// atMost returns a check for at most n arguments.
+func atMost(n int) func(int) bool {
+	return func(m int) bool { return m <= n }
+}
+
+// flagSet returns the flag set of a command.
+func (c *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+
+	flagConfig(fs)
+
+	if c.flags != nil {
+		c.flags(fs)
+	}
+
+	fs.Usage = func() {
+		c.usage(fs.Output(), fs)
+	}
+
+	return fs
+}
+
+// usage writes the synopsis and flags of a command.
+func (c *command) usage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage:\n\tmmse %s [options] %s\n\nOptions:\n", c.name, c.args)
+
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fs.SetOutput(nil)
+}
+
+// help writes the full help of a command.
+func (c *command) help(w io.Writer) {
+	fmt.Fprintf(w, "mmse %s - %s\n\n", c.name, c.short)
+
+	c.usage(w, c.flagSet())
+
+	if c.long != "" {
+		fmt.Fprintf(w, "\n%s\n", strings.TrimSpace(c.long))
+	}
+
+	if c.example != "" {
+		fmt.Fprintf(w, "\nExamples:\n")
+
+		for _, l := range strings.Split(strings.TrimSpace(c.example), "\n") {
+			fmt.Fprintf(w, "\t%s\n", l)
+		}
+	}
+}
+
+// execute parses the arguments of a command, loads the configuration, and
+// runs the command.
+func (c *command) execute(args []string) {
+	fs := c.flagSet()
+
+	// flag.ExitOnError makes Parse exit on errors.
+	_ = fs.Parse(args)
+
+	if c.nargs != nil && !c.nargs(fs.NArg()) {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	loadConfig(fs)
+	checkConfig()
+
+	auditCommand = c.name
+
+	c.run(fs.Args())
+}
+
+// usage writes the list of commands and help topics.
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "mmse packs and unpacks Motorsport Manager save files.\n\n")
+	fmt.Fprintf(w, "Usage:\n\tmmse <command> [options] [arguments]\n")
+	fmt.Fprintf(w, "\tmmse [options] <game.sav>...\n")
+	fmt.Fprintf(w, "\tmmse [options] <info.json> [<data.json>]\n\nCommands:\n")
+
+	// The descriptions line up after the longest name.
+	width := 0
+
+	for n := range commands {
+		if len(n) > width {
+			width = len(n)
+		}
+	}
+
+	for n := range topics {
+		if len(n) > width {
+			width = len(n)
+		}
+	}
+
+	for _, c := range sortedCommands() {
+		fmt.Fprintf(w, "\t%-*s %s\n", width, c.name, c.short)
+	}
+
+	fmt.Fprintf(w, "\nHelp topics:\n")
+
+	for _, t := range sortedTopics() {
+		fmt.Fprintf(w, "\t%-*s %s\n", width, t.name, t.short)
+	}
+
+	fmt.Fprintf(w, "\nRun \"mmse help <command>\" or \"mmse help <topic>\" for details.\n")
+}
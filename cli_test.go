@@ -0,0 +1,2947 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+	"github.com/mys721tx/mmse-go/pkg/mmse/mmsetest"
+)
+
+// runMainEnv makes the test binary run main instead of the tests, so that the
+// tests run mmse as users do without building it first.
+const runMainEnv = "MMSE_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+// Exit statuses of mmse.
+const (
+	// exitFailed is the status of a command that failed, such as on a missing
+	// save or a refused overwrite.
+	exitFailed = 1
+	// exitUsage is the status of unknown flags and wrong numbers of
+	// arguments.
+	exitUsage = 2
+)
+
+// mmseCommand returns the command running mmse in dir with a configuration
+// directory of its own.
+func mmseCommand(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Dir = dir
+	cmd.Env = append(
+		os.Environ(),
+		runMainEnv+"=1",
+		"HOME="+dir,
+		"XDG_CONFIG_HOME="+filepath.Join(dir, "config"),
+	)
+
+	return cmd
+}
+
+// mmseRun runs mmse as mmseCommand does, and returns its output and exit
+// code. A run ending in a Go panic fails the test: errors are reported with a
+// message and an exit status.
+func mmseRun(t *testing.T, dir string, args ...string) (string, int) {
+	t.Helper()
+
+	out, err := mmseCommand(dir, args...).CombinedOutput()
+
+	if bytes.Contains(out, []byte("\ngoroutine ")) {
+		t.Errorf("mmse %s crashed: %s", strings.Join(args, " "), out)
+	}
+
+	if e, ok := err.(*exec.ExitError); ok {
+		return string(out), e.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(out), 0
+}
+
+// copyFixture copies saves from testdata to dir.
+//
+// The saves in testdata are small synthetic saves made with mmsetest at level
+// 0: small.sav as is, padded.sav with four NUL bytes before the info frame,
+// trailing.sav with four bytes after the data frame, badmagic.sav with the
+// magic number replaced, and truncated.sav missing the last ten bytes.
+func copyFixture(t *testing.T, dir string, names ...string) {
+	t.Helper()
+
+	for _, n := range names {
+		if err := copyFile(filepath.Join(dir, n), filepath.Join("testdata", n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeConfig writes the configuration file of mmseRun in dir.
+func writeConfig(t *testing.T, dir, yaml string) {
+	t.Helper()
+
+	fn := filepath.Join(dir, "config", "mmse", "config.yml")
+
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(fn, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readFixture returns the content of a save in testdata.
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// writeFixture writes a synthetic save to dir and returns its content.
+func writeFixture(t *testing.T, dir, name string, o mmsetest.Options) []byte {
+	t.Helper()
+
+	b := mmsetest.Generate(o)
+
+	if err := os.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestCLIUnpackPack(t *testing.T) {
+	dir := t.TempDir()
+
+	save := writeFixture(t, dir, "career.sav", mmsetest.Options{Teams: 2, Drivers: 20})
+
+	out, code := mmseRun(t, dir, "unpack", "career.sav")
+
+	if !assert.Equal(t, 0, code, "Unpack should succeed: %s", out) {
+		return
+	}
+
+	s, err := mmse.ReadSaveFile(bytes.NewReader(save))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []struct {
+		fn   string
+		want []byte
+	}{{"career_info.json", s.Info.Bytes()}, {"career_data.json", s.Data.Bytes()}} {
+		b, err := os.ReadFile(filepath.Join(dir, d.fn))
+
+		if assert.NoError(t, err, "Unpack should write %s.", d.fn) {
+			assert.Equal(t, d.want, b, "%s should hold the decoded document.", d.fn)
+		}
+	}
+
+	out, code = mmseRun(t, dir, "pack", "career_info.json", "career_data.json")
+
+	if !assert.Equal(t, 0, code, "Pack should succeed: %s", out) {
+		return
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "career_data.sav"))
+
+	if assert.NoError(t, err, "Pack should write career_data.sav.") {
+		p, err := mmse.ReadSaveFile(bytes.NewReader(b))
+
+		if assert.NoError(t, err, "Pack should write a valid save.") {
+			assert.Equal(t, s.Info.Bytes(), p.Info.Bytes(), "Pack should keep the info document.")
+			assert.Equal(t, s.Data.Bytes(), p.Data.Bytes(), "Pack should keep the data document.")
+		}
+	}
+}
+
+func TestCLISet(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFixture(t, dir, "career.sav", mmsetest.Options{})
+
+	out, code := mmseRun(t, dir, "set", "career.sav", "data.teams[0].budget", "12345")
+
+	if !assert.Equal(t, 0, code, "Set should succeed: %s", out) {
+		return
+	}
+
+	out, code = mmseRun(t, dir, "get", "career.sav", "data.teams[0].budget")
+
+	if assert.Equal(t, 0, code, "Get should succeed: %s", out) {
+		assert.Equal(t, "12345", strings.TrimSpace(out), "Get should print the value set.")
+	}
+}
+
+func TestCLISetVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	b := writeFixture(t, dir, "career.sav", mmsetest.Options{})
+
+	// A version mmse does not know, which set must not rewrite as version 4.
+	mmse.PutLE(b[4:], int32(5))
+
+	if err := os.WriteFile(filepath.Join(dir, "career.sav"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code := mmseRun(t, dir, "set", "career.sav", "data.teams[0].budget", "12345")
+
+	if !assert.Equal(t, 0, code, "Set should succeed: %s", out) {
+		return
+	}
+
+	assert.Contains(t, out, "unknown version number 5", "Set should warn about the version.")
+
+	b, err := os.ReadFile(filepath.Join(dir, "career.sav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, int32(5), mmse.DecodeLE[int32](b[4:]), "Set should keep the version number.")
+}
+
+func TestCLIUnpackFixtures(t *testing.T) {
+	for _, c := range []struct {
+		save  string
+		code  int
+		out   string
+		files []string
+	}{
+		{"small.sav", 0, "", []string{"small_info.json", "small_data.json"}},
+		{
+			"padded.sav", 0, "Keeping 4 bytes of padding",
+			[]string{"padded_info.json", "padded_data.json", "padded_data.padding"},
+		},
+		{
+			"trailing.sav", 0, "Keeping 4 bytes after the data frame",
+			[]string{"trailing_info.json", "trailing_data.json", "trailing_data.trailing"},
+		},
+		{"badmagic.sav", exitFailed, "incorrect magic number", nil},
+		{"truncated.sav", exitFailed, "expecting 1309 bytes, read 1299", nil},
+	} {
+		dir := t.TempDir()
+
+		copyFixture(t, dir, c.save)
+
+		out, code := mmseRun(t, dir, "unpack", c.save)
+
+		assert.Equal(t, c.code, code, "Unpack of %s should exit with %d: %s", c.save, c.code, out)
+		assert.Contains(t, out, c.out, "Unpack of %s should explain itself.", c.save)
+
+		for _, fn := range c.files {
+			assert.FileExists(t, filepath.Join(dir, fn), "Unpack of %s should write %s.", c.save, fn)
+		}
+
+		if c.code != 0 {
+			ms, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+			assert.Empty(t, ms, "Unpack of %s should write no documents.", c.save)
+		}
+	}
+}
+
+func TestCLIRoundTripFixtures(t *testing.T) {
+	for _, n := range []string{"small", "padded", "trailing"} {
+		dir := t.TempDir()
+
+		copyFixture(t, dir, n+".sav")
+
+		for _, args := range [][]string{
+			{"unpack", n + ".sav"},
+			{"pack", n + "_info.json", n + "_data.json"},
+		} {
+			if out, code := mmseRun(t, dir, args...); code != 0 {
+				t.Fatalf("%s failed with %d: %s", strings.Join(args, " "), code, out)
+			}
+		}
+
+		want, _ := os.ReadFile(filepath.Join("testdata", n+".sav"))
+		got, err := os.ReadFile(filepath.Join(dir, n+"_data.sav"))
+
+		if assert.NoError(t, err, "Pack should write %s_data.sav.", n) {
+			assert.Equal(t, want, got, "Unpack and pack should restore %s.sav byte for byte.", n)
+		}
+	}
+}
+
+func TestCLILegacyArguments(t *testing.T) {
+	dir := t.TempDir()
+
+	copyFixture(t, dir, "small.sav")
+
+	out, code := mmseRun(t, dir, "small.sav")
+
+	if assert.Equal(t, 0, code, "A save alone should be unpacked: %s", out) {
+		assert.FileExists(t, filepath.Join(dir, "small_data.json"))
+	}
+
+	out, code = mmseRun(t, dir, "small_info.json", "small_data.json")
+
+	if assert.Equal(t, 0, code, "Two documents should be packed: %s", out) {
+		assert.FileExists(t, filepath.Join(dir, "small_data.sav"))
+	}
+}
+
+func TestCLIErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	copyFixture(t, dir, "small.sav", "badmagic.sav")
+
+	for _, c := range []struct {
+		args []string
+		code int
+		out  string
+	}{
+		{nil, exitUsage, "Usage"},
+		{[]string{"unpack"}, exitUsage, "Usage"},
+		{[]string{"unpack", "-nosuchflag", "small.sav"}, exitUsage, "flag provided but not defined"},
+		{[]string{"unpack", "missing.sav"}, exitFailed, "no such file"},
+		{[]string{"pack", "missing_info.json", "missing_data.json"}, exitFailed, "no such file"},
+		{[]string{"get", "small.sav", "data.nosuchkey"}, exitFailed, "nosuchkey"},
+		{[]string{"unpack", "small.sav", "badmagic.sav"}, exitFailed, "Unable to unpack badmagic.sav"},
+	} {
+		out, code := mmseRun(t, dir, c.args...)
+
+		assert.Equal(t, c.code, code, "mmse %s should exit with %d: %s", strings.Join(c.args, " "), c.code, out)
+		assert.Contains(t, out, c.out, "mmse %s should explain the error.", strings.Join(c.args, " "))
+	}
+}
+
+func TestCLIPackSwapped(t *testing.T) {
+	dir := t.TempDir()
+
+	copyFixture(t, dir, "small.sav")
+
+	if out, code := mmseRun(t, dir, "unpack", "small.sav"); code != 0 {
+		t.Fatalf("Unpack failed with %d: %s", code, out)
+	}
+
+	// Names that the output templates do not tell apart.
+	for from, to := range map[string]string{"small_info.json": "a.json", "small_data.json": "b.json"} {
+		if err := os.Rename(filepath.Join(dir, from), filepath.Join(dir, to)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, code := mmseRun(t, dir, "pack", "b.json", "a.json")
+
+	assert.Equal(t, exitFailed, code, "Pack should refuse swapped documents: %s", out)
+	assert.Contains(t, out, "b.json and a.json look swapped: the info document is")
+	assert.NoFileExists(t, filepath.Join(dir, "a.sav"), "Pack should write no save.")
+
+	out, code = mmseRun(t, dir, "pack", "-force", "b.json", "a.json")
+
+	assert.Equal(t, 0, code, "Pack should pack swapped documents with -force: %s", out)
+
+	out, code = mmseRun(t, dir, "pack", "a.json", "b.json")
+
+	assert.Equal(t, 0, code, "Pack should pack documents in order: %s", out)
+
+	// The version path outweighs the sizes.
+	writeConfig(t, dir, "version_path: gameVersion\n")
+
+	out, code = mmseRun(t, dir, "pack", "b.json", "a.json")
+
+	assert.Equal(t, exitFailed, code, "Pack should refuse swapped documents: %s", out)
+	assert.Contains(t, out, "gameVersion is in the data document and not in the info document")
+
+	big := `{"gameVersion":"1","notes":"` + strings.Repeat("x", 100) + `"}`
+
+	if err := os.WriteFile(filepath.Join(dir, "big.json"), []byte(big), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "tiny.json"), []byte(`{"teams":[]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code = mmseRun(t, dir, "pack", "big.json", "tiny.json")
+
+	assert.Equal(t, 0, code, "Pack should accept a large info document with the version: %s", out)
+}
+
+func TestCLIPackOneDocument(t *testing.T) {
+	dir := t.TempDir()
+
+	copyFixture(t, dir, "small.sav")
+
+	for _, args := range [][]string{
+		{"pack", "small_data.json"},
+		{"pack", "small_info.json"},
+		{"small_data.json"},
+	} {
+		if out, code := mmseRun(t, dir, "unpack", "-force", "small.sav"); code != 0 {
+			t.Fatalf("Unpack failed with %d: %s", code, out)
+		}
+
+		os.Remove(filepath.Join(dir, "small_data.sav"))
+
+		out, code := mmseRun(t, dir, args...)
+
+		if assert.Equal(t, 0, code, "mmse %s should pack: %s", strings.Join(args, " "), out) {
+			assert.FileExists(t, filepath.Join(dir, "small_data.sav"))
+		}
+	}
+
+	// The info document in another format.
+	if out, code := mmseRun(t, dir, "unpack", "-format", "yaml", "small.sav"); code != 0 {
+		t.Fatalf("Unpack failed with %d: %s", code, out)
+	}
+
+	os.Remove(filepath.Join(dir, "small_info.json"))
+
+	out, code := mmseRun(t, dir, "pack", "small_data.json")
+
+	assert.Equal(t, 0, code, "Pack should find small_info.yaml: %s", out)
+
+	os.Remove(filepath.Join(dir, "small_info.yaml"))
+
+	out, code = mmseRun(t, dir, "pack", "small_data.json")
+
+	assert.Equal(t, exitFailed, code, "Pack should refuse a document without its pair: %s", out)
+	assert.Contains(t, out, "small_data.json has no matching document small_info.json")
+}
+
+func TestCLISniff(t *testing.T) {
+	dir := t.TempDir()
+
+	copyFixture(t, dir, "small.sav", "trailing.sav")
+
+	// A save without an extension, given with a second save.
+	if err := os.Rename(filepath.Join(dir, "small.sav"), filepath.Join(dir, "career")); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code := mmseRun(t, dir, "career", "trailing.sav")
+
+	if assert.Equal(t, 0, code, "Two saves should be unpacked: %s", out) {
+		assert.FileExists(t, filepath.Join(dir, "career_data.json"))
+		assert.FileExists(t, filepath.Join(dir, "trailing_data.json"))
+	}
+
+	// Documents named by neither template, in the wrong order.
+	for from, to := range map[string]string{"career_info.json": "head", "career_data.json": "body"} {
+		if err := os.Rename(filepath.Join(dir, from), filepath.Join(dir, to)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, code = mmseRun(t, dir, "body", "head")
+
+	if assert.Equal(t, 0, code, "Two documents should be packed in either order: %s", out) {
+		b, _ := os.ReadFile(filepath.Join(dir, "body.sav"))
+
+		if s, err := mmse.ReadSaveFile(bytes.NewReader(b)); assert.NoError(t, err) {
+			assert.Contains(t, s.Info.String(), "gameVersion", "The smaller document should be the info document.")
+		}
+	}
+
+	for _, c := range []struct {
+		args []string
+		out  string
+	}{
+		{[]string{"career", "body"}, "career is a save file and body is a document"},
+		{[]string{"head", "body", "trailing_info.json"}, "not 3 documents"},
+		{[]string{"pack", "career"}, "career is a save file, not a document"},
+		{[]string{"unpack", "body"}, "body is a document, not a save file"},
+	} {
+		out, code := mmseRun(t, dir, c.args...)
+
+		assert.Equal(t, exitFailed, code, "mmse %s should fail: %s", strings.Join(c.args, " "), out)
+		assert.Contains(t, out, c.out, "mmse %s should explain the mistake.", strings.Join(c.args, " "))
+	}
+}
+
+func TestCLIOverwrite(t *testing.T) {
+	dir := t.TempDir()
+
+	copyFixture(t, dir, "small.sav")
+
+	if out, code := mmseRun(t, dir, "unpack", "small.sav"); code != 0 {
+		t.Fatalf("Unpack failed with %d: %s", code, out)
+	}
+
+	out, code := mmseRun(t, dir, "unpack", "small.sav")
+
+	assert.Equal(t, exitFailed, code, "Unpack should not overwrite documents: %s", out)
+	assert.Contains(t, out, "small_info.json exists; use -force to overwrite it, or -o")
+
+	out, code = mmseRun(t, dir, "unpack", "-force", "small.sav")
+
+	assert.Equal(t, 0, code, "Unpack should overwrite documents with -force: %s", out)
+
+	out, code = mmseRun(t, dir, "unpack", "-o", "edits", "small.sav")
+
+	if assert.Equal(t, 0, code, "Unpack should write to the directory of -o: %s", out) {
+		assert.FileExists(t, filepath.Join(dir, "edits", "small_info.json"))
+		assert.FileExists(t, filepath.Join(dir, "edits", "small_data.json"))
+	}
+
+	// A data document named like the save it packs to.
+	if err := os.Rename(filepath.Join(dir, "small_data.json"), filepath.Join(dir, "game.sav")); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code = mmseRun(t, dir, "pack", "small_info.json", "game.sav")
+
+	assert.Equal(t, exitFailed, code, "Pack should not overwrite its input: %s", out)
+	assert.Contains(t, out, "Pack would write the save over its input game.sav; name the save with -o")
+
+	out, code = mmseRun(t, dir, "pack", "-o", "new.sav", "small_info.json", "game.sav")
+
+	if assert.Equal(t, 0, code, "Pack should write the save of -o: %s", out) {
+		assert.Equal(t, kindSave, sniff(filepath.Join(dir, "new.sav")))
+		assert.Equal(t, kindDoc, sniff(filepath.Join(dir, "game.sav")), "Pack should leave its input intact.")
+	}
+}
+
+func TestCLISidecar(t *testing.T) {
+	dir := t.TempDir()
+
+	copyFixture(t, dir, "small.sav")
+
+	if out, code := mmseRun(t, dir, "unpack", "-sidecar", "small.sav"); code != 0 {
+		t.Fatalf("Unpack failed with %d: %s", code, out)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "small_data.mmse.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sc sidecar
+
+	if assert.NoError(t, json.Unmarshal(b, &sc)) {
+		want, _ := hashFile(filepath.Join("testdata", "small.sav"))
+
+		assert.Equal(t, want, sc.SHA256, "The sidecar should record the checksum of the save.")
+		assert.Equal(t, "small_info.json", sc.Info.Name)
+		assert.Equal(t, mmse.Ver, sc.Version)
+	}
+
+	out, code := mmseRun(t, dir, "pack", "-o", "small.sav", "small_info.json", "small_data.json")
+
+	if assert.Equal(t, 0, code, "Pack should pack unchanged documents: %s", out) {
+		assert.Contains(t, out, "Neither document changed since small.sav was unpacked")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "other_info.json"), []byte(`{"saveName":"Other"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code = mmseRun(t, dir, "pack", "other_info.json", "small_data.json")
+
+	assert.Equal(t, exitFailed, code, "Pack should refuse documents of two saves: %s", out)
+	assert.Contains(t, out, "records small_info.json as the info document of small_data.json, not other_info.json")
+
+	// The game saves again.
+	copyFixture(t, dir, "trailing.sav")
+
+	if err := os.Rename(filepath.Join(dir, "trailing.sav"), filepath.Join(dir, "small.sav")); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code = mmseRun(t, dir, "pack", "-o", "small.sav", "small_info.json", "small_data.json")
+
+	assert.Equal(t, exitFailed, code, "Pack should not discard the changes of the save: %s", out)
+	assert.Contains(t, out, "small.sav changed since it was unpacked")
+
+	out, code = mmseRun(t, dir, "pack", "-force", "-o", "small.sav", "small_info.json", "small_data.json")
+
+	assert.Equal(t, 0, code, "Pack should overwrite the save with -force: %s", out)
+
+	// Unpacking without -sidecar removes the sidecar of an earlier unpack.
+	if out, code := mmseRun(t, dir, "unpack", "-force", "small.sav"); code != 0 {
+		t.Fatalf("Unpack failed with %d: %s", code, out)
+	}
+
+	assert.NoFileExists(t, filepath.Join(dir, "small_data.mmse.json"))
+}
+
+func TestCLIMod(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFixture(t, dir, "career.sav", mmsetest.Options{})
+
+	budget, _ := mmseRun(t, dir, "get", "career.sav", "data.teams[0].budget")
+
+	writeMod := func(ops string) {
+		m := `{"name": "budget", "version": "1.0", "author": "tester",
+			"game": {"min": "1.5", "max": "1.6"}, "patch": [` + ops + `]}`
+
+		if err := os.WriteFile(filepath.Join(dir, "budget.json"), []byte(m), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeMod(`{"op": "test", "path": "/info/gameVersion", "value": "synthetic"},
+		{"op": "replace", "path": "/data/teams/0/budget", "value": 777}`)
+
+	out, code := mmseRun(t, dir, "mod", "install", "budget.json", "career.sav")
+
+	assert.Equal(t, exitFailed, code, "Install should refuse an unsigned mod: %s", out)
+	assert.Contains(t, out, "budget.json is not signed")
+
+	out, code = mmseRun(t, dir, "mod", "-newkey", "-keyfile", "author.key", "sign", "budget.json")
+
+	if !assert.Equal(t, 0, code, "Sign should succeed: %s", out) {
+		return
+	}
+
+	var key string
+
+	for _, l := range strings.Split(out, "\n") {
+		if strings.HasPrefix(l, "Public key: ") {
+			key = strings.TrimPrefix(l, "Public key: ")
+		}
+	}
+
+	out, code = mmseRun(t, dir, "mod", "install", "budget.json", "career.sav")
+
+	assert.Equal(t, exitFailed, code, "Install should refuse an untrusted key: %s", out)
+	assert.Contains(t, out, "which is not in mod_keys")
+
+	writeConfig(t, dir, "mod_keys: ["+key+"]\nversion_path: gameVersion\n")
+
+	out, code = mmseRun(t, dir, "mod", "show", "budget.json")
+
+	assert.Equal(t, 0, code, "Show should succeed: %s", out)
+	assert.Contains(t, out, "Game versions: 1.5 to 1.6")
+	assert.Contains(t, out, "Signature: valid, trusted key "+key)
+
+	out, code = mmseRun(t, dir, "mod", "install", "budget.json", "career.sav")
+
+	assert.Equal(t, exitFailed, code, "Install should refuse another game version: %s", out)
+	assert.Contains(t, out, "budget is for game versions 1.5 to 1.6")
+
+	out, code = mmseRun(t, dir, "mod", "-gameversion", "1.5.2", "install", "budget.json", "career.sav")
+
+	if !assert.Equal(t, 0, code, "Install should succeed: %s", out) {
+		return
+	}
+
+	out, _ = mmseRun(t, dir, "get", "career.sav", "data.teams[0].budget")
+
+	assert.Equal(t, "777", strings.TrimSpace(out), "Install should apply the patch.")
+
+	// A patch whose test fails leaves the save unchanged.
+	writeMod(`{"op": "replace", "path": "/data/teams/0/budget", "value": 1},
+		{"op": "test", "path": "/info/gameVersion", "value": "other"}`)
+
+	if out, code := mmseRun(t, dir, "mod", "-keyfile", "author.key", "sign", "budget.json"); code != 0 {
+		t.Fatalf("Sign failed with %d: %s", code, out)
+	}
+
+	out, code = mmseRun(t, dir, "mod", "-gameversion", "1.5", "install", "budget.json", "career.sav")
+
+	assert.Equal(t, exitFailed, code, "Install should refuse a failing patch: %s", out)
+	assert.Contains(t, out, "which is unchanged: operation 1, test /info/gameVersion: test failed")
+
+	out, _ = mmseRun(t, dir, "get", "career.sav", "data.teams[0].budget")
+
+	assert.Equal(t, "777", strings.TrimSpace(out), "A failing patch should leave the save unchanged.")
+
+	// A mod changed after it was signed is refused even with -force.
+	b, err := os.ReadFile(filepath.Join(dir, "budget.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b = []byte(strings.Replace(string(b), `"other"`, `"synthetic"`, 1))
+
+	if err := os.WriteFile(filepath.Join(dir, "budget.json"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code = mmseRun(t, dir, "mod", "-force", "install", "budget.json", "career.sav")
+
+	assert.Equal(t, exitFailed, code, "Install should refuse a changed mod: %s", out)
+	assert.Contains(t, out, "Invalid signature in mod budget")
+
+	out, code = mmseRun(t, dir, "backup", "restore", "career.sav", "before-budget")
+
+	assert.Equal(t, 0, code, "Restore should undo the mod: %s", out)
+
+	out, _ = mmseRun(t, dir, "get", "career.sav", "data.teams[0].budget")
+
+	assert.Equal(t, budget, out, "Restore should bring back the value before the mod.")
+}
+
+func TestCLIBackup(t *testing.T) {
+	dir := t.TempDir()
+
+	writeConfig(t, dir, "backup: store\nbackup_keep: 2\nbackup_dir: "+filepath.Join(dir, "store")+"\n")
+	writeFixture(t, dir, "career.sav", mmsetest.Options{})
+
+	// Each set stores the save before it, keeping the two newest backups,
+	// those with budgets 1 and 2.
+	for _, v := range []string{"1", "2", "3"} {
+		if out, code := mmseRun(t, dir, "set", "career.sav", "data.teams[0].budget", v); code != 0 {
+			t.Fatalf("Set failed with %d: %s", code, out)
+		}
+	}
+
+	list := func(fn string) []map[string]interface{} {
+		out, code := mmseRun(t, dir, "backup", "-output", "json", "list", fn)
+
+		var es []map[string]interface{}
+
+		if assert.Equal(t, 0, code, "List should succeed: %s", out) {
+			assert.NoError(t, json.Unmarshal([]byte(out), &es), "List should print JSON: %s", out)
+		}
+
+		return es
+	}
+
+	assert.Len(t, list("career.sav"), 2, "The store should keep two backups.")
+
+	// Storing the current save before the restore prunes the oldest backup,
+	// which is the one restored.
+	out, code := mmseRun(t, dir, "backup", "restore", "career.sav", "2")
+
+	if !assert.Equal(t, 0, code, "Restore should succeed: %s", out) {
+		return
+	}
+
+	out, _ = mmseRun(t, dir, "get", "career.sav", "data.teams[0].budget")
+
+	assert.Equal(t, "1", strings.TrimSpace(out), "Restore should bring back the oldest backup.")
+	assert.Len(t, list("career.sav"), 2, "The restore should store the current save and prune.")
+	assert.NoFileExists(t, filepath.Join(dir, "career.sav.restore"))
+
+	// A save of the same name elsewhere has a store of its own.
+	if err := os.Mkdir(filepath.Join(dir, "other"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFixture(t, filepath.Join(dir, "other"), "career.sav", mmsetest.Options{Seed: 2})
+
+	if out, code := mmseRun(t, dir, "backup", "create", filepath.Join("other", "career.sav")); code != 0 {
+		t.Fatalf("Create failed with %d: %s", code, out)
+	}
+
+	assert.Len(t, list(filepath.Join("other", "career.sav")), 1, "The other save should have its own backup.")
+	assert.Len(t, list("career.sav"), 2, "The other save should leave the backups of the first alone.")
+
+	out, code = mmseRun(t, dir, "backup", "restore", "career.sav", "3")
+
+	assert.Equal(t, exitFailed, code, "Restore should refuse a missing backup: %s", out)
+	assert.Contains(t, out, "career.sav has no backup 3")
+
+	// A save in the working directory is found before one in the save
+	// directory.
+	out, code = mmseRun(t, dir, "backup", "-savedir", "other", "-output", "json", "list", "career.sav")
+
+	var es []map[string]interface{}
+
+	if assert.Equal(t, 0, code, "List should succeed: %s", out) && assert.NoError(t, json.Unmarshal([]byte(out), &es)) {
+		assert.Len(t, es, 2, "The save in the working directory should be listed.")
+	}
+}
+
+func TestCLIVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	out, code := mmseRun(t, dir, "version", "-formats", "-json")
+
+	if !assert.Equal(t, 0, code, "Version should succeed: %s", out) {
+		return
+	}
+
+	var r struct {
+		Version string `json:"version"`
+		Save    struct {
+			Magic    string  `json:"magic"`
+			Versions []int32 `json:"versions"`
+		} `json:"save_format"`
+		Documents []string `json:"document_formats"`
+	}
+
+	if assert.NoError(t, json.Unmarshal([]byte(out), &r), "Version should print JSON: %s", out) {
+		assert.NotEmpty(t, r.Version)
+		assert.Equal(t, "0x73326d6d", r.Save.Magic)
+		assert.Contains(t, r.Save.Versions, mmse.Ver)
+		assert.Equal(t, []string{"json", "yaml", "toml"}, r.Documents)
+	}
+
+	fs, _ := os.ReadDir(dir)
+
+	assert.Empty(t, fs, "Version should write no files.")
+}
+
+func TestCLIClipboard(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("The fake clipboard stands in for xclip.")
+	}
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bin")
+	clip := filepath.Join(dir, "clipboard")
+
+	if err := os.Mkdir(bin, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	xclip := "#!/bin/sh\nif [ \"$3\" = -o ]; then cat \"$MMSE_TEST_CLIP\"; else cat > \"$MMSE_TEST_CLIP\"; fi\n"
+
+	if err := os.WriteFile(filepath.Join(bin, "xclip"), []byte(xclip), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("WAYLAND_DISPLAY", "")
+	t.Setenv("MMSE_TEST_CLIP", clip)
+
+	copyFixture(t, dir, "small.sav")
+
+	out, code := mmseRun(t, dir, "get", "-clipboard", "small.sav", "info.saveName")
+
+	if assert.Equal(t, 0, code, "Get should succeed: %s", out) {
+		b, _ := os.ReadFile(clip)
+		assert.Equal(t, "Synthetic 1", string(b), "Get should copy strings without quotes.")
+	}
+
+	// Spreadsheets add a line break after a cell.
+	if err := os.WriteFile(clip, []byte("Pasted Name\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code = mmseRun(t, dir, "set", "-fromclipboard", "small.sav", "info.saveName")
+
+	if !assert.Equal(t, 0, code, "Set should succeed: %s", out) {
+		return
+	}
+
+	b, _ := os.ReadFile(filepath.Join(dir, "small.sav"))
+
+	if s, err := mmse.ReadSaveFile(bytes.NewReader(b)); assert.NoError(t, err) {
+		assert.Contains(t, s.Info.String(), `"saveName":"Pasted Name"`, "Set should write the pasted value.")
+	}
+}
+
+func TestCLIOutput(t *testing.T) {
+	dir := t.TempDir()
+	copyFixture(t, dir, "small.sav")
+
+	save, _ := os.ReadFile(filepath.Join(dir, "small.sav"))
+
+	for _, c := range []struct {
+		format string
+		want   string
+	}{
+		{"table", "path           in     match\ninfo.saveName  value  \"Synthetic 1\"\n"},
+		{"csv", "path,in,match\ninfo.saveName,value,\"\"\"Synthetic 1\"\"\"\n"},
+		{"json", "[\n  {\n    \"path\": \"info.saveName\",\n    \"in\": \"value\",\n    \"match\": \"\\\"Synthetic 1\\\"\"\n  }\n]\n"},
+		{"yaml", "- path: info.saveName\n  in: value\n  match: '\"Synthetic 1\"'\n"},
+	} {
+		out, code := mmseRun(t, dir, "search", "-output", c.format, "small.sav", "Synthetic")
+
+		if assert.Equal(t, 0, code, "Search should succeed with %s: %s", c.format, out) {
+			assert.Equal(t, c.want, out, "Search should write %s.", c.format)
+		}
+	}
+
+	out, code := mmseRun(t, dir, "search", "-output", "xml", "small.sav", "Synthetic")
+
+	assert.Equal(t, exitFailed, code, "Unknown formats should fail.")
+	assert.Contains(t, out, "Unknown output format: xml")
+
+	b, _ := os.ReadFile(filepath.Join(dir, "small.sav"))
+	assert.Equal(t, save, b, "Search should not change the save.")
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1, "Search should not write files.")
+}
+
+func TestCLINDJSON(t *testing.T) {
+	dir := t.TempDir()
+	copyFixture(t, dir, "small.sav")
+
+	out, code := mmseRun(t, dir, "search", "-output", "json", "small.sav", "a")
+
+	if !assert.Equal(t, 0, code, "Search should succeed: %s", out) {
+		return
+	}
+
+	var want []map[string]string
+
+	if !assert.NoError(t, json.Unmarshal([]byte(out), &want)) {
+		return
+	}
+
+	out, code = mmseRun(t, dir, "search", "-output", "ndjson", "small.sav", "a")
+
+	if !assert.Equal(t, 0, code, "Search should succeed: %s", out) {
+		return
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+
+	if !assert.Len(t, lines, len(want), "Ndjson should write a line per result.") {
+		return
+	}
+
+	for i, l := range lines {
+		var got map[string]string
+
+		if assert.NoError(t, json.Unmarshal([]byte(l), &got), "Line %d should be an object.", i) {
+			assert.Equal(t, want[i], got, "Line %d should match the json output.", i)
+		}
+	}
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1, "Search should not write files.")
+}
+
+func TestCLISign(t *testing.T) {
+	dir := t.TempDir()
+	copyFixture(t, dir, "small.sav", "padded.sav")
+
+	out, code := mmseRun(t, dir, "sign", "-newkey", "-keyfile", "league.key")
+
+	if !assert.Equal(t, 0, code, "Sign should write a key: %s", out) {
+		return
+	}
+
+	if st, err := os.Stat(filepath.Join(dir, "league.key")); assert.NoError(t, err) && runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(0600), st.Mode().Perm(), "The key should be private.")
+	}
+
+	out, code = mmseRun(t, dir, "sign", "-newkey", "-keyfile", "league.key")
+	assert.Equal(t, exitFailed, code, "Sign should not replace a key: %s", out)
+
+	out, code = mmseRun(t, dir, "sign", "-keyfile", "league.key", "small.sav")
+
+	if !assert.Equal(t, 0, code, "Sign should succeed: %s", out) {
+		return
+	}
+
+	assert.FileExists(t, filepath.Join(dir, "small.sav.sig"))
+	assert.NoFileExists(t, filepath.Join(dir, "padded.sav.sig"))
+
+	out, code = mmseRun(t, dir, "verify-signature", "-keyfile", "league.key", "small.sav")
+
+	assert.Equal(t, 0, code, "Verify should succeed: %s", out)
+	assert.Equal(t, "small.sav: ok\n", out)
+
+	out, code = mmseRun(t, dir, "verify-signature", "-keyfile", "league.key", "small.sav", "padded.sav")
+
+	assert.Equal(t, exitFailed, code, "Unsigned saves should fail.")
+	assert.Equal(t, "small.sav: ok\npadded.sav: not signed\n", out)
+
+	out, code = mmseRun(t, dir, "set", "small.sav", "info.saveName", `"Edited"`)
+
+	if !assert.Equal(t, 0, code, "Set should succeed: %s", out) {
+		return
+	}
+
+	out, code = mmseRun(t, dir, "verify-signature", "-keyfile", "league.key", "small.sav")
+
+	assert.Equal(t, exitFailed, code, "Edited saves should fail.")
+	assert.Equal(t, "small.sav: changed since it was signed\n", out)
+
+	// Another key does not match the signature either.
+	mmseRun(t, dir, "sign", "-newkey", "-keyfile", "other.key")
+	copyFixture(t, dir, "small.sav")
+
+	out, code = mmseRun(t, dir, "verify-signature", "-keyfile", "other.key", "small.sav")
+
+	assert.Equal(t, exitFailed, code, "Other keys should fail.")
+	assert.Equal(t, "small.sav: changed since it was signed\n", out)
+}
+
+func TestCLITag(t *testing.T) {
+	dir := t.TempDir()
+
+	writeConfig(t, dir, "backup: store\nbackup_keep: 1\nbackup_dir: "+filepath.Join(dir, "store")+"\n")
+	writeFixture(t, dir, "career.sav", mmsetest.Options{})
+
+	budget := func() string {
+		out, _ := mmseRun(t, dir, "get", "career.sav", "data.teams[0].budget")
+		return strings.TrimSpace(out)
+	}
+
+	tags := func(args ...string) []map[string]interface{} {
+		out, code := mmseRun(t, dir, append([]string{"tags", "-output", "json"}, args...)...)
+
+		var rs []map[string]interface{}
+
+		if assert.Equal(t, 0, code, "Tags should succeed: %s", out) {
+			assert.NoError(t, json.Unmarshal([]byte(out), &rs), "Tags should print JSON: %s", out)
+		}
+
+		return rs
+	}
+
+	want := budget()
+
+	if out, code := mmseRun(t, dir, "tag", "career.sav", "before-cheat", "second"); code != 0 {
+		t.Fatalf("Tag failed with %d: %s", code, out)
+	}
+
+	assert.FileExists(t, filepath.Join(dir, ".mmse-tags.json"))
+
+	rs := tags("-label", "before-cheat", dir)
+
+	if !assert.Len(t, rs, 1, "Tags should filter by label.") {
+		return
+	}
+
+	assert.Equal(t, "career.sav", rs[0]["save"])
+	assert.Equal(t, "unchanged", rs[0]["state"])
+
+	labelled, _ := rs[0]["backup"].(string)
+	assert.FileExists(t, labelled, "The label should keep a backup.")
+
+	// Keeping one backup, the sets prune every backup but the labelled ones.
+	for _, v := range []string{"1", "2", "3"} {
+		if out, code := mmseRun(t, dir, "set", "career.sav", "data.teams[0].budget", v); code != 0 {
+			t.Fatalf("Set failed with %d: %s", code, out)
+		}
+	}
+
+	assert.FileExists(t, labelled, "Pruning should keep labelled backups.")
+
+	if rs := tags("-label", "before-cheat", dir); assert.Len(t, rs, 1) {
+		assert.Equal(t, "changed", rs[0]["state"])
+	}
+
+	out, code := mmseRun(t, dir, "backup", "restore", "career.sav", "before-cheat")
+
+	if assert.Equal(t, 0, code, "Restore should succeed: %s", out) {
+		assert.Equal(t, want, budget(), "Restore should bring back the labelled save.")
+	}
+
+	out, code = mmseRun(t, dir, "tag", "-delete", "career.sav", "before-cheat")
+
+	if assert.Equal(t, 0, code, "Delete should succeed: %s", out) {
+		rs := tags(dir)
+
+		if assert.Len(t, rs, 1, "Delete should keep the other label.") {
+			assert.Equal(t, "second", rs[0]["label"])
+		}
+	}
+
+	out, code = mmseRun(t, dir, "tag", "-delete", "career.sav", "before-cheat")
+
+	assert.Equal(t, exitFailed, code, "Delete should refuse a missing label.")
+	assert.Contains(t, out, "has no label before-cheat")
+}
+
+func TestCLIDedupe(t *testing.T) {
+	dir := t.TempDir()
+	s, o := filepath.Join(dir, "s"), filepath.Join(dir, "o")
+
+	for _, d := range []string{s, o} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The copies in s are newer than the one in o, which is kept.
+	copyFixture(t, s, "small.sav")
+	copyFixture(t, o, "small.sav")
+
+	if err := copyFile(filepath.Join(s, "copy.sav"), filepath.Join(s, "small.sav")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, fn := range []string{filepath.Join(o, "small.sav"), filepath.Join(s, "small.sav")} {
+		mt := time.Now().Add(time.Duration(i-2) * time.Hour)
+
+		if err := os.Chtimes(fn, mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeFixture(t, s, "other.sav", mmsetest.Options{Seed: 2})
+
+	// Giving a directory twice does not make a save a duplicate of itself.
+	out, code := mmseRun(t, dir, "dedupe", "-delete", "s", "s/")
+
+	if assert.Equal(t, 0, code, "Dedupe should succeed: %s", out) {
+		assert.Contains(t, out, "1 duplicates of 1 saves")
+		assert.FileExists(t, filepath.Join(s, "small.sav"))
+		assert.NoFileExists(t, filepath.Join(s, "copy.sav"))
+		assert.FileExists(t, filepath.Join(s, "other.sav"))
+	}
+
+	out, code = mmseRun(t, dir, "dedupe", "-link", "s", "o", "s")
+
+	if !assert.Equal(t, 0, code, "Dedupe should succeed: %s", out) {
+		return
+	}
+
+	assert.Contains(t, out, "1 duplicates of 1 saves")
+	assert.Regexp(t, `o.small\.sav +[0-9a-f]+ +kept`, out, "The oldest save of all directories should be kept.")
+	assert.True(t, sameFile(filepath.Join(o, "small.sav"), filepath.Join(s, "small.sav")), "The newer save should be linked.")
+	assert.FileExists(t, filepath.Join(s, "other.sav"))
+
+	// Linked saves are the same file and no longer duplicates.
+	out, code = mmseRun(t, dir, "dedupe", "-delete", "o", "s")
+
+	if assert.Equal(t, 0, code, "Dedupe should succeed: %s", out) {
+		assert.Contains(t, out, "No duplicate saves found")
+		assert.FileExists(t, filepath.Join(o, "small.sav"))
+		assert.FileExists(t, filepath.Join(s, "small.sav"))
+	}
+}
+
+func TestCLIApplyPatch(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFixture(t, dir, "old.sav", mmsetest.Options{})
+	writeFixture(t, dir, "new.sav", mmsetest.Options{Seed: 2})
+
+	if err := copyFile(filepath.Join(dir, "copy.sav"), filepath.Join(dir, "old.sav")); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, code := mmseRun(t, dir, "make-patch", "-o", "change.json", "old.sav", "new.sav"); code != 0 {
+		t.Fatalf("Make-patch failed with %d: %s", code, out)
+	}
+
+	if b, _ := os.ReadFile(filepath.Join(dir, "change.json")); string(b) == "[]\n" {
+		t.Fatal("The saves should differ.")
+	}
+
+	out, code := mmseRun(t, dir, "apply-patch", "change.json", "copy.sav")
+
+	if !assert.Equal(t, 0, code, "Apply-patch should succeed: %s", out) {
+		return
+	}
+
+	assert.Contains(t, out, "Patched copy.sav")
+
+	read := func(fn string) *mmse.SaveFile {
+		b, _ := os.ReadFile(filepath.Join(dir, fn))
+
+		s, err := mmse.ReadSaveFile(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("Unable to read %s: %s", fn, err)
+		}
+
+		return s
+	}
+
+	got, want := read("copy.sav"), read("new.sav")
+
+	assert.Equal(t, want.Info.String(), got.Info.String(), "The patched info document should match.")
+	assert.Equal(t, want.Data.String(), got.Data.String(), "The patched data document should match.")
+
+	out, code = mmseRun(t, dir, "make-patch", "copy.sav", "new.sav")
+
+	if assert.Equal(t, 0, code, "Make-patch should succeed: %s", out) {
+		assert.Equal(t, "[]\n", out, "No differences should be left.")
+	}
+
+	// A patch whose test fails leaves the save unchanged.
+	patch := `[{"op": "replace", "path": "/data/teams/0/budget", "value": 1},
+		{"op": "test", "path": "/info/gameVersion", "value": "other"}]`
+
+	if err := os.WriteFile(filepath.Join(dir, "bad.json"), []byte(patch), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	before, _ := os.ReadFile(filepath.Join(dir, "old.sav"))
+
+	out, code = mmseRun(t, dir, "apply-patch", "bad.json", "old.sav")
+
+	assert.Equal(t, exitFailed, code, "Apply-patch should refuse a failing patch: %s", out)
+	assert.Contains(t, out, "old.sav: unable to test /info/gameVersion: test failed")
+	assert.Contains(t, out, "Aborting at test /info/gameVersion; old.sav is unchanged")
+
+	after, _ := os.ReadFile(filepath.Join(dir, "old.sav"))
+	assert.Equal(t, before, after, "A failing patch should leave the save unchanged.")
+
+	// Without a terminal, asking aborts.
+	out, code = mmseRun(t, dir, "apply-patch", "-onconflict", "ask", "bad.json", "old.sav")
+
+	assert.Equal(t, exitFailed, code, "Asking without answers should abort: %s", out)
+
+	after, _ = os.ReadFile(filepath.Join(dir, "old.sav"))
+	assert.Equal(t, before, after, "An aborted patch should leave the save unchanged.")
+
+	summary := func() []map[string]interface{} {
+		var r []map[string]interface{}
+
+		b, err := os.ReadFile(filepath.Join(dir, "summary.json"))
+
+		if assert.NoError(t, err, "The summary should be written.") {
+			assert.NoError(t, json.Unmarshal(b, &r))
+		}
+
+		return r
+	}
+
+	out, code = mmseRun(t, dir, "apply-patch", "-onconflict", "skip", "-summary", "summary.json", "bad.json", "old.sav")
+
+	if assert.Equal(t, 0, code, "Skipping should succeed: %s", out) {
+		out, _ = mmseRun(t, dir, "get", "old.sav", "data.teams[0].budget")
+		assert.Equal(t, "1", strings.TrimSpace(out), "Skipping should apply the other operations.")
+
+		assert.Equal(t, []map[string]interface{}{{
+			"save":    "old.sav",
+			"written": true,
+			"operations": []interface{}{
+				map[string]interface{}{"operation": "replace /data/teams/0/budget", "status": "applied"},
+				map[string]interface{}{"operation": "test /info/gameVersion", "status": "skipped"},
+			},
+		}}, summary())
+	}
+
+	// Forcing adds a missing value and leaves out the failed test.
+	patch = `[{"op": "replace", "path": "/data/teams/0/forced", "value": 5},
+		{"op": "test", "path": "/info/gameVersion", "value": "other"}]`
+
+	if err := os.WriteFile(filepath.Join(dir, "bad.json"), []byte(patch), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code = mmseRun(t, dir, "apply-patch", "-onconflict", "force", "-summary", "summary.json", "bad.json", "old.sav")
+
+	if assert.Equal(t, 0, code, "Forcing should succeed: %s", out) {
+		out, _ = mmseRun(t, dir, "get", "old.sav", "data.teams[0].forced")
+		assert.Equal(t, "5", strings.TrimSpace(out), "Forcing should add the value.")
+
+		if r := summary(); assert.Len(t, r, 1) {
+			assert.Equal(t, []interface{}{
+				map[string]interface{}{"operation": "replace /data/teams/0/forced", "status": "forced"},
+				map[string]interface{}{"operation": "test /info/gameVersion", "status": "forced"},
+			}, r[0]["operations"])
+		}
+	}
+
+	out, code = mmseRun(t, dir, "apply-patch", "-onconflict", "retry", "bad.json", "old.sav")
+
+	assert.Equal(t, exitFailed, code, "Unknown policies should fail: %s", out)
+	assert.Contains(t, out, "Unknown conflict policy: retry")
+}
+
+func TestCLISession(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Sessions listen on Unix sockets.")
+	}
+
+	dir := t.TempDir()
+	writeFixture(t, dir, "career.sav", mmsetest.Options{})
+
+	out, code := mmseRun(t, dir, "session", "-config", "", "open", "career.sav")
+
+	assert.Equal(t, exitFailed, code, "Open should fail without a configuration directory: %s", out)
+	assert.Contains(t, out, "No configuration directory for the sessions")
+
+	out, code = mmseRun(t, dir, "session", "open", "career.sav")
+
+	if !assert.Equal(t, 0, code, "Open should succeed: %s", out) {
+		return
+	}
+
+	t.Cleanup(func() { mmseRun(t, dir, "session", "close", "career.sav") })
+
+	if !testing.Short() {
+		// A client that sends nothing is dropped instead of blocking the
+		// session.
+		socks, _ := filepath.Glob(filepath.Join(dir, "config", "mmse", "sessions", "*.sock"))
+
+		if assert.Len(t, socks, 1) {
+			c, err := net.Dial("unix", socks[0])
+			if assert.NoError(t, err) {
+				defer c.Close()
+			}
+		}
+	}
+
+	out, code = mmseRun(t, dir, "session", "set", "career.sav", "data.teams[0].budget", "777")
+	assert.Equal(t, 0, code, "Set should succeed: %s", out)
+
+	out, code = mmseRun(t, dir, "session", "get", "career.sav", "data.teams[0].budget")
+
+	if assert.Equal(t, 0, code, "Get should succeed: %s", out) {
+		assert.Equal(t, "777", strings.TrimSpace(out), "Get should see the set value.")
+	}
+
+	out, _ = mmseRun(t, dir, "get", "career.sav", "data.teams[0].budget")
+	assert.NotEqual(t, "777", strings.TrimSpace(out), "The save should be unchanged before commit.")
+
+	out, code = mmseRun(t, dir, "session", "commit", "career.sav")
+
+	if assert.Equal(t, 0, code, "Commit should succeed: %s", out) {
+		out, _ = mmseRun(t, dir, "get", "career.sav", "data.teams[0].budget")
+		assert.Equal(t, "777", strings.TrimSpace(out), "Commit should write the save.")
+	}
+}
+
+func TestCLISessionActivation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Socket activation is a feature of systemd.")
+	}
+
+	dir := t.TempDir()
+	writeFixture(t, dir, "career.sav", mmsetest.Options{})
+
+	// The test sets up the socket as a socket unit of systemd would.
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(dir, "unit.sock"), Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	f, err := l.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	// LISTEN_PID names the process of mmse, which the shell becomes.
+	cmd := mmseCommand(dir, "session", "serve", "career.sav")
+	cmd.Args = append([]string{"sh", "-c", `LISTEN_PID=$$ exec "$0" "$@"`, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+	cmd.Env = append(cmd.Env, "LISTEN_FDS=1")
+	cmd.ExtraFiles = []*os.File{f}
+
+	var stderr bytes.Buffer
+
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+
+	go func() { done <- cmd.Wait() }()
+
+	send := func(req request) reply {
+		c, err := net.Dial("unix", filepath.Join(dir, "unit.sock"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer c.Close()
+
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+
+		var rep reply
+
+		if err := json.NewEncoder(c).Encode(req); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := json.NewDecoder(c).Decode(&rep); err != nil {
+			t.Fatalf("%s: %s", err, stderr.String())
+		}
+
+		return rep
+	}
+
+	rep := send(request{Op: "set", Path: "data.teams[0].budget", Value: json.RawMessage("777")})
+	assert.False(t, rep.Failed, "Set should succeed: %s", rep.Log)
+
+	rep = send(request{Op: "get", Path: "data.teams[0].budget"})
+	assert.Equal(t, "777\n", rep.Output)
+
+	rep = send(request{Op: "commit"})
+	assert.False(t, rep.Failed, "Commit should succeed: %s", rep.Log)
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err, "The session should end after commit: %s", stderr.String())
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("The session did not end after commit.")
+	}
+
+	assert.Contains(t, stderr.String(), "unit.sock")
+
+	socks, _ := filepath.Glob(filepath.Join(dir, "config", "mmse", "sessions", "*.sock"))
+	assert.Empty(t, socks, "The session should not listen on a socket of its own.")
+
+	out, _ := mmseRun(t, dir, "get", "career.sav", "data.teams[0].budget")
+	assert.Equal(t, "777", strings.TrimSpace(out), "Commit should write the save.")
+}
+
+func TestCLIBackupd(t *testing.T) {
+	dir := t.TempDir()
+
+	writeConfig(t, dir, "backup: store\nbackup_keep: 2\nbackup_dir: "+filepath.Join(dir, "store")+"\n")
+	writeFixture(t, dir, "a.sav", mmsetest.Options{})
+	writeFixture(t, dir, "b.sav", mmsetest.Options{Seed: 2})
+
+	// a.sav has two backups older than it, and b.sav one matching it.
+	for _, args := range [][]string{
+		{"set", "a.sav", "data.teams[0].budget", "1"},
+		{"set", "a.sav", "data.teams[0].budget", "2"},
+		{"backup", "create", "b.sav"},
+	} {
+		if out, code := mmseRun(t, dir, args...); code != 0 {
+			t.Fatalf("%s failed with %d: %s", strings.Join(args, " "), code, out)
+		}
+	}
+
+	// Saves are read oldest first, so b.sav is done when a.sav is stored.
+	for i, fn := range []string{"b.sav", "a.sav"} {
+		mt := time.Now().Add(time.Duration(i-2) * time.Minute)
+
+		if err := os.Chtimes(filepath.Join(dir, fn), mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A save written moments ago is left until it settles.
+	writeFixture(t, dir, "new.sav", mmsetest.Options{Seed: 3})
+
+	// stored returns the paths of the stored backups of a save, newest first.
+	stored := func(fn string) []string {
+		out, code := mmseRun(t, dir, "backup", "-output", "json", "list", fn)
+
+		var es []struct {
+			Path   string
+			Stored bool
+		}
+
+		if code != 0 || json.Unmarshal([]byte(out), &es) != nil {
+			t.Fatalf("List failed with %d: %s", code, out)
+		}
+
+		var ps []string
+
+		for _, e := range es {
+			if e.Stored {
+				ps = append(ps, e.Path)
+			}
+		}
+
+		return ps
+	}
+
+	before := stored("b.sav")
+
+	cmd := mmseCommand(dir, "backupd", "-interval", "1h", ".")
+
+	logs, err := cmd.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	done := make(chan string)
+
+	go func() {
+		s := bufio.NewScanner(logs)
+
+		for s.Scan() {
+			if strings.Contains(s.Text(), "Stored a.sav") {
+				done <- s.Text()
+			}
+		}
+
+		close(done)
+	}()
+
+	select {
+	case _, ok := <-done:
+		if !ok {
+			t.Fatal("Backupd exited without storing a.sav.")
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatal("Backupd did not store a.sav.")
+	}
+
+	es := stored("a.sav")
+
+	if assert.Len(t, es, 2, "Backupd should prune to two backups.") {
+		h, _ := hashFile(filepath.Join(dir, "a.sav"))
+		g, _ := gzipHash(es[0])
+
+		assert.Equal(t, h, g, "The newest backup should be the save.")
+	}
+
+	assert.Equal(t, before, stored("b.sav"), "Unchanged saves should not be stored again.")
+	assert.Empty(t, stored("new.sav"), "Saves written moments ago should be left.")
+}
+
+func TestCLICloud(t *testing.T) {
+	dir := t.TempDir()
+
+	// Two careers keep saves of the same name. A second account has two
+	// entries matching the save equally well.
+	caches := map[string][]string{
+		"1": {"Saves/career2/autosave.sav", "Saves/career1/autosave.sav"},
+		"2": {"A/career1/autosave.sav", "B/career1/autosave.sav"},
+	}
+
+	for id, keys := range caches {
+		var b strings.Builder
+
+		b.WriteString("\"415200\"\n{\n")
+
+		for _, k := range keys {
+			fmt.Fprintf(&b, "\t\"%s\"\n\t{\n\t\t\"root\"\t\t\"2\"\n\t\t\"size\"\t\t\"1\"\n\t\t\"sha\"\t\t\"00\"\n\t}\n", k)
+		}
+
+		b.WriteString("}\n")
+
+		d := filepath.Join(dir, "steam", "userdata", id, "415200")
+
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(d, "remotecache.vdf"), []byte(b.String()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, d := range []string{"Saves/career1", "other"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := copyFile(filepath.Join(dir, d, "autosave.sav"), filepath.Join("testdata", "small.sav")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cloud := func(args ...string) (string, int) {
+		return mmseRun(t, dir, append([]string{"cloud", "-steamdir", "steam", "-savedir", "Saves", "-force"}, args...)...)
+	}
+
+	out, code := cloud("status", "career1/autosave.sav")
+
+	if assert.Equal(t, 0, code, "Status should succeed: %s", out) {
+		assert.Contains(t, out, "entry:  Saves/career1/autosave.sav")
+		assert.NotContains(t, out, "career2", "An entry matching only the file name should be ignored.")
+		assert.Contains(t, out, "both A/career1/autosave.sav and B/career1/autosave.sav", "Equal matches should be refused.")
+	}
+
+	out, code = cloud("status", "other/autosave.sav")
+
+	if assert.Equal(t, 0, code, "Status should succeed: %s", out) {
+		assert.Contains(t, out, "not in the Steam Cloud cache")
+	}
+
+	tie, err := os.ReadFile(filepath.Join(dir, "steam", "userdata", "2", "415200", "remotecache.vdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, code = cloud("update", "career1/autosave.sav")
+
+	if !assert.Equal(t, 0, code, "Update should succeed: %s", out) {
+		return
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "steam", "userdata", "1", "415200", "remotecache.vdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 1, strings.Count(string(b), `"00"`), "Only the entry of the save should be updated.")
+
+	b, err = os.ReadFile(filepath.Join(dir, "steam", "userdata", "2", "415200", "remotecache.vdf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, string(tie), string(b), "An ambiguous cache should be left alone.")
+}
+
+// wsDial opens a WebSocket connection to a test server, checking the
+// handshake.
+func wsDial(t *testing.T, srv *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+
+	c, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { c.Close() })
+
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// The key and accept key of the example in RFC 6455.
+	fmt.Fprintf(c, "GET /feed HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", srv.Listener.Addr())
+
+	r := bufio.NewReader(c)
+
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Handshake failed: %s", resp.Status)
+	}
+
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+
+	return c, r
+}
+
+// wsFrame reads a frame from the server, which must not be masked.
+func wsFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	t.Helper()
+
+	var h [2]byte
+
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, byte(0x80), h[0]&0xf0, "Frames should be final.")
+	assert.Zero(t, h[1]&0x80, "Frames from the server should not be masked.")
+
+	n := int(h[1] & 0x7f)
+
+	if n == 126 {
+		var b [2]byte
+
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			t.Fatal(err)
+		}
+
+		n = int(binary.BigEndian.Uint16(b[:]))
+	}
+
+	p := make([]byte, n)
+
+	if _, err := io.ReadFull(r, p); err != nil {
+		t.Fatal(err)
+	}
+
+	return h[0] & 0x0f, p
+}
+
+// wsSend writes a masked frame as a client does.
+func wsSend(t *testing.T, c net.Conn, opcode byte, p []byte) {
+	t.Helper()
+
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	b := append([]byte{0x80 | opcode, 0x80 | byte(len(p))}, mask[:]...)
+
+	for i, x := range p {
+		b = append(b, x^mask[i%4])
+	}
+
+	if _, err := c.Write(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFeedHandshake(t *testing.T) {
+	srv := httptest.NewServer(newFeed())
+	defer srv.Close()
+
+	wsDial(t, srv)
+
+	get := func(h map[string]string) *http.Response {
+		req, err := http.NewRequest("GET", srv.URL+"/feed", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+
+		for k, v := range h {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		return resp
+	}
+
+	resp := get(map[string]string{"Origin": "https://example.com"})
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "Pages of other sites should be refused.")
+
+	resp = get(map[string]string{"Sec-WebSocket-Version": "8"})
+
+	if assert.Equal(t, http.StatusUpgradeRequired, resp.StatusCode, "Other versions should be refused.") {
+		assert.Equal(t, "13", resp.Header.Get("Sec-WebSocket-Version"))
+	}
+
+	resp = get(map[string]string{"Upgrade": ""})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Plain requests should be refused.")
+}
+
+func TestFeedFrames(t *testing.T) {
+	f := newFeed()
+	f.send([]byte(`{"file":"a.sav"}`))
+
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	c, r := wsDial(t, srv)
+
+	op, p := wsFrame(t, r)
+
+	if assert.Equal(t, byte(wsText), op) {
+		assert.Equal(t, `{"file":"a.sav"}`, string(p), "A new client should get the last message.")
+	}
+
+	long := bytes.Repeat([]byte("x"), 300)
+	f.send(long)
+
+	op, p = wsFrame(t, r)
+
+	if assert.Equal(t, byte(wsText), op) {
+		assert.Equal(t, long, p)
+	}
+
+	wsSend(t, c, wsPing, []byte("ping"))
+
+	op, p = wsFrame(t, r)
+
+	if assert.Equal(t, byte(wsPong), op) {
+		assert.Equal(t, "ping", string(p), "Pings should be answered with their unmasked payload.")
+	}
+
+	wsSend(t, c, wsClose, []byte{0x03, 0xe8})
+
+	op, p = wsFrame(t, r)
+
+	if assert.Equal(t, byte(wsClose), op) {
+		assert.Equal(t, []byte{0x03, 0xe8}, p)
+	}
+
+	_, err := r.ReadByte()
+	assert.Equal(t, io.EOF, err, "The server should close the connection after a close frame.")
+
+	// A frame over the limit closes the connection.
+	c, r = wsDial(t, srv)
+	wsFrame(t, r)
+
+	if _, err := c.Write([]byte{0x82, 0xff, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}); err != nil {
+		t.Fatal(err)
+	}
+
+	op, p = wsFrame(t, r)
+
+	if assert.Equal(t, byte(wsClose), op) {
+		assert.Equal(t, []byte{0x03, 0xf1}, p, "An oversized frame should close with status 1009.")
+	}
+
+	_, err = r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestWatchMetrics(t *testing.T) {
+	f := newFeed()
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	// The client is counted once it gets a message.
+	_, r := wsDial(t, srv)
+	f.send([]byte("{}"))
+	wsFrame(t, r)
+
+	ws := newWatchStats(f)
+	ws.add(100, 2*time.Second, nil)
+	ws.add(50, time.Second, nil)
+	ws.add(10, 0, fmt.Errorf("info frame is %w", errNotJSON))
+	ws.add(0, 0, &fs.PathError{Op: "open", Path: "a.sav", Err: fs.ErrNotExist})
+	ws.add(10, 0, errors.New("bad magic number"))
+	ws.add(10, 0, errors.New("truncated frame"))
+
+	rec := httptest.NewRecorder()
+	ws.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, "text/plain; version=0.0.4", rec.Header().Get("Content-Type"))
+
+	want := []string{
+		"# TYPE mmse_watch_saves_read_total counter",
+		"mmse_watch_saves_read_total 2",
+		"mmse_watch_read_bytes_total 150",
+		"mmse_watch_read_seconds_total 3",
+		`mmse_watch_read_errors_total{type="format"} 2`,
+		`mmse_watch_read_errors_total{type="io"} 1`,
+		`mmse_watch_read_errors_total{type="json"} 1`,
+		"# TYPE mmse_watch_feed_clients gauge",
+		"mmse_watch_feed_clients 1",
+	}
+
+	lines := strings.Split(rec.Body.String(), "\n")
+
+	for _, w := range want {
+		assert.Contains(t, lines, w)
+	}
+
+	// Every sample is preceded by its help and type.
+	for i, l := range lines {
+		if l != "" && !strings.HasPrefix(l, "#") {
+			name := strings.FieldsFunc(l, func(r rune) bool { return r == ' ' || r == '{' })[0]
+
+			if i < 2 || !strings.HasPrefix(lines[i-1], "# TYPE "+name+" ") && !strings.HasPrefix(lines[i-1], name) {
+				t.Errorf("%s has no type", l)
+			}
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var (
+		got    []map[string]string
+		status = http.StatusNoContent
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var m map[string]string
+
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			t.Error(err)
+		}
+
+		got = append(got, m)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	e := watchEvent{
+		File: "a.sav",
+		Changes: map[string]change{
+			"money": {json.RawMessage("1"), json.RawMessage("2")},
+			"cars":  {nil, json.RawMessage(`"x"`)},
+		},
+	}
+
+	postWebhook(srv.URL, e.message())
+	postWebhook(srv.URL, watchEvent{File: "b.sav", Error: "bad magic number"}.message())
+
+	long := watchEvent{File: "c.sav", Changes: map[string]change{"name": {nil, json.RawMessage(strings.Repeat("é", 3000))}}}
+	postWebhook(srv.URL, long.message())
+
+	var b bytes.Buffer
+
+	log.SetOutput(&b)
+	defer log.SetOutput(os.Stderr)
+
+	status = http.StatusTooManyRequests
+	postWebhook(srv.URL, "x")
+
+	assert.Contains(t, b.String(), "the webhook refused the message: 429")
+
+	if !assert.Len(t, got, 4) {
+		return
+	}
+
+	assert.Equal(t, map[string]string{"content": "New save **a.sav**\ncars: none -> \"x\"\nmoney: 1 -> 2"}, got[0])
+	assert.Equal(t, map[string]string{"content": "**b.sav** could not be read: bad magic number"}, got[1])
+
+	c := []rune(got[2]["content"])
+
+	if assert.Len(t, c, maxMessage, "Long messages should be cut to the limit of Discord.") {
+		assert.Equal(t, '…', c[len(c)-1])
+	}
+}
+
+func TestCLIAudit(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, dir, "audit: true\n")
+	copyFixture(t, dir, "small.sav")
+
+	if err := os.Mkdir(filepath.Join(dir, "s"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	copyFixture(t, filepath.Join(dir, "s"), "small.sav")
+
+	if err := copyFile(filepath.Join(dir, "s", "copy.sav"), filepath.Join("testdata", "small.sav")); err != nil {
+		t.Fatal(err)
+	}
+
+	mt := time.Now().Add(-time.Hour)
+
+	if err := os.Chtimes(filepath.Join(dir, "s", "small.sav"), mt, mt); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("MMSE_PASSWORD", "secret")
+
+	for _, args := range [][]string{
+		{"lock", "small.sav"},
+		{"unlock", "small.sav.locked"},
+		{"dedupe", "-delete", "s"},
+	} {
+		if out, code := mmseRun(t, dir, args...); code != 0 {
+			t.Fatalf("mmse %s failed: %s", strings.Join(args, " "), out)
+		}
+	}
+
+	out, code := mmseRun(t, dir, "audit", "-output", "json")
+
+	if !assert.Equal(t, 0, code, "Audit should succeed: %s", out) {
+		return
+	}
+
+	var rs []auditRecord
+
+	if err := json.Unmarshal([]byte(out), &rs); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+
+	if !assert.Len(t, rs, 3) {
+		return
+	}
+
+	sum, err := hashFile(filepath.Join("testdata", "small.sav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "lock", rs[0].Command)
+	assert.Equal(t, sum, rs[0].Before)
+	assert.Equal(t, []string{"lock to small.sav.locked"}, rs[0].Ops)
+
+	assert.Equal(t, "unlock", rs[1].Command)
+	assert.Equal(t, sum, rs[1].After, "Unlocking should restore the save.")
+
+	assert.Equal(t, "dedupe", rs[2].Command)
+	assert.Equal(t, filepath.Join(dir, "s", "copy.sav"), rs[2].Save)
+	assert.Equal(t, sum, rs[2].Before)
+	assert.Empty(t, rs[2].After, "A deleted save should have no hash after.")
+}
+
+func TestCLIFields(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "career.sav", mmsetest.Options{Seed: 1})
+
+	// The built-in fields are documented without a fields.yml.
+	out, code := mmseRun(t, dir, "fields", "search", "budget")
+
+	if assert.Equal(t, 0, code, "Search should succeed: %s", out) {
+		assert.Contains(t, out, "data.teams[].budget")
+	}
+
+	out, code = mmseRun(t, dir, "set", "career.sav", "data.teams[0].budget", "3000000000")
+
+	if assert.Equal(t, 0, code, "Set should succeed: %s", out) {
+		assert.Contains(t, out, "clamping 3000000000 to 2000000000")
+		assert.NotContains(t, out, "unknown")
+	}
+
+	out, code = mmseRun(t, dir, "set", "career.sav", "data.teams[0].id", "7")
+	assert.Equal(t, exitFailed, code, "Changing an ID should be refused: %s", out)
+
+	// fields.yml replaces a built-in field and adds another.
+	writeConfig(t, dir, "")
+
+	yml := "- path: data.teams[].budget\n  max: 100\n- path: data.season\n  safety: risky\n"
+
+	if err := os.WriteFile(filepath.Join(dir, "config", "mmse", "fields.yml"), []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code = mmseRun(t, dir, "set", "career.sav", "data.teams[0].budget", "500")
+
+	if assert.Equal(t, 0, code, "Set should succeed: %s", out) {
+		assert.Contains(t, out, "clamping 500 to 100")
+	}
+
+	out, code = mmseRun(t, dir, "fields", "list")
+
+	if assert.Equal(t, 0, code, "List should succeed: %s", out) {
+		assert.Contains(t, out, "data.season")
+		assert.Contains(t, out, "data.drivers[].morale")
+		assert.Equal(t, 1, strings.Count(out, "data.teams[].budget"), "A field should be listed once.")
+	}
+}
+
+func TestCLIHelp(t *testing.T) {
+	dir := t.TempDir()
+
+	out, code := mmseRun(t, dir, "help")
+
+	if !assert.Equal(t, 0, code, "Help should succeed: %s", out) {
+		return
+	}
+
+	// The descriptions of commands and topics start in one column.
+	col := -1
+
+	for _, l := range strings.Split(out, "\n") {
+		if !strings.HasPrefix(l, "\t") || strings.HasPrefix(l, "\tmmse") {
+			continue
+		}
+
+		f := strings.Fields(l)
+		c := strings.Index(l[1+len(f[0]):], f[1]) + 1 + len(f[0])
+
+		if col < 0 {
+			col = c
+		}
+
+		assert.Equal(t, col, c, "The description of %s should line up.", f[0])
+	}
+
+	assert.Contains(t, out, "analyze-rejection list likely reasons")
+
+	for _, c := range sortedCommands() {
+		assert.Contains(t, out, "\t"+c.name+" ", "Help should list %s.", c.name)
+	}
+
+	out, code = mmseRun(t, dir, "help", "unpack")
+
+	if assert.Equal(t, 0, code, "Help should succeed: %s", out) {
+		assert.Contains(t, out, "mmse unpack - ")
+		assert.Contains(t, out, "Examples:")
+	}
+
+	out, code = mmseRun(t, dir, "help", "formats")
+
+	if assert.Equal(t, 0, code, "Help should succeed: %s", out) {
+		assert.Contains(t, out, "magic number 0x")
+	}
+
+	out, code = mmseRun(t, dir, "help", "nonesuch")
+	assert.Equal(t, exitFailed, code, "Help should refuse unknown topics: %s", out)
+
+	out, code = mmseRun(t, dir, "man")
+
+	if !assert.Equal(t, 0, code, "Man should succeed: %s", out) {
+		return
+	}
+
+	assert.True(t, strings.HasPrefix(out, ".TH MMSE 1 "), "Man should write a manual page.")
+	assert.Contains(t, out, `.SS "mmse unpack `)
+	assert.Contains(t, out, ".SH CONFIG\n")
+	assert.NotContains(t, out, ".PP\n.PP\n", "Paragraphs should be separated once.")
+	assert.Equal(t, strings.Count(out, ".RS\n"), strings.Count(out, ".RE\n"), "Indented blocks should be closed.")
+}
+
+func TestCLIInUse(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("open files are found through /proc")
+	}
+
+	dir := t.TempDir()
+	writeFixture(t, dir, "career.sav", mmsetest.Options{Seed: 1})
+
+	// The test holds the save open as the game would.
+	f, err := os.Open(filepath.Join(dir, "career.sav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, code := mmseRun(t, dir, "set", "career.sav", "data.season", "2019")
+
+	if assert.Equal(t, exitFailed, code, "Set should refuse a save in use: %s", out) {
+		assert.Regexp(t, fmt.Sprintf(`career\.sav is open in .*\(pid %d\); close it or use -force`, os.Getpid()), out)
+	}
+
+	out, code = mmseRun(t, dir, "set", "-force", "career.sav", "data.season", "2019")
+	assert.Equal(t, 0, code, "Set should write a save in use with -force: %s", out)
+
+	f.Close()
+
+	out, code = mmseRun(t, dir, "set", "career.sav", "data.season", "2020")
+	assert.Equal(t, 0, code, "Set should write a closed save: %s", out)
+
+	// A running game is only warned about.
+	game := filepath.Join(dir, "MM.x86_64")
+
+	sleep, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep is missing")
+	}
+
+	if err := copyFile(game, sleep); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chmod(game, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(game, "30")
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+
+	out, code = mmseRun(t, dir, "set", "career.sav", "data.season", "2021")
+
+	if assert.Equal(t, 0, code, "Set should write a save while the game runs: %s", out) {
+		assert.Contains(t, out, "Warning: Motorsport Manager is running as MM.x86_64")
+	}
+}
+
+// writeCareer writes saves named save1.sav and up to dir, one per budget of
+// the first team, each written a day after the one before.
+func writeCareer(t *testing.T, dir string, budgets ...int) {
+	t.Helper()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Duration(len(budgets)) * 24 * time.Hour)
+
+	for i, b := range budgets {
+		n := fmt.Sprintf("save%d.sav", i+1)
+		writeFixture(t, dir, n, mmsetest.Options{Seed: 1})
+
+		if out, code := mmseRun(t, dir, "set", "-backup", "none", n, "data.teams[0].budget", strconv.Itoa(b)); code != 0 {
+			t.Fatalf("Set failed with %d: %s", code, out)
+		}
+
+		mt := start.Add(time.Duration(i) * 24 * time.Hour)
+
+		if err := os.Chtimes(filepath.Join(dir, n), mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCLIStats(t *testing.T) {
+	dir := t.TempDir()
+	writeCareer(t, filepath.Join(dir, "saves"), 100, 300)
+	writeConfig(t, dir, "metrics:\n  season: data.season\n")
+
+	out, code := mmseRun(t, dir, "stats", "-metric", "budget=data.teams[0].budget,missing=data.nonesuch", "saves")
+
+	if !assert.Equal(t, 0, code, "Stats should succeed: %s", out) {
+		return
+	}
+
+	ls := strings.Split(strings.TrimSpace(out), "\n")
+
+	if assert.Len(t, ls, 3) {
+		assert.Equal(t, "file,modified,day,budget,missing,season", ls[0])
+		assert.Regexp(t, `^save1\.sav,[^,]+,0\.00,100,,2018$`, ls[1])
+		assert.Regexp(t, `^save2\.sav,[^,]+,1\.00,300,,2018$`, ls[2])
+	}
+
+	out, code = mmseRun(t, dir, "stats", "-output", "json", "-metric", "budget=data.teams[0].budget", "saves")
+
+	var rs []map[string]interface{}
+
+	if assert.Equal(t, 0, code, "Stats should succeed: %s", out) && assert.NoError(t, json.Unmarshal([]byte(out), &rs), out) {
+		assert.Len(t, rs, 2)
+	}
+}
+
+func TestCLIPlot(t *testing.T) {
+	dir := t.TempDir()
+	writeCareer(t, filepath.Join(dir, "saves"), 100, 300, 200)
+
+	out, code := mmseRun(t, dir, "plot", "-metric", "budget=data.teams[0].budget", "-output", "vega", "saves")
+
+	if !assert.Equal(t, 0, code, "Plot should succeed: %s", out) {
+		return
+	}
+
+	var spec struct {
+		Data struct {
+			Values []struct {
+				Save  int     `json:"save"`
+				File  string  `json:"file"`
+				Value float64 `json:"value"`
+			} `json:"values"`
+		} `json:"data"`
+	}
+
+	if assert.NoError(t, json.Unmarshal([]byte(out), &spec), out) && assert.Len(t, spec.Data.Values, 3) {
+		for i, v := range []float64{100, 300, 200} {
+			assert.Equal(t, i+1, spec.Data.Values[i].Save)
+			assert.Equal(t, v, spec.Data.Values[i].Value)
+		}
+	}
+
+	out, code = mmseRun(t, dir, "plot", "-metric", "budget=data.teams[0].budget", "-o", "budget.svg", "saves")
+
+	if !assert.Equal(t, 0, code, "Plot should succeed: %s", out) {
+		return
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "budget.svg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(b))
+
+	for {
+		if _, err := d.Token(); err == io.EOF {
+			break
+		} else if !assert.NoError(t, err, "The chart should be well-formed SVG.") {
+			break
+		}
+	}
+
+	assert.Contains(t, string(b), ">budget</text>")
+
+	out, code = mmseRun(t, dir, "plot", "-metric", "name=data.teams[0].name", "saves")
+	assert.NotEqual(t, 0, code, "Plot should refuse a metric without numbers: %s", out)
+}
+
+func TestCLISearch(t *testing.T) {
+	dir := t.TempDir()
+	copyFixture(t, dir, "small.sav")
+
+	out, code := mmseRun(t, dir, "search", "small.sav", "Driver 3")
+
+	if assert.Equal(t, 0, code, "Search should succeed: %s", out) {
+		assert.Regexp(t, `data\.drivers\[3\]\.name +value +"Driver 3"`, out)
+		assert.NotContains(t, out, "data.drivers[13]", "Search should not print paths without the pattern.")
+	}
+
+	out, code = mmseRun(t, dir, "search", "small.sav", "driver 3")
+	assert.Equal(t, exitFailed, code, "Search should fail when nothing matches: %s", out)
+
+	out, code = mmseRun(t, dir, "search", "-i", "small.sav", "driver 3")
+	assert.Equal(t, 0, code, "Search should ignore case with -i: %s", out)
+
+	out, code = mmseRun(t, dir, "search", "-output", "json", "-regexp", "small.sav", `^T0[12]$`)
+
+	var rs []map[string]interface{}
+
+	if assert.Equal(t, 0, code, "Search should succeed: %s", out) && assert.NoError(t, json.Unmarshal([]byte(out), &rs), out) {
+		if assert.Len(t, rs, 2) {
+			assert.Equal(t, "data.teams[1].shortName", rs[0]["path"])
+			assert.Equal(t, "data.teams[2].shortName", rs[1]["path"])
+		}
+	}
+
+	out, code = mmseRun(t, dir, "search", "small.sav", "shortName")
+
+	if assert.Equal(t, 0, code, "Search should succeed: %s", out) {
+		assert.Regexp(t, `data\.teams\[0\]\.shortName +key`, out, "Search should match keys.")
+	}
+}
+
+func TestCLIXref(t *testing.T) {
+	dir := t.TempDir()
+	copyFixture(t, dir, "small.sav")
+
+	xref := func(args ...string) (defs, refs []string) {
+		out, code := mmseRun(t, dir, append([]string{"xref", "-output", "json"}, args...)...)
+
+		var rs []map[string]string
+
+		if assert.Equal(t, 0, code, "Xref should succeed: %s", out) && assert.NoError(t, json.Unmarshal([]byte(out), &rs), out) {
+			for _, r := range rs {
+				if r["kind"] == "definition" {
+					defs = append(defs, r["path"])
+				} else {
+					refs = append(refs, r["path"])
+				}
+			}
+		}
+
+		return defs, refs
+	}
+
+	defs, refs := xref("small.sav", "1")
+
+	assert.Equal(t, []string{"data.teams[1].id", "data.drivers[1].id"}, defs)
+
+	if assert.NotEmpty(t, refs) {
+		for _, r := range refs {
+			assert.Regexp(t, `^data\.drivers\[\d+\]\.team$`, r, "Only whole values should match.")
+		}
+	}
+
+	// Keys in id_keys define entities too.
+	writeConfig(t, dir, "id_keys: [id, team]\n")
+
+	defs2, refs2 := xref("small.sav", "1")
+
+	assert.Empty(t, refs2)
+	assert.Len(t, defs2, len(defs)+len(refs))
+
+	// IDs may be strings.
+	_, refs = xref("small.sav", "T01")
+	assert.Equal(t, []string{"data.teams[1].shortName"}, refs)
+
+	out, code := mmseRun(t, dir, "xref", "small.sav", "999")
+	assert.Equal(t, exitFailed, code, "Xref should fail for an unused ID: %s", out)
+}
+
+func TestCLIUnpackAll(t *testing.T) {
+	dir := t.TempDir()
+	saves := filepath.Join(dir, "saves")
+
+	if err := os.Mkdir(saves, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFixture(t, saves, "a.sav", mmsetest.Options{Seed: 1})
+	writeFixture(t, saves, "b.sav", mmsetest.Options{Seed: 2})
+
+	all := func(want string, args ...string) {
+		t.Helper()
+
+		out, code := mmseRun(t, dir, append([]string{"unpack", "-all", "-savedir", "saves"}, args...)...)
+
+		if assert.Equal(t, 0, code, "Unpack should succeed: %s", out) {
+			assert.Contains(t, out, want)
+		}
+	}
+
+	all("Unpacked 2 saves, skipped 0 unchanged, 0 failed")
+	assert.FileExists(t, filepath.Join(dir, ".mmse-unpack.json"))
+	assert.FileExists(t, filepath.Join(dir, "a_data.json"))
+
+	all("Unpacked 0 saves, skipped 2 unchanged, 0 failed")
+
+	// A changed save is unpacked again, overwriting its documents.
+	writeFixture(t, saves, "a.sav", mmsetest.Options{Seed: 3})
+	all("Unpacked 1 saves, skipped 1 unchanged, 0 failed")
+
+	// So is a save whose documents are gone.
+	if err := os.Remove(filepath.Join(dir, "b_info.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	all("Unpacked 1 saves, skipped 1 unchanged, 0 failed")
+
+	// Other output flags unpack every save.
+	all("Unpacked 2 saves, skipped 0 unchanged, 0 failed", "-format", "yaml")
+	assert.FileExists(t, filepath.Join(dir, "a_data.yaml"))
+
+	copyFixture(t, saves, "badmagic.sav")
+
+	out, code := mmseRun(t, dir, "unpack", "-all", "-savedir", "saves", "-format", "yaml")
+
+	if assert.Equal(t, exitFailed, code, "Unpack should report a failed save: %s", out) {
+		assert.Contains(t, out, "Unpacked 0 saves, skipped 2 unchanged, 1 failed")
+	}
+
+	out, code = mmseRun(t, dir, "unpack", "-all", "a.sav")
+	assert.Equal(t, exitUsage, code, "-all should take no saves: %s", out)
+}
+
+func TestCLIAliases(t *testing.T) {
+	dir := t.TempDir()
+	writeFixture(t, dir, "career.sav", mmsetest.Options{Seed: 1})
+	writeConfig(t, dir, "aliases:\n  money: data.teams[0].budget\n  driver1: data.drivers[1]\n")
+
+	if out, code := mmseRun(t, dir, "set", "career.sav", "money", "4242"); code != 0 {
+		t.Fatalf("Set failed with %d: %s", code, out)
+	}
+
+	out, code := mmseRun(t, dir, "get", "career.sav", "data.teams[0].budget")
+
+	if assert.Equal(t, 0, code, "Get should succeed: %s", out) {
+		assert.Equal(t, "4242", strings.TrimSpace(out), "Set should write through the alias.")
+	}
+
+	out, code = mmseRun(t, dir, "get", "career.sav", "money")
+
+	if assert.Equal(t, 0, code, "Get should succeed: %s", out) {
+		assert.Equal(t, "4242", strings.TrimSpace(out))
+	}
+
+	// The rest of a path may follow an alias.
+	out, code = mmseRun(t, dir, "get", "career.sav", "driver1.name")
+
+	if assert.Equal(t, 0, code, "Get should succeed: %s", out) {
+		assert.Equal(t, `"Driver 1"`, strings.TrimSpace(out))
+	}
+
+	out, code = mmseRun(t, dir, "stats", "-metric", "m=money", ".")
+
+	if assert.Equal(t, 0, code, "Stats should succeed: %s", out) {
+		assert.Contains(t, out, ",4242\n")
+	}
+
+	for yml, msg := range map[string]string{
+		"aliases:\n  data: info.saveName\n": "Alias data hides the data document",
+		"aliases:\n  money: teams[0]\n":     "Alias money does not start with info or data",
+	} {
+		writeConfig(t, dir, yml)
+
+		out, code = mmseRun(t, dir, "get", "career.sav", "money")
+
+		if assert.Equal(t, exitFailed, code, "A bad alias should be refused: %s", out) {
+			assert.Contains(t, out, msg)
+		}
+	}
+}
+
+func TestCLIRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, n := range []string{"a.sav", "b.sav", "c.sav"} {
+		writeFixture(t, dir, n, mmsetest.Options{Seed: 1})
+	}
+
+	get := func(fn string) string {
+		out, _ := mmseRun(t, dir, "get", fn, "data.teams[0].budget")
+		return strings.TrimSpace(out)
+	}
+
+	before := get("a.sav")
+
+	record := func(input string, args ...string) (string, int) {
+		cmd := mmseCommand(dir, append([]string{"record"}, args...)...)
+		cmd.Stdin = strings.NewReader(input)
+
+		out, err := cmd.CombinedOutput()
+
+		if e, ok := err.(*exec.ExitError); ok {
+			return string(out), e.ExitCode()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		return string(out), 0
+	}
+
+	// Failed operations are reported and left out of the script.
+	out, code := record(
+		"get data.teams[0].budget\nset data.teams[0].budget 555\nset data.teams[0].nonesuch[3] 1\n"+
+			"bogus\nset data.drivers[0].morale 0.5\nquit\n",
+		"a.sav", "season.mmse",
+	)
+
+	if !assert.Equal(t, 0, code, "Record should succeed: %s", out) {
+		return
+	}
+
+	assert.Contains(t, out, "> "+before+"\n")
+	assert.Contains(t, out, `unknown operation "bogus"`)
+	assert.Contains(t, out, "Recorded 3 operations to season.mmse")
+	assert.Equal(t, "555", get("a.sav"))
+
+	b, err := os.ReadFile(filepath.Join(dir, "season.mmse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "# Recorded by mmse from a.sav.\nget data.teams[0].budget\n"+
+		"set data.teams[0].budget 555\nset data.drivers[0].morale 0.5\n", string(b))
+
+	out, code = mmseRun(t, dir, "replay", "season.mmse", "b.sav", "c.sav")
+
+	if assert.Equal(t, 0, code, "Replay should succeed: %s", out) {
+		assert.Contains(t, out, "b.sav: "+before+"\n")
+		assert.Contains(t, out, "c.sav: "+before+"\n")
+		assert.Equal(t, "555", get("b.sav"))
+		assert.Equal(t, "555", get("c.sav"))
+	}
+
+	// Abort leaves the save and the script alone.
+	out, code = record("set data.teams[0].budget 1\nabort\n", "a.sav", "other.mmse")
+
+	if assert.Equal(t, 0, code, "Record should succeed: %s", out) {
+		assert.Equal(t, "555", get("a.sav"))
+		assert.NoFileExists(t, filepath.Join(dir, "other.mmse"))
+	}
+
+	// A save is changed by all the operations of a script or none.
+	if err := os.WriteFile(filepath.Join(dir, "bad.mmse"), []byte("set data.teams[0].budget 1\nset data.teams[0].id 9\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code = mmseRun(t, dir, "replay", "bad.mmse", "b.sav")
+
+	if assert.Equal(t, exitFailed, code, "Replay should fail: %s", out) {
+		assert.Contains(t, out, "Unable to replay bad.mmse on b.sav")
+		assert.Equal(t, "555", get("b.sav"))
+	}
+}
+
+func TestCLIBaseline(t *testing.T) {
+	dir := t.TempDir()
+	copyFixture(t, dir, "small.sav")
+
+	if out, code := mmseRun(t, dir, "unpack", "small.sav"); code != 0 {
+		t.Fatalf("Unpack failed with %d: %s", code, out)
+	}
+
+	// The update renames a field of the teams, retypes the season, and adds
+	// the weather.
+	fn := filepath.Join(dir, "small_data.json")
+
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := strings.ReplaceAll(string(b), `"reputation":`, `"prestige":`)
+	s = strings.Replace(s, `"season":2018`, `"season":"2018"`, 1)
+	s = `{"weather":[1],` + strings.TrimPrefix(s, "{")
+
+	if err := os.WriteFile(fn, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, code := mmseRun(t, dir, "pack", "-o", "updated.sav", "small_info.json", "small_data.json"); code != 0 {
+		t.Fatalf("Pack failed with %d: %s", code, out)
+	}
+
+	out, code := mmseRun(t, dir, "baseline", "capture", "v1", "small.sav")
+
+	if !assert.Equal(t, 0, code, "Capture should succeed: %s", out) {
+		return
+	}
+
+	assert.FileExists(t, filepath.Join(dir, "config", "mmse", "baselines", "v1.json"))
+
+	out, code = mmseRun(t, dir, "baseline", "list")
+
+	if assert.Equal(t, 0, code, "List should succeed: %s", out) {
+		assert.Contains(t, out, "v1")
+	}
+
+	out, code = mmseRun(t, dir, "baseline", "diff", "v1", "small.sav")
+	assert.Equal(t, 0, code, "A save should not differ from its baseline: %s", out)
+
+	out, code = mmseRun(t, dir, "baseline", "diff", "v1", "updated.sav")
+
+	if assert.Equal(t, exitFailed, code, "Diff should report the changes: %s", out) {
+		assert.Equal(t, "~ data.season: number to string\n"+
+			"> data.teams[].reputation renamed to data.teams[].prestige\n"+
+			"+ data.weather (array)\n"+
+			"+ data.weather[] (number)\n", out)
+	}
+
+	out, code = mmseRun(t, dir, "baseline", "diff", "v2", "updated.sav")
+	assert.Equal(t, exitFailed, code, "Diff should refuse a missing baseline: %s", out)
+}
+
+func TestCLIGzip(t *testing.T) {
+	dir := t.TempDir()
+	copyFixture(t, dir, "small.sav")
+
+	out, code := mmseRun(t, dir, "unpack", "-gzipoutput", "small.sav")
+
+	if !assert.Equal(t, 0, code, "Unpack should succeed: %s", out) {
+		return
+	}
+
+	assert.NoFileExists(t, filepath.Join(dir, "small_data.json"))
+
+	s, err := mmse.ReadSaveFile(bufio.NewReader(bytes.NewReader(readFixture(t, "small.sav"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []struct {
+		fn   string
+		want []byte
+	}{{"small_info.json.gz", s.Info.Bytes()}, {"small_data.json.gz", s.Data.Bytes()}} {
+		f, err := os.Open(filepath.Join(dir, d.fn))
+		if !assert.NoError(t, err, "Unpack should write %s.", d.fn) {
+			continue
+		}
+
+		r, err := gzip.NewReader(f)
+
+		if assert.NoError(t, err, "%s should be gzip-compressed.", d.fn) {
+			b, err := io.ReadAll(r)
+
+			if assert.NoError(t, err) {
+				assert.Equal(t, d.want, b, "%s should hold the decoded document.", d.fn)
+			}
+		}
+
+		f.Close()
+	}
+
+	out, code = mmseRun(t, dir, "pack", "small_info.json.gz", "small_data.json.gz")
+
+	if !assert.Equal(t, 0, code, "Pack should succeed: %s", out) {
+		return
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "small_data.sav"))
+
+	if assert.NoError(t, err, "Pack should write small_data.sav.") {
+		assert.Equal(t, readFixture(t, "small.sav"), got, "Pack should read gzip-compressed documents.")
+	}
+
+	// A gzip-compressed document may be packed with a plain one.
+	if err := os.Remove(filepath.Join(dir, "small_data.sav")); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code = mmseRun(t, dir, "unpack", "-o", "plain", "small.sav")
+
+	if !assert.Equal(t, 0, code, "Unpack should succeed: %s", out) {
+		return
+	}
+
+	out, code = mmseRun(t, dir, "pack", "small_info.json.gz", filepath.Join("plain", "small_data.json"))
+
+	if assert.Equal(t, 0, code, "Pack should succeed: %s", out) {
+		got, err := os.ReadFile(filepath.Join(dir, "small_data.sav"))
+
+		if assert.NoError(t, err, "Pack should write small_data.sav.") {
+			assert.Equal(t, readFixture(t, "small.sav"), got)
+		}
+	}
+}
+
+func TestCLIDoc(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig(t, dir, "")
+
+	yml := "- path: data.weather\n  type: string\n  meaning: rain | dry <wet>\n"
+
+	if err := os.WriteFile(filepath.Join(dir, "config", "mmse", "fields.yml"), []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code := mmseRun(t, dir, "doc", "format")
+
+	if !assert.Equal(t, 0, code, "Doc should succeed: %s", out) {
+		return
+	}
+
+	assert.True(t, strings.HasPrefix(out, "# Motorsport Manager save format\n"))
+	assert.Contains(t, out, fmt.Sprintf("| 0 | 4 | magic | magic number %#08x", uint32(mmse.Magic)))
+	assert.Contains(t, out, fmt.Sprintf("| 4 | 4 | version | version number %d |", mmse.Ver))
+	assert.Contains(t, out, "| data.teams[].budget | number | 0 to 2000000000 | safe | budget of the team |")
+	assert.Contains(t, out, `| data.weather | string |  |  | rain \| dry <wet> |`, "Cells should escape pipes.")
+
+	out, code = mmseRun(t, dir, "doc", "-html", "format")
+
+	if !assert.Equal(t, 0, code, "Doc should succeed: %s", out) {
+		return
+	}
+
+	assert.True(t, strings.HasPrefix(out, "<!DOCTYPE html>\n"))
+	assert.Contains(t, out, "<td>rain | dry &lt;wet&gt;</td>", "HTML should be escaped.")
+	assert.Contains(t, out, "<h2>Fields</h2>")
+
+	d := xml.NewDecoder(strings.NewReader(strings.TrimPrefix(out, "<!DOCTYPE html>\n")))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+
+	for {
+		if _, err := d.Token(); err == io.EOF {
+			break
+		} else if !assert.NoError(t, err, "The HTML should be well-formed.") {
+			break
+		}
+	}
+}
+
+func TestCLIAnalyzeRejection(t *testing.T) {
+	dir := t.TempDir()
+	copyFixture(t, dir, "small.sav", "trailing.sav", "truncated.sav", "badmagic.sav")
+
+	out, code := mmseRun(t, dir, "analyze-rejection", "small.sav")
+
+	if assert.Equal(t, 0, code, "A clean save should pass: %s", out) {
+		assert.Contains(t, out, "no likely reasons found")
+	}
+
+	// Unlikely reasons are listed without failing.
+	out, code = mmseRun(t, dir, "analyze-rejection", "trailing.sav")
+
+	if assert.Equal(t, 0, code, "Trailing bytes should not fail: %s", out) {
+		assert.Regexp(t, `\d+ +layout +trailing: 4 bytes after the data frame`, out)
+	}
+
+	out, code = mmseRun(t, dir, "analyze-rejection", "truncated.sav")
+
+	if assert.Equal(t, exitFailed, code, "A truncated save should fail: %s", out) {
+		assert.Contains(t, out, "10 of ")
+		assert.Contains(t, out, "bytes missing")
+	}
+
+	out, code = mmseRun(t, dir, "analyze-rejection", "-output", "json", "badmagic.sav")
+
+	var fs []struct {
+		Score   int    `json:"score"`
+		Check   string `json:"check"`
+		Finding string `json:"finding"`
+	}
+
+	if assert.Equal(t, exitFailed, code, "A bad magic number should fail: %s", out) && assert.NoError(t, json.Unmarshal([]byte(out), &fs), out) {
+		if assert.NotEmpty(t, fs) {
+			assert.Equal(t, "header", fs[0].Check)
+			assert.Equal(t, 100, fs[0].Score)
+		}
+	}
+
+	// Values beyond the documented fields and dangling references, the most
+	// likely first.
+	patch := `[{"op": "replace", "path": "/data/drivers/0/morale", "value": 5},
+		{"op": "add", "path": "/data/drivers/0/teamID", "value": 99}]`
+
+	if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte(patch), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, code := mmseRun(t, dir, "apply-patch", "broken.json", "small.sav"); code != 0 {
+		t.Fatalf("Apply-patch failed with %d: %s", code, out)
+	}
+
+	out, code = mmseRun(t, dir, "analyze-rejection", "small.sav")
+
+	if assert.Equal(t, exitFailed, code, "A value beyond its range should fail: %s", out) {
+		v := strings.Index(out, "data.drivers[].morale: values beyond the documented range, such as 5")
+		r := strings.Index(out, "data.drivers[].teamID: values matching no entity ID, such as 99")
+
+		if assert.True(t, v >= 0 && r >= 0, out) {
+			assert.Less(t, v, r, "The findings should be sorted by score.")
+		}
+	}
+}
+
+func TestCLISetMany(t *testing.T) {
+	dir := t.TempDir()
+	save := writeFixture(t, dir, "career.sav", mmsetest.Options{Seed: 1})
+
+	// The second change fails, so neither is made.
+	out, code := mmseRun(t, dir, "set", "-backup", "none", "career.sav",
+		"data.teams[0].budget", "1", "data.teams[0].nonesuch[2]", "2")
+
+	if assert.Equal(t, exitFailed, code, "Set should fail: %s", out) {
+		b, err := os.ReadFile(filepath.Join(dir, "career.sav"))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, save, b, "A failed change should leave the save unchanged.")
+		}
+	}
+
+	out, code = mmseRun(t, dir, "set", "-backup", "none", "career.sav",
+		"data.teams[0].budget", "1", "data.teams[1].name", "Predator Racing", "data.season", "2019")
+
+	if !assert.Equal(t, 0, code, "Set should succeed: %s", out) {
+		return
+	}
+
+	for p, want := range map[string]string{
+		"data.teams[0].budget": "1",
+		"data.teams[1].name":   `"Predator Racing"`,
+		"data.season":          "2019",
+	} {
+		out, _ := mmseRun(t, dir, "get", "career.sav", p)
+		assert.Equal(t, want, strings.TrimSpace(out), "Set should change %s.", p)
+	}
+
+	ms, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	assert.Empty(t, ms, "The temporary save should be renamed over the save.")
+
+	out, code = mmseRun(t, dir, "set", "career.sav", "data.season", "2020", "data.teams[0].budget")
+	assert.Equal(t, exitUsage, code, "A path without a value should be refused: %s", out)
+}
+
+func TestCLITimeline(t *testing.T) {
+	dir := t.TempDir()
+	saves := filepath.Join(dir, "saves")
+
+	if err := os.Mkdir(saves, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// s4 reloads s2, whose continuation is s3, and s6 continues s4.
+	dates := []string{"2018-01-01", "2018-02-01", "2018-03-01", "2018-02-15", "2018-03-10", "2018-02-20"}
+	start := time.Now().Add(-24 * time.Hour)
+
+	for i, d := range dates {
+		n := fmt.Sprintf("s%d.sav", i+1)
+		copyFixture(t, saves, "small.sav")
+
+		if err := os.Rename(filepath.Join(saves, "small.sav"), filepath.Join(saves, n)); err != nil {
+			t.Fatal(err)
+		}
+
+		patch := fmt.Sprintf(`[{"op": "add", "path": "/info/gameDate", "value": %q}]`, d)
+
+		if err := os.WriteFile(filepath.Join(dir, "date.json"), []byte(patch), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if out, code := mmseRun(t, dir, "apply-patch", "-backup", "none", "date.json", filepath.Join("saves", n)); code != 0 {
+			t.Fatalf("Apply-patch failed with %d: %s", code, out)
+		}
+
+		mt := start.Add(time.Duration(i) * time.Hour)
+
+		if err := os.Chtimes(filepath.Join(saves, n), mt, mt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	copyFixture(t, saves, "small.sav")
+	writeConfig(t, dir, "date_path: gameDate\n")
+
+	out, code := mmseRun(t, dir, "timeline", "-output", "json", "saves")
+
+	if !assert.Equal(t, 0, code, "Timeline should succeed: %s", out) {
+		return
+	}
+
+	assert.Contains(t, out, "small.sav has no in-game date at gameDate")
+
+	var es []struct {
+		Save   string `json:"save"`
+		Date   string `json:"date"`
+		Parent string `json:"parent"`
+		Depth  int    `json:"depth"`
+		Branch bool   `json:"branch"`
+	}
+
+	// The warning goes to standard error, before the JSON.
+	if !assert.NoError(t, json.Unmarshal([]byte(out[strings.Index(out, "["):]), &es), out) || !assert.Len(t, es, 6) {
+		return
+	}
+
+	for i, want := range []struct {
+		parent string
+		depth  int
+		branch bool
+	}{{"", 0, false}, {"s1.sav", 0, false}, {"s2.sav", 0, false}, {"s2.sav", 1, true}, {"s3.sav", 0, false}, {"s4.sav", 1, false}} {
+		assert.Equal(t, fmt.Sprintf("s%d.sav", i+1), es[i].Save)
+		assert.Equal(t, dates[i], es[i].Date)
+		assert.Equal(t, want.parent, es[i].Parent, "Parent of %s", es[i].Save)
+		assert.Equal(t, want.depth, es[i].Depth, "Depth of %s", es[i].Save)
+		assert.Equal(t, want.branch, es[i].Branch, "Branch of %s", es[i].Save)
+	}
+
+	out, code = mmseRun(t, dir, "timeline", "saves")
+
+	if assert.Equal(t, 0, code, "Timeline should succeed: %s", out) {
+		assert.Regexp(t, `\n  s4\.sav +2018-02-15 +[-0-9]+ [0-9:]+ +s2\.sav\n`, out, "A branch should be indented with its parent.")
+	}
+}
+
+func TestCLIInspect(t *testing.T) {
+	dir := t.TempDir()
+	copyFixture(t, dir, "padded.sav", "truncated.sav")
+
+	out, code := mmseRun(t, dir, "inspect", "-hexdump", "20", "padded.sav")
+
+	if !assert.Equal(t, 0, code, "Inspect should succeed: %s", out) {
+		return
+	}
+
+	assert.Contains(t, out, "magic\t\t0x73326d6d (ok)")
+	assert.Regexp(t, `\n24 +4 +padding\n00000018  00 00 00 00 `, out)
+
+	// The dump of a region gives the offsets in the file, also past its first
+	// line.
+	assert.Regexp(t, `\n28 +110 +info frame\n0000001c  [0-9a-f ]+\|.*\|\n0000002c  `, out)
+
+	out, code = mmseRun(t, dir, "inspect", "-offsets", "truncated.sav")
+
+	if assert.Equal(t, 0, code, "Inspect should succeed: %s", out) {
+		assert.Regexp(t, `data frame \(truncated, 10 bytes missing\)`, out)
+		assert.NotContains(t, out, "|", "Only -hexdump should dump bytes.")
+	}
+}
+
+func TestParallel(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	failed := parallel(6, func(i int) {
+		if i%2 == 1 {
+			log.Panicf("call %d failed", i)
+		}
+	})
+
+	assert.Equal(t, []int{1, 3, 5}, failed, "Parallel should return the calls failing with log.Panicf.")
+}
+
+func TestTry(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	assert.True(t, try(func() {}), "Try should report success.")
+	assert.False(t, try(func() { log.Panicf("failed") }), "Try should report errors from log.Panicf.")
+
+	assert.Panics(t, func() {
+		var m map[string]int
+
+		try(func() { m["x"] = 1 })
+	}, "Try should not hide runtime errors.")
+}
+
+func TestUnitQuote(t *testing.T) {
+	for in, want := range map[string]string{
+		"/usr/bin/mmse":     `"/usr/bin/mmse"`,
+		`/home/a b/saves`:   `"/home/a b/saves"`,
+		`C:\saves`:          `"C:\\saves"`,
+		`say "hi"`:          `"say \"hi\""`,
+		"100%":              `"100%%"`,
+		"/home/$USER/saves": `"/home/$$USER/saves"`,
+		"${HOME}/$$":        `"$${HOME}/$$$$"`,
+	} {
+		assert.Equal(t, want, unitQuote(in), "unitQuote(%q)", in)
+	}
+}
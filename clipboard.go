@@ -0,0 +1,124 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// clipTool is a pair of programs copying standard input to the clipboard and
+// pasting the clipboard to standard output.
+type clipTool struct {
+	copy, paste []string
+}
+
+// clipTools returns the clipboard programs of the platform in order of
+// preference.
+func clipTools() []clipTool {
+	switch runtime.GOOS {
+	case "windows":
+		// clip.exe does not read UTF-8, so PowerShell is used both ways.
+		ps := []string{"powershell", "-NoProfile", "-NonInteractive", "-Command"}
+
+		return []clipTool{{
+			copy: append(ps[:4:4], "[Console]::InputEncoding = [Text.Encoding]::UTF8; "+
+				"Set-Clipboard -Value ([Console]::In.ReadToEnd())"),
+			paste: append(ps[:4:4], "[Console]::OutputEncoding = [Text.Encoding]::UTF8; "+
+				"Get-Clipboard -Raw"),
+		}}
+	case "darwin":
+		return []clipTool{{[]string{"pbcopy"}, []string{"pbpaste"}}}
+	}
+
+	ts := []clipTool{
+		{[]string{"xclip", "-selection", "clipboard"}, []string{"xclip", "-selection", "clipboard", "-o"}},
+		{[]string{"xsel", "--clipboard", "--input"}, []string{"xsel", "--clipboard", "--output"}},
+	}
+
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		ts = append([]clipTool{{[]string{"wl-copy"}, []string{"wl-paste", "-n"}}}, ts...)
+	}
+
+	return ts
+}
+
+// findClipTool returns the first clipboard program found.
+func findClipTool() (clipTool, error) {
+	ts := clipTools()
+
+	for _, t := range ts {
+		if _, err := exec.LookPath(t.copy[0]); err == nil {
+			return t, nil
+		}
+	}
+
+	names := make([]string, len(ts))
+
+	for i, t := range ts {
+		names[i] = t.copy[0]
+	}
+
+	return clipTool{}, fmt.Errorf("no clipboard program found; install one of %v", names)
+}
+
+// copyClipboard copies a JSON value to the clipboard. Strings are copied
+// without quotes, so that they paste as text.
+func copyClipboard(v []byte) error {
+	t, err := findClipTool()
+	if err != nil {
+		return err
+	}
+
+	var s string
+
+	if err := json.Unmarshal(v, &s); err == nil {
+		v = []byte(s)
+	}
+
+	cmd := exec.Command(t.copy[0], t.copy[1:]...)
+	cmd.Stdin = bytes.NewReader(v)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s: %s", t.copy[0], err, out)
+	}
+
+	return nil
+}
+
+// pasteClipboard returns the text on the clipboard without the line breaks
+// that spreadsheets add after a cell.
+func pasteClipboard() (string, error) {
+	t, err := findClipTool()
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command(t.paste[0], t.paste[1:]...)
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", t.paste[0], err)
+	}
+
+	return string(bytes.TrimRight(out, "\r\n")), nil
+}
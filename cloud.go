@@ -0,0 +1,394 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/mys721tx/mmse-go/pkg/vdf"
+)
+
+// appID is the Steam application ID of Motorsport Manager.
+const appID = "415200"
+
+func init() {
+	register(&command{
+		name:  "cloud",
+		args:  "status|update <game.sav>",
+		short: "compare a save with the Steam Cloud cache",
+		long: `
+Steam records the size and SHA-1 of every file it syncs in remotecache.vdf.
+When a save is edited while Steam is closed, Steam notices the mismatch on the
+next start and may replace the edited save with the copy in the cloud, or ask
+which copy to keep.
+
+Status finds the entry of the save in the cache of every Steam account on the
+machine and reports whether it matches the save. An entry must match the path
+of the save below the save directory, or its file name and directory for saves
+elsewhere; a cache with two such entries is left alone. Pack prints the same warning
+after writing a save that the cache records differently.
+
+Update records the size, SHA-1, and modification time of the save in the cache
+so that Steam treats the edited save as the synced one and uploads it. Close
+Steam before running update; Steam rewrites the cache when it exits. The cache
+is copied to remotecache.vdf.bak first.
+
+Steam is looked up in its default install locations, or in the directory given
+by -steamdir or steam_dir in the configuration file.`,
+		example: `
+mmse cloud status game.sav
+mmse cloud update game.sav`,
+		flags: func(fs *flag.FlagSet) {
+			flagSteamDir(fs)
+			flagSaveDir(fs)
+			flagForce(fs)
+		},
+		nargs: exactly(2),
+		run:   runCloud,
+	})
+}
+
+// flagSteamDir registers the flag selecting the Steam directory.
+func flagSteamDir(fs *flag.FlagSet) {
+	fs.StringVar(
+		&cfg.SteamDir, "steamdir", cfg.SteamDir,
+		"directory of the Steam installation",
+	)
+}
+
+// steamDirs returns the candidate Steam installation directories.
+func steamDirs() []string {
+	if cfg.SteamDir != "" {
+		return []string{cfg.SteamDir}
+	}
+
+	h, _ := os.UserHomeDir()
+
+	switch runtime.GOOS {
+	case "windows":
+		return []string{
+			filepath.Join(os.Getenv("ProgramFiles(x86)"), "Steam"),
+			filepath.Join(os.Getenv("ProgramFiles"), "Steam"),
+		}
+	case "darwin":
+		return []string{
+			filepath.Join(h, "Library", "Application Support", "Steam"),
+		}
+	}
+
+	return []string{
+		filepath.Join(h, ".steam", "steam"),
+		filepath.Join(h, ".local", "share", "Steam"),
+		filepath.Join(h, ".var", "app", "com.valvesoftware.Steam", ".local", "share", "Steam"),
+	}
+}
+
+// remoteCaches returns the remotecache.vdf files of the game for every Steam
+// account.
+func remoteCaches() []string {
+	var (
+		fs   []string
+		seen = make(map[string]bool)
+	)
+
+	for _, d := range steamDirs() {
+		ms, _ := filepath.Glob(filepath.Join(d, "userdata", "*", appID, "remotecache.vdf"))
+
+		for _, m := range ms {
+			r, err := filepath.EvalSymlinks(m)
+			if err != nil {
+				r = m
+			}
+
+			if !seen[r] {
+				seen[r] = true
+				fs = append(fs, r)
+			}
+		}
+	}
+
+	return fs
+}
+
+// cacheEntry is the entry of a save in a remotecache.vdf file.
+type cacheEntry struct {
+	file string
+	root *vdf.Node
+	node *vdf.Node
+}
+
+// findEntry returns the entry in a cache file whose path shares the longest
+// suffix with the save, or nil. The suffix must cover the path of the save
+// relative to the save directory, or the file name and its directory for
+// saves elsewhere, so that a save of another career with the same file name
+// is not taken for it. Two entries matching equally are an error.
+func findEntry(fn, save string) (*cacheEntry, error) {
+	r, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	root, err := vdf.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", fn, err)
+	}
+
+	app := root.Child(appID)
+	if app == nil {
+		return nil, nil
+	}
+
+	abs, err := filepath.Abs(save)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := strings.Split(strings.ToLower(filepath.ToSlash(abs)), "/")
+	need := minMatch(abs)
+
+	var (
+		best  *vdf.Node
+		tie   *vdf.Node
+		score int
+	)
+
+	for _, c := range app.Children {
+		if !c.IsMap {
+			continue
+		}
+
+		kp := strings.Split(strings.ToLower(path.Clean(filepath.ToSlash(c.Key))), "/")
+
+		n := 0
+
+		for n < len(kp) && n < len(sp) && kp[len(kp)-1-n] == sp[len(sp)-1-n] {
+			n++
+		}
+
+		switch {
+		case n < need || n < score:
+		case n == score:
+			tie = c
+		default:
+			best, tie, score = c, nil, n
+		}
+	}
+
+	switch {
+	case best == nil:
+		return nil, nil
+	case tie != nil:
+		return nil, fmt.Errorf("%s: both %s and %s may be %s; leaving them alone", fn, best.Key, tie.Key, save)
+	}
+
+	return &cacheEntry{file: fn, root: root, node: best}, nil
+}
+
+// minMatch returns the number of trailing path elements that a cache entry
+// must share with a save: those of its path relative to the save directory,
+// or else its file name and directory.
+func minMatch(abs string) int {
+	if cfg.SaveDir != "" {
+		if d, err := filepath.Abs(cfg.SaveDir); err == nil {
+			if rel, err := filepath.Rel(d, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return len(strings.Split(filepath.ToSlash(rel), "/"))
+			}
+		}
+	}
+
+	return 2
+}
+
+// fileDigest returns the size, SHA-1, and modification time of a file.
+func fileDigest(fn string) (int64, string, int64, error) {
+	r, err := os.Open(fn)
+	if err != nil {
+		return 0, "", 0, err
+	}
+
+	defer r.Close()
+
+	st, err := r.Stat()
+	if err != nil {
+		return 0, "", 0, err
+	}
+
+	h := sha1.New()
+
+	if _, err := io.Copy(h, r); err != nil {
+		return 0, "", 0, err
+	}
+
+	return st.Size(), hex.EncodeToString(h.Sum(nil)), st.ModTime().Unix(), nil
+}
+
+// inSync reports whether a cache entry records the size and SHA-1 of a save.
+func (e *cacheEntry) inSync(size int64, sum string) bool {
+	return e.node.Get("size") == strconv.FormatInt(size, 10) &&
+		strings.EqualFold(e.node.Get("sha"), sum)
+}
+
+// cloudEntries returns the cache entries of a save.
+func cloudEntries(save string) []*cacheEntry {
+	var es []*cacheEntry
+
+	for _, fn := range remoteCaches() {
+		e, err := findEntry(fn, save)
+
+		if err != nil {
+			log.Printf("Warning: %s", err)
+		} else if e != nil {
+			es = append(es, e)
+		}
+	}
+
+	return es
+}
+
+// warnCloud warns when the Steam Cloud cache records a save differently.
+func warnCloud(save string) {
+	es := cloudEntries(save)
+	if len(es) == 0 {
+		return
+	}
+
+	size, sum, _, err := fileDigest(save)
+	if err != nil {
+		return
+	}
+
+	for _, e := range es {
+		if !e.inSync(size, sum) {
+			log.Printf(
+				"Warning: Steam Cloud records a different %s in %s; "+
+					"Steam may replace the edited save when it syncs. "+
+					"See \"mmse help cloud\".",
+				e.node.Key, e.file,
+			)
+		}
+	}
+}
+
+// runCloud runs the cloud command.
+func runCloud(args []string) {
+	save := findSave(args[1])
+
+	size, sum, mtime, err := fileDigest(save)
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", save, err)
+	}
+
+	es := cloudEntries(save)
+
+	if len(es) == 0 {
+		fmt.Printf("%s is not in the Steam Cloud cache.\n", save)
+		return
+	}
+
+	switch args[0] {
+	case "status":
+		for _, e := range es {
+			fmt.Printf("%s\n\tentry:  %s\n", e.file, e.node.Key)
+			fmt.Printf("\tcached: size %s, sha %s\n", e.node.Get("size"), e.node.Get("sha"))
+			fmt.Printf("\tlocal:  size %d, sha %s\n", size, sum)
+
+			if e.inSync(size, sum) {
+				fmt.Printf("\tstate:  in sync\n")
+			} else {
+				fmt.Printf("\tstate:  differs; Steam may replace the save when it syncs\n")
+			}
+		}
+	case "update":
+		if !force {
+			for _, p := range runningProcesses() {
+				if isSteam(p.name) {
+					log.Panicf(
+						"Steam is running as %s and rewrites the cache on exit; "+
+							"close it or use -force", p,
+					)
+				}
+			}
+		}
+
+		h, _ := hashFile(save)
+
+		for _, e := range es {
+			e.node.Set("size", strconv.FormatInt(size, 10))
+			e.node.Set("sha", sum)
+			e.node.Set("localtime", strconv.FormatInt(mtime, 10))
+			e.node.Set("time", strconv.FormatInt(mtime, 10))
+
+			if err := copyFile(e.file+".bak", e.file); err != nil {
+				log.Panicf("Unable to back up %s: %s", e.file, err)
+			}
+
+			writeCache(e)
+
+			audit(save, h, h, []string{"record " + e.node.Key + " in " + e.file})
+
+			fmt.Printf("Updated %s in %s\n", e.node.Key, e.file)
+		}
+	default:
+		log.Panicf("Unknown cloud action: %s", args[0])
+	}
+}
+
+// isSteam reports whether a process name belongs to the Steam client.
+func isSteam(name string) bool {
+	switch strings.ToLower(filepath.Base(name)) {
+	case "steam", "steam.exe", "steam_osx":
+		return true
+	}
+
+	return false
+}
+
+// writeCache writes a modified cache file through a temporary file.
+func writeCache(e *cacheEntry) {
+	tmp := e.file + ".tmp"
+
+	w, err := os.Create(tmp)
+	if err != nil {
+		log.Panicf("Unable to create %s: %s", tmp, err)
+	}
+
+	if err := vdf.Write(w, e.root); err != nil {
+		w.Close()
+		log.Panicf("Unable to write %s: %s", tmp, err)
+	}
+
+	if err := w.Close(); err != nil {
+		log.Panicf("Unable to close %s: %s", tmp, err)
+	}
+
+	if err := os.Rename(tmp, e.file); err != nil {
+		log.Panicf("Unable to replace %s: %s", e.file, err)
+	}
+}
@@ -0,0 +1,381 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+// Backup policies.
+const (
+	backupNone      = "none"
+	backupBak       = "bak"
+	backupTimestamp = "timestamp"
+	backupStore     = "store"
+)
+
+// Duplicate key policies.
+const (
+	dupWarn  = "warn"
+	dupError = "error"
+)
+
+// config holds the settings read from the configuration file. Flags given on
+// the command line override them.
+type config struct {
+	SaveDir string `yaml:"save_dir"`
+	Backup  string `yaml:"backup"`
+	Pretty  bool   `yaml:"pretty"`
+	Level   int    `yaml:"compression_level"`
+	Format  string `yaml:"format"`
+	Info    string `yaml:"info_template"`
+	Data    string `yaml:"data_template"`
+	Save    string `yaml:"save_template"`
+
+	SteamDir    string `yaml:"steam_dir"`
+	VersionPath string `yaml:"version_path"`
+	DatePath    string `yaml:"date_path"`
+
+	Metrics map[string]string `yaml:"metrics"`
+	IDKeys  []string          `yaml:"id_keys"`
+	Aliases map[string]string `yaml:"aliases"`
+
+	BackupDir    string `yaml:"backup_dir"`
+	BackupKeep   int    `yaml:"backup_keep"`
+	BackupMaxAge string `yaml:"backup_max_age"`
+
+	DupKeys string `yaml:"dup_keys"`
+	Jobs    int    `yaml:"jobs"`
+
+	GzipOutput   bool `yaml:"gzip_output"`
+	ExtractBlobs bool `yaml:"extract_blobs"`
+	Sidecar      bool `yaml:"sidecar"`
+
+	SignKey string   `yaml:"sign_key"`
+	ModKeys []string `yaml:"mod_keys"`
+	Audit   bool     `yaml:"audit"`
+
+	DiscordWebhook string `yaml:"discord_webhook"`
+}
+
+// names holds the fields available to output templates.
+type names struct {
+	// Name is the file name of the input without directory and extension.
+	Name string
+	// Ext is the extension of the output, including the dot.
+	Ext string
+}
+
+var (
+	cfg = config{
+		Backup: backupNone,
+		Format: "json",
+		Info:   "{{.Name}}_info{{.Ext}}",
+		Data:   "{{.Name}}_data{{.Ext}}",
+		Save:   "{{.Name}}{{.Ext}}",
+
+		DupKeys: dupWarn,
+	}
+
+	cfgPath = defaultConfigPath()
+)
+
+// flagConfig registers the flag selecting the configuration file.
+func flagConfig(fs *flag.FlagSet) {
+	fs.StringVar(&cfgPath, "config", cfgPath, "path of the configuration file")
+}
+
+// flagFormat registers the flag selecting the output format.
+func flagFormat(fs *flag.FlagSet) {
+	fs.StringVar(
+		&cfg.Format, "format", cfg.Format,
+		"output format of unpack: json, yaml, or toml",
+	)
+}
+
+// flagPretty registers the flag selecting indented JSON.
+func flagPretty(fs *flag.FlagSet) {
+	fs.BoolVar(
+		&cfg.Pretty, "pretty", cfg.Pretty,
+		"indent JSON on unpack and compact it on pack",
+	)
+}
+
+// flagGzipOutput registers the flag selecting compressed documents.
+func flagGzipOutput(fs *flag.FlagSet) {
+	fs.BoolVar(
+		&cfg.GzipOutput, "gzipoutput", cfg.GzipOutput,
+		"compress the documents written by unpack with gzip",
+	)
+}
+
+// flagLevel registers the flag selecting the compression level.
+func flagLevel(fs *flag.FlagSet) {
+	fs.IntVar(
+		&cfg.Level, "level", cfg.Level,
+		"compression level of pack, from 0 (fast) to 9 (small)",
+	)
+}
+
+// flagBackup registers the flag selecting the backup policy.
+func flagBackup(fs *flag.FlagSet) {
+	fs.StringVar(
+		&cfg.Backup, "backup", cfg.Backup,
+		"backup of overwritten saves: none, bak, timestamp, or store",
+	)
+}
+
+// flagDupKeys registers the flag selecting the duplicate key policy.
+func flagDupKeys(fs *flag.FlagSet) {
+	fs.StringVar(
+		&cfg.DupKeys, "dupkeys", cfg.DupKeys,
+		"keys repeated within an object: warn or error",
+	)
+}
+
+// flagSaveDir registers the flag selecting the save directory.
+func flagSaveDir(fs *flag.FlagSet) {
+	fs.StringVar(
+		&cfg.SaveDir, "savedir", cfg.SaveDir,
+		"directory of saves, used for packed saves and to find saves to unpack",
+	)
+}
+
+// defaultConfigPath returns the path of the configuration file in the user
+// configuration directory.
+func defaultConfigPath() string {
+	d, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(d, "mmse", "config.yml")
+}
+
+// loadConfig reads the configuration file into cfg, keeping the values of
+// flags set on the command line. A missing file is not an error.
+func loadConfig(fs *flag.FlagSet) {
+	if cfgPath == "" {
+		return
+	}
+
+	r, err := os.Open(cfgPath)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Panicf("Unable to open %s: %s", cfgPath, err)
+	}
+
+	defer r.Close()
+
+	set := make(map[string]string)
+
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	d := yaml.NewDecoder(r)
+	d.KnownFields(true)
+
+	if err := d.Decode(&cfg); err != nil && err != io.EOF {
+		log.Panicf("Unable to parse %s: %s", cfgPath, err)
+	}
+
+	for k, v := range set {
+		if err := fs.Set(k, v); err != nil {
+			log.Panicf("%s", err)
+		}
+	}
+
+	if cfg.SaveDir != "" {
+		cfg.SaveDir = expandHome(cfg.SaveDir)
+	}
+
+	if cfg.SteamDir != "" {
+		cfg.SteamDir = expandHome(cfg.SteamDir)
+	}
+
+	if cfg.BackupDir != "" {
+		cfg.BackupDir = expandHome(cfg.BackupDir)
+	}
+
+	if cfg.SignKey != "" {
+		cfg.SignKey = expandHome(cfg.SignKey)
+	}
+}
+
+// expandHome replaces a leading ~ in a path with the home directory.
+func expandHome(p string) string {
+	if p != "~" && !(len(p) > 1 && p[0] == '~' && os.IsPathSeparator(p[1])) {
+		return p
+	}
+
+	h, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+
+	return filepath.Join(h, p[1:])
+}
+
+// checkConfig validates the settings.
+func checkConfig() {
+	switch cfg.Backup {
+	case backupNone, backupBak, backupTimestamp, backupStore:
+	default:
+		log.Panicf("Unknown backup policy: %s", cfg.Backup)
+	}
+
+	switch cfg.DupKeys {
+	case dupWarn, dupError:
+	default:
+		log.Panicf("Unknown duplicate key policy: %s", cfg.DupKeys)
+	}
+
+	for n, a := range cfg.Aliases {
+		p, err := jsonpath.Parse(a)
+
+		switch {
+		case n == "info" || n == "data":
+			log.Panicf("Alias %s hides the %s document", n, n)
+		case err != nil:
+			log.Panicf("Invalid alias %s: %s", n, err)
+		case len(p) == 0 || p[0].IsIndex || p[0].Key != "info" && p[0].Key != "data":
+			log.Panicf("Alias %s does not start with info or data", n)
+		}
+	}
+
+	if cfg.Jobs < 0 {
+		log.Panicf("Number of jobs out of range: %d", cfg.Jobs)
+	}
+
+	if _, err := maxAge(); err != nil {
+		log.Panicf("Invalid backup_max_age: %s", err)
+	}
+
+	if cfg.Level < 0 || cfg.Level > mmse.MaxLevel {
+		log.Panicf("Compression level out of range: %d", cfg.Level)
+	}
+
+	for _, t := range []string{cfg.Info, cfg.Data, cfg.Save} {
+		if _, err := template.New("").Parse(t); err != nil {
+			log.Panicf("Invalid output template %q: %s", t, err)
+		}
+	}
+}
+
+// outputName renders an output template.
+func outputName(tmpl string, n names) string {
+	t := template.Must(template.New("").Parse(tmpl))
+
+	b := new(bytes.Buffer)
+
+	if err := t.Execute(b, n); err != nil {
+		log.Panicf("Unable to render output template %q: %s", tmpl, err)
+	}
+
+	return b.String()
+}
+
+// findSave returns the path of a save to read. A save that does not exist
+// relative to the working directory is looked up in the save directory.
+func findSave(fn string) string {
+	if cfg.SaveDir == "" || filepath.IsAbs(fn) {
+		return fn
+	}
+
+	if _, err := os.Stat(fn); os.IsNotExist(err) {
+		if p := filepath.Join(cfg.SaveDir, fn); fileExists(p) {
+			return p
+		}
+	}
+
+	return fn
+}
+
+// savePath returns the path of a save to write.
+func savePath(fn string) string {
+	if cfg.SaveDir == "" || filepath.IsAbs(fn) {
+		return fn
+	}
+
+	return filepath.Join(cfg.SaveDir, fn)
+}
+
+// fileExists reports whether a file exists.
+func fileExists(fn string) bool {
+	_, err := os.Stat(fn)
+	return err == nil
+}
+
+// backup copies a file about to be overwritten according to the backup
+// policy.
+func backup(fn string) {
+	if cfg.Backup == backupNone || !fileExists(fn) {
+		return
+	}
+
+	if cfg.Backup == backupStore {
+		storeBackup(fn)
+		return
+	}
+
+	dst := fn + ".bak"
+
+	if cfg.Backup == backupTimestamp {
+		dst = fmt.Sprintf("%s.%s.bak", fn, time.Now().Format("20060102-150405"))
+	}
+
+	if err := copyFile(dst, fn); err != nil {
+		log.Panicf("Unable to back up %s: %s", fn, err)
+	}
+}
+
+// copyFile copies file src to dst.
+func copyFile(dst, src string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+
+	w, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
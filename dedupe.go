@@ -0,0 +1,295 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+// Actions of dedupe on duplicates.
+var dedupeLink, dedupeDelete bool
+
+func init() {
+	register(&command{
+		name:  "dedupe",
+		args:  "<dir>...",
+		short: "find saves with the same content and link or delete the copies",
+		long: `
+Dedupe reads every save in the directories and groups the saves whose decoded
+documents are identical, such as autosaves the game wrote twice. Saves are
+compared by the SHA-256 of their version number and decoded frames, so saves
+compressed differently but holding the same career are duplicates. Saves that
+cannot be read are skipped with a warning.
+
+In each group, the save written first is kept. Without -link or -delete, the
+groups are listed and nothing is changed. With -link, every other save of the
+group is replaced by a hard link to the kept save, which frees its space but
+keeps its name; the directories must be on the same file system. With
+-delete, the other saves are removed. Saves open in the game are not touched.
+
+A save found more than once, such as in a directory given twice or through
+hard links made by an earlier dedupe -link, is only counted once, so linked
+saves are not listed again.`,
+		example: `
+mmse dedupe ~/saves/autosaves
+mmse dedupe -link ~/saves/career1 ~/saves/autosaves`,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&dedupeLink, "link", false, "replace duplicates with hard links to the kept save")
+			fs.BoolVar(&dedupeDelete, "delete", false, "delete duplicates")
+			flagJobs(fs)
+			flagOutput(fs, outputTable)
+		},
+		nargs: atLeast(1),
+		run:   runDedupe,
+	})
+}
+
+// duplicate is a save of a group of saves with the same content.
+type duplicate struct {
+	Group  int    `json:"group"`
+	Save   string `json:"save"`
+	Hash   string `json:"hash"`
+	Action string `json:"action"`
+}
+
+// Actions taken on the saves of a group.
+const (
+	dupKept    = "kept"
+	dupFound   = "duplicate"
+	dupLinked  = "linked"
+	dupDeleted = "deleted"
+)
+
+// contentHash returns the SHA-256 of the version number and decoded frames of
+// a save. Each frame is prefixed by its length so that content cannot move
+// between frames unnoticed.
+func contentHash(fn string) string {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Panicf("Unable to open %s: %s", fn, err)
+	}
+
+	defer f.Close()
+
+	s, err := mmse.ReadSaveFile(bufio.NewReader(f))
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	h := sha256.New()
+
+	v := s.Version
+	if v == 0 {
+		v = mmse.Ver
+	}
+
+	binary.Write(h, binary.LittleEndian, v)
+
+	for _, fr := range append([]*mmse.Frame{s.Info, s.Data}, s.Extra...) {
+		binary.Write(h, binary.LittleEndian, int64(fr.Len()))
+		h.Write(fr.Bytes())
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// linkSave replaces dup with a hard link to kept.
+func linkSave(kept, dup string) error {
+	tmp := dup + ".tmp"
+
+	if err := os.Link(kept, tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, dup); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// sameFile reports whether two paths name the same file, such as after an
+// earlier run of dedupe -link.
+func sameFile(a, b string) bool {
+	sa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+
+	sb, err := os.Stat(b)
+
+	return err == nil && os.SameFile(sa, sb)
+}
+
+// dedupeFiles returns the saves in the directories, oldest first. Paths
+// naming a file already found, such as through a directory given twice or a
+// hard link left by dedupe -link, are dropped.
+func dedupeFiles(dirs []string) []string {
+	type save struct {
+		fn string
+		st os.FileInfo
+	}
+
+	var ss []save
+
+	for _, d := range dirs {
+	files:
+		for _, fn := range saveFiles(d) {
+			st, err := os.Stat(fn)
+			if err != nil {
+				log.Printf("Warning: unable to read %s: %s", fn, err)
+				continue
+			}
+
+			for _, s := range ss {
+				if os.SameFile(s.st, st) {
+					continue files
+				}
+			}
+
+			ss = append(ss, save{fn, st})
+		}
+	}
+
+	sort.SliceStable(ss, func(i, j int) bool { return ss[i].st.ModTime().Before(ss[j].st.ModTime()) })
+
+	fs := make([]string, len(ss))
+
+	for i, s := range ss {
+		fs[i] = s.fn
+	}
+
+	return fs
+}
+
+// runDedupe runs the dedupe command.
+func runDedupe(args []string) {
+	if dedupeLink && dedupeDelete {
+		log.Panicf("-link and -delete cannot be used together")
+	}
+
+	fs := dedupeFiles(args)
+	hs := make([]string, len(fs))
+
+	// Saves that cannot be read keep an empty hash; their error is logged.
+	parallel(len(fs), func(i int) {
+		hs[i] = contentHash(fs[i])
+	})
+
+	// Saves are grouped in the order they were written, so that the first
+	// save of a group is the oldest of all the directories.
+	var order []string
+
+	groups := make(map[string][]string)
+
+	for i, fn := range fs {
+		if hs[i] == "" {
+			continue
+		}
+
+		if _, ok := groups[hs[i]]; !ok {
+			order = append(order, hs[i])
+		}
+
+		groups[hs[i]] = append(groups[hs[i]], fn)
+	}
+
+	l := &listing{
+		cols:  []string{"group", "save", "hash", "action"},
+		right: map[string]bool{"group": true},
+	}
+
+	n, dups := 0, 0
+
+	for _, h := range order {
+		g := groups[h]
+
+		if len(g) < 2 {
+			continue
+		}
+
+		n++
+
+		l.addRecord(duplicate{n, g[0], h, dupKept}, strconv.Itoa(n), g[0], shortHash(h), dupKept)
+
+		for _, fn := range g[1:] {
+			dups++
+
+			a := dupFound
+
+			switch {
+			case dedupeLink:
+				if !sameFile(g[0], fn) {
+					checkInUse(fn)
+
+					before, _ := hashFile(fn)
+
+					if err := linkSave(g[0], fn); err != nil {
+						log.Panicf("Unable to link %s to %s: %s", fn, g[0], err)
+					}
+
+					after, _ := hashFile(fn)
+					audit(fn, before, after, []string{"link to " + g[0]})
+				}
+
+				a = dupLinked
+			case dedupeDelete:
+				// Another name of the kept save is never removed.
+				if sameFile(g[0], fn) {
+					break
+				}
+
+				checkInUse(fn)
+
+				before, _ := hashFile(fn)
+
+				if err := os.Remove(fn); err != nil {
+					log.Panicf("Unable to delete %s: %s", fn, err)
+				}
+
+				audit(fn, before, "", []string{"delete as a duplicate of " + g[0]})
+
+				a = dupDeleted
+			}
+
+			l.addRecord(duplicate{n, fn, h, a}, strconv.Itoa(n), fn, shortHash(h), a)
+		}
+	}
+
+	if n == 0 && outputFormat == outputTable {
+		fmt.Println("No duplicate saves found")
+		return
+	}
+
+	l.write()
+
+	if outputFormat == outputTable {
+		fmt.Printf("%d duplicates of %d saves\n", dups, n)
+	}
+}
@@ -17,16 +17,36 @@
 /*
 mmse packs and unpacks the save file from Motorsport Manager.
 
-When given one parameter, mmse unpacks the save file to an info JSON file and a
-data JSON file. The JSON files use the file name of the save file as prefix.
+The unpack command unpacks the save file to an info JSON file and a data JSON
+file. The JSON files use the file name of the save file as prefix.
 
-When given two parameters, mmse packs the info JSON file and the data JSON file
-to a save file. The save files use the file name of the data JSON file as
-prefix.
+The pack command packs the info JSON file and the data JSON file to a save
+file. The save files use the file name of the data JSON file as prefix.
+
+The validate command checks a save file, and warns about fields unknown to the
+game version of the save when a field catalog for the version exists. The
+catalog command captures catalogs from known good saves.
+
+Without a command, mmse unpacks when given saves and packs when given one or
+two documents, so that files can be dropped on the program. Saves and
+documents are told apart by their content, so they can be given in any order. On Windows, the
+register command adds the same actions to the context menu of Explorer.
+
+The -format flag unpacks to YAML or TOML instead of JSON. Files ending in
+.yaml, .yml, or .toml are converted back to JSON when packing.
+
+mmse exits with status 1 when a command fails, after printing the reason, and
+with status 2 when it is used with unknown flags or the wrong number of
+arguments.
+
+Defaults for the flags are read from ~/.config/mmse/config.yml. Run
+"mmse help" for the list of commands and help topics, or "mmse man" for the
+manual page.
 
 Usage:
-	mmse <savefile>
-	mmse <infofile> <datafile>
 
+	mmse <command> [options] [arguments]
+	mmse [options] <savefile>
+	mmse [options] <infofile> [<datafile>]
 */
 package main
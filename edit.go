@@ -0,0 +1,394 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+func init() {
+	register(&command{
+		name:  "set",
+		args:  "<game.sav> <path> [<value>] [<path> <value>]...",
+		short: "change values in a save file",
+		long: `
+Set replaces the value at a path in a save file and writes the save in place.
+The value is JSON, such as 42, true, or {"a": 1}; a value that is not valid
+JSON is taken as a string. With -fromclipboard, the value is read from the
+clipboard instead, as copied by get -clipboard or from a spreadsheet.
+
+Several paths and values may be given, and are set all or none: every change
+is checked before any is made, the changed documents are encoded and decoded
+again to verify them, and only then is the save replaced. The save is written
+to a temporary file first, so that a failed write leaves it intact. To apply
+a longer list of changes as one, see replay.
+
+Only the bytes of the value change. The rest of the document is kept byte for
+byte, and the frame of the other document is copied without recompressing it,
+so the edited save differs from the original as little as possible.
+
+Set classifies the change by the documentation of fields; see "mmse help
+fields". A number beyond the documented range is clamped to the nearest bound
+with a warning. Set refuses to change a field documented as risky, to set a
+value not among the documented values, or with -noclamp to set a number beyond
+the range, unless -allowrisky is given, and warns when the field is not
+documented.
+` + pathHelp,
+		example: `
+mmse set game.sav data.teams[0].budget 250000000
+mmse set game.sav data.teams[0].name "Predator Racing"
+mmse set -fromclipboard game.sav data.teams[0].name
+mmse set game.sav data.drivers[0].morale 1 data.drivers[1].morale 1`,
+		flags: func(fs *flag.FlagSet) {
+			flagLevel(fs)
+			flagBackup(fs)
+			flagSaveDir(fs)
+			flagForce(fs)
+			flagSteamDir(fs)
+			fs.BoolVar(&allowRisky, "allowrisky", false, "change fields documented as risky")
+			flagNoClamp(fs)
+			fs.BoolVar(&fromClipboard, "fromclipboard", false, "read the value from the clipboard")
+		},
+		nargs: func(n int) bool { return n >= 3 && n%2 == 1 && !fromClipboard || n == 2 && fromClipboard },
+		run: func(args []string) {
+			if fromClipboard {
+				s, err := pasteClipboard()
+				if err != nil {
+					log.Panicf("Unable to paste from the clipboard: %s", err)
+				}
+
+				args = append(args, s)
+			}
+
+			// Check every change before making any.
+			vs := make([][]byte, 0, len(args)/2)
+
+			for i := 1; i < len(args); i += 2 {
+				vs = append(vs, checkSafety(args[i], jsonValue(args[i+1])))
+			}
+
+			e := openRaw(args[0])
+
+			for i, v := range vs {
+				e.splice(args[1+2*i], v)
+			}
+
+			e.write()
+
+			warnCloud(e.fn)
+		},
+	})
+
+	register(&command{
+		name:  "rebrand",
+		args:  "-team <name> -name <new name> [-oldshort <short> -short <new short>] <game.sav>",
+		short: "rename a team everywhere in a save file",
+		long: `
+Rebrand replaces every string value equal to the team name with the new name,
+in both the info and the data document, so that no copy of the name is
+missed. With -oldshort and -short, the short name of the team is replaced the
+same way. Keys are never changed.
+
+With -contains, the names are also replaced inside longer strings, such as
+"Predator Racing Group". Check the result with search before playing; a short
+name such as "PRD" may occur in unrelated strings.
+
+Like set, rebrand only changes the bytes of the replaced strings.`,
+		example: `
+mmse rebrand -team Predator -name "My Team" -oldshort PRD -short MYT game.sav`,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&rebrandTeam, "team", "", "current team `name`")
+			fs.StringVar(&rebrandName, "name", "", "new team `name`")
+			fs.StringVar(&rebrandOldShort, "oldshort", "", "current short `name`")
+			fs.StringVar(&rebrandShort, "short", "", "new short `name`")
+			fs.BoolVar(&rebrandContains, "contains", false, "also replace names inside longer strings")
+			flagLevel(fs)
+			flagBackup(fs)
+			flagSaveDir(fs)
+			flagForce(fs)
+			flagSteamDir(fs)
+		},
+		nargs: exactly(1),
+		run:   runRebrand,
+	})
+}
+
+// jsonValue returns a value given to set as JSON, quoting it as a string when it
+// is not valid JSON.
+func jsonValue(s string) []byte {
+	v := []byte(s)
+
+	if !json.Valid(v) {
+		v, _ = json.Marshal(s)
+	}
+
+	return v
+}
+
+// fromClipboard reads the value of set from the clipboard.
+var fromClipboard bool
+
+// allowRisky allows changes to fields documented as risky.
+var allowRisky bool
+
+// noClamp keeps numbers beyond the documented range from being clamped.
+var noClamp bool
+
+// flagNoClamp registers the flag keeping numbers from being clamped.
+func flagNoClamp(fs *flag.FlagSet) {
+	fs.BoolVar(&noClamp, "noclamp", false, "refuse numbers beyond the documented range instead of clamping them")
+}
+
+// checkSafety clamps a number to the documented range of its field, refuses
+// risky changes without -allowrisky, and warns about changes of unknown
+// safety. It returns the value to set.
+func checkSafety(path string, v []byte) []byte {
+	i, p := docPath(path)
+
+	pat := p.Pattern()
+
+	if pat == "" || pat[0] != '[' {
+		pat = "." + pat
+	}
+
+	pat = strings.TrimSuffix([]string{"info", "data"}[i]+pat, ".")
+
+	fs := readFields()
+
+	if c, ok := clamp(fs, pat, v); ok && !noClamp {
+		log.Printf("Warning: clamping %s to %s, the bound of %s", v, c, pat)
+		v = c
+	}
+
+	switch class, why := classify(fs, pat, v); class {
+	case safetyRisky:
+		if !allowRisky {
+			log.Panicf("Refusing a risky change: %s; use -allowrisky to make it", why)
+		}
+
+		log.Printf("Warning: making a risky change: %s", why)
+	case safetyUnknown:
+		log.Printf("Warning: the safety of the change is unknown: %s", why)
+	}
+
+	return v
+}
+
+// runRebrand runs the rebrand command.
+func runRebrand(args []string) {
+	if rebrandTeam == "" || rebrandName == "" {
+		log.Panicf("Rebrand needs -team and -name")
+	}
+
+	if (rebrandOldShort == "") != (rebrandShort == "") {
+		log.Panicf("Rebrand needs both -oldshort and -short to change the short name")
+	}
+
+	names := map[string]string{rebrandTeam: rebrandName}
+
+	if rebrandShort != "" {
+		names[rebrandOldShort] = rebrandShort
+	}
+
+	e := openRaw(args[0])
+
+	ns := e.rebrand(names)
+
+	for o, n := range names {
+		e.ops = append(e.ops, fmt.Sprintf("rebrand %q %q", o, n))
+	}
+
+	if ns[0]+ns[1] == 0 {
+		log.Panicf("%s does not occur in %s", rebrandTeam, e.fn)
+	}
+
+	e.write()
+
+	fmt.Printf("Replaced %d strings in info and %d in data\n", ns[0], ns[1])
+
+	warnCloud(e.fn)
+}
+
+var (
+	// rebrandTeam and rebrandName are the old and new team names.
+	rebrandTeam, rebrandName string
+	// rebrandOldShort and rebrandShort are the old and new short names.
+	rebrandOldShort, rebrandShort string
+	// rebrandContains also replaces names inside longer strings.
+	rebrandContains bool
+)
+
+// rebrand renames a team in both documents and returns the number of strings
+// changed in each.
+func (e *rawSave) rebrand(names map[string]string) [2]int {
+	var ns [2]int
+
+	for i, d := range e.docs {
+		b, n, err := jsonpath.ReplaceStrings(d, func(s string) (string, bool) {
+			if r, ok := names[s]; ok {
+				return r, true
+			}
+
+			if !rebrandContains {
+				return "", false
+			}
+
+			r := s
+
+			for o, n := range names {
+				r = strings.Replace(r, o, n, -1)
+			}
+
+			return r, r != s
+		})
+
+		if err != nil {
+			log.Panicf("Unable to read %s document: %s", []string{"info", "data"}[i], err)
+		}
+
+		if n > 0 {
+			e.docs[i], e.frames[i] = b, nil
+		}
+
+		ns[i] = n
+	}
+
+	return ns
+}
+
+// rawSave is a save file whose encoded frames are kept, so that a frame left
+// unchanged is written back as it was read.
+type rawSave struct {
+	fn string
+	// docs are the decoded info and data documents.
+	docs [2][]byte
+	// frames are the encoded frames, or nil once a document is changed.
+	frames [2]*mmse.Frame
+	// version is the version number of the save, and extra the encoded
+	// frames after the data frame, which are written back unchanged.
+	version int32
+	extra   []*mmse.Frame
+	// padding is the number of NUL bytes before the info frame.
+	padding int
+	// trailing holds the bytes after the data frame.
+	trailing []byte
+	// ops lists the changes made, for the audit log.
+	ops []string
+}
+
+// openRaw reads a save file for editing.
+func openRaw(fn string) *rawSave {
+	fn = findSave(fn)
+
+	r, err := os.Open(fn)
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	defer r.Close()
+
+	s := &mmse.SaveFile{Raw: true}
+
+	if _, err := s.ReadFrom(r); err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	for _, w := range s.Warnings {
+		if w.Region == "version" {
+			log.Printf("Warning: %s: %s", fn, w)
+		}
+	}
+
+	e := &rawSave{
+		fn: fn, version: s.Version, extra: s.Extra,
+		padding: s.Padding, trailing: s.Trailing,
+	}
+
+	for i, f := range []*mmse.Frame{s.Info, s.Data} {
+		// Keep a copy of the encoded frame, which decoding overwrites.
+		e.frames[i] = &mmse.Frame{SizeRaw: f.SizeRaw, SizeCom: f.SizeCom}
+		e.frames[i].Write(f.Bytes())
+
+		if err := f.Decode(); err != nil {
+			log.Panicf("Unable to read %s: %s: %s", fn, mmse.FrameRegion(i), err)
+		}
+
+		e.docs[i] = f.Bytes()
+	}
+
+	return e
+}
+
+// splice replaces the value at a path.
+func (e *rawSave) splice(path string, v []byte) {
+	i, p := docPath(path)
+
+	b, err := jsonpath.Splice(e.docs[i], p, v)
+	if err != nil {
+		log.Panicf("Unable to set %s: %s", path, err)
+	}
+
+	e.docs[i], e.frames[i] = b, nil
+	e.ops = append(e.ops, fmt.Sprintf("set %s %s", path, v))
+}
+
+// write writes the save in place, encoding the changed documents and
+// verifying that they decode again.
+func (e *rawSave) write() {
+	for i, f := range e.frames {
+		if f != nil {
+			continue
+		}
+
+		f = mmse.ReadToFrame(bytes.NewReader(e.docs[i]), cfg.Level)
+
+		if err := verifyFrame(f, e.docs[i]); err != nil {
+			log.Panicf("Unable to verify the encoded %s frame: %s", []string{"info", "data"}[i], err)
+		}
+
+		e.frames[i] = f
+	}
+
+	fs := append([]*mmse.Frame{e.frames[0], e.frames[1]}, e.extra...)
+
+	writeSave(e.fn, e.version, fs, e.padding, e.trailing, e.ops)
+}
+
+// verifyFrame checks that an encoded frame decodes to its document.
+func verifyFrame(f *mmse.Frame, doc []byte) error {
+	b := make([]byte, f.SizeRaw)
+
+	n, err := mmse.DefaultCodec.Decompress(b, f.Bytes())
+
+	switch {
+	case err != nil:
+		return err
+	case !bytes.Equal(b[:n], doc):
+		return fmt.Errorf("the frame does not decode to the document")
+	}
+
+	return nil
+}
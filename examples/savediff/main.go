@@ -0,0 +1,83 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Savediff prints the differences between two saves as JSON Patch operations,
+// one per line, with paths under /info and /data. It shows how to read saves
+// with package mmse and compare documents with package jsonpatch.
+//
+// Usage:
+//
+//	savediff <old.sav> <new.sav>
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpatch"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+// read reads a save file.
+func read(fn string) (*mmse.SaveFile, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return mmse.ReadSaveFile(f)
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: savediff <old.sav> <new.sav>")
+		os.Exit(2)
+	}
+
+	a, err := read(os.Args[1])
+	if err != nil {
+		log.Fatalf("%s: %s", os.Args[1], err)
+	}
+
+	b, err := read(os.Args[2])
+	if err != nil {
+		log.Fatalf("%s: %s", os.Args[2], err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+
+	for _, d := range []struct {
+		name string
+		a, b *mmse.Frame
+	}{{"info", a.Info, b.Info}, {"data", a.Data, b.Data}} {
+		ops, err := jsonpatch.Diff(d.a.Bytes(), d.b.Bytes())
+		if err != nil {
+			log.Fatalf("%s document: %s", d.name, err)
+		}
+
+		for _, o := range ops {
+			o.Path = "/" + d.name + o.Path
+
+			if err := enc.Encode(o); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+}
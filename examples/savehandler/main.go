@@ -0,0 +1,98 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Savehandler serves an HTTP endpoint that unpacks uploaded saves. It shows
+// how to use package mmse on untrusted input: the body is limited, decoding
+// is bounded by MaxDecodedSize, and errors are reported to the client.
+//
+// Usage:
+//
+//	savehandler [-addr :8080]
+//	curl --data-binary @game.sav 'localhost:8080/unpack?doc=info'
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+// maxSave is the largest save accepted.
+const maxSave = 64 << 20
+
+// unpack writes the info or data document of the save in the request body.
+func unpack(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST a save file", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s, err := mmse.ReadSaveFile(http.MaxBytesReader(w, r.Body, maxSave))
+
+	var sm *mmse.SizeMismatchError
+
+	switch {
+	case errors.Is(err, mmse.ErrBadMagic):
+		http.Error(w, "not a save file", http.StatusUnsupportedMediaType)
+		return
+	case errors.As(err, &sm):
+		http.Error(w, "truncated save: "+err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, warn := range s.Warnings {
+		w.Header().Add("X-Save-Warning", warn.String())
+	}
+
+	var f *mmse.Frame
+
+	switch r.URL.Query().Get("doc") {
+	case "", "info":
+		f = s.Info
+	case "data":
+		f = s.Data
+	default:
+		http.Error(w, "doc is info or data", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", fmt.Sprint(f.Len()))
+
+	if _, err := f.WriteTo(w); err != nil {
+		log.Printf("%s: %s", r.RemoteAddr, err)
+	}
+}
+
+func main() {
+	addr := flag.String("addr", ":8080", "listen on `address`")
+	flag.Parse()
+
+	// Refuse frames declaring more than 256 MiB, well above the saves of
+	// the game.
+	mmse.MaxDecodedSize = 256 << 20
+
+	http.HandleFunc("/unpack", unpack)
+
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
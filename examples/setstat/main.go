@@ -0,0 +1,145 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Setstat sets values in many saves at once, such as the budget of every
+// team in a league of saves. It shows how to read a save with package mmse,
+// change a document with package jsonpath, and write the save again.
+//
+// Usage:
+//
+//	setstat -set data.playerTeam.financeBalance=5000000 [-set ...] <game.sav>...
+//
+// Values are JSON. Each save is overwritten; keep a copy.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+// assignment is a value to set at a path.
+type assignment struct {
+	path  jsonpath.Path
+	value []byte
+}
+
+// assignments collects the -set flags.
+type assignments []assignment
+
+func (as *assignments) String() string {
+	return fmt.Sprint(len(*as), " values")
+}
+
+func (as *assignments) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return fmt.Errorf("%q is not path=value", s)
+	}
+
+	p, err := jsonpath.Parse(s[:i])
+	if err != nil {
+		return err
+	}
+
+	if len(p) < 2 || p[0].IsIndex || p[0].Key != "info" && p[0].Key != "data" {
+		return fmt.Errorf("%s does not start with info or data", p)
+	}
+
+	*as = append(*as, assignment{p, []byte(s[i+1:])})
+
+	return nil
+}
+
+// edit applies the assignments to a save file.
+func edit(fn string, as assignments) error {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+
+	s, err := mmse.ReadSaveFile(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+
+	docs := map[string]*mmse.Frame{"info": s.Info, "data": s.Data}
+	out := make(map[string][]byte)
+
+	for _, a := range as {
+		k := a.path[0].Key
+
+		doc, ok := out[k]
+		if !ok {
+			doc = docs[k].Bytes()
+		}
+
+		if out[k], err = jsonpath.Splice(doc, a.path[1:], a.value); err != nil {
+			return fmt.Errorf("%s: %w", a.path, err)
+		}
+	}
+
+	// WriteTo encodes the frames that are not encoded, such as new ones.
+	for k, doc := range out {
+		f := &mmse.Frame{SizeRaw: int32(len(doc)), Level: mmse.MaxLevel}
+		f.Write(doc)
+
+		if k == "info" {
+			s.Info = f
+		} else {
+			s.Data = f
+		}
+	}
+
+	w := new(bytes.Buffer)
+
+	if _, err := s.WriteTo(w); err != nil {
+		return err
+	}
+
+	return os.WriteFile(fn, w.Bytes(), 0644)
+}
+
+func main() {
+	var as assignments
+
+	flag.Var(&as, "set", "set `path=value`, such as data.playerTeam.financeBalance=5000000")
+	flag.Parse()
+
+	if len(as) == 0 || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+
+	for _, fn := range flag.Args() {
+		if err := edit(fn, as); err != nil {
+			log.Printf("%s: %s", fn, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,378 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	register(&command{
+		name:  "fields",
+		args:  "list | search <term>",
+		short: "look up documented save fields",
+		long: `
+Fields prints the documented fields of saves: their path, with array indices
+written as [], the type and safe range of their values, and their meaning.
+List prints every documented field and search those whose path or meaning
+contains the term, ignoring case.
+
+With -gameversion, search also prints the undocumented fields of the field
+catalog of that version that match the term; see "mmse help catalog".
+
+Common fields are documented in mmse. Fields.yml next to the configuration file
+adds to them, and replaces those with the same path. It is a list of entries
+such as:
+
+	- path: data.playerTeam.financeBalance
+	  type: number
+	  meaning: money of the team of the player
+	  min: 0
+	  max: 2000000000
+	  safety: safe
+	- path: data.drivers[].tyreCompound
+	  enum: [Soft, Medium, Hard]
+
+Only path is required. Min and max bound the values of numbers, and enum
+lists the only values allowed. Safety is safe for fields that can be edited
+freely within their range, or risky for fields known to break saves when
+edited, such as IDs and references between entities. A field without safety
+takes it from the nearest documented field containing it.
+
+Set clamps numbers beyond the range to the nearest bound. With -noclamp, or
+for values not in enum, set refuses the change unless -allowrisky is given.
+Set also refuses to change risky fields without -allowrisky, and warns about
+fields whose safety is unknown. Collect and share the entries you find, so
+that others need not find them again.`,
+		example: `
+mmse fields search tyre
+mmse fields -gameversion 1.52 search tyre`,
+		flags: func(fs *flag.FlagSet) {
+			flagGameVersion(fs)
+			flagOutput(fs, outputTable)
+		},
+		nargs: func(n int) bool { return n == 1 || n == 2 },
+		run:   runFields,
+	})
+}
+
+// field documents a field of saves.
+type field struct {
+	Path    string   `yaml:"path" json:"path"`
+	Type    string   `yaml:"type,omitempty" json:"type,omitempty"`
+	Meaning string   `yaml:"meaning,omitempty" json:"meaning,omitempty"`
+	Min     *float64 `yaml:"min,omitempty" json:"min,omitempty"`
+	Max     *float64 `yaml:"max,omitempty" json:"max,omitempty"`
+	// Enum lists the values allowed, or is empty to allow any.
+	Enum   []interface{} `yaml:"enum,omitempty" json:"enum,omitempty"`
+	Safety string        `yaml:"safety,omitempty" json:"safety,omitempty"`
+}
+
+// Safety classes of fields.
+const (
+	safetySafe    = "safe"
+	safetyRisky   = "risky"
+	safetyUnknown = "unknown"
+)
+
+// fieldsPath returns the path of the field documentation.
+func fieldsPath() string {
+	return filepath.Join(filepath.Dir(cfgPath), "fields.yml")
+}
+
+// builtinFields documents the fields of saves known to mmse.
+//
+//go:embed fields.yml
+var builtinFields []byte
+
+// readFields returns the documented fields sorted by path: the built-in
+// fields, extended and replaced by those of the field documentation.
+func readFields() []field {
+	fs := parseFields(bytes.NewReader(builtinFields), "the built-in fields")
+
+	r, err := os.Open(fieldsPath())
+	if os.IsNotExist(err) {
+		sort.SliceStable(fs, func(i, j int) bool { return fs[i].Path < fs[j].Path })
+		return fs
+	} else if err != nil {
+		log.Panicf("Unable to open field documentation: %s", err)
+	}
+
+	defer r.Close()
+
+	byPath := make(map[string]int)
+
+	for i, f := range fs {
+		byPath[f.Path] = i
+	}
+
+	for _, f := range parseFields(r, fieldsPath()) {
+		if i, ok := byPath[f.Path]; ok {
+			fs[i] = f
+		} else {
+			byPath[f.Path] = len(fs)
+			fs = append(fs, f)
+		}
+	}
+
+	sort.SliceStable(fs, func(i, j int) bool { return fs[i].Path < fs[j].Path })
+
+	return fs
+}
+
+// parseFields reads a list of documented fields from r, named name in errors.
+// An empty list has no fields.
+func parseFields(r io.Reader, name string) []field {
+	var fs []field
+
+	d := yaml.NewDecoder(r)
+	d.KnownFields(true)
+
+	if err := d.Decode(&fs); err != nil && err != io.EOF {
+		log.Panicf("Unable to parse %s: %s", name, err)
+	}
+
+	for _, f := range fs {
+		if f.Path == "" {
+			log.Panicf("Field without path in %s", name)
+		}
+
+		switch f.Safety {
+		case "", safetySafe, safetyRisky:
+		default:
+			log.Panicf("Unknown safety %q of %s in %s", f.Safety, f.Path, name)
+		}
+	}
+
+	return fs
+}
+
+// rangeText describes the safe range of the values of a field, or returns an
+// empty string without one.
+func (f field) rangeText() string {
+	switch {
+	case len(f.Enum) > 0:
+		vs := make([]string, len(f.Enum))
+
+		for i, v := range f.Enum {
+			vs[i] = fmt.Sprint(v)
+		}
+
+		return "one of " + strings.Join(vs, ", ")
+	case f.Min != nil && f.Max != nil:
+		return formatFloat(*f.Min) + " to " + formatFloat(*f.Max)
+	case f.Min != nil:
+		return "at least " + formatFloat(*f.Min)
+	case f.Max != nil:
+		return "at most " + formatFloat(*f.Max)
+	}
+
+	return ""
+}
+
+// allows reports whether a JSON value is in the enum of a field, or the field
+// has no enum. Values are compared by their text, strings without quotes.
+func (f field) allows(v []byte) bool {
+	if len(f.Enum) == 0 {
+		return true
+	}
+
+	text := string(v)
+
+	var s string
+
+	if json.Unmarshal(v, &s) == nil {
+		text = s
+	}
+
+	for _, e := range f.Enum {
+		if fmt.Sprint(e) == text {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clamp returns a number beyond the range of the field with a pattern clamped
+// to the nearest bound, and reports whether it was clamped. Other values are
+// returned unchanged.
+func clamp(fs []field, pattern string, v []byte) ([]byte, bool) {
+	for _, f := range fs {
+		if f.Path != pattern {
+			continue
+		}
+
+		n, err := strconv.ParseFloat(string(v), 64)
+
+		switch {
+		case err != nil:
+		case f.Min != nil && n < *f.Min:
+			return []byte(formatFloat(*f.Min)), true
+		case f.Max != nil && n > *f.Max:
+			return []byte(formatFloat(*f.Max)), true
+		}
+	}
+
+	return v, false
+}
+
+// classify returns the safety class of setting the field with a pattern to a
+// JSON value, and the reason for it.
+func classify(fs []field, pattern string, v []byte) (string, string) {
+	byPath := make(map[string]field)
+
+	for _, f := range fs {
+		byPath[f.Path] = f
+	}
+
+	if f, ok := byPath[pattern]; ok {
+		if n, err := strconv.ParseFloat(string(v), 64); err == nil &&
+			(f.Min != nil && n < *f.Min || f.Max != nil && n > *f.Max) {
+			return safetyRisky, fmt.Sprintf("%s is outside the safe range of %s", v, f.Path)
+		}
+
+		if !f.allows(v) {
+			return safetyRisky, fmt.Sprintf("%s is not a value allowed for %s", v, f.Path)
+		}
+	}
+
+	// Fall back to the nearest field containing the pattern.
+	for p := pattern; p != ""; p = parentPattern(p) {
+		switch byPath[p].Safety {
+		case safetySafe:
+			return safetySafe, fmt.Sprintf("%s is documented as safe", p)
+		case safetyRisky:
+			return safetyRisky, fmt.Sprintf("%s is documented as risky", p)
+		}
+	}
+
+	return safetyUnknown, fmt.Sprintf("%s is not documented", pattern)
+}
+
+// parentPattern returns the pattern of the field containing a field, or an
+// empty string for a document.
+func parentPattern(p string) string {
+	if strings.HasSuffix(p, "[]") {
+		return p[:len(p)-2]
+	}
+
+	// Quoted keys may hold dots and brackets; the parent ends before them.
+	if strings.HasSuffix(p, `"]`) {
+		if i := strings.LastIndex(p, `["`); i >= 0 {
+			return p[:i]
+		}
+	}
+
+	if i := strings.LastIndexAny(p, ".["); i >= 0 {
+		return p[:i]
+	}
+
+	return ""
+}
+
+// formatFloat formats a number without trailing zeros.
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// runFields runs the fields command.
+func runFields(args []string) {
+	fs := readFields()
+
+	// fieldRecord is a field in the json and yaml output.
+	type fieldRecord struct {
+		field
+		Documented bool `json:"documented"`
+	}
+
+	l := &listing{cols: []string{"path", "type", "range", "safety", "meaning"}}
+
+	add := func(f field, documented bool) {
+		meaning := f.Meaning
+		if !documented {
+			meaning = "(undocumented)"
+		}
+
+		l.addRecord(fieldRecord{f, documented}, f.Path, f.Type, f.rangeText(), f.Safety, meaning)
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		if len(fs) == 0 && outputFormat == outputTable {
+			fmt.Printf("No fields are documented in %s\n", fieldsPath())
+			return
+		}
+
+		for _, f := range fs {
+			add(f, true)
+		}
+	case args[0] == "search" && len(args) == 2:
+		term := strings.ToLower(args[1])
+		found := make(map[string]bool)
+
+		for _, f := range fs {
+			if strings.Contains(strings.ToLower(f.Path), term) ||
+				strings.Contains(strings.ToLower(f.Meaning), term) {
+				add(f, true)
+				found[f.Path] = true
+			}
+		}
+
+		if gameVer != "" {
+			c := readCatalog(gameVer)
+			if c == nil {
+				log.Panicf("No field catalog for game version %s", gameVer)
+			}
+
+			var ps []string
+
+			for p := range c {
+				if !found[p] && strings.Contains(strings.ToLower(p), term) {
+					ps = append(ps, p)
+				}
+			}
+
+			sort.Strings(ps)
+
+			for _, p := range ps {
+				add(field{Path: p}, false)
+			}
+		}
+
+		if l.n == 0 {
+			fail()
+		}
+	default:
+		log.Panicf("Usage: mmse fields list | search <term>")
+	}
+
+	l.write()
+}
@@ -0,0 +1,264 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonconv"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+// docHTML selects HTML output for the doc command.
+var docHTML bool
+
+func init() {
+	register(&command{
+		name:  "doc",
+		args:  "format",
+		short: "generate the specification of the save format",
+		long: `
+Doc format writes a specification of the save format in Markdown, or in HTML
+with -html: the layout of the header and frames, the supported document
+formats, and the documented fields; see "mmse help fields".
+
+The specification is generated from the constants and the layout used by the
+parser, so that it always describes the format that mmse reads. Regenerate it
+rather than editing it.`,
+		example: `
+mmse doc format > FORMAT.md
+mmse doc -html format > format.html`,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&docHTML, "html", false, "write HTML instead of Markdown")
+		},
+		nargs: exactly(1),
+		run:   runDoc,
+	})
+}
+
+// docSection is a section of a generated document.
+type docSection struct {
+	title string
+	paras []string
+	head  []string
+	rows  [][]string
+}
+
+// layoutSection describes the regions of a save file as found by mmse.Layout.
+func layoutSection() docSection {
+	// A save with empty frames and one trailing byte has every region.
+	b := make([]byte, 25)
+
+	mmse.PutLE(b, mmse.Magic)
+	mmse.PutLE(b[4:], mmse.Ver)
+
+	m := uint32(mmse.Magic)
+
+	what := map[string]string{
+		"magic": fmt.Sprintf(
+			"magic number 0x%08x (%q)",
+			m, string([]byte{byte(m), byte(m >> 8), byte(m >> 16), byte(m >> 24)}),
+		),
+		"version":    fmt.Sprintf("version number %d", mmse.Ver),
+		"info sizes": "encoded size, then decoded size, of the info frame",
+		"data sizes": "encoded size, then decoded size, of the data frame",
+		"info frame": fmt.Sprintf("info document encoded by the %s codec", mmse.DefaultCodec),
+		"data frame": fmt.Sprintf("data document encoded by the %s codec", mmse.DefaultCodec),
+		"trailing":   "bytes after the data frame, not written by the game",
+	}
+
+	s := docSection{
+		title: "Layout",
+		paras: []string{
+			"All integers are 32-bit little endian. A frame starts right after " +
+				"the previous region, so the offsets of the frames depend on the " +
+				"encoded sizes in the size table.",
+		},
+		head: []string{"Offset", "Size", "Region", "Content"},
+	}
+
+	var at string
+
+	for _, r := range mmse.Layout(b) {
+		off, size := strconv.FormatInt(r.Offset, 10), strconv.FormatInt(r.Length, 10)
+
+		if at != "" {
+			off = at
+		}
+
+		switch {
+		case strings.HasSuffix(r.Name, " frame"):
+			size = "encoded size of the " + r.Name
+			at = off + " + " + size
+		case r.Name == "trailing":
+			size = "rest of the file"
+		}
+
+		s.rows = append(s.rows, []string{off, size, r.Name, what[r.Name]})
+	}
+
+	return s
+}
+
+// formatDoc returns the sections of the specification of the save format.
+func formatDoc() []docSection {
+	frames := docSection{
+		title: "Frames",
+		paras: []string{
+			fmt.Sprintf(
+				"Each frame is encoded by the %s codec and decodes to a JSON "+
+					"document of the decoded size. The info document is a small "+
+					"summary shown in the load menu of the game; the data document "+
+					"holds the career.",
+				mmse.DefaultCodec,
+			),
+			fmt.Sprintf(
+				"mmse refuses frames declaring a decoded size beyond %d bytes or "+
+					"beyond %d times their encoded size.",
+				mmse.MaxDecodedSize, mmse.MaxRatio,
+			),
+		},
+	}
+
+	docs := docSection{
+		title: "Documents",
+		paras: []string{"Unpack writes the documents in these formats:"},
+		head:  []string{"Format", "Extension"},
+	}
+
+	for _, f := range jsonconv.Formats {
+		docs.rows = append(docs.rows, []string{string(f), f.Ext()})
+	}
+
+	fields := docSection{
+		title: "Fields",
+		head:  []string{"Path", "Type", "Range", "Safety", "Meaning"},
+	}
+
+	fs := readFields()
+
+	if len(fs) == 0 {
+		fields.paras = []string{"No fields are documented."}
+		fields.head = nil
+	}
+
+	for _, f := range fs {
+		fields.rows = append(fields.rows, []string{f.Path, f.Type, f.rangeText(), f.Safety, f.Meaning})
+	}
+
+	return []docSection{layoutSection(), frames, docs, fields}
+}
+
+// docTitle is the title of the specification.
+const docTitle = "Motorsport Manager save format"
+
+// writeMarkdown writes sections as a Markdown document.
+func writeMarkdown(w io.Writer, ss []docSection) {
+	cell := strings.NewReplacer("|", `\|`, "\n", " ")
+
+	fmt.Fprintf(w, "# %s\n\n", docTitle)
+	fmt.Fprintf(w, "<!-- Generated by mmse %s doc format; do not edit. -->\n", version)
+
+	for _, s := range ss {
+		fmt.Fprintf(w, "\n## %s\n", s.title)
+
+		for _, p := range s.paras {
+			fmt.Fprintf(w, "\n%s\n", p)
+		}
+
+		if s.head == nil {
+			continue
+		}
+
+		fmt.Fprintf(w, "\n| %s |\n|", strings.Join(s.head, " | "))
+		fmt.Fprint(w, strings.Repeat(" --- |", len(s.head)))
+		fmt.Fprintln(w)
+
+		for _, r := range s.rows {
+			cs := make([]string, len(r))
+
+			for i, c := range r {
+				cs[i] = cell.Replace(c)
+			}
+
+			fmt.Fprintf(w, "| %s |\n", strings.Join(cs, " | "))
+		}
+	}
+}
+
+// writeHTML writes sections as an HTML document.
+func writeHTML(w io.Writer, ss []docSection) {
+	e := html.EscapeString
+
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<!-- Generated by mmse %s doc format; do not edit. -->\n", e(version))
+	fmt.Fprintf(w, "<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n", e(docTitle))
+	fmt.Fprintf(w, "<h1>%s</h1>\n", e(docTitle))
+
+	for _, s := range ss {
+		fmt.Fprintf(w, "<h2>%s</h2>\n", e(s.title))
+
+		for _, p := range s.paras {
+			fmt.Fprintf(w, "<p>%s</p>\n", e(p))
+		}
+
+		if s.head == nil {
+			continue
+		}
+
+		fmt.Fprint(w, "<table>\n<tr>")
+
+		for _, h := range s.head {
+			fmt.Fprintf(w, "<th>%s</th>", e(h))
+		}
+
+		fmt.Fprintln(w, "</tr>")
+
+		for _, r := range s.rows {
+			fmt.Fprint(w, "<tr>")
+
+			for _, c := range r {
+				fmt.Fprintf(w, "<td>%s</td>", e(c))
+			}
+
+			fmt.Fprintln(w, "</tr>")
+		}
+
+		fmt.Fprintln(w, "</table>")
+	}
+
+	fmt.Fprintln(w, "</body>\n</html>")
+}
+
+// runDoc runs the doc command.
+func runDoc(args []string) {
+	if args[0] != "format" {
+		log.Panicf("Unknown document: %s", args[0])
+	}
+
+	if docHTML {
+		writeHTML(os.Stdout, formatDoc())
+	} else {
+		writeMarkdown(os.Stdout, formatDoc())
+	}
+}
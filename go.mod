@@ -1,10 +1,16 @@
 module github.com/mys721tx/mmse-go
 
 require (
-	github.com/frankban/quicktest v1.5.0 // indirect
 	github.com/pierrec/lz4 v2.5.2+incompatible
-	github.com/stretchr/objx v0.2.0 // indirect
 	github.com/stretchr/testify v1.6.1
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/frankban/quicktest v1.5.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/stretchr/objx v0.2.0 // indirect
 )
 
-go 1.13
+go 1.18
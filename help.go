@@ -0,0 +1,287 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonconv"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+// version is set at build time by the Makefile.
+var version = "dev"
+
+func init() {
+	register(&command{
+		name:  "help",
+		args:  "[command|topic]",
+		short: "show help for a command or topic",
+		long: `
+Help lists the commands and help topics, or shows the full help of one
+command or topic.`,
+		example: `
+mmse help
+mmse help unpack
+mmse help formats`,
+		nargs: func(n int) bool { return n <= 1 },
+		run:   runHelp,
+	})
+
+	register(&command{
+		name:  "man",
+		short: "write the manual page in roff format",
+		long: `
+Man writes a manual page covering every command and help topic to standard
+output.`,
+		example: `mmse man > mmse.1`,
+		nargs:   exactly(0),
+		run: func([]string) {
+			writeMan(os.Stdout)
+		},
+	})
+
+	registerTopic(&topic{
+		name:  "formats",
+		short: "layout of save files and the supported document formats",
+		long:  formatsHelp(),
+	})
+
+	registerTopic(&topic{
+		name:  "config",
+		short: "the configuration file",
+		long: `
+Defaults for the flags are read from config.yml in the mmse directory under
+the user configuration directory, such as ~/.config/mmse/config.yml, or from
+the file given by -config. Flags override the configuration file. An example
+configuration:
+
+	save_dir: ~/Documents/Motorsport Manager/Saves
+	backup: store  # none, bak, timestamp, or store
+	backup_dir: ~/mmse-backups  # for store
+	backup_keep: 20
+	backup_max_age: 30d
+	dup_keys: warn  # warn or error
+	jobs: 4  # saves processed at once; 0 uses every CPU
+	pretty: true
+	compression_level: 9
+	format: json
+	gzip_output: false  # write .json.gz documents
+	extract_blobs: false  # write long base64 strings to files
+	sidecar: false  # describe unpacked saves for pack to check
+	info_template: "{{.Name}}_info{{.Ext}}"
+	data_template: "{{.Name}}_data{{.Ext}}"
+	save_template: "{{.Name}}{{.Ext}}"
+	steam_dir: ~/.local/share/Steam
+	version_path: gameVersion  # path of the version in the info document
+	date_path: gameDate  # path of the in-game date, for timeline
+	metrics:  # for stats
+	  balance: data.playerTeam.financeBalance
+	id_keys: [id, ID, Id]  # for xref
+	sign_key: ~/league.key  # for sign and verify-signature
+	mod_keys: [<public key>]  # authors whose mods install; see mmse help mod
+	audit: true  # record changes to saves; see mmse help audit
+	discord_webhook: https://discord.com/api/webhooks/<id>/<token>  # for watch
+	aliases:  # short names for paths
+	  money: data.playerTeam.financeBalance
+	  driver1: data.playerTeam.drivers[0]
+
+Saves not found in the working directory are looked up in save_dir, and packed
+saves are written to it. Before a save is overwritten, it is copied to a .bak
+file, or to the backup store, according to the backup policy. Backup_keep and
+backup_max_age limit the backups kept in the store; see "mmse help backup". The templates name the output files; Name
+is the input file name without extension and Ext is the output extension.
+Version_path locates the game version in the info document, which selects the
+field catalog used by validate and pack. Metrics name the paths tabulated by
+stats, and id_keys the keys holding the IDs of entities for xref. Aliases name
+paths for get, set, extract, and stats, such as "mmse set game.sav money
+5000000"; the rest of a path may follow an alias, as in driver1.name.`,
+	})
+}
+
+// formatsHelp describes the save file layout from the constants used by the
+// parser.
+func formatsHelp() string {
+	b := new(strings.Builder)
+
+	m := uint32(mmse.Magic)
+
+	fmt.Fprintf(b, `
+A save file is a header, a size table, and two LZ4 blocks. All integers are
+32-bit little endian.
+
+	offset  size  field
+	0       4     magic number 0x%08x (%q)
+	4       4     version number %d
+	8       4     compressed size of the info frame
+	12      4     uncompressed size of the info frame
+	16      4     compressed size of the data frame
+	20      4     uncompressed size of the data frame
+	24      ...   info frame, an LZ4 block
+	...     ...   data frame, an LZ4 block
+
+Each frame decompresses to a JSON document. The info frame is a small summary
+shown in the load menu of the game; the data frame holds the career. Some
+builds of the game write NUL bytes between the size table and the info frame;
+unpack skips them, and commands editing a save in place keep them.
+
+Unpack writes the frames as documents in one of these formats:
+`, m, string([]byte{byte(m), byte(m >> 8), byte(m >> 16), byte(m >> 24)}), mmse.Ver)
+
+	for _, f := range jsonconv.Formats {
+		fmt.Fprintf(b, "\n\t%s (%s)", f, f.Ext())
+	}
+
+	fmt.Fprintf(b, `
+
+Pack chooses the format of each input by its extension. Key order and the text
+of numbers are kept, except that TOML places the sub-tables of a table after
+its other keys. Numbers are never converted to floating point, so IDs beyond
+2^53 and long fractions survive a round trip exactly. TOML cannot hold null
+values.`)
+
+	return b.String()
+}
+
+// runHelp runs the help command.
+func runHelp(args []string) {
+	if len(args) == 0 {
+		usage(os.Stdout)
+		return
+	}
+
+	if c, ok := commands[args[0]]; ok {
+		c.help(os.Stdout)
+		return
+	}
+
+	if t, ok := topics[args[0]]; ok {
+		fmt.Printf("mmse %s - %s\n\n%s\n", t.name, t.short, strings.TrimSpace(t.long))
+		return
+	}
+
+	log.Panicf("Unknown help topic: %s", args[0])
+}
+
+// roff escapes text for a manual page.
+func roff(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+
+	ls := strings.Split(s, "\n")
+
+	for i, l := range ls {
+		if strings.HasPrefix(l, ".") || strings.HasPrefix(l, "'") {
+			ls[i] = `\&` + l
+		}
+	}
+
+	return strings.Join(ls, "\n")
+}
+
+// writeText writes help text to a manual page, keeping indented lines as
+// preformatted blocks. Blank lines separate paragraphs, and end blocks only
+// when text follows.
+func writeText(w io.Writer, s string) {
+	pre, para, blank := false, false, 0
+
+	for _, l := range strings.Split(strings.TrimSpace(s), "\n") {
+		switch {
+		case l == "" && pre:
+			blank++
+		case l == "":
+			para = true
+		case strings.HasPrefix(l, "\t"):
+			if !pre {
+				fmt.Fprintln(w, ".PP\n.RS\n.nf")
+				pre, para, blank = true, false, 0
+			}
+
+			fmt.Fprint(w, strings.Repeat("\n", blank))
+			fmt.Fprintln(w, roff(strings.TrimPrefix(l, "\t")))
+
+			blank = 0
+		default:
+			if pre {
+				fmt.Fprintln(w, ".fi\n.RE")
+				pre, para, blank = false, true, 0
+			}
+
+			if para {
+				fmt.Fprintln(w, ".PP")
+				para = false
+			}
+
+			fmt.Fprintln(w, roff(l))
+		}
+	}
+
+	if pre {
+		fmt.Fprintln(w, ".fi\n.RE")
+	}
+}
+
+// writeMan writes the manual page.
+func writeMan(w io.Writer) {
+	fmt.Fprintf(w, ".TH MMSE 1 \"\" \"mmse %s\" \"User Commands\"\n", roff(version))
+	fmt.Fprintln(w, ".SH NAME\nmmse \\- Motorsport Manager save edit suite")
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	fmt.Fprintln(w, ".B mmse\n\\fIcommand\\fR [\\fIoptions\\fR] [\\fIarguments\\fR]")
+	fmt.Fprintln(w, ".SH DESCRIPTION")
+	fmt.Fprintln(w, "mmse packs and unpacks the save files of Motorsport Manager.")
+	fmt.Fprintln(w, "Given a save file and no command, mmse unpacks it; given two")
+	fmt.Fprintln(w, "documents and no command, mmse packs them.")
+	fmt.Fprintln(w, ".SH COMMANDS")
+
+	for _, c := range sortedCommands() {
+		fmt.Fprintf(w, ".SS \"mmse %s %s\"\n", c.name, roff(c.args))
+		fmt.Fprintln(w, roff(c.short))
+		fmt.Fprintln(w, ".PP")
+		writeText(w, c.long)
+
+		fs := c.flagSet()
+
+		fs.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(w, ".TP\n.B \\-%s\n%s", f.Name, roff(f.Usage))
+
+			// The default of -config depends on the user building the page.
+			if f.Name != "config" && f.DefValue != "" &&
+				f.DefValue != "false" && f.DefValue != "0" {
+				fmt.Fprintf(w, " (default %s)", roff(f.DefValue))
+			}
+
+			fmt.Fprintln(w)
+		})
+
+		if c.example != "" {
+			fmt.Fprintln(w, ".PP\nExamples:\n.PP\n.RS\n.nf")
+			fmt.Fprintln(w, roff(strings.TrimSpace(c.example)))
+			fmt.Fprintln(w, ".fi\n.RE")
+		}
+	}
+
+	for _, t := range sortedTopics() {
+		fmt.Fprintf(w, ".SH %s\n", strings.ToUpper(t.name))
+		writeText(w, t.long)
+	}
+
+	fmt.Fprintln(w, ".SH SEE ALSO\nhttps://github.com/mys721tx/mmse\\-go")
+}
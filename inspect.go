@@ -0,0 +1,155 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+var (
+	// offsets prints the offset map of a save.
+	offsets bool
+	// dump is the number of bytes of each region to hexdump.
+	dump int
+)
+
+func init() {
+	register(&command{
+		name:  "inspect",
+		args:  "<game.sav>",
+		short: "describe the layout of a save file",
+		long: `
+Inspect prints the header and the frame sizes of a save file without decoding
+the frames. It works on damaged saves and saves of unknown versions.
+
+Saves of version 4 have two frames, info and data. The frames of other
+versions are listed as the size table of their version describes them.
+
+With -offsets, inspect also prints the offset and length of every region of
+the file: the magic number, the version number, the size fields, the frames,
+and any bytes after the last frame. Regions cut short by truncation are marked.
+With -hexdump, the first bytes of every region are dumped as well, at their
+offsets in the file.`,
+		example: `
+mmse inspect game.sav
+mmse inspect -offsets -hexdump 64 game.sav`,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&offsets, "offsets", false, "print the offset map")
+			fs.IntVar(&dump, "hexdump", 0, "hexdump the first `n` bytes of every region")
+			flagSaveDir(fs)
+		},
+		nargs: exactly(1),
+		run:   runInspect,
+	})
+}
+
+// runInspect runs the inspect command.
+func runInspect(args []string) {
+	fn := findSave(args[0])
+
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	field := func(at int) (int32, bool) {
+		if len(b) < at+4 {
+			return 0, false
+		}
+
+		return mmse.DecodeLE[int32](b[at:]), true
+	}
+
+	check := func(v, want int32) string {
+		if v == want {
+			return "ok"
+		}
+
+		return fmt.Sprintf("expected %#x", want)
+	}
+
+	fmt.Printf("%s: %d bytes\n", fn, len(b))
+
+	if m, ok := field(0); ok {
+		fmt.Printf("magic\t\t%#x (%s)\n", uint32(m), check(m, mmse.Magic))
+	}
+
+	v, ok := field(4)
+
+	if ok {
+		fmt.Printf("version\t\t%d (%s)\n", v, check(v, mmse.Ver))
+	}
+
+	for i := 0; i < mmse.FrameCount(v); i++ {
+		com, ok1 := field(8 + 8*i)
+		raw, ok2 := field(12 + 8*i)
+
+		if ok1 && ok2 {
+			fmt.Printf("%s\t%d encoded, %d decoded\n", mmse.FrameRegion(i), com, raw)
+		}
+	}
+
+	if !offsets && dump <= 0 {
+		return
+	}
+
+	fmt.Printf("\n%-10s %-10s %s\n", "offset", "length", "region")
+
+	for _, r := range mmse.Layout(b) {
+		fmt.Printf("%-10d %-10d %s", r.Offset, r.Length, r.Name)
+
+		if r.Missing > 0 {
+			fmt.Printf(" (truncated, %d bytes missing)", r.Missing)
+		}
+
+		fmt.Println()
+
+		if n := int64(dump); n > 0 && r.Length > 0 {
+			if n > r.Length {
+				n = r.Length
+			}
+
+			os.Stdout.WriteString(dumpAt(b[r.Offset:r.Offset+n], r.Offset))
+		}
+	}
+}
+
+// dumpAt returns the hexdump of b, which starts at offset off of the file,
+// with the offsets in the file.
+func dumpAt(b []byte, off int64) string {
+	ls := strings.SplitAfter(hex.Dump(b), "\n")
+
+	for i, l := range ls {
+		if len(l) < 8 {
+			continue
+		}
+
+		if n, err := strconv.ParseInt(l[:8], 16, 64); err == nil {
+			ls[i] = fmt.Sprintf("%08x", off+n) + l[8:]
+		}
+	}
+
+	return strings.Join(ls, "")
+}
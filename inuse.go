@@ -0,0 +1,105 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// gameProcesses are the process names of Motorsport Manager.
+var gameProcesses = []string{"MM.exe", "MM.x86_64", "Motorsport Manager"}
+
+// force disables the checks refusing to write files, such as the checks for
+// saves in use.
+var force bool
+
+// flagForce registers the flag overriding the checks refusing to write files.
+func flagForce(fs *flag.FlagSet) {
+	fs.BoolVar(
+		&force, "force", force,
+		"write files despite the checks, such as saves open in another process",
+	)
+}
+
+// process describes a running process.
+type process struct {
+	pid  int
+	name string
+}
+
+// String formats the process for messages.
+func (p process) String() string {
+	if p.pid == 0 {
+		return p.name
+	}
+
+	return fmt.Sprintf("%s (pid %d)", p.name, p.pid)
+}
+
+// isGame reports whether a process name belongs to the game.
+func isGame(name string) bool {
+	for _, g := range gameProcesses {
+		if strings.EqualFold(filepath.Base(name), g) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkInUse refuses to overwrite a save that another process has open and
+// warns when the game is running, since the game may overwrite the save
+// later. The checks are skipped with -force.
+func checkInUse(fn string) {
+	if force || !fileExists(fn) {
+		return
+	}
+
+	if abs, err := filepath.Abs(fn); err == nil {
+		fn = abs
+	}
+
+	if p, err := filepath.EvalSymlinks(fn); err == nil {
+		fn = p
+	}
+
+	if ps := openBy(fn); len(ps) > 0 {
+		s := make([]string, len(ps))
+
+		for i, p := range ps {
+			s[i] = p.String()
+		}
+
+		log.Panicf(
+			"%s is open in %s; close it or use -force",
+			fn, strings.Join(s, ", "),
+		)
+	}
+
+	for _, p := range runningProcesses() {
+		if isGame(p.name) {
+			log.Printf(
+				"Warning: Motorsport Manager is running as %s and may overwrite %s",
+				p, fn,
+			)
+		}
+	}
+}
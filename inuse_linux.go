@@ -0,0 +1,101 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pids returns the process IDs listed in /proc.
+func pids() []int {
+	fs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+
+	var ps []int
+
+	for _, f := range fs {
+		if pid, err := strconv.Atoi(f.Name()); err == nil && pid != os.Getpid() {
+			ps = append(ps, pid)
+		}
+	}
+
+	return ps
+}
+
+// processName returns the name of a process. Windows programs running under
+// Wine are named after their executable.
+func processName(pid int) string {
+	d := filepath.Join("/proc", strconv.Itoa(pid))
+
+	if b, err := os.ReadFile(filepath.Join(d, "cmdline")); err == nil {
+		if i := strings.IndexByte(string(b), 0); i > 0 {
+			if exe := string(b[:i]); strings.HasSuffix(strings.ToLower(exe), ".exe") {
+				return filepath.Base(strings.Replace(exe, `\`, "/", -1))
+			}
+		}
+	}
+
+	b, err := os.ReadFile(filepath.Join(d, "comm"))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+// openBy returns the processes having file fn open. Processes of other users
+// cannot be inspected and are skipped.
+func openBy(fn string) []process {
+	var ps []process
+
+	for _, pid := range pids() {
+		d := filepath.Join("/proc", strconv.Itoa(pid), "fd")
+
+		fds, err := os.ReadDir(d)
+		if err != nil {
+			continue
+		}
+
+		for _, fd := range fds {
+			if t, err := os.Readlink(filepath.Join(d, fd.Name())); err == nil && t == fn {
+				ps = append(ps, process{pid: pid, name: processName(pid)})
+				break
+			}
+		}
+	}
+
+	return ps
+}
+
+// runningProcesses returns the running processes.
+func runningProcesses() []process {
+	var ps []process
+
+	for _, pid := range pids() {
+		ps = append(ps, process{pid: pid, name: processName(pid)})
+	}
+
+	return ps
+}
@@ -0,0 +1,89 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"strconv"
+)
+
+// lsof runs lsof with arguments and parses the process IDs and names in its
+// field output. Nothing is returned when lsof is not available.
+func lsof(args ...string) []process {
+	out, err := exec.Command("lsof", append([]string{"-F", "pc"}, args...)...).Output()
+	if err != nil && len(out) == 0 {
+		return nil
+	}
+
+	var (
+		ps []process
+		p  process
+	)
+
+	s := bufio.NewScanner(bytes.NewReader(out))
+
+	for s.Scan() {
+		l := s.Text()
+
+		if l == "" {
+			continue
+		}
+
+		switch l[0] {
+		case 'p':
+			if p.pid != 0 {
+				ps = append(ps, p)
+			}
+
+			p = process{}
+			p.pid, _ = strconv.Atoi(l[1:])
+		case 'c':
+			p.name = l[1:]
+		}
+	}
+
+	if p.pid != 0 {
+		ps = append(ps, p)
+	}
+
+	return ps
+}
+
+// openBy returns the processes having file fn open.
+func openBy(fn string) []process {
+	return lsof("--", fn)
+}
+
+// runningProcesses returns the running processes having the game name. lsof
+// truncates process names, so the found processes are named after the game.
+func runningProcesses() []process {
+	var ps []process
+
+	for _, g := range gameProcesses {
+		for _, p := range lsof("-c", g) {
+			p.name = g
+			ps = append(ps, p)
+		}
+	}
+
+	return ps
+}
@@ -0,0 +1,76 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// errSharingViolation is ERROR_SHARING_VIOLATION.
+const errSharingViolation syscall.Errno = 32
+
+// openBy returns a placeholder process when file fn cannot be opened
+// exclusively. Windows does not tell which process holds the file.
+func openBy(fn string) []process {
+	p, err := syscall.UTF16PtrFromString(fn)
+	if err != nil {
+		return nil
+	}
+
+	h, err := syscall.CreateFile(
+		p, syscall.GENERIC_READ|syscall.GENERIC_WRITE, 0, nil,
+		syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0,
+	)
+
+	if err == errSharingViolation {
+		return []process{{name: "another process"}}
+	} else if err == nil {
+		syscall.CloseHandle(h)
+	}
+
+	return nil
+}
+
+// runningProcesses returns the running processes.
+func runningProcesses() []process {
+	s, err := syscall.CreateToolhelp32Snapshot(syscall.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil
+	}
+
+	defer syscall.CloseHandle(s)
+
+	var (
+		ps []process
+		e  syscall.ProcessEntry32
+	)
+
+	e.Size = uint32(unsafe.Sizeof(e))
+
+	for err = syscall.Process32First(s, &e); err == nil; err = syscall.Process32Next(s, &e) {
+		ps = append(ps, process{
+			pid:  int(e.ProcessID),
+			name: syscall.UTF16ToString(e.ExeFile[:]),
+		})
+	}
+
+	return ps
+}
@@ -0,0 +1,243 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/mys721tx/mmse-go/pkg/lock"
+)
+
+// lockExt is appended to the names of locked saves so that the game does not
+// list them.
+const lockExt = ".locked"
+
+var (
+	// passFile is the file holding the password.
+	passFile string
+	// keep keeps the input of lock and unlock.
+	keep bool
+)
+
+func init() {
+	lockFlags := func(fs *flag.FlagSet) {
+		fs.StringVar(&passFile, "passfile", "", "read the password from the first line of `file`")
+		fs.BoolVar(&keep, "keep", false, "keep the input file")
+		flagSaveDir(fs)
+	}
+
+	register(&command{
+		name:  "lock",
+		args:  "<game.sav>",
+		short: "protect a save file with a password",
+		long: `
+Lock encrypts a save file with a password into game.sav.locked and removes the
+save, so that the career cannot be loaded or edited without the password. The
+locked file is not a save file and the game does not list it; run unlock to
+restore the save before playing.
+
+The password is read from the file given by -passfile, from the MMSE_PASSWORD
+environment variable, or from the terminal. Locked files use AES-256-GCM with a
+key derived from the password by PBKDF2-HMAC-SHA256. A forgotten password
+cannot be recovered. An empty password is refused, also from -passfile.
+
+Lock only protects the save itself. Copies made before, such as the backups
+in the backup store, .bak files, and the backups of labels, stay readable;
+see "mmse help backup" to find them.`,
+		example: `
+mmse lock game.sav
+mmse unlock game.sav.locked`,
+		flags: lockFlags,
+		nargs: exactly(1),
+		run:   runLock,
+	})
+
+	register(&command{
+		name:  "unlock",
+		args:  "<game.sav.locked>",
+		short: "restore a save file protected by lock",
+		long: `
+Unlock decrypts a file written by lock and restores the save, then removes the
+locked file. The password is read as by lock. An existing save of the same name
+is not overwritten unless -force is given.`,
+		example: `
+mmse unlock game.sav.locked`,
+		flags: func(fs *flag.FlagSet) {
+			lockFlags(fs)
+			flagForce(fs)
+		},
+		nargs: exactly(1),
+		run:   runUnlock,
+	})
+}
+
+// password returns the password for lock and unlock, refusing an empty one.
+func password(confirm bool) []byte {
+	p := readSecret(confirm)
+
+	if len(p) == 0 {
+		log.Panicf("Empty password")
+	}
+
+	return p
+}
+
+// readSecret reads the password from the password file, the environment, or
+// the terminal. New passwords read from the terminal are asked twice.
+func readSecret(confirm bool) []byte {
+	if passFile != "" {
+		b, err := os.ReadFile(passFile)
+		if err != nil {
+			log.Panicf("Unable to read password file: %s", err)
+		}
+
+		return []byte(strings.TrimRight(strings.SplitN(string(b), "\n", 2)[0], "\r"))
+	}
+
+	if p := os.Getenv("MMSE_PASSWORD"); p != "" {
+		return []byte(p)
+	}
+
+	p := readPassword("Password: ")
+
+	if confirm && !bytes.Equal(p, readPassword("Repeat password: ")) {
+		log.Panicf("Passwords do not match")
+	}
+
+	return p
+}
+
+// readPassword reads a line from the terminal, hiding the input where stty is
+// available.
+func readPassword(prompt string) []byte {
+	fmt.Fprint(os.Stderr, prompt)
+
+	if runtime.GOOS != "windows" {
+		stty := func(arg string) error {
+			c := exec.Command("stty", arg)
+			c.Stdin = os.Stdin
+
+			return c.Run()
+		}
+
+		if stty("-echo") == nil {
+			defer func() {
+				stty("echo")
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+
+	l, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && l == "" {
+		log.Panicf("Unable to read password: %s", err)
+	}
+
+	return []byte(strings.TrimRight(l, "\r\n"))
+}
+
+// runLock runs the lock command.
+func runLock(args []string) {
+	fn := findSave(args[0])
+	out := fn + lockExt
+
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	if lock.IsLocked(b) {
+		log.Panicf("%s is already locked", fn)
+	}
+
+	if fileExists(out) {
+		log.Panicf("%s exists", out)
+	}
+
+	if !keep {
+		checkInUse(fn)
+	}
+
+	l, err := lock.Seal(b, password(true))
+	if err != nil {
+		log.Panicf("Unable to lock %s: %s", fn, err)
+	}
+
+	if err := os.WriteFile(out, l, 0600); err != nil {
+		log.Panicf("Unable to write %s: %s", out, err)
+	}
+
+	audit(fn, hashBytes(b), hashBytes(l), []string{"lock to " + out})
+
+	if !keep {
+		if err := os.Remove(fn); err != nil {
+			log.Panicf("Unable to remove %s: %s", fn, err)
+		}
+	}
+
+	fmt.Printf("Locked %s to %s\n", fn, out)
+}
+
+// runUnlock runs the unlock command.
+func runUnlock(args []string) {
+	fn := findSave(args[0])
+
+	if !strings.HasSuffix(fn, lockExt) {
+		log.Panicf("%s does not end in %s", fn, lockExt)
+	}
+
+	out := strings.TrimSuffix(fn, lockExt)
+
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	if fileExists(out) && !force {
+		log.Panicf("%s exists; use -force to overwrite it", out)
+	}
+
+	s, err := lock.Open(b, password(false))
+	if err != nil {
+		log.Panicf("Unable to unlock %s: %s", fn, err)
+	}
+
+	// A save overwritten with -force has a hash before.
+	before, _ := hashFile(out)
+
+	if err := os.WriteFile(out, s, 0644); err != nil {
+		log.Panicf("Unable to write %s: %s", out, err)
+	}
+
+	audit(out, before, hashBytes(s), []string{"unlock " + fn})
+
+	if !keep {
+		if err := os.Remove(fn); err != nil {
+			log.Panicf("Unable to remove %s: %s", fn, err)
+		}
+	}
+
+	fmt.Printf("Unlocked %s to %s\n", fn, out)
+}
@@ -0,0 +1,351 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+)
+
+func init() {
+	register(&command{
+		name:  "record",
+		args:  "<game.sav> <script>",
+		short: "edit a save interactively and record the edits",
+		long: `
+Record reads get and set operations from standard input, one per line, applies
+them to a save file, and writes the operations that succeeded to a script
+that replay applies to other saves:
+
+	get <path>
+	set <path> <value>
+
+Get prints a value as the get command does and set changes it as the set
+command does, including its safety checks. Quit, or the end of input, writes
+the save and the script; abort leaves both unchanged.
+
+Scripts are text files that can also be written by hand. Lines starting with #
+are comments.
+` + pathHelp,
+		example: `
+mmse record game.sav season.mmse
+mmse replay season.mmse next_season.sav`,
+		flags: func(fs *flag.FlagSet) {
+			flagLevel(fs)
+			flagBackup(fs)
+			flagSaveDir(fs)
+			flagForce(fs)
+			fs.BoolVar(&allowRisky, "allowrisky", false, "change fields documented as risky")
+			flagNoClamp(fs)
+		},
+		nargs: exactly(2),
+		run:   runRecord,
+	})
+
+	register(&command{
+		name:  "replay",
+		args:  "<script> <game.sav>...",
+		short: "apply a recorded script to save files",
+		long: `
+Replay applies the operations of a script written by record, or by hand, to
+each save file in turn and writes the saves. Get operations print the value
+with the name of the save. A save is left unchanged when an operation fails,
+and replay exits with status 1 when any save fails.`,
+		example: `
+mmse replay season.mmse game.sav
+mmse replay -allowrisky season.mmse autosave*.sav`,
+		flags: func(fs *flag.FlagSet) {
+			flagLevel(fs)
+			flagBackup(fs)
+			flagSaveDir(fs)
+			flagForce(fs)
+			fs.BoolVar(&allowRisky, "allowrisky", false, "change fields documented as risky")
+			flagNoClamp(fs)
+		},
+		nargs: atLeast(2),
+		run:   runReplay,
+	})
+}
+
+// op is an operation of a script.
+type op struct {
+	name, path string
+	value      []byte
+}
+
+// String formats the operation as a line of a script.
+func (o op) String() string {
+	if o.name == "set" {
+		return fmt.Sprintf("set %s %s", o.path, o.value)
+	}
+
+	return o.name + " " + o.path
+}
+
+// parseOp parses a line of a script. Empty lines and comments return false.
+func parseOp(l string) (op, bool, error) {
+	l = strings.TrimSpace(l)
+
+	if l == "" || strings.HasPrefix(l, "#") {
+		return op{}, false, nil
+	}
+
+	name, rest := cutSpace(l)
+
+	switch name {
+	case "quit", "abort":
+		return op{name: name}, true, nil
+	case "get", "set":
+	default:
+		return op{}, false, fmt.Errorf("unknown operation %q", name)
+	}
+
+	path, rest := cutPath(rest)
+
+	if path == "" {
+		return op{}, false, fmt.Errorf("%s needs a path", name)
+	}
+
+	if _, err := jsonpath.Parse(path); err != nil {
+		return op{}, false, fmt.Errorf("invalid path: %s", err)
+	}
+
+	o := op{name: name, path: path}
+
+	switch {
+	case name == "set" && rest == "":
+		return op{}, false, fmt.Errorf("set needs a value")
+	case name == "set":
+		o.value = jsonValue(rest)
+	case rest != "":
+		return op{}, false, fmt.Errorf("get takes only a path")
+	}
+
+	return o, true, nil
+}
+
+// cutSpace splits a string at the first white space.
+func cutSpace(s string) (string, string) {
+	if i := strings.IndexFunc(s, unicode.IsSpace); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+
+	return s, ""
+}
+
+// cutPath splits a path from the rest of a string. Quoted keys in the path may
+// hold white space.
+func cutPath(s string) (string, string) {
+	quoted := false
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && unicode.IsSpace(rune(c)):
+			return s[:i], strings.TrimSpace(s[i:])
+		}
+	}
+
+	return s, ""
+}
+
+// apply applies an operation to a save, writing the values of gets to w with
+// a prefix.
+func (e *rawSave) apply(o op, w io.Writer, prefix string) {
+	switch o.name {
+	case "get":
+		i, p := docPath(o.path)
+
+		b := new(bytes.Buffer)
+
+		ok, err := jsonpath.Extract(bytes.NewReader(e.docs[i]), p, b)
+		if err != nil {
+			log.Panicf("Unable to read %s: %s", o.path, err)
+		} else if !ok {
+			log.Panicf("No value at %s", o.path)
+		}
+
+		fmt.Fprintf(w, "%s%s\n", prefix, b)
+	case "set":
+		e.splice(o.path, checkSafety(o.path, o.value))
+	}
+}
+
+// try runs fn and reports whether it returned without failing with
+// log.Panicf, which has already logged the message. Other panics are bugs and
+// crash as in main.
+func try(fn func()) (ok bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		if _, isErr := r.(string); !isErr {
+			panic(r)
+		}
+
+		ok = false
+	}()
+
+	fn()
+
+	return true
+}
+
+// runRecord runs the record command.
+func runRecord(args []string) {
+	e := openRaw(args[0])
+
+	var (
+		script []string
+		abort  bool
+		quit   bool
+	)
+
+	s := bufio.NewScanner(os.Stdin)
+
+	fmt.Fprint(os.Stderr, "> ")
+
+	for s.Scan() {
+		o, ok, err := parseOp(s.Text())
+
+		switch {
+		case err != nil:
+			log.Printf("%s", err)
+		case ok && o.name == "abort":
+			abort = true
+		case ok && o.name != "quit" && try(func() { e.apply(o, os.Stdout, "") }):
+			script = append(script, o.String())
+		}
+
+		if quit = o.name == "quit" || abort; quit {
+			break
+		}
+
+		fmt.Fprint(os.Stderr, "> ")
+	}
+
+	if !quit {
+		// End the prompt line left by the end of input.
+		fmt.Fprintln(os.Stderr)
+	}
+
+	if err := s.Err(); err != nil {
+		log.Panicf("Unable to read operations: %s", err)
+	}
+
+	if abort {
+		log.Printf("Aborted; %s and %s are unchanged", e.fn, args[1])
+		return
+	}
+
+	b := new(bytes.Buffer)
+
+	fmt.Fprintf(b, "# Recorded by mmse from %s.\n", e.fn)
+
+	for _, l := range script {
+		fmt.Fprintln(b, l)
+	}
+
+	if err := os.WriteFile(args[1], b.Bytes(), 0644); err != nil {
+		log.Panicf("Unable to write %s: %s", args[1], err)
+	}
+
+	if e.frames[0] == nil || e.frames[1] == nil {
+		e.write()
+		warnCloud(e.fn)
+	}
+
+	fmt.Printf("Recorded %d operations to %s\n", len(script), args[1])
+}
+
+// readScript reads the operations of a script.
+func readScript(fn string) []op {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Panicf("Unable to open %s: %s", fn, err)
+	}
+
+	defer f.Close()
+
+	var ops []op
+
+	s := bufio.NewScanner(f)
+
+	for n := 1; s.Scan(); n++ {
+		o, ok, err := parseOp(s.Text())
+		if err != nil {
+			log.Panicf("%s:%d: %s", fn, n, err)
+		}
+
+		if ok && o.name == "quit" {
+			break
+		} else if ok && o.name != "abort" {
+			ops = append(ops, o)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	return ops
+}
+
+// runReplay runs the replay command.
+func runReplay(args []string) {
+	ops := readScript(args[0])
+
+	failed := 0
+
+	for _, fn := range args[1:] {
+		ok := try(func() {
+			e := openRaw(fn)
+
+			for _, o := range ops {
+				e.apply(o, os.Stdout, e.fn+": ")
+			}
+
+			if e.frames[0] == nil || e.frames[1] == nil {
+				e.write()
+				warnCloud(e.fn)
+			}
+		})
+
+		if !ok {
+			log.Printf("Unable to replay %s on %s", args[0], fn)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fail()
+	}
+}
@@ -17,26 +17,374 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/mys721tx/mmse-go/pkg/jsonconv"
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
 	"github.com/mys721tx/mmse-go/pkg/mmse"
 )
 
-var (
-	usg = `Usage:
-	%[1]s <game.sav>
-	%[1]s <info.json> <data.json>
-`
+func init() {
+	register(&command{
+		name:  "unpack",
+		args:  "<game.sav>... | -all",
+		short: "unpack save files to info and data documents",
+		long: `
+Unpack decompresses the two frames of a save file and writes each to a
+document named after the save file, such as game_info.json and
+game_data.json. With -format, the documents are written as YAML or TOML.
+
+The game may write a key twice within an object. Unpack warns about such keys,
+or fails with -dupkeys error. JSON documents keep both values, but YAML and
+TOML cannot hold them, so unpack to those formats fails. To edit such a save
+without losing either value, unpack it as JSON or use set, which changes the
+save in place.
+
+With -gzipoutput, the documents are compressed with gzip and named with the
+extension .gz appended, such as game_data.json.gz.
+
+With -extractblobs, string values of at least 4096 characters holding base64,
+such as embedded images, are written to files in a directory named like the
+document with the extension .blobs, such as game_data.blobs/1.png, and
+replaced by references such as "mmse-blob:1.png". Pack encodes the files
+into the save again, so they can be viewed and replaced as files.
+
+Bytes after the data frame, which the game does not write, are kept in a file
+named like the data document with the extension .trailing, such as
+game_data.trailing. NUL bytes that some builds write before the info frame
+are counted in a file with the extension .padding, such as game_data.padding.
+Pack writes both into the save again.
+
+With -sidecar, unpack also writes a file named like the data document with the
+extension .mmse.json, such as game_data.mmse.json, recording the path, size,
+checksum, and version of the save, the sizes and checksums of its frames, the
+version of mmse, and the time of the unpack. Pack checks the documents against
+it; see "mmse help pack".
+
+Several saves are unpacked at once, up to -jobs at a time, and unpack exits with
+status 1 when any of them fails.
+
+With -all, unpack unpacks every save in the save directory, or in the working
+directory without one. It records the checksum of each save unpacked in
+.mmse-unpack.json in the working directory and skips saves that are unchanged
+since, as long as their documents exist and the output flags are the same, so
+that it can run from a scheduled task. Delete the file to unpack every save
+again.
+
+Unpack refuses to overwrite existing documents, which may hold edits not
+packed yet, unless -force is given; -all overwrites the documents of changed
+saves, since it keeps them in step with the saves. With -o, the documents are
+written to another directory, which is created if needed.
+
+A save that is not found in the working directory is looked up in the save
+directory. See "mmse help formats" for the save layout and "mmse help config"
+for the output templates.`,
+		example: `
+mmse unpack game.sav
+mmse unpack -format yaml -pretty game.sav
+mmse unpack -jobs 4 autosave*.sav
+mmse unpack -gzipoutput game.sav
+mmse unpack -o edits game.sav
+mmse unpack -all -savedir ~/saves`,
+		flags: func(fs *flag.FlagSet) {
+			flagFormat(fs)
+			flagPretty(fs)
+			flagGzipOutput(fs)
+			flagExtractBlobs(fs)
+			flagDupKeys(fs)
+			flagJobs(fs)
+			fs.BoolVar(&unpackAll, "all", false, "unpack the saves in the save directory that changed")
+			fs.StringVar(&unpackDir, "o", "", "write the documents to `dir`")
+			flagSidecar(fs)
+			flagSaveDir(fs)
+			flagForce(fs)
+		},
+		nargs: func(n int) bool { return (n == 0) == unpackAll },
+		run:   runUnpack,
+	})
+
+	register(&command{
+		name:  "pack",
+		args:  "<info> [<data>]",
+		short: "pack an info and a data document into a save file",
+		long: `
+Pack compresses an info document and a data document into a save file named
+after the data document, such as game_data.sav. Documents ending in .yaml,
+.yml, or .toml are converted to JSON first. Documents compressed with gzip,
+such as game_data.json.gz, are decompressed.
+
+Given one document, pack finds the other by the output templates, so that
+game_info.json and game_data.json are packed from either. The other document
+may be in another format, such as game_info.yaml, when it is the only one. Two documents given
+in the wrong order are swapped when the templates tell them apart. Otherwise,
+pack refuses documents that look swapped by their content: an info document
+larger than the data document, or the paths of version_path or date_path
+found only in the data document. Use -force to pack them as given.
+
+With a save directory, the save is written there; -o names the save instead.
+An existing save is backed up according to the backup policy before it is
+overwritten. Pack refuses to write the save over one of its documents.
+
+When unpack -sidecar described the save of the data document, pack reports
+which documents changed since the unpack. Unless -force is given, it refuses
+an info document of another save, and refuses to overwrite the save when it
+changed since the unpack, as when the game saved again, since the changes
+would be lost.
+
+Pack refuses to overwrite a save that another process, usually the game, has
+open, and warns when the game is running, since the game may overwrite the
+save seconds later. Use -force to skip these checks. A .trailing file next to
+the data document, written by unpack, is appended to the save, the padding
+counted in a .padding file is written before the info frame, and blobs
+extracted by unpack -extractblobs are embedded again. Pack also warns when the
+Steam Cloud cache records the save differently; see "mmse help cloud". It
+warns about fields unknown to the game version; see "mmse help catalog". Keys
+repeated within an object are kept, with a warning, or refused with -dupkeys
+error.`,
+		example: `
+mmse pack game_info.json game_data.json
+mmse pack game_data.json
+mmse pack -level 9 -backup bak game_info.yaml game_data.yaml`,
+		flags: func(fs *flag.FlagSet) {
+			flagPretty(fs)
+			flagLevel(fs)
+			flagBackup(fs)
+			flagDupKeys(fs)
+			flagSaveDir(fs)
+			flagForce(fs)
+			flagSteamDir(fs)
+			flagGameVersion(fs)
+			fs.StringVar(&packOut, "o", "", "write the save to `file`")
+		},
+		nargs: func(n int) bool { return n == 1 || n == 2 },
+		run: func(args []string) {
+			for _, a := range args {
+				if sniff(a) == kindSave {
+					log.Panicf("%s is a save file, not a document; unpack it with mmse unpack %s", a, a)
+				}
+			}
+
+			in, dn := pairDocs(args)
+			warnCloud(pack(in, dn))
+		},
+	})
+}
+
+// gzipExt is appended to the extension of documents compressed with gzip.
+const gzipExt = ".gz"
+
+// docExt returns the extension of a document, including gzipExt after the
+// extension of the format for a compressed document.
+func docExt(fn string) string {
+	ext := filepath.Ext(fn)
+
+	if ext == gzipExt {
+		return filepath.Ext(strings.TrimSuffix(fn, ext)) + ext
+	}
+
+	return ext
+}
+
+// outputExt returns the extension of documents written by unpack.
+func outputExt(ft jsonconv.Format) string {
+	if cfg.GzipOutput {
+		return ft.Ext() + gzipExt
+	}
+
+	return ft.Ext()
+}
+
+// templateName returns the Name rendered by an output template into file name
+// fn, if the template can render fn.
+func templateName(tmpl, fn string) (string, bool) {
+	ext := docExt(fn)
+
+	// A NUL byte cannot be in a file name, so it marks the Name in the output.
+	r := outputName(tmpl, names{Name: "\x00", Ext: ext})
+
+	i := strings.IndexByte(r, 0)
+	if i < 0 || strings.IndexByte(r[i+1:], 0) >= 0 {
+		return "", false
+	}
+
+	pre, suf := r[:i], r[i+1:]
+
+	if len(fn) <= len(pre)+len(suf) ||
+		!strings.HasPrefix(fn, pre) || !strings.HasSuffix(fn, suf) {
+		return "", false
+	}
+
+	return fn[len(pre) : len(fn)-len(suf)], true
+}
+
+// pairDocs returns the info and data documents to pack from the arguments of
+// pack. A missing document is found, and documents in the wrong order are
+// swapped, by the output templates.
+func pairDocs(args []string) (string, string) {
+	dir, fn := filepath.Split(args[0])
+	ext := docExt(fn)
+
+	in, isInfo := templateName(cfg.Info, fn)
+	dn, isData := templateName(cfg.Data, fn)
+
+	switch {
+	case len(args) == 2:
+		if isData && !isInfo {
+			if _, ok := templateName(cfg.Info, filepath.Base(args[1])); ok {
+				return args[1], args[0]
+			}
+		}
+
+		return args[0], args[1]
+	case isInfo && !isData:
+		return args[0], otherDoc(args[0], dir, cfg.Data, in, ext)
+	case isData && !isInfo:
+		return otherDoc(args[0], dir, cfg.Info, dn, ext), args[0]
+	}
+
+	log.Panicf("Unable to tell the other document of %s from the output templates", args[0])
+
+	return "", ""
+}
+
+// otherDoc returns the document rendered by an output template for the Name of
+// document fn in directory dir. A document with the extension of fn is taken
+// first; otherwise the one document found with the extension of another
+// format, or of gzip, is taken.
+func otherDoc(fn, dir, tmpl, name, ext string) string {
+	p := dir + outputName(tmpl, names{Name: name, Ext: ext})
+
+	if fileExists(p) {
+		return p
+	}
+
+	var found []string
+
+	for _, f := range jsonconv.Formats {
+		for _, e := range []string{f.Ext(), f.Ext() + gzipExt} {
+			if q := dir + outputName(tmpl, names{Name: name, Ext: e}); e != ext && fileExists(q) {
+				found = append(found, q)
+			}
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		log.Panicf("%s has no matching document %s; give both documents to pack", fn, p)
+	case 1:
+		return found[0]
+	}
+
+	log.Panicf("%s matches %s; give both documents to pack", fn, strings.Join(found, " and "))
+
+	return ""
+}
+
+// Kinds of files told apart by sniff.
+const (
+	kindUnknown = iota
+	kindSave
+	kindDoc
 )
 
-// split splits a file name into base and extension. Modified from path.Ext().
+// sniff tells a save file from a document by its first bytes: the magic number
+// of saves, the magic number of gzip, or the start of a JSON object or array.
+// Other files, and files that cannot be read, are told by their extension.
+func sniff(fn string) int {
+	if f, err := os.Open(fn); err == nil {
+		b := make([]byte, 512)
+
+		n, _ := io.ReadFull(f, b)
+		f.Close()
+
+		b = bytes.TrimLeft(bytes.TrimPrefix(b[:n], []byte("\ufeff")), " \t\r\n")
+
+		switch {
+		case len(b) >= 4 && mmse.DecodeLE[int32](b) == mmse.Magic:
+			return kindSave
+		case bytes.HasPrefix(b, []byte{0x1f, 0x8b}), len(b) > 0 && (b[0] == '{' || b[0] == '['):
+			return kindDoc
+		}
+	}
+
+	switch {
+	case strings.EqualFold(filepath.Ext(fn), ".sav"):
+		return kindSave
+	case isDoc(fn):
+		return kindDoc
+	}
+
+	return kindUnknown
+}
+
+// isDoc reports whether a file is a document judging by its extension.
+func isDoc(fn string) bool {
+	ext := strings.TrimSuffix(docExt(fn), gzipExt)
+	if ext == "" {
+		return false
+	}
+
+	_, err := jsonconv.ParseFormat(ext[1:])
+
+	return err == nil
+}
+
+// runUnpack runs the unpack command.
+func runUnpack(args []string) {
+	ft, err := jsonconv.ParseFormat(cfg.Format)
+	if err != nil {
+		log.Panicf("%s", err)
+	}
+
+	if unpackAll {
+		unpackChanged(ft)
+		return
+	}
+
+	if len(args) == 1 {
+		unpack(args[0], ft)
+		return
+	}
+
+	failed := parallel(len(args), func(i int) { unpack(args[i], ft) })
+
+	for _, i := range failed {
+		log.Printf("Unable to unpack %s", args[i])
+	}
+
+	if len(failed) > 0 {
+		fail()
+	}
+}
+
+// trailingExt is the extension of the file keeping the bytes after the data
+// frame of a save.
+const trailingExt = ".trailing"
+
+// paddingExt is the extension of the file counting the NUL bytes before the
+// info frame of a save.
+const paddingExt = ".padding"
+
+// split splits a file name into base and extension. Modified from filepath.Ext().
+// The extension of a document compressed with gzip includes gzipExt.
 func split(fn string) string {
+	fn = strings.TrimSuffix(fn, gzipExt)
+
 	for i := len(fn) - 1; i >= 0; i-- {
 		if fn[i] == '.' {
-			if fn[i:] == ".sav" || fn[i:] == ".json" {
+			switch fn[i:] {
+			case ".sav", ".json", ".yaml", ".yml", ".toml":
 				return fn[:i]
 			}
 			break
@@ -45,9 +393,119 @@ func split(fn string) string {
 	return fn
 }
 
+// writeDoc writes the decoded Frame to a file in format ft, compressed with
+// gzip when the name ends in gzipExt.
+func writeDoc(fn string, f *mmse.Frame, ft jsonconv.Format) {
+	c, err := os.Create(fn)
+	if err != nil {
+		log.Panicf("Unable to create %s: %s", fn, err)
+	}
+
+	defer func() {
+		if err = c.Close(); err != nil {
+			log.Panicf("Unable to close %s: %s", fn, err)
+		}
+	}()
+
+	var w io.Writer = c
+
+	if strings.HasSuffix(fn, gzipExt) {
+		z := gzip.NewWriter(c)
+
+		defer func() {
+			if err := z.Close(); err != nil {
+				log.Panicf("Unable to compress %s: %s", fn, err)
+			}
+		}()
+
+		w = z
+	}
+
+	if ft == jsonconv.JSON && cfg.Pretty {
+		b := new(bytes.Buffer)
+
+		if err := json.Indent(b, f.Bytes(), "", "  "); err != nil {
+			log.Panicf("Unable to indent %s: %s", fn, err)
+		}
+
+		b.WriteByte('\n')
+
+		if _, err := b.WriteTo(w); err != nil {
+			log.Panicf("Unable to write %s: %s", fn, err)
+		}
+
+		return
+	}
+
+	if err := jsonconv.Convert(w, f.Reader(), jsonconv.JSON, ft); err != nil {
+		log.Panicf("Unable to convert %s: %s", fn, err)
+	}
+}
+
+// readDoc reads a JSON, YAML, or TOML file, which may be compressed with gzip,
+// and returns it as JSON.
+func readDoc(fn string) []byte {
+	ft := jsonconv.FormatOf(strings.TrimSuffix(fn, gzipExt))
+
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Panicf("Unable to open %s: %s", fn, err)
+	}
+
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+
+	var r io.Reader = br
+
+	// Compressed documents are told by the magic number of gzip rather than by
+	// their extension.
+	if m, _ := br.Peek(2); bytes.Equal(m, []byte{0x1f, 0x8b}) {
+		z, err := gzip.NewReader(br)
+		if err != nil {
+			log.Panicf("Unable to decompress %s: %s", fn, err)
+		}
+
+		defer z.Close()
+
+		r = z
+	}
+
+	b := new(bytes.Buffer)
+
+	if ft != jsonconv.JSON {
+		if err := jsonconv.Convert(b, r, ft, jsonconv.JSON); err != nil {
+			log.Panicf("Unable to convert %s: %s", fn, err)
+		}
+
+		return b.Bytes()
+	}
+
+	if _, err := b.ReadFrom(r); err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	if !cfg.Pretty {
+		return b.Bytes()
+	}
+
+	c := new(bytes.Buffer)
+
+	if err := json.Compact(c, b.Bytes()); err != nil {
+		log.Panicf("Unable to compact %s: %s", fn, err)
+	}
+
+	return c.Bytes()
+}
+
 // unpack is a wrapper for unpacking json files.
-func unpack(fn string) {
-	bn := split(path.Base(fn))
+func unpack(fn string, ft jsonconv.Format) {
+	fn = findSave(fn)
+	bn := split(filepath.Base(fn))
+
+	if sniff(fn) == kindDoc {
+		log.Panicf("%s is a document, not a save file; pack it with mmse pack %s", fn, fn)
+	}
 
 	f, err := os.Open(fn)
 	if err != nil {
@@ -61,57 +519,412 @@ func unpack(fn string) {
 		}
 	}()
 
-	mmse.CheckHeader(f)
+	// Refuse other versions, whose extra frames unpack would drop.
+	s := &mmse.SaveFile{Strict: true}
+
+	if _, err := s.ReadFrom(bufio.NewReader(f)); err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	info, data, trailing := s.Info, s.Data, s.Trailing
+
+	n := names{Name: bn, Ext: outputExt(ft)}
+	in, dn := unpackName(cfg.Info, n), unpackName(cfg.Data, n)
+
+	if !force && !unpackAll {
+		for _, p := range []string{in, dn} {
+			if fileExists(p) {
+				log.Panicf("%s exists; use -force to overwrite it, or -o to unpack to another directory", p)
+			}
+		}
+	}
+
+	if unpackDir != "" {
+		if err := os.MkdirAll(unpackDir, 0755); err != nil {
+			log.Panicf("Unable to create %s: %s", unpackDir, err)
+		}
+	}
+
+	checkDuplicates(fn+" info frame", info.Bytes(), ft)
+	checkDuplicates(fn+" data frame", data.Bytes(), ft)
 
-	info := mmse.ReadSizeToFrame(f)
+	var sc *sidecar
+
+	if cfg.Sidecar {
+		sc = newSidecar(fn, in, dn, s)
+	}
+
+	if cfg.ExtractBlobs {
+		for _, d := range []struct {
+			tmpl string
+			f    *mmse.Frame
+		}{{cfg.Info, info}, {cfg.Data, data}} {
+			dir := unpackName(d.tmpl, names{Name: bn, Ext: blobExt})
+
+			if b, n := extractBlobs(d.f.Bytes(), dir); n > 0 {
+				log.Printf("Extracted %d blobs to %s", n, dir)
+
+				d.f.Reset()
+				d.f.Write(b)
+			}
+		}
+	}
+
+	writeDoc(in, info, ft)
+	writeDoc(dn, data, ft)
+
+	switch p := sidecarPath(dn); {
+	case sc != nil:
+		sc.write(dn)
+	case fileExists(p):
+		// Do not let pack check the documents against an earlier save.
+		if err := os.Remove(p); err != nil {
+			log.Panicf("Unable to remove %s: %s", p, err)
+		}
+	}
+
+	tn := unpackName(cfg.Data, names{Name: bn, Ext: trailingExt})
+
+	switch {
+	case len(trailing) > 0:
+		log.Printf("Keeping %d bytes after the data frame in %s", len(trailing), tn)
+
+		if err := os.WriteFile(tn, trailing, 0644); err != nil {
+			log.Panicf("Unable to write %s: %s", tn, err)
+		}
+	case fileExists(tn):
+		// Do not let pack append the bytes of an earlier unpack.
+		if err := os.Remove(tn); err != nil {
+			log.Panicf("Unable to remove %s: %s", tn, err)
+		}
+	}
 
-	data := mmse.ReadSizeToFrame(f)
+	pn := unpackName(cfg.Data, names{Name: bn, Ext: paddingExt})
 
-	mmse.WriteJSON(fmt.Sprintf("%s_info.json", bn), f, info)
-	mmse.WriteJSON(fmt.Sprintf("%s_data.json", bn), f, data)
+	switch {
+	case s.Padding > 0:
+		log.Printf("Keeping %d bytes of padding before the info frame in %s", s.Padding, pn)
+
+		if err := os.WriteFile(pn, []byte(strconv.Itoa(s.Padding)+"\n"), 0644); err != nil {
+			log.Panicf("Unable to write %s: %s", pn, err)
+		}
+	case fileExists(pn):
+		// Do not let pack pad the save like an earlier one.
+		if err := os.Remove(pn); err != nil {
+			log.Panicf("Unable to remove %s: %s", pn, err)
+		}
+	}
 }
 
-// unpack is a wrapper for packing json files.
-func pack(in, dn string) {
-	bn := split(path.Base(dn))
+// unpackDir is the directory of the documents written by unpack, given with
+// -o, or empty for the working directory.
+var unpackDir string
 
-	f, err := os.Create(fmt.Sprintf("%s.sav", bn))
+// unpackName renders an output template of unpack into unpackDir.
+func unpackName(tmpl string, n names) string {
+	return filepath.Join(unpackDir, outputName(tmpl, n))
+}
 
+// checkDuplicates reports the keys repeated within an object of a document
+// according to the duplicate key policy. Only JSON keeps both values, so
+// repeated keys are an error for other formats.
+func checkDuplicates(name string, b []byte, ft jsonconv.Format) {
+	ps, err := jsonpath.Duplicates(bytes.NewReader(b))
 	if err != nil {
-		log.Panicf("%s", err)
+		log.Panicf("Unable to read %s: %s", name, err)
 	}
 
-	defer func() {
-		if err = f.Close(); err != nil {
-			log.Panicf("Unable to close file: %s", err)
+	if len(ps) == 0 {
+		return
+	}
+
+	for _, p := range ps {
+		log.Printf("Warning: %s: duplicate key %s", name, p)
+	}
+
+	switch {
+	case cfg.DupKeys == dupError:
+		log.Panicf("%s has %d duplicate keys", name, len(ps))
+	case ft != jsonconv.JSON:
+		log.Panicf("%s has %d duplicate keys, which %s cannot hold; use JSON", name, len(ps), ft)
+	}
+}
+
+// readTrailing returns the bytes kept by unpack for the save of a data
+// document, or nil.
+func readTrailing(dn string) []byte {
+	tn := filepath.Join(filepath.Dir(dn), split(filepath.Base(dn))+trailingExt)
+
+	b, err := os.ReadFile(tn)
+	if err != nil && !os.IsNotExist(err) {
+		log.Panicf("Unable to read %s: %s", tn, err)
+	}
+
+	return b
+}
+
+// readPadding returns the number of NUL bytes before the info frame counted
+// by unpack for the save of a data document, or 0.
+func readPadding(dn string) int {
+	pn := filepath.Join(filepath.Dir(dn), split(filepath.Base(dn))+paddingExt)
+
+	b, err := os.ReadFile(pn)
+	if os.IsNotExist(err) {
+		return 0
+	} else if err != nil {
+		log.Panicf("Unable to read %s: %s", pn, err)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || n < 0 {
+		log.Panicf("%s does not hold a number of bytes", pn)
+	}
+
+	return n
+}
+
+// packOut is the save written by pack, given with -o.
+var packOut string
+
+// pack is a wrapper for packing json files. pack returns the name of the save
+// file.
+func pack(in, dn string) string {
+	bn := split(filepath.Base(dn))
+
+	sn := savePath(outputName(cfg.Save, names{Name: bn, Ext: ".sav"}))
+
+	if packOut != "" {
+		sn = packOut
+	}
+
+	for _, p := range []string{in, dn} {
+		if sameFile(sn, p) {
+			log.Panicf("Pack would write the save over its input %s; name the save with -o", p)
 		}
-	}()
+	}
 
-	mmse.WriteHeader(f)
+	// Read the documents first so that a bad document leaves the save intact.
+	ib, db := readDoc(in), readDoc(dn)
+
+	if why := swappedDocs(ib, db); why != "" && !force {
+		log.Panicf(
+			"%s and %s look swapped: %s; give the info document first, or use -force to pack them as given",
+			in, dn, why,
+		)
+	}
 
-	info := mmse.ReadJSONToFrame(in)
+	ib, db = embedBlobs(ib, blobDir(in)), embedBlobs(db, blobDir(dn))
 
-	mmse.WriteSize(f, info)
+	checkSidecar(in, dn, ib, db, sn)
 
-	data := mmse.ReadJSONToFrame(dn)
+	checkDuplicates(in, ib, jsonconv.JSON)
+	checkDuplicates(dn, db, jsonconv.JSON)
 
-	mmse.WriteSize(f, data)
+	checkFields(ib, db)
 
-	mmse.WriteFrame(f, info)
-	mmse.WriteFrame(f, data)
+	info := mmse.ReadToFrame(bytes.NewReader(ib), cfg.Level)
+	data := mmse.ReadToFrame(bytes.NewReader(db), cfg.Level)
+
+	writeSave(sn, mmse.Ver, []*mmse.Frame{info, data}, readPadding(dn), readTrailing(dn), []string{"pack " + in + " " + dn})
+
+	return sn
 }
 
-func main() {
+// swappedDocs returns why an info and a data document look swapped, or an
+// empty string. The paths of version_path and date_path are in the info
+// document; without them, or when they are in both or neither document, the
+// info document is told by its size, a few kilobytes against megabytes of data.
+func swappedDocs(ib, db []byte) string {
+	for _, p := range []string{cfg.VersionPath, cfg.DatePath} {
+		if p == "" {
+			continue
+		}
+
+		inInfo, inData := hasPath(ib, p), hasPath(db, p)
+
+		switch {
+		case inInfo && !inData:
+			return ""
+		case inData && !inInfo:
+			return fmt.Sprintf("%s is in the data document and not in the info document", p)
+		}
+	}
+
+	if len(ib) > len(db) {
+		return fmt.Sprintf(
+			"the info document is %d bytes, larger than the data document of %d bytes",
+			len(ib), len(db),
+		)
+	}
+
+	return ""
+}
+
+// hasPath reports whether a document has a value at a path.
+func hasPath(doc []byte, path string) bool {
+	p, err := jsonpath.Parse(path)
+	if err != nil {
+		return false
+	}
+
+	_, ok, err := jsonpath.Lookup(bytes.NewReader(doc), p)
+
+	return err == nil && ok
+}
+
+// writeSave writes encoded frames, with the version number, padding NUL bytes
+// before them, and any trailing bytes after them, to a save file after
+// checking that the game does not have it open and backing it up. With
+// auditing on, ops are recorded in the audit log.
+func writeSave(sn string, version int32, frames []*mmse.Frame, padding int, trailing []byte, ops []string) {
+	checkInUse(sn)
+	backup(sn)
+
+	before := ""
+
+	if cfg.Audit {
+		before, _ = hashFile(sn)
+	}
+
+	b := new(bytes.Buffer)
+
+	mmse.WriteLE(b, mmse.Magic)
+	mmse.WriteLE(b, version)
+
+	for _, f := range frames {
+		mmse.WriteSize(b, f)
+	}
+
+	b.Write(make([]byte, padding))
+
+	for _, f := range frames {
+		mmse.WriteFrame(b, f)
+	}
+
+	b.Write(trailing)
+
+	// The save is replaced once the new one is written in full, so that a
+	// failed write leaves the old save intact.
+	tmp := sn + ".tmp"
+
+	if err := os.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		os.Remove(tmp)
+		log.Panicf("Unable to write %s: %s", sn, err)
+	}
+
+	if err := os.Rename(tmp, sn); err != nil {
+		os.Remove(tmp)
+		log.Panicf("Unable to write %s: %s", sn, err)
+	}
 
-	switch len(os.Args) {
-	case 2:
-		// unpack when parameos.Args has one file
-		unpack(os.Args[1])
-	case 3:
-		// pack when os.Args has two files
-		pack(os.Args[1], os.Args[2])
+	if cfg.Audit {
+		audit(sn, before, hashBytes(b.Bytes()), ops)
+	}
+}
+
+// legacy runs mmse without a command: saves are unpacked, and one or two
+// documents are packed.
+func legacy(args []string) {
+	fs := flag.NewFlagSet("mmse", flag.ExitOnError)
+
+	flagConfig(fs)
+	flagFormat(fs)
+	flagPretty(fs)
+	flagGzipOutput(fs)
+	flagLevel(fs)
+	flagBackup(fs)
+	flagDupKeys(fs)
+	flagSaveDir(fs)
+	flagForce(fs)
+
+	fs.Usage = func() {
+		usage(fs.Output())
+	}
+
+	// flag.ExitOnError makes Parse exit on errors.
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// Tell saves from documents by their content, so that they can be given,
+	// or dropped on the program, in any order.
+	kinds := make(map[int][]string)
+
+	for _, a := range fs.Args() {
+		k := sniff(a)
+		kinds[k] = append(kinds[k], a)
+	}
+
+	saves, docs, unknown := kinds[kindSave], kinds[kindDoc], kinds[kindUnknown]
+
+	var c *command
+
+	switch {
+	case len(saves) > 0 && len(docs) > 0:
+		log.Panicf(
+			"%s is a save file and %s is a document; unpack saves and pack documents separately",
+			saves[0], docs[0],
+		)
+	case len(saves) > 0 && len(unknown) > 0:
+		log.Panicf("Unable to tell whether %s is a save file or a document", unknown[0])
+	case len(saves) > 0, fs.NArg() == 1 && len(docs) == 0:
+		c = commands["unpack"]
+	case fs.NArg() <= 2:
+		c = commands["pack"]
 	default:
-		// print usage in other case
-		fmt.Printf(usg, os.Args[0])
+		log.Panicf("Pack takes an info and a data document, not %d documents", fs.NArg())
 	}
+
+	loadConfig(fs)
+	checkConfig()
+
+	args = fs.Args()
+
+	// Documents dropped on the program come in no particular order.
+	if len(docs)+len(unknown) == 2 && swappedDocs(readDoc(args[0]), readDoc(args[1])) != "" {
+		args[0], args[1] = args[1], args[0]
+	}
+
+	c.run(args)
+}
+
+// exitOnError ends mmse with status 1 after an error reported with
+// log.Panicf, whose message is already logged, instead of crashing with a
+// stack trace. Other panics are bugs and crash as usual.
+func exitOnError() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	if _, ok := r.(string); !ok {
+		panic(r)
+	}
+
+	fail()
+}
+
+// fail ends mmse with status 1, after the console is held as at the end of
+// main. Commands whose output tells what failed, such as a search finding
+// nothing, fail without a message.
+func fail() {
+	holdConsole()
+	os.Exit(1)
+}
+
+func main() {
+	defer holdConsole()
+	defer exitOnError()
+
+	if len(os.Args) > 1 {
+		if c, ok := commands[os.Args[1]]; ok {
+			c.execute(os.Args[2:])
+			return
+		}
+	}
+
+	legacy(os.Args[1:])
 }
@@ -0,0 +1,440 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpatch"
+)
+
+// modKeyFile is the file holding the private key of a mod author.
+var modKeyFile string
+
+func init() {
+	register(&command{
+		name:  "mod",
+		args:  "sign|show|install <mod.json> [game.sav]",
+		short: "sign, inspect, and install shared edits of saves",
+		long: `
+A mod is an edit of saves to share with other players: a JSON Patch of the
+documents, as written by make-patch, with a name, a version, an author, the
+range of game versions it applies to, and the signature of the author. A mod
+is a JSON file such as:
+
+	{
+	  "name": "engine-cheat",
+	  "version": "1.0",
+	  "author": "Predator Racing",
+	  "description": "Doubles the engine performance of the player team",
+	  "game": {"min": "1.5", "max": "1.52"},
+	  "patch": [
+	    {"op": "test", "path": "/data/playerTeam/name", "value": "Predator"},
+	    {"op": "replace", "path": "/data/playerTeam/engine", "value": 200}
+	  ]
+	}
+
+Paths start with /info or /data. The operations add, remove, replace, and test
+are supported. Either end of the range of game versions may be left out.
+
+Sign signs a mod in place with the private key in the file given by -keyfile,
+adding the public key of the author and the signature. With -newkey, sign
+first writes a new random key to the key file, which must not exist, and
+prints the public key for the author to publish. Signatures are Ed25519, so
+unlike those of "mmse sign", anyone can check them with the public key, and
+only the author can sign.
+
+Show prints a mod and whether its signature is valid and trusted.
+
+Install applies a mod to a save. It refuses a mod whose signature is invalid,
+as when the mod was changed after it was signed. Unless -force is given, it
+also refuses an unsigned mod, a mod signed with a key not in mod_keys of the
+configuration file, and a save of a game version outside the range of the mod,
+or of an unknown version. The game version is read as by validate, and
+versions are compared by their dot separated numbers.
+
+The patch applies in full or not at all: when an operation fails, such as a
+test or a replace of a missing value, the save is left unchanged. Before
+writing the save, install labels it before-<name>, storing a backup, and it
+restores the backup when writing or verifying the save fails. To undo the mod
+later, use "mmse backup restore game.sav before-<name>".`,
+		example: `
+mmse mod -newkey -keyfile author.key sign engine-cheat.json
+mmse mod show engine-cheat.json
+mmse mod install engine-cheat.json game.sav`,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&modKeyFile, "keyfile", "", "read the private key of the author from `file`")
+			fs.BoolVar(&newKey, "newkey", false, "write a new random key to the key file")
+			flagGameVersion(fs)
+			flagLevel(fs)
+			flagBackup(fs)
+			flagSaveDir(fs)
+			flagForce(fs)
+			flagSteamDir(fs)
+		},
+		nargs: func(n int) bool { return n == 2 || n == 3 },
+		run:   runMod,
+	})
+}
+
+// modManifest is a mod.
+type modManifest struct {
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Author      string `json:"author"`
+	Description string `json:"description,omitempty"`
+	// Game is the range of game versions the mod applies to.
+	Game versionRange `json:"game"`
+	// Patch applies to an object holding both documents, so its paths start
+	// with /info or /data.
+	Patch []jsonpatch.Operation `json:"patch"`
+	// Key is the public key of the author, and Signature the signature of
+	// the mod without it, both in hexadecimal.
+	Key       string `json:"key,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// versionRange is an inclusive range of game versions. An empty end is open.
+type versionRange struct {
+	Min string `json:"min,omitempty"`
+	Max string `json:"max,omitempty"`
+}
+
+// String returns the range for messages.
+func (r versionRange) String() string {
+	switch {
+	case r.Min == "" && r.Max == "":
+		return "any version"
+	case r.Max == "":
+		return r.Min + " or later"
+	case r.Min == "":
+		return r.Max + " or earlier"
+	}
+
+	return r.Min + " to " + r.Max
+}
+
+// contains reports whether a game version is in the range.
+func (r versionRange) contains(v string) bool {
+	return (r.Min == "" || compareVersions(v, r.Min) >= 0) &&
+		(r.Max == "" || compareVersions(v, r.Max) <= 0)
+}
+
+// compareVersions compares game versions by their dot separated parts,
+// numerically when both parts are numbers, and returns -1, 0, or 1. Missing
+// parts count as 0, so 1.5 equals 1.5.0.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+
+	for len(as) < len(bs) {
+		as = append(as, "0")
+	}
+
+	for len(bs) < len(as) {
+		bs = append(bs, "0")
+	}
+
+	for i := range as {
+		x, errx := strconv.Atoi(as[i])
+		y, erry := strconv.Atoi(bs[i])
+
+		switch {
+		case errx != nil || erry != nil:
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// readMod reads and checks a mod.
+func readMod(fn string) *modManifest {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	m := new(modManifest)
+
+	if err := json.Unmarshal(b, m); err != nil {
+		log.Panicf("Unable to parse %s: %s", fn, err)
+	}
+
+	switch {
+	case m.Name == "":
+		log.Panicf("%s has no name", fn)
+	case len(m.Patch) == 0:
+		log.Panicf("%s has no patch", fn)
+	}
+
+	if _, err := m.split(); err != nil {
+		log.Panicf("Invalid patch in %s: %s", fn, err)
+	}
+
+	return m
+}
+
+// split returns the operations of the patch on the info and data documents,
+// with paths relative to the documents.
+func (m *modManifest) split() ([2][]jsonpatch.Operation, error) {
+	var ops [2][]jsonpatch.Operation
+
+	for _, o := range m.Patch {
+		found := false
+
+		for i, doc := range []string{"/info", "/data"} {
+			if o.Path == doc || strings.HasPrefix(o.Path, doc+"/") {
+				o.Path = strings.TrimPrefix(o.Path, doc)
+				ops[i] = append(ops[i], o)
+				found = true
+			}
+		}
+
+		if !found {
+			return ops, fmt.Errorf("%s does not start with /info or /data", o.Path)
+		}
+	}
+
+	return ops, nil
+}
+
+// signed returns the bytes signed by the author: the mod without the
+// signature, as compact JSON.
+func (m *modManifest) signed() []byte {
+	c := *m
+	c.Signature = ""
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		log.Panicf("Unable to encode mod %s: %s", m.Name, err)
+	}
+
+	return b
+}
+
+// verify checks the signature of the mod and reports whether it is signed
+// and whether the key is in mod_keys. It panics when the signature is
+// invalid.
+func (m *modManifest) verify() (signed, trusted bool) {
+	if m.Key == "" && m.Signature == "" {
+		return false, false
+	}
+
+	k, err := hex.DecodeString(m.Key)
+	if err != nil || len(k) != ed25519.PublicKeySize {
+		log.Panicf("Invalid key in mod %s", m.Name)
+	}
+
+	sig, err := hex.DecodeString(m.Signature)
+	if err != nil || !ed25519.Verify(k, m.signed(), sig) {
+		log.Panicf("Invalid signature in mod %s; it changed since it was signed", m.Name)
+	}
+
+	for _, t := range cfg.ModKeys {
+		if strings.EqualFold(strings.TrimSpace(t), m.Key) {
+			return true, true
+		}
+	}
+
+	return true, false
+}
+
+// modKey reads the private key of a mod author, written by sign -newkey.
+func modKey() ed25519.PrivateKey {
+	if modKeyFile == "" {
+		log.Panicf("No key file; use -keyfile")
+	}
+
+	b, err := os.ReadFile(modKeyFile)
+	if err != nil {
+		log.Panicf("Unable to read key file: %s", err)
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		log.Panicf("%s does not hold a key written by mmse mod sign -newkey", modKeyFile)
+	}
+
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+// runMod runs the mod command.
+func runMod(args []string) {
+	switch args[0] {
+	case "sign":
+		signMod(args[1:])
+	case "show":
+		showMod(args[1:])
+	case "install":
+		installMod(args[1:])
+	default:
+		log.Panicf("Unknown mod action: %s", args[0])
+	}
+}
+
+// signMod signs a mod in place.
+func signMod(args []string) {
+	if len(args) != 1 {
+		log.Panicf("Usage: mmse mod [-newkey] -keyfile <file> sign <mod.json>")
+	}
+
+	if newKey {
+		writeKey(modKeyFile)
+	}
+
+	k := modKey()
+	pub := hex.EncodeToString(k.Public().(ed25519.PublicKey))
+
+	if newKey {
+		fmt.Printf("Public key: %s\n", pub)
+	}
+
+	m := readMod(args[0])
+	m.Key = pub
+	m.Signature = hex.EncodeToString(ed25519.Sign(k, m.signed()))
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		log.Panicf("Unable to encode %s: %s", args[0], err)
+	}
+
+	if err := os.WriteFile(args[0], append(b, '\n'), 0644); err != nil {
+		log.Panicf("Unable to write %s: %s", args[0], err)
+	}
+
+	fmt.Printf("Signed %s\n", args[0])
+}
+
+// showMod prints a mod.
+func showMod(args []string) {
+	if len(args) != 1 {
+		log.Panicf("Usage: mmse mod show <mod.json>")
+	}
+
+	m := readMod(args[0])
+	ops, _ := m.split()
+
+	fmt.Printf("%s %s by %s\n", m.Name, m.Version, m.Author)
+
+	if m.Description != "" {
+		fmt.Println(m.Description)
+	}
+
+	fmt.Printf("Game versions: %s\n", m.Game)
+	fmt.Printf("Operations: %d on the info document, %d on the data document\n", len(ops[0]), len(ops[1]))
+
+	switch signed, trusted := m.verify(); {
+	case !signed:
+		fmt.Println("Signature: none")
+	case trusted:
+		fmt.Printf("Signature: valid, trusted key %s\n", m.Key)
+	default:
+		fmt.Printf("Signature: valid, untrusted key %s\n", m.Key)
+	}
+}
+
+// installMod applies a mod to a save.
+func installMod(args []string) {
+	if len(args) != 2 {
+		log.Panicf("Usage: mmse mod install <mod.json> <game.sav>")
+	}
+
+	m := readMod(args[0])
+
+	switch signed, trusted := m.verify(); {
+	case force:
+	case !signed:
+		log.Panicf("%s is not signed; use -force to install it anyway", args[0])
+	case !trusted:
+		log.Panicf(
+			"%s is signed with key %s, which is not in mod_keys; use -force to install it anyway",
+			args[0], m.Key,
+		)
+	}
+
+	e := openRaw(args[1])
+
+	if v := gameVersion(e.docs[0]); !force {
+		switch {
+		case m.Game.Min == "" && m.Game.Max == "":
+		case v == "":
+			log.Panicf(
+				"The game version of %s is unknown; use -gameversion, or -force to install %s anyway",
+				e.fn, m.Name,
+			)
+		case !m.Game.contains(v):
+			log.Panicf(
+				"%s is for game versions %s, and %s is of version %s; use -force to install it anyway",
+				m.Name, m.Game, e.fn, v,
+			)
+		}
+	}
+
+	if err := e.patch(m.Patch); err != nil {
+		log.Panicf("Unable to apply %s to %s, which is unchanged: %s", m.Name, e.fn, err)
+	}
+
+	e.ops = append(e.ops, fmt.Sprintf("mod install %s %s", m.Name, m.Version))
+
+	checkInUse(e.fn)
+
+	t := labelSave(e.fn, "before-"+m.Name)
+
+	defer func() {
+		if r := recover(); r != nil {
+			if err := restoreBackup(e.fn, backupEntry{path: t.Backup, stored: true}); err != nil {
+				log.Printf("Unable to roll back %s from %s: %s", e.fn, t.Backup, err)
+			} else {
+				log.Printf("Rolled back %s", e.fn)
+			}
+
+			panic(r)
+		}
+	}()
+
+	e.write()
+
+	// Check that the save reads back as patched.
+	w := openRaw(e.fn)
+
+	for i := range w.docs {
+		if string(w.docs[i]) != string(e.docs[i]) {
+			log.Panicf("The %s document of %s does not read back as patched", []string{"info", "data"}[i], e.fn)
+		}
+	}
+
+	fmt.Printf(
+		"Installed %s %s into %s; undo it with mmse backup restore %s before-%s\n",
+		m.Name, m.Version, e.fn, args[1], m.Name,
+	)
+
+	warnCloud(e.fn)
+}
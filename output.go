@@ -0,0 +1,261 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonconv"
+)
+
+// Output formats of listings.
+const (
+	outputTable  = "table"
+	outputCSV    = "csv"
+	outputJSON   = "json"
+	outputYAML   = "yaml"
+	outputNDJSON = "ndjson"
+)
+
+// maxCellWidth is the width beyond which table cells, except in the last
+// column, are truncated.
+const maxCellWidth = 48
+
+// outputFormat is the output format of listings.
+var outputFormat = outputTable
+
+// flagOutput registers the flag selecting the output format of a listing,
+// with a default for the command.
+func flagOutput(fs *flag.FlagSet, def string) {
+	fs.StringVar(&outputFormat, "output", def, "output format: table, csv, json, yaml, or ndjson")
+}
+
+func init() {
+	registerTopic(&topic{
+		name:  "output",
+		short: "output formats of listings",
+		long: `
+The commands printing lists, such as stats, search, xref, fields, and backup
+list, select their output format with -output:
+
+	table  aligned columns for reading, the default except for stats
+	csv    comma-separated values with a header row
+	json   an array of objects
+	yaml   the same objects as YAML
+	ndjson the same objects, one per line, each written as soon as it is found
+
+Tables align numbers to the right and truncate long cells, except in the last
+column, to keep the columns readable; use another format to see every value
+in full. Ndjson suits tools such as jq that process a long listing, such as
+the results of a search through a large save, while it is being written. On a
+terminal, the header row is shown in bold unless the NO_COLOR
+environment variable is set.`,
+	})
+}
+
+// listing is the output of a list-style command.
+type listing struct {
+	cols []string
+	rows [][]string
+	// right holds the columns aligned to the right in tables, such as
+	// numbers.
+	right map[string]bool
+	// records are written by the json, yaml, and ndjson formats. Without
+	// them, each row is written as an object keyed by the columns.
+	records []interface{}
+	// n is the number of rows added, including rows already streamed.
+	n int
+}
+
+// add adds a row.
+func (l *listing) add(cells ...string) {
+	l.addRecord(nil, cells...)
+}
+
+// addRecord adds a row written as record r by the json, yaml, and ndjson
+// formats. With the ndjson format, the row is written at once.
+func (l *listing) addRecord(r interface{}, cells ...string) {
+	l.n++
+
+	if r == nil {
+		r = l.object(cells)
+	}
+
+	if outputFormat == outputNDJSON {
+		if err := json.NewEncoder(os.Stdout).Encode(r); err != nil {
+			log.Panicf("Unable to write output: %s", err)
+		}
+
+		return
+	}
+
+	l.rows = append(l.rows, cells)
+	l.records = append(l.records, r)
+}
+
+// object returns a row as an object keyed by the columns.
+func (l *listing) object(cells []string) json.RawMessage {
+	// The object is written key by key to keep the order of the columns.
+	b := new(bytes.Buffer)
+
+	b.WriteByte('{')
+
+	for j, c := range l.cols {
+		if j > 0 {
+			b.WriteByte(',')
+		}
+
+		k, _ := json.Marshal(c)
+		v, _ := json.Marshal(cells[j])
+
+		fmt.Fprintf(b, "%s:%s", k, v)
+	}
+
+	b.WriteByte('}')
+
+	return b.Bytes()
+}
+
+// write writes the listing to the standard output in the output format. With
+// the ndjson format, the rows have already been written.
+func (l *listing) write() {
+	var err error
+
+	switch outputFormat {
+	case outputTable:
+		l.writeTable(os.Stdout, useColor())
+	case outputCSV:
+		w := csv.NewWriter(os.Stdout)
+
+		if err = w.Write(l.cols); err == nil {
+			err = w.WriteAll(l.rows)
+		}
+	case outputJSON:
+		err = l.writeJSON(os.Stdout)
+	case outputYAML:
+		b := new(bytes.Buffer)
+
+		if err = l.writeJSON(b); err == nil {
+			err = jsonconv.Convert(os.Stdout, b, jsonconv.JSON, jsonconv.YAML)
+		}
+	case outputNDJSON:
+	default:
+		log.Panicf("Unknown output format: %s", outputFormat)
+	}
+
+	if err != nil {
+		log.Panicf("Unable to write output: %s", err)
+	}
+}
+
+// writeJSON writes the records of the listing as an indented JSON array.
+func (l *listing) writeJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+
+	if l.records == nil {
+		return e.Encode([]interface{}{})
+	}
+
+	return e.Encode(l.records)
+}
+
+// writeTable writes the listing as aligned columns, with the header in bold
+// with color.
+func (l *listing) writeTable(w io.Writer, color bool) {
+	last := len(l.cols) - 1
+
+	cell := func(r []string, j int) string {
+		if j != last && utf8.RuneCountInString(r[j]) > maxCellWidth {
+			return string([]rune(r[j])[:maxCellWidth-1]) + "…"
+		}
+
+		return r[j]
+	}
+
+	widths := make([]int, len(l.cols))
+
+	for j, c := range l.cols {
+		widths[j] = utf8.RuneCountInString(c)
+	}
+
+	for _, r := range l.rows {
+		for j := range l.cols {
+			if n := utf8.RuneCountInString(cell(r, j)); n > widths[j] {
+				widths[j] = n
+			}
+		}
+	}
+
+	line := func(r []string, header bool) {
+		b := new(strings.Builder)
+
+		for j := range l.cols {
+			c := cell(r, j)
+			pad := strings.Repeat(" ", widths[j]-utf8.RuneCountInString(c))
+
+			if j > 0 {
+				b.WriteString("  ")
+			}
+
+			switch {
+			case l.right[l.cols[j]]:
+				b.WriteString(pad + c)
+			case j == last:
+				b.WriteString(c)
+			default:
+				b.WriteString(c + pad)
+			}
+		}
+
+		s := strings.TrimRight(b.String(), " ")
+
+		if header && color {
+			s = "\x1b[1m" + s + "\x1b[0m"
+		}
+
+		fmt.Fprintln(w, s)
+	}
+
+	line(l.cols, true)
+
+	for _, r := range l.rows {
+		line(r, false)
+	}
+}
+
+// useColor reports whether the standard output is a terminal that shows
+// color. NO_COLOR turns color off.
+func useColor() bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" || runtime.GOOS == "windows" {
+		return false
+	}
+
+	st, err := os.Stdout.Stat()
+
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
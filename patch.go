@@ -0,0 +1,374 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpatch"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+// Conflict policies of apply-patch.
+const (
+	conflictAbort = "abort"
+	conflictSkip  = "skip"
+	conflictForce = "force"
+	conflictAsk   = "ask"
+)
+
+// Statuses of patch operations.
+const (
+	statusApplied = "applied"
+	statusSkipped = "skipped"
+	statusForced  = "forced"
+	statusFailed  = "failed"
+)
+
+var (
+	// patchFile is the file receiving the patch of make-patch.
+	patchFile string
+	// onConflict is the conflict policy of apply-patch.
+	onConflict = conflictAbort
+	// summaryPath is the file receiving the summary of apply-patch.
+	summaryPath string
+	// answers reads the answers to the conflict prompt.
+	answers *bufio.Reader
+)
+
+// patched is the summary of applying a patch to a save.
+type patched struct {
+	Save    string     `json:"save"`
+	Written bool       `json:"written"`
+	Ops     []opStatus `json:"operations"`
+}
+
+// opStatus is the status of a patch operation.
+type opStatus struct {
+	Op     string `json:"operation"`
+	Status string `json:"status"`
+}
+
+func init() {
+	register(&command{
+		name:  "make-patch",
+		args:  "<old.sav> <new.sav>",
+		short: "write the differences between two saves as a JSON Patch",
+		long: `
+Make-patch compares the documents of two save files and writes the changes
+turning the first into the second as a JSON Patch, as specified by RFC 6902,
+to standard output or to the file given with -o. The patch applies to an
+object holding both documents, so its paths start with /info or /data, such
+as /data/drivers/3/name.
+
+Keys are compared by name and array elements by position, so an element
+removed from the middle of an array shows as changes to the elements after it.
+Numbers are compared by their text. Apply-patch applies the patch to other
+saves.`,
+		example: `
+mmse make-patch before.sav after.sav
+mmse make-patch -o change.patch.json before.sav after.sav`,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&patchFile, "o", "", "write the patch to `file`")
+			flagSaveDir(fs)
+		},
+		nargs: exactly(2),
+		run:   runMakePatch,
+	})
+
+	register(&command{
+		name:  "apply-patch",
+		args:  "<patch.json> <game.sav>...",
+		short: "apply a JSON Patch to save files",
+		long: `
+Apply-patch applies a JSON Patch, as written by make-patch, to each save file
+in turn and writes the saves. The paths of the patch start with /info or
+/data, and the operations add, remove, replace, and test are supported, so a
+patch can check a value with test before changing others.
+
+An operation fails when its path is not in the save or a test does not
+match. What apply-patch does then is chosen with -onconflict:
+
+	abort  leave the save unchanged and go on with the next save, the default
+	skip   leave out the operation and go on with the next one
+	force  apply a replace as an add, and leave out a failed test or the
+	       remove of a missing value; abort if the operation still fails
+	ask    ask on the terminal whether to skip, abort, or force
+
+With -summary, apply-patch writes a JSON summary to a file, listing for each
+save whether it was written and the status of each operation: applied,
+skipped, forced, or failed. Apply-patch exits with status 1 when any save
+fails.`,
+		example: `
+mmse make-patch -o change.patch.json before.sav after.sav
+mmse apply-patch change.patch.json other.sav
+mmse apply-patch -onconflict skip -summary result.json change.patch.json autosave*.sav`,
+		flags: func(fs *flag.FlagSet) {
+			flagLevel(fs)
+			flagBackup(fs)
+			flagSaveDir(fs)
+			flagForce(fs)
+			fs.StringVar(&onConflict, "onconflict", onConflict, "on a failed operation: abort, skip, force, or ask")
+			fs.StringVar(&summaryPath, "summary", "", "write a JSON summary of the operations to `file`")
+		},
+		nargs: atLeast(2),
+		run:   runApplyPatch,
+	})
+}
+
+// runMakePatch runs the make-patch command.
+func runMakePatch(args []string) {
+	a, b := openSave(args[0]), openSave(args[1])
+
+	ops := []jsonpatch.Operation{}
+
+	for i, fs := range [][2]*mmse.Frame{{a.Info, b.Info}, {a.Data, b.Data}} {
+		doc := []string{"info", "data"}[i]
+
+		d, err := jsonpatch.Diff(fs[0].Bytes(), fs[1].Bytes())
+		if err != nil {
+			log.Panicf("Unable to compare the %s documents: %s", doc, err)
+		}
+
+		for _, o := range d {
+			o.Path = jsonpatch.Pointer(doc) + o.Path
+			ops = append(ops, o)
+		}
+	}
+
+	out, err := json.MarshalIndent(ops, "", "  ")
+	if err != nil {
+		log.Panicf("Unable to write the patch: %s", err)
+	}
+
+	out = append(out, '\n')
+
+	if patchFile == "" {
+		os.Stdout.Write(out)
+		return
+	}
+
+	if err := os.WriteFile(patchFile, out, 0644); err != nil {
+		log.Panicf("Unable to write %s: %s", patchFile, err)
+	}
+}
+
+// readPatch reads the operations of a JSON Patch.
+func readPatch(fn string) []jsonpatch.Operation {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	var ops []jsonpatch.Operation
+
+	if err := json.Unmarshal(b, &ops); err != nil {
+		log.Panicf("Invalid patch in %s: %s", fn, err)
+	}
+
+	return ops
+}
+
+// patch applies the operations of a JSON Patch, as written by make-patch, to
+// the documents of a save. On error, the save is unchanged.
+func (e *rawSave) patch(ops []jsonpatch.Operation) error {
+	// The patch applies to an object holding both documents.
+	doc := append(append(append(append([]byte(`{"info":`), e.docs[0]...), `,"data":`...), e.docs[1]...), '}')
+
+	b, err := jsonpatch.Apply(doc, ops)
+	if err != nil {
+		return err
+	}
+
+	var docs map[string]json.RawMessage
+
+	if err := json.Unmarshal(b, &docs); err != nil {
+		return err
+	}
+
+	if len(docs) != 2 || docs["info"] == nil || docs["data"] == nil {
+		return fmt.Errorf("the patch adds or removes a document")
+	}
+
+	for i, k := range []string{"info", "data"} {
+		if d := []byte(docs[k]); !bytes.Equal(d, e.docs[i]) {
+			e.docs[i], e.frames[i] = d, nil
+		}
+	}
+
+	return nil
+}
+
+// askConflict asks on the terminal how to resolve a failed operation and
+// returns the conflict policy chosen. The end of input aborts.
+func askConflict(o jsonpatch.Operation) string {
+	if answers == nil {
+		answers = bufio.NewReader(os.Stdin)
+	}
+
+	for {
+		fmt.Fprintf(os.Stderr, "%s %s failed; skip, abort, or force? [s/a/f] ", o.Op, o.Path)
+
+		l, err := answers.ReadString('\n')
+
+		switch strings.ToLower(strings.TrimSpace(l)) {
+		case "s", "skip":
+			return conflictSkip
+		case "a", "abort":
+			return conflictAbort
+		case "f", "force":
+			return conflictForce
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr)
+			return conflictAbort
+		}
+	}
+}
+
+// forced returns the operations forcing a failed operation: a replace becomes
+// an add, and a test or a remove is left out.
+func forced(o jsonpatch.Operation) []jsonpatch.Operation {
+	switch o.Op {
+	case "test", "remove":
+		return nil
+	case "replace":
+		o.Op = "add"
+	}
+
+	return []jsonpatch.Operation{o}
+}
+
+// resolve applies the operations of a patch to a save one by one, resolving
+// the failed ones by the conflict policy, and adds their statuses to r. It
+// panics to abort; the save is then unchanged on disk.
+func (e *rawSave) resolve(ops []jsonpatch.Operation, r *patched) {
+	for _, o := range ops {
+		r.Ops = append(r.Ops, opStatus{o.Op + " " + o.Path, statusFailed})
+		st := &r.Ops[len(r.Ops)-1]
+
+		err := e.patch([]jsonpatch.Operation{o})
+		if err == nil {
+			st.Status = statusApplied
+			continue
+		}
+
+		// Leave out the index of the operation within the one-operation patch.
+		if u := errors.Unwrap(err); u != nil {
+			err = u
+		}
+
+		log.Printf("%s: unable to %s: %s", e.fn, st.Op, err)
+
+		policy := onConflict
+
+		if policy == conflictAsk {
+			policy = askConflict(o)
+		}
+
+		switch policy {
+		case conflictSkip:
+			log.Printf("Skipping %s", st.Op)
+			st.Status = statusSkipped
+		case conflictForce:
+			if err := e.patch(forced(o)); err != nil {
+				log.Panicf("Unable to force %s: %s", st.Op, err)
+			}
+
+			st.Status = statusForced
+		default:
+			log.Panicf("Aborting at %s; %s is unchanged", st.Op, e.fn)
+		}
+	}
+}
+
+// runApplyPatch runs the apply-patch command.
+func runApplyPatch(args []string) {
+	switch onConflict {
+	case conflictAbort, conflictSkip, conflictForce, conflictAsk:
+	default:
+		log.Panicf("Unknown conflict policy: %s", onConflict)
+	}
+
+	ops := readPatch(args[0])
+
+	var summary []patched
+
+	failed := 0
+
+	for _, fn := range args[1:] {
+		r := patched{Save: fn}
+
+		ok := try(func() {
+			e := openRaw(fn)
+			r.Save = e.fn
+
+			// Apply the whole patch at once, and only go through the
+			// operations one by one to find and resolve the failed ones.
+			if err := e.patch(ops); err == nil {
+				for _, o := range ops {
+					r.Ops = append(r.Ops, opStatus{o.Op + " " + o.Path, statusApplied})
+				}
+			} else {
+				e.resolve(ops, &r)
+			}
+
+			if e.frames[0] != nil && e.frames[1] != nil {
+				fmt.Printf("%s already matches %s\n", e.fn, args[0])
+				return
+			}
+
+			e.ops = append(e.ops, "apply-patch "+args[0])
+			e.write()
+			r.Written = true
+
+			fmt.Printf("Patched %s\n", e.fn)
+
+			warnCloud(e.fn)
+		})
+
+		if !ok {
+			failed++
+		}
+
+		summary = append(summary, r)
+	}
+
+	if summaryPath != "" {
+		b, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			log.Panicf("Unable to write the summary: %s", err)
+		}
+
+		if err := os.WriteFile(summaryPath, append(b, '\n'), 0644); err != nil {
+			log.Panicf("Unable to write %s: %s", summaryPath, err)
+		}
+	}
+
+	if failed > 0 {
+		fail()
+	}
+}
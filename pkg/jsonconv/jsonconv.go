@@ -0,0 +1,454 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package jsonconv converts the JSON documents in a Motorsport Manager save
+// file to and from YAML and TOML.
+//
+// Documents are held in a yaml.Node tree while converting, so key order and
+// the literal text of numbers survive a round trip.
+package jsonconv
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the name of a document format.
+type Format string
+
+const (
+	// JSON is the format used inside the save file.
+	JSON Format = "json"
+	// YAML is YAML 1.2.
+	YAML Format = "yaml"
+	// TOML is TOML 1.0.
+	TOML Format = "toml"
+)
+
+// Formats lists the supported formats.
+var Formats = []Format{JSON, YAML, TOML}
+
+// ParseFormat returns the Format named by s.
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "json":
+		return JSON, nil
+	case "yaml", "yml":
+		return YAML, nil
+	case "toml":
+		return TOML, nil
+	}
+
+	return "", fmt.Errorf("unknown format %q", s)
+}
+
+// Ext returns the file extension of the format, including the dot.
+func (f Format) Ext() string {
+	return "." + string(f)
+}
+
+// FormatOf returns the format of a file name judging by its extension.
+// FormatOf returns JSON when the extension is not recognized.
+func FormatOf(fn string) Format {
+	if i := strings.LastIndexByte(fn, '.'); i >= 0 {
+		if f, err := ParseFormat(fn[i+1:]); err == nil {
+			return f
+		}
+	}
+
+	return JSON
+}
+
+// Convert reads a document in format from from r and writes it to w in format
+// to.
+func Convert(w io.Writer, r io.Reader, from, to Format) error {
+	if from == to {
+		_, err := io.Copy(w, r)
+		return err
+	}
+
+	var (
+		n   *yaml.Node
+		err error
+	)
+
+	switch from {
+	case JSON:
+		n, err = decodeJSON(r)
+	case YAML:
+		n, err = decodeYAML(r)
+	case TOML:
+		n, err = decodeTOML(r)
+	default:
+		err = fmt.Errorf("unknown format %q", from)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+
+	switch to {
+	case JSON:
+		err = encodeJSON(bw, n)
+	case YAML:
+		err = encodeYAML(bw, n)
+	case TOML:
+		err = encodeTOML(bw, n)
+	default:
+		err = fmt.Errorf("unknown format %q", to)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// decodeJSON reads a JSON document into a node tree.
+func decodeJSON(r io.Reader) (*yaml.Node, error) {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+
+	n, err := decodeJSONValue(d)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := d.Token(); err != io.EOF {
+		return nil, fmt.Errorf("trailing data after JSON document")
+	}
+
+	return n, nil
+}
+
+// decodeJSONValue reads the next JSON value from d.
+func decodeJSONValue(d *json.Decoder) (*yaml.Node, error) {
+	t, err := d.Token()
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := t.(type) {
+	case json.Delim:
+		switch v {
+		case '{':
+			n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+
+			for d.More() {
+				k, err := d.Token()
+
+				if err != nil {
+					return nil, err
+				}
+
+				c, err := decodeJSONValue(d)
+
+				if err != nil {
+					return nil, err
+				}
+
+				n.Content = append(n.Content, stringNode(k.(string)), c)
+			}
+
+			_, err = d.Token()
+
+			return n, err
+		case '[':
+			n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+
+			for d.More() {
+				c, err := decodeJSONValue(d)
+
+				if err != nil {
+					return nil, err
+				}
+
+				n.Content = append(n.Content, c)
+			}
+
+			_, err = d.Token()
+
+			return n, err
+		}
+	case string:
+		return stringNode(v), nil
+	case json.Number:
+		return numberNode(string(v)), nil
+	case bool:
+		return scalarNode("!!bool", strconv.FormatBool(v)), nil
+	case nil:
+		return scalarNode("!!null", "null"), nil
+	}
+
+	return nil, fmt.Errorf("unexpected JSON token %v", t)
+}
+
+// encodeJSON writes a node tree as a compact JSON document.
+func encodeJSON(w *bufio.Writer, n *yaml.Node) error {
+	if err := encodeJSONValue(w, n); err != nil {
+		return err
+	}
+
+	return w.WriteByte('\n')
+}
+
+// encodeJSONValue writes a node as a JSON value.
+func encodeJSONValue(w *bufio.Writer, n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			_, err := w.WriteString("null")
+			return err
+		}
+
+		return encodeJSONValue(w, n.Content[0])
+	case yaml.AliasNode:
+		return encodeJSONValue(w, n.Alias)
+	case yaml.MappingNode:
+		w.WriteByte('{')
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+
+			k := n.Content[i]
+
+			for k.Kind == yaml.AliasNode {
+				k = k.Alias
+			}
+
+			if k.Kind != yaml.ScalarNode {
+				return fmt.Errorf(
+					"line %d: JSON keys must be scalars", k.Line,
+				)
+			}
+
+			writeJSONString(w, k.Value)
+			w.WriteByte(':')
+
+			if err := encodeJSONValue(w, n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+
+		return w.WriteByte('}')
+	case yaml.SequenceNode:
+		w.WriteByte('[')
+
+		for i, c := range n.Content {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+
+			if err := encodeJSONValue(w, c); err != nil {
+				return err
+			}
+		}
+
+		return w.WriteByte(']')
+	case yaml.ScalarNode:
+		return encodeJSONScalar(w, n)
+	}
+
+	return fmt.Errorf("line %d: unexpected node kind %d", n.Line, n.Kind)
+}
+
+// encodeJSONScalar writes a scalar node as a JSON value.
+func encodeJSONScalar(w *bufio.Writer, n *yaml.Node) error {
+	switch n.ShortTag() {
+	case "!!null":
+		_, err := w.WriteString("null")
+		return err
+	case "!!bool":
+		var b bool
+
+		if err := n.Decode(&b); err != nil {
+			return err
+		}
+
+		_, err := w.WriteString(strconv.FormatBool(b))
+
+		return err
+	case "!!int", "!!float":
+		v, err := jsonNumber(n.Value)
+
+		if err != nil {
+			return fmt.Errorf("line %d: %s", n.Line, err)
+		}
+
+		_, err = w.WriteString(v)
+
+		return err
+	}
+
+	writeJSONString(w, n.Value)
+
+	return nil
+}
+
+// writeJSONString writes s as a JSON string without escaping HTML.
+func writeJSONString(w *bufio.Writer, s string) {
+	const hex = "0123456789abcdef"
+
+	w.WriteByte('"')
+
+	for _, c := range s {
+		switch c {
+		case '"', '\\':
+			w.WriteByte('\\')
+			w.WriteRune(c)
+		case '\n':
+			w.WriteString(`\n`)
+		case '\r':
+			w.WriteString(`\r`)
+		case '\t':
+			w.WriteString(`\t`)
+		default:
+			if c < 0x20 {
+				w.WriteString(`\u00`)
+				w.WriteByte(hex[c>>4])
+				w.WriteByte(hex[c&0xf])
+			} else {
+				w.WriteRune(c)
+			}
+		}
+	}
+
+	w.WriteByte('"')
+}
+
+// jsonNumber normalizes the text of a YAML or TOML number to a JSON number.
+// Text that is already a valid JSON number is returned unchanged so that no
+// precision is lost.
+func jsonNumber(s string) (string, error) {
+	if isJSONNumber(s) {
+		return s, nil
+	}
+
+	t := strings.ReplaceAll(s, "_", "")
+	t = strings.TrimPrefix(t, "+")
+
+	if isJSONNumber(t) {
+		return t, nil
+	}
+
+	if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+		return strconv.FormatInt(i, 10), nil
+	}
+
+	if u, err := strconv.ParseUint(strings.TrimPrefix(s, "+"), 0, 64); err == nil {
+		return strconv.FormatUint(u, 10), nil
+	}
+
+	if f, err := strconv.ParseFloat(t, 64); err == nil && !isInfOrNaN(t) {
+		return strconv.FormatFloat(f, 'g', -1, 64), nil
+	}
+
+	return "", fmt.Errorf("number %q cannot be represented in JSON", s)
+}
+
+// isInfOrNaN reports whether s spells an infinity or NaN.
+func isInfOrNaN(s string) bool {
+	s = strings.ToLower(strings.TrimLeft(s, "+-."))
+
+	return strings.HasPrefix(s, "inf") || strings.HasPrefix(s, "nan")
+}
+
+// isJSONNumber reports whether s is a number in JSON syntax.
+func isJSONNumber(s string) bool {
+	i := 0
+
+	if i < len(s) && s[i] == '-' {
+		i++
+	}
+
+	switch {
+	case i < len(s) && s[i] == '0':
+		i++
+	case i < len(s) && s[i] >= '1' && s[i] <= '9':
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+	default:
+		return false
+	}
+
+	if i < len(s) && s[i] == '.' {
+		i++
+
+		if i == len(s) || !isDigit(s[i]) {
+			return false
+		}
+
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+	}
+
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+
+		if i == len(s) || !isDigit(s[i]) {
+			return false
+		}
+
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+	}
+
+	return i == len(s)
+}
+
+// isDigit reports whether c is an ASCII digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// scalarNode returns a scalar node with a tag and a value.
+func scalarNode(tag, v string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: v}
+}
+
+// stringNode returns a scalar node holding a string.
+func stringNode(v string) *yaml.Node {
+	return scalarNode("!!str", v)
+}
+
+// numberNode returns a scalar node holding the text of a JSON number.
+func numberNode(v string) *yaml.Node {
+	if strings.ContainsAny(v, ".eE") {
+		return scalarNode("!!float", v)
+	}
+
+	return scalarNode("!!int", v)
+}
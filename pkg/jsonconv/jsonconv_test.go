@@ -0,0 +1,174 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jsonconv_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonconv"
+)
+
+const doc = `{"zeta":1,"alpha":"x\"y\n<&>","id":9007199254740993,` +
+	`"ratio":0.1000000000000000055511151231257827,"big":1e400,"ok":true,` +
+	`"nums":[1,2.5,-3],"team":{"name":"Predator","code":"007"},` +
+	`"drivers":[{"name":"A","age":30},{"name":"B","age":22}],` +
+	`"mixed":[1,"a",{"k":[]}],"empty":{},"none":[]}` + "\n"
+
+func roundTrip(t *testing.T, f jsonconv.Format, in string) string {
+	var mid, out bytes.Buffer
+
+	if !assert.NoError(t, jsonconv.Convert(&mid, strings.NewReader(in), jsonconv.JSON, f)) {
+		return ""
+	}
+
+	if !assert.NoError(t, jsonconv.Convert(&out, &mid, f, jsonconv.JSON)) {
+		t.Log(mid.String())
+		return ""
+	}
+
+	return out.String()
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	assert.Equal(
+		t, doc, roundTrip(t, jsonconv.YAML, doc),
+		"YAML round trip should preserve key order and number text.",
+	)
+}
+
+func TestTOMLRoundTrip(t *testing.T) {
+	// TOML writes the tables of a table after its other keys.
+	want := `{"zeta":1,"alpha":"x\"y\n<&>","id":9007199254740993,` +
+		`"ratio":0.1000000000000000055511151231257827,"big":1e400,"ok":true,` +
+		`"nums":[1,2.5,-3],"mixed":[1,"a",{"k":[]}],"empty":{},"none":[],` +
+		`"team":{"name":"Predator","code":"007"},` +
+		`"drivers":[{"name":"A","age":30},{"name":"B","age":22}]}` + "\n"
+
+	assert.Equal(
+		t, want, roundTrip(t, jsonconv.TOML, doc),
+		"TOML round trip should preserve number text.",
+	)
+	assert.Equal(
+		t, want, roundTrip(t, jsonconv.TOML, want),
+		"TOML round trip should preserve key order.",
+	)
+}
+
+func TestTOMLNull(t *testing.T) {
+	var out bytes.Buffer
+
+	err := jsonconv.Convert(
+		&out, strings.NewReader(`{"a":{"b":null}}`), jsonconv.JSON, jsonconv.TOML,
+	)
+
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "a.b", "The error should name the path.")
+	}
+}
+
+func TestFromTOML(t *testing.T) {
+	in := `# comment
+title = 'lit\eral'
+n = 1_000
+h = 0xff
+f = +1.5
+d = 1979-05-27 07:32:00Z
+a.b = """
+multi \
+  line"""
+
+[t]
+arr = [
+  1, # one
+  2,
+]
+
+[[list]]
+x = 1
+
+[[list]]
+x = 2
+inline = { y = "z" }
+`
+
+	var out bytes.Buffer
+
+	if assert.NoError(t, jsonconv.Convert(&out, strings.NewReader(in), jsonconv.TOML, jsonconv.JSON)) {
+		assert.Equal(
+			t,
+			`{"title":"lit\\eral","n":1000,"h":255,"f":1.5,`+
+				`"d":"1979-05-27 07:32:00Z","a":{"b":"multi line"},`+
+				`"t":{"arr":[1,2]},"list":[{"x":1},{"x":2,"inline":{"y":"z"}}]}`+"\n",
+			out.String(),
+		)
+	}
+}
+
+func TestFromYAMLNumbers(t *testing.T) {
+	var out bytes.Buffer
+
+	in := "a: 0x10\nb: +5\nc: ~\nd: '12'\n"
+
+	if assert.NoError(t, jsonconv.Convert(&out, strings.NewReader(in), jsonconv.YAML, jsonconv.JSON)) {
+		assert.Equal(t, `{"a":16,"b":5,"c":null,"d":"12"}`+"\n", out.String())
+	}
+
+	err := jsonconv.Convert(
+		&out, strings.NewReader("a: .inf\n"), jsonconv.YAML, jsonconv.JSON,
+	)
+
+	assert.Error(t, err, "Infinity cannot be represented in JSON.")
+}
+
+func TestFormatOf(t *testing.T) {
+	assert.Equal(t, jsonconv.YAML, jsonconv.FormatOf("game_info.yml"))
+	assert.Equal(t, jsonconv.TOML, jsonconv.FormatOf("game_info.toml"))
+	assert.Equal(t, jsonconv.JSON, jsonconv.FormatOf("game_info.json"))
+	assert.Equal(t, jsonconv.JSON, jsonconv.FormatOf("game_info"))
+}
+
+// numbers are values that lose precision when decoded to float64 or int64.
+var numbers = []string{
+	"9007199254740993",
+	"-9223372036854775808",
+	"18446744073709551616",
+	"0.1000000000000000055511151231257827",
+	"3.141592653589793238462643383279",
+	"1e400",
+	"5e-324",
+	"1.0",
+	"-0",
+	"1E+2",
+	"100000000000000000000000000000001",
+}
+
+func TestNumberPrecision(t *testing.T) {
+	for _, f := range []jsonconv.Format{jsonconv.YAML, jsonconv.TOML} {
+		for _, n := range numbers {
+			in := `{"id":` + n + `,"ids":[` + n + `]}` + "\n"
+
+			assert.Equal(
+				t, in, roundTrip(t, f, in),
+				"%s round trip should keep %s exactly.", f, n,
+			)
+		}
+	}
+}
@@ -0,0 +1,949 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jsonconv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// encodeTOML writes a node tree as a TOML document.
+//
+// TOML requires the plain keys of a table to precede its sub-tables, so
+// within each table, keys holding scalars and inline arrays are written
+// first. TOML has no null; encodeTOML fails on documents containing one.
+func encodeTOML(w *bufio.Writer, n *yaml.Node) error {
+	n = resolve(n)
+
+	if n.Kind != yaml.MappingNode {
+		return fmt.Errorf("TOML documents must be tables")
+	}
+
+	return encodeTOMLTable(w, nil, n, false)
+}
+
+// encodeTOMLTable writes a table under path. array is true when the table is
+// an element of an array of tables.
+func encodeTOMLTable(w *bufio.Writer, path []string, n *yaml.Node, array bool) error {
+	if len(path) > 0 {
+		if array {
+			fmt.Fprintf(w, "\n[[%s]]\n", tomlPath(path))
+		} else {
+			fmt.Fprintf(w, "\n[%s]\n", tomlPath(path))
+		}
+	}
+
+	var nested []int
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := resolve(n.Content[i]), resolve(n.Content[i+1])
+
+		switch {
+		case isTOMLTable(v), isTOMLArrayOfTables(v):
+			nested = append(nested, i)
+		default:
+			w.WriteString(tomlKey(k.Value))
+			w.WriteString(" = ")
+
+			if err := encodeTOMLInline(w, append(path, k.Value), v); err != nil {
+				return err
+			}
+
+			w.WriteByte('\n')
+		}
+	}
+
+	for _, i := range nested {
+		p := append(append([]string(nil), path...), resolve(n.Content[i]).Value)
+		v := resolve(n.Content[i+1])
+
+		if v.Kind == yaml.MappingNode {
+			if err := encodeTOMLTable(w, p, v, false); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		for _, c := range v.Content {
+			if err := encodeTOMLTable(w, p, resolve(c), true); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// encodeTOMLInline writes a node as an inline TOML value.
+func encodeTOMLInline(w *bufio.Writer, path []string, n *yaml.Node) error {
+	n = resolve(n)
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		w.WriteByte('{')
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+
+			k := resolve(n.Content[i]).Value
+
+			w.WriteByte(' ')
+			w.WriteString(tomlKey(k))
+			w.WriteString(" = ")
+
+			p := append(append([]string(nil), path...), k)
+
+			if err := encodeTOMLInline(w, p, n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+
+		if len(n.Content) > 0 {
+			w.WriteByte(' ')
+		}
+
+		return w.WriteByte('}')
+	case yaml.SequenceNode:
+		w.WriteByte('[')
+
+		for i, c := range n.Content {
+			if i > 0 {
+				w.WriteString(", ")
+			}
+
+			p := append(append([]string(nil), path...), strconv.Itoa(i))
+
+			if err := encodeTOMLInline(w, p, c); err != nil {
+				return err
+			}
+		}
+
+		return w.WriteByte(']')
+	case yaml.ScalarNode:
+		switch n.ShortTag() {
+		case "!!null":
+			return fmt.Errorf(
+				"%s: TOML cannot represent null", strings.Join(path, "."),
+			)
+		case "!!bool":
+			var b bool
+
+			if err := n.Decode(&b); err != nil {
+				return err
+			}
+
+			_, err := w.WriteString(strconv.FormatBool(b))
+
+			return err
+		case "!!int", "!!float":
+			v, err := jsonNumber(n.Value)
+
+			if err != nil {
+				return fmt.Errorf("%s: %s", strings.Join(path, "."), err)
+			}
+
+			_, err = w.WriteString(v)
+
+			return err
+		}
+
+		w.WriteString(tomlString(n.Value))
+
+		return nil
+	}
+
+	return fmt.Errorf("%s: unexpected node kind %d", strings.Join(path, "."), n.Kind)
+}
+
+// isTOMLTable reports whether n is written as a standard table.
+func isTOMLTable(n *yaml.Node) bool {
+	return n.Kind == yaml.MappingNode && len(n.Content) > 0
+}
+
+// isTOMLArrayOfTables reports whether n is written as an array of tables.
+func isTOMLArrayOfTables(n *yaml.Node) bool {
+	if n.Kind != yaml.SequenceNode || len(n.Content) == 0 {
+		return false
+	}
+
+	for _, c := range n.Content {
+		if resolve(c).Kind != yaml.MappingNode {
+			return false
+		}
+	}
+
+	return true
+}
+
+// tomlPath returns the dotted key of a table header.
+func tomlPath(path []string) string {
+	ks := make([]string, len(path))
+
+	for i, k := range path {
+		ks[i] = tomlKey(k)
+	}
+
+	return strings.Join(ks, ".")
+}
+
+// tomlKey returns k as a bare key when possible and a quoted key otherwise.
+func tomlKey(k string) string {
+	if k == "" {
+		return `""`
+	}
+
+	for i := 0; i < len(k); i++ {
+		if !isBareKeyChar(k[i]) {
+			return tomlString(k)
+		}
+	}
+
+	return k
+}
+
+// isBareKeyChar reports whether c may appear in a bare key.
+func isBareKeyChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || isDigit(c) ||
+		c == '_' || c == '-'
+}
+
+// tomlString returns s as a TOML basic string.
+func tomlString(s string) string {
+	var b strings.Builder
+
+	b.WriteByte('"')
+
+	for _, c := range s {
+		switch c {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, c)
+			} else {
+				b.WriteRune(c)
+			}
+		}
+	}
+
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// resolve follows alias nodes.
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+
+	return n
+}
+
+// tomlParser parses a TOML document into a node tree.
+type tomlParser struct {
+	s    []byte
+	i    int
+	line int
+	root *yaml.Node
+	keys map[*yaml.Node]map[string]*yaml.Node
+}
+
+// decodeTOML reads a TOML document into a node tree. Dates and times are
+// converted to strings.
+func decodeTOML(r io.Reader) (*yaml.Node, error) {
+	s, err := io.ReadAll(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !utf8.Valid(s) {
+		return nil, fmt.Errorf("TOML document is not valid UTF-8")
+	}
+
+	p := &tomlParser{
+		s:    s,
+		line: 1,
+		root: &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"},
+		keys: make(map[*yaml.Node]map[string]*yaml.Node),
+	}
+
+	if err := p.parse(); err != nil {
+		return nil, fmt.Errorf("line %d: %s", p.line, err)
+	}
+
+	return p.root, nil
+}
+
+// parse parses the whole document.
+func (p *tomlParser) parse() error {
+	cur := p.root
+
+	for {
+		p.skipSpace()
+
+		if p.eof() {
+			return nil
+		}
+
+		switch p.s[p.i] {
+		case '#', '\r', '\n':
+		case '[':
+			t, err := p.parseHeader()
+
+			if err != nil {
+				return err
+			}
+
+			cur = t
+		default:
+			if err := p.parseKeyValue(cur); err != nil {
+				return err
+			}
+		}
+
+		if err := p.endLine(); err != nil {
+			return err
+		}
+	}
+}
+
+// parseHeader parses a table or array of tables header and returns the
+// table it opens.
+func (p *tomlParser) parseHeader() (*yaml.Node, error) {
+	p.i++
+
+	array := p.peek('[')
+
+	if array {
+		p.i++
+	}
+
+	ks, err := p.parseKey()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.consume(']') || array && !p.consume(']') {
+		return nil, fmt.Errorf("unterminated table header")
+	}
+
+	t := p.root
+
+	for _, k := range ks[:len(ks)-1] {
+		if t, err = p.descend(t, k); err != nil {
+			return nil, err
+		}
+	}
+
+	k := ks[len(ks)-1]
+	c := p.lookup(t, k)
+
+	if !array {
+		return p.descend(t, k)
+	}
+
+	m := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+
+	switch {
+	case c == nil:
+		p.set(t, k, &yaml.Node{
+			Kind: yaml.SequenceNode, Tag: "!!seq", Content: []*yaml.Node{m},
+		})
+	case c.Kind == yaml.SequenceNode:
+		c.Content = append(c.Content, m)
+	default:
+		return nil, fmt.Errorf("key %q is not an array of tables", k)
+	}
+
+	return m, nil
+}
+
+// parseKeyValue parses a key/value pair into table t.
+func (p *tomlParser) parseKeyValue(t *yaml.Node) error {
+	ks, err := p.parseKey()
+
+	if err != nil {
+		return err
+	}
+
+	if !p.consume('=') {
+		return fmt.Errorf("expected '=' after key")
+	}
+
+	p.skipSpace()
+
+	v, err := p.parseValue()
+
+	if err != nil {
+		return err
+	}
+
+	for _, k := range ks[:len(ks)-1] {
+		if t, err = p.descend(t, k); err != nil {
+			return err
+		}
+	}
+
+	k := ks[len(ks)-1]
+
+	if p.lookup(t, k) != nil {
+		return fmt.Errorf("duplicate key %q", k)
+	}
+
+	p.set(t, k, v)
+
+	return nil
+}
+
+// parseKey parses a possibly dotted key.
+func (p *tomlParser) parseKey() ([]string, error) {
+	var ks []string
+
+	for {
+		p.skipSpace()
+
+		if p.eof() {
+			return nil, fmt.Errorf("expected key")
+		}
+
+		var (
+			k   string
+			err error
+		)
+
+		switch p.s[p.i] {
+		case '"':
+			k, err = p.parseBasicString()
+		case '\'':
+			k, err = p.parseLiteralString()
+		default:
+			j := p.i
+
+			for !p.eof() && isBareKeyChar(p.s[p.i]) {
+				p.i++
+			}
+
+			if j == p.i {
+				return nil, fmt.Errorf("expected key")
+			}
+
+			k = string(p.s[j:p.i])
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		ks = append(ks, k)
+
+		p.skipSpace()
+
+		if !p.consume('.') {
+			return ks, nil
+		}
+	}
+}
+
+// parseValue parses a value.
+func (p *tomlParser) parseValue() (*yaml.Node, error) {
+	if p.eof() {
+		return nil, fmt.Errorf("expected value")
+	}
+
+	switch p.s[p.i] {
+	case '"':
+		var (
+			s   string
+			err error
+		)
+
+		if p.hasPrefix(`"""`) {
+			s, err = p.parseMultilineBasicString()
+		} else {
+			s, err = p.parseBasicString()
+		}
+
+		return stringNode(s), err
+	case '\'':
+		var (
+			s   string
+			err error
+		)
+
+		if p.hasPrefix(`'''`) {
+			s, err = p.parseMultilineLiteralString()
+		} else {
+			s, err = p.parseLiteralString()
+		}
+
+		return stringNode(s), err
+	case '[':
+		return p.parseArray()
+	case '{':
+		return p.parseInlineTable()
+	}
+
+	return p.parseBareValue()
+}
+
+// parseArray parses an array.
+func (p *tomlParser) parseArray() (*yaml.Node, error) {
+	p.i++
+
+	n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+
+	for {
+		p.skipBlank()
+
+		if p.consume(']') {
+			return n, nil
+		}
+
+		v, err := p.parseValue()
+
+		if err != nil {
+			return nil, err
+		}
+
+		n.Content = append(n.Content, v)
+
+		p.skipBlank()
+
+		if p.consume(']') {
+			return n, nil
+		}
+
+		if !p.consume(',') {
+			return nil, fmt.Errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+// parseInlineTable parses an inline table.
+func (p *tomlParser) parseInlineTable() (*yaml.Node, error) {
+	p.i++
+
+	n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+
+	p.skipSpace()
+
+	if p.consume('}') {
+		return n, nil
+	}
+
+	for {
+		if err := p.parseKeyValue(n); err != nil {
+			return nil, err
+		}
+
+		p.skipSpace()
+
+		if p.consume('}') {
+			return n, nil
+		}
+
+		if !p.consume(',') {
+			return nil, fmt.Errorf("expected ',' or '}' in inline table")
+		}
+	}
+}
+
+// parseBareValue parses a boolean, number, or date-time.
+func (p *tomlParser) parseBareValue() (*yaml.Node, error) {
+	j := p.i
+
+	for !p.eof() && isBareValueChar(p.s[p.i]) {
+		p.i++
+	}
+
+	// A space may separate the date and the time of a date-time.
+	if p.i-j == 10 && p.s[j+4] == '-' && p.i+3 < len(p.s) &&
+		p.s[p.i] == ' ' && isDigit(p.s[p.i+1]) && isDigit(p.s[p.i+2]) &&
+		p.s[p.i+3] == ':' {
+		p.i++
+
+		for !p.eof() && isBareValueChar(p.s[p.i]) {
+			p.i++
+		}
+	}
+
+	v := string(p.s[j:p.i])
+
+	switch {
+	case v == "":
+		return nil, fmt.Errorf("expected value")
+	case v == "true" || v == "false":
+		return scalarNode("!!bool", v), nil
+	case strings.Contains(v, ":") || len(v) >= 10 && v[4] == '-' && isDigit(v[0]):
+		return stringNode(v), nil
+	}
+
+	n, err := jsonNumber(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return numberNode(n), nil
+}
+
+// isBareValueChar reports whether c may appear in an unquoted value.
+func isBareValueChar(c byte) bool {
+	return isBareKeyChar(c) || c == '+' || c == '.' || c == ':'
+}
+
+// parseBasicString parses a single-line basic string.
+func (p *tomlParser) parseBasicString() (string, error) {
+	p.i++
+
+	var b strings.Builder
+
+	for {
+		if p.eof() || p.s[p.i] == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+
+		c := p.s[p.i]
+
+		switch c {
+		case '"':
+			p.i++
+			return b.String(), nil
+		case '\\':
+			if err := p.parseEscape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+			p.i++
+		}
+	}
+}
+
+// parseMultilineBasicString parses a multi-line basic string.
+func (p *tomlParser) parseMultilineBasicString() (string, error) {
+	p.i += 3
+	p.trimNewline()
+
+	var b strings.Builder
+
+	for {
+		if p.eof() {
+			return "", fmt.Errorf("unterminated string")
+		}
+
+		if p.hasPrefix(`"""`) {
+			p.i += 3
+
+			// Up to two quotes may precede the closing delimiter.
+			for k := 0; k < 2 && p.peek('"'); k++ {
+				b.WriteByte('"')
+				p.i++
+			}
+
+			return b.String(), nil
+		}
+
+		c := p.s[p.i]
+
+		switch {
+		case c == '\\' && p.isLineEndingBackslash():
+			p.i++
+			p.skipBlank()
+		case c == '\\':
+			if err := p.parseEscape(&b); err != nil {
+				return "", err
+			}
+		default:
+			if c == '\n' {
+				p.line++
+			}
+
+			b.WriteByte(c)
+			p.i++
+		}
+	}
+}
+
+// isLineEndingBackslash reports whether the backslash at the cursor is
+// followed only by whitespace up to the end of the line.
+func (p *tomlParser) isLineEndingBackslash() bool {
+	for j := p.i + 1; j < len(p.s); j++ {
+		switch p.s[j] {
+		case ' ', '\t', '\r':
+		case '\n':
+			return true
+		default:
+			return false
+		}
+	}
+
+	return false
+}
+
+// parseEscape parses an escape sequence in a basic string.
+func (p *tomlParser) parseEscape(b *strings.Builder) error {
+	p.i++
+
+	if p.eof() {
+		return fmt.Errorf("unterminated escape sequence")
+	}
+
+	c := p.s[p.i]
+	p.i++
+
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case 'e':
+		b.WriteByte(0x1b)
+	case '"':
+		b.WriteByte('"')
+	case '\\':
+		b.WriteByte('\\')
+	case 'u', 'U':
+		l := 4
+
+		if c == 'U' {
+			l = 8
+		}
+
+		if p.i+l > len(p.s) {
+			return fmt.Errorf("short unicode escape")
+		}
+
+		r, err := strconv.ParseUint(string(p.s[p.i:p.i+l]), 16, 32)
+
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return fmt.Errorf("invalid unicode escape")
+		}
+
+		b.WriteRune(rune(r))
+		p.i += l
+	default:
+		return fmt.Errorf("invalid escape sequence \\%c", c)
+	}
+
+	return nil
+}
+
+// parseLiteralString parses a single-line literal string.
+func (p *tomlParser) parseLiteralString() (string, error) {
+	p.i++
+	j := p.i
+
+	for !p.eof() && p.s[p.i] != '\'' {
+		if p.s[p.i] == '\n' {
+			break
+		}
+
+		p.i++
+	}
+
+	if !p.consume('\'') {
+		return "", fmt.Errorf("unterminated string")
+	}
+
+	return string(p.s[j : p.i-1]), nil
+}
+
+// parseMultilineLiteralString parses a multi-line literal string.
+func (p *tomlParser) parseMultilineLiteralString() (string, error) {
+	p.i += 3
+	p.trimNewline()
+
+	j := p.i
+
+	for !p.eof() && !p.hasPrefix(`'''`) {
+		if p.s[p.i] == '\n' {
+			p.line++
+		}
+
+		p.i++
+	}
+
+	if p.eof() {
+		return "", fmt.Errorf("unterminated string")
+	}
+
+	p.i += 3
+
+	// Up to two quotes may precede the closing delimiter.
+	for k := 0; k < 2 && p.peek('\''); k++ {
+		p.i++
+	}
+
+	return string(p.s[j : p.i-3]), nil
+}
+
+// trimNewline skips a newline immediately following an opening delimiter.
+func (p *tomlParser) trimNewline() {
+	if p.hasPrefix("\r\n") {
+		p.i += 2
+		p.line++
+	} else if p.peek('\n') {
+		p.i++
+		p.line++
+	}
+}
+
+// descend returns the table under key k of table t, creating it if needed.
+// When k holds an array of tables, its last element is returned.
+func (p *tomlParser) descend(t *yaml.Node, k string) (*yaml.Node, error) {
+	c := p.lookup(t, k)
+
+	switch {
+	case c == nil:
+		c = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		p.set(t, k, c)
+	case c.Kind == yaml.SequenceNode && len(c.Content) > 0 &&
+		c.Content[len(c.Content)-1].Kind == yaml.MappingNode:
+		c = c.Content[len(c.Content)-1]
+	case c.Kind != yaml.MappingNode:
+		return nil, fmt.Errorf("key %q is not a table", k)
+	}
+
+	return c, nil
+}
+
+// lookup returns the value under key k of table t, or nil.
+func (p *tomlParser) lookup(t *yaml.Node, k string) *yaml.Node {
+	return p.keys[t][k]
+}
+
+// set appends key k with value v to table t.
+func (p *tomlParser) set(t *yaml.Node, k string, v *yaml.Node) {
+	m, ok := p.keys[t]
+
+	if !ok {
+		m = make(map[string]*yaml.Node)
+		p.keys[t] = m
+	}
+
+	m[k] = v
+	t.Content = append(t.Content, stringNode(k), v)
+}
+
+// endLine consumes an optional comment and the end of the line.
+func (p *tomlParser) endLine() error {
+	p.skipSpace()
+
+	if p.peek('#') {
+		for !p.eof() && p.s[p.i] != '\n' {
+			p.i++
+		}
+	}
+
+	switch {
+	case p.eof():
+	case p.hasPrefix("\r\n"):
+		p.i += 2
+		p.line++
+	case p.peek('\n'):
+		p.i++
+		p.line++
+	default:
+		return fmt.Errorf("unexpected %q", p.s[p.i])
+	}
+
+	return nil
+}
+
+// skipSpace skips spaces and tabs.
+func (p *tomlParser) skipSpace() {
+	for !p.eof() && (p.s[p.i] == ' ' || p.s[p.i] == '\t') {
+		p.i++
+	}
+}
+
+// skipBlank skips whitespace, newlines, and comments.
+func (p *tomlParser) skipBlank() {
+	for !p.eof() {
+		switch p.s[p.i] {
+		case ' ', '\t', '\r':
+		case '\n':
+			p.line++
+		case '#':
+			for !p.eof() && p.s[p.i] != '\n' {
+				p.i++
+			}
+
+			continue
+		default:
+			return
+		}
+
+		p.i++
+	}
+}
+
+// consume skips c if it is at the cursor.
+func (p *tomlParser) consume(c byte) bool {
+	if p.peek(c) {
+		p.i++
+		return true
+	}
+
+	return false
+}
+
+// peek reports whether c is at the cursor.
+func (p *tomlParser) peek(c byte) bool {
+	return !p.eof() && p.s[p.i] == c
+}
+
+// hasPrefix reports whether s is at the cursor.
+func (p *tomlParser) hasPrefix(s string) bool {
+	return len(p.s)-p.i >= len(s) && string(p.s[p.i:p.i+len(s)]) == s
+}
+
+// eof reports whether the cursor is at the end of the document.
+func (p *tomlParser) eof() bool {
+	return p.i >= len(p.s)
+}
@@ -0,0 +1,55 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jsonconv
+
+import (
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeYAML reads a YAML document into a node tree.
+func decodeYAML(r io.Reader) (*yaml.Node, error) {
+	var n yaml.Node
+
+	if err := yaml.NewDecoder(r).Decode(&n); err != nil {
+		return nil, err
+	}
+
+	if n.Kind == yaml.DocumentNode {
+		if len(n.Content) == 0 {
+			return nil, fmt.Errorf("empty YAML document")
+		}
+
+		return n.Content[0], nil
+	}
+
+	return &n, nil
+}
+
+// encodeYAML writes a node tree as a YAML document.
+func encodeYAML(w io.Writer, n *yaml.Node) error {
+	e := yaml.NewEncoder(w)
+	e.SetIndent(2)
+
+	if err := e.Encode(n); err != nil {
+		return err
+	}
+
+	return e.Close()
+}
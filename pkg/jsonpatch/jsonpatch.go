@@ -0,0 +1,439 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package jsonpatch computes JSON Patch documents, as specified by RFC 6902,
+// describing the differences between two JSON documents, and applies them.
+//
+// Like the other packages of mmse, jsonpatch keeps the text of numbers, so a
+// number is only reported as changed when its text changes.
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Operation is an operation of a JSON Patch.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// node is a parsed JSON value keeping the order of keys.
+type node struct {
+	// delim is '{' for objects, '[' for arrays, and 0 for scalars.
+	delim json.Delim
+	keys  []string
+	vals  []*node
+	// scalar is the compact text of a scalar.
+	scalar []byte
+}
+
+// parse parses a JSON document.
+func parse(b []byte) (*node, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+
+	n, err := parseValue(d)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := d.Token(); err != io.EOF {
+		return nil, fmt.Errorf("trailing data after JSON document")
+	}
+
+	return n, nil
+}
+
+// parseValue parses the next value of a decoder.
+func parseValue(d *json.Decoder) (*node, error) {
+	t, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	n := new(node)
+
+	switch t := t.(type) {
+	case json.Delim:
+		n.delim = t
+
+		for d.More() {
+			if t == '{' {
+				k, err := d.Token()
+				if err != nil {
+					return nil, err
+				}
+
+				n.keys = append(n.keys, k.(string))
+			}
+
+			v, err := parseValue(d)
+			if err != nil {
+				return nil, err
+			}
+
+			n.vals = append(n.vals, v)
+		}
+
+		if _, err := d.Token(); err != nil {
+			return nil, err
+		}
+	case json.Number:
+		n.scalar = []byte(t)
+	case nil:
+		n.scalar = []byte("null")
+	default:
+		if n.scalar, err = json.Marshal(t); err != nil {
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
+// marshal returns the compact text of a value.
+func (n *node) marshal() json.RawMessage {
+	if n.delim == 0 {
+		return n.scalar
+	}
+
+	b := new(bytes.Buffer)
+
+	b.WriteByte(byte(n.delim))
+
+	for i, v := range n.vals {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		if n.delim == '{' {
+			k, _ := json.Marshal(n.keys[i])
+			b.Write(k)
+			b.WriteByte(':')
+		}
+
+		b.Write(v.marshal())
+	}
+
+	if n.delim == '{' {
+		b.WriteByte('}')
+	} else {
+		b.WriteByte(']')
+	}
+
+	return b.Bytes()
+}
+
+// Pointer returns the JSON Pointer of a path given as keys and array indices,
+// escaping ~ and / in the keys.
+func Pointer(elems ...string) string {
+	r := strings.NewReplacer("~", "~0", "/", "~1")
+
+	b := new(strings.Builder)
+
+	for _, e := range elems {
+		b.WriteByte('/')
+		b.WriteString(r.Replace(e))
+	}
+
+	return b.String()
+}
+
+// Diff returns the operations turning JSON document a into b, with paths
+// relative to the root of the documents. Keys are compared by name and array
+// elements by index, so removing an element from the middle of an array
+// replaces the elements after it. Removed array elements are removed from the
+// end of the array, so that the indices stay valid.
+func Diff(a, b []byte) ([]Operation, error) {
+	na, err := parse(a)
+	if err != nil {
+		return nil, fmt.Errorf("first document: %w", err)
+	}
+
+	nb, err := parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("second document: %w", err)
+	}
+
+	var ops []Operation
+
+	diff(&ops, "", na, nb)
+
+	return ops, nil
+}
+
+// diff adds the operations turning value a at pointer p into b.
+func diff(ops *[]Operation, p string, a, b *node) {
+	switch {
+	case a.delim != b.delim:
+		*ops = append(*ops, Operation{Op: "replace", Path: p, Value: b.marshal()})
+	case a.delim == '{':
+		in := make(map[string]int, len(b.keys))
+
+		for i, k := range b.keys {
+			in[k] = i
+		}
+
+		seen := make(map[string]bool, len(a.keys))
+
+		for i, k := range a.keys {
+			seen[k] = true
+
+			if j, ok := in[k]; ok {
+				diff(ops, p+Pointer(k), a.vals[i], b.vals[j])
+			} else {
+				*ops = append(*ops, Operation{Op: "remove", Path: p + Pointer(k)})
+			}
+		}
+
+		for j, k := range b.keys {
+			if !seen[k] {
+				*ops = append(*ops, Operation{Op: "add", Path: p + Pointer(k), Value: b.vals[j].marshal()})
+			}
+		}
+	case a.delim == '[':
+		n := len(a.vals)
+
+		if len(b.vals) < n {
+			n = len(b.vals)
+		}
+
+		for i := 0; i < n; i++ {
+			diff(ops, p+Pointer(strconv.Itoa(i)), a.vals[i], b.vals[i])
+		}
+
+		for i := len(a.vals) - 1; i >= n; i-- {
+			*ops = append(*ops, Operation{Op: "remove", Path: p + Pointer(strconv.Itoa(i))})
+		}
+
+		for i := n; i < len(b.vals); i++ {
+			*ops = append(*ops, Operation{Op: "add", Path: p + Pointer(strconv.Itoa(i)), Value: b.vals[i].marshal()})
+		}
+	case !bytes.Equal(a.scalar, b.scalar):
+		*ops = append(*ops, Operation{Op: "replace", Path: p, Value: b.marshal()})
+	}
+}
+
+// Apply applies the operations of a JSON Patch to JSON document doc and
+// returns the patched document, compact. The operations add, remove, replace,
+// and test are supported. When an operation fails, Apply returns the error and
+// no document, so a patch applies in full or not at all. Like Diff, test
+// compares numbers by their text.
+func Apply(doc []byte, ops []Operation) ([]byte, error) {
+	n, err := parse(doc)
+	if err != nil {
+		return nil, fmt.Errorf("document: %w", err)
+	}
+
+	for i, o := range ops {
+		if n, err = apply(n, o); err != nil {
+			return nil, fmt.Errorf("operation %d, %s %s: %w", i, o.Op, o.Path, err)
+		}
+	}
+
+	return n.marshal(), nil
+}
+
+// apply applies an operation to root and returns the new root.
+func apply(root *node, o Operation) (*node, error) {
+	toks, err := parsePointer(o.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var v *node
+
+	switch o.Op {
+	case "add", "replace", "test":
+		if o.Value == nil {
+			return nil, fmt.Errorf("missing value")
+		}
+
+		if v, err = parse(o.Value); err != nil {
+			return nil, fmt.Errorf("value: %w", err)
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("unsupported operation")
+	}
+
+	if len(toks) == 0 {
+		switch o.Op {
+		case "add", "replace":
+			return v, nil
+		case "test":
+			if !equal(root, v) {
+				return nil, fmt.Errorf("test failed")
+			}
+
+			return root, nil
+		default:
+			return nil, fmt.Errorf("unable to remove the document")
+		}
+	}
+
+	parent := root
+
+	for _, t := range toks[:len(toks)-1] {
+		i, err := parent.index(t, false)
+		if err != nil {
+			return nil, err
+		}
+
+		parent = parent.vals[i]
+	}
+
+	t := toks[len(toks)-1]
+
+	if o.Op == "add" {
+		return root, parent.add(t, v)
+	}
+
+	i, err := parent.index(t, false)
+	if err != nil {
+		return nil, err
+	}
+
+	switch o.Op {
+	case "remove":
+		if parent.delim == '{' {
+			parent.keys = append(parent.keys[:i], parent.keys[i+1:]...)
+		}
+
+		parent.vals = append(parent.vals[:i], parent.vals[i+1:]...)
+	case "replace":
+		parent.vals[i] = v
+	case "test":
+		if !equal(parent.vals[i], v) {
+			return nil, fmt.Errorf("test failed")
+		}
+	}
+
+	return root, nil
+}
+
+// parsePointer returns the reference tokens of a JSON Pointer.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+
+	if p[0] != '/' {
+		return nil, fmt.Errorf("pointer does not start with /")
+	}
+
+	r := strings.NewReplacer("~1", "/", "~0", "~")
+	toks := strings.Split(p[1:], "/")
+
+	for i, t := range toks {
+		toks[i] = r.Replace(t)
+	}
+
+	return toks, nil
+}
+
+// index returns the index in n of the value of reference token t. With end,
+// the index may also be the length of an array, given as such or as -, to
+// add an element at the end.
+func (n *node) index(t string, end bool) (int, error) {
+	switch n.delim {
+	case '{':
+		for i := len(n.keys) - 1; i >= 0; i-- {
+			if n.keys[i] == t {
+				return i, nil
+			}
+		}
+
+		return 0, fmt.Errorf("no key %q", t)
+	case '[':
+		if end && t == "-" {
+			return len(n.vals), nil
+		}
+
+		i, err := strconv.Atoi(t)
+
+		switch {
+		case err != nil || i < 0 || (len(t) > 1 && t[0] == '0') || t[0] == '+':
+			return 0, fmt.Errorf("invalid array index %q", t)
+		case i < len(n.vals) || (end && i == len(n.vals)):
+			return i, nil
+		}
+
+		return 0, fmt.Errorf("array index %d out of range", i)
+	}
+
+	return 0, fmt.Errorf("unable to look up %q in a scalar", t)
+}
+
+// add adds value v at reference token t of n, replacing the value of an
+// existing key and inserting into arrays.
+func (n *node) add(t string, v *node) error {
+	if n.delim == '{' {
+		if i, err := n.index(t, false); err == nil {
+			n.vals[i] = v
+		} else {
+			n.keys, n.vals = append(n.keys, t), append(n.vals, v)
+		}
+
+		return nil
+	}
+
+	i, err := n.index(t, true)
+	if err != nil {
+		return err
+	}
+
+	n.vals = append(n.vals, nil)
+	copy(n.vals[i+1:], n.vals[i:])
+	n.vals[i] = v
+
+	return nil
+}
+
+// equal reports whether two values are equal, comparing objects regardless of
+// the order of their keys and scalars by their text.
+func equal(a, b *node) bool {
+	if a.delim != b.delim || len(a.vals) != len(b.vals) {
+		return false
+	}
+
+	switch a.delim {
+	case '{':
+		for i, k := range a.keys {
+			j, err := b.index(k, false)
+			if err != nil || !equal(a.vals[i], b.vals[j]) {
+				return false
+			}
+		}
+	case '[':
+		for i := range a.vals {
+			if !equal(a.vals[i], b.vals[i]) {
+				return false
+			}
+		}
+	default:
+		return bytes.Equal(a.scalar, b.scalar)
+	}
+
+	return true
+}
@@ -0,0 +1,143 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jsonpatch_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpatch"
+)
+
+func TestDiff(t *testing.T) {
+	a := `{"name":"team","budget":1.50,"a/b":1,"drivers":[{"age":30},{"age":31},{"age":32}],"gone":null}`
+	b := `{"name":"team","budget":1.5,"a/b":2,"drivers":[{"age":30},{"age":41}],"new":{"x":[1,"é"]}}`
+
+	ops, err := jsonpatch.Diff([]byte(a), []byte(b))
+
+	if assert.NoError(t, err) {
+		assert.Equal(
+			t,
+			[]jsonpatch.Operation{
+				{Op: "replace", Path: "/budget", Value: json.RawMessage(`1.5`)},
+				{Op: "replace", Path: "/a~1b", Value: json.RawMessage(`2`)},
+				{Op: "replace", Path: "/drivers/1/age", Value: json.RawMessage(`41`)},
+				{Op: "remove", Path: "/drivers/2"},
+				{Op: "remove", Path: "/gone"},
+				{Op: "add", Path: "/new", Value: json.RawMessage(`{"x":[1,"é"]}`)},
+			},
+			ops,
+			"Diff should keep the text of numbers and escape keys.",
+		)
+	}
+
+	ops, err = jsonpatch.Diff([]byte(`[1]`), []byte(`[1,2,3]`))
+
+	if assert.NoError(t, err) {
+		assert.Equal(
+			t,
+			[]jsonpatch.Operation{
+				{Op: "add", Path: "/1", Value: json.RawMessage(`2`)},
+				{Op: "add", Path: "/2", Value: json.RawMessage(`3`)},
+			},
+			ops,
+			"Diff should add elements in order.",
+		)
+	}
+
+	ops, err = jsonpatch.Diff([]byte(`{"a":[1]}`), []byte(`{"a":{"0":1}}`))
+
+	if assert.NoError(t, err) {
+		assert.Equal(
+			t,
+			[]jsonpatch.Operation{{Op: "replace", Path: "/a", Value: json.RawMessage(`{"0":1}`)}},
+			ops,
+			"Diff should replace values changing type.",
+		)
+	}
+
+	ops, err = jsonpatch.Diff([]byte(a), []byte(a))
+
+	assert.NoError(t, err)
+	assert.Empty(t, ops, "Equal documents should have no operations.")
+
+	_, err = jsonpatch.Diff([]byte(`{`), []byte(a))
+
+	assert.Error(t, err, "Diff should fail on invalid JSON.")
+}
+
+func TestPointer(t *testing.T) {
+	assert.Equal(t, "/data/a~0b~1c/0", jsonpatch.Pointer("data", "a~b/c", "0"))
+	assert.Equal(t, "", jsonpatch.Pointer())
+}
+
+func TestApply(t *testing.T) {
+	a := `{"name":"team","budget":1.50,"a/b":1,"drivers":[{"age":30},{"age":31},{"age":32}],"gone":null}`
+	b := `{"name":"team","budget":1.5,"a/b":2,"drivers":[{"age":30},{"age":41}],"new":{"x":[1,"é"]}}`
+
+	ops, err := jsonpatch.Diff([]byte(a), []byte(b))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	got, err := jsonpatch.Apply([]byte(a), ops)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, b, string(got), "Applying the diff should give the second document.")
+	}
+
+	got, err = jsonpatch.Apply([]byte(`{"a":[1,3],"b":{"c":1}}`), []jsonpatch.Operation{
+		{Op: "test", Path: "/b", Value: json.RawMessage(`{ "c" : 1 }`)},
+		{Op: "add", Path: "/a/1", Value: json.RawMessage(`2`)},
+		{Op: "add", Path: "/a/-", Value: json.RawMessage(`4`)},
+		{Op: "add", Path: "/b/c", Value: json.RawMessage(`5`)},
+		{Op: "remove", Path: "/b"},
+	})
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, `{"a":[1,2,3,4]}`, string(got), "Add should insert into arrays and replace keys.")
+	}
+
+	got, err = jsonpatch.Apply([]byte(`[1]`), []jsonpatch.Operation{{Op: "replace", Path: "", Value: json.RawMessage(`{}`)}})
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, `{}`, string(got), "Replace should replace the document at the empty pointer.")
+	}
+
+	for _, o := range []jsonpatch.Operation{
+		{Op: "replace", Path: "/missing", Value: json.RawMessage(`1`)},
+		{Op: "remove", Path: "/a/2"},
+		{Op: "add", Path: "/a/01", Value: json.RawMessage(`1`)},
+		{Op: "add", Path: "/a/0/x", Value: json.RawMessage(`1`)},
+		{Op: "test", Path: "/a/0", Value: json.RawMessage(`1.0`)},
+		{Op: "add", Path: "/b"},
+		{Op: "move", Path: "/b"},
+		{Op: "remove", Path: "a"},
+		{Op: "remove", Path: ""},
+	} {
+		got, err := jsonpatch.Apply([]byte(`{"a":[1,2]}`), []jsonpatch.Operation{o})
+
+		assert.Error(t, err, "%s %s should fail.", o.Op, o.Path)
+		assert.Nil(t, got, "A failed patch should return no document.")
+	}
+
+	_, err = jsonpatch.Apply([]byte(`{`), nil)
+
+	assert.Error(t, err, "Apply should fail on invalid JSON.")
+}
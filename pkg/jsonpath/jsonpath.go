@@ -0,0 +1,514 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package jsonpath names locations in the JSON documents of a save file and
+// walks documents without unmarshalling them.
+//
+// A path is written as keys separated by dots with array indices in
+// brackets, such as drivers[3].name. Keys that are not plain identifiers are
+// quoted, such as stats["top speed"].
+package jsonpath
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Elem is an element of a path: an object key or an array index.
+type Elem struct {
+	Key     string
+	Index   int
+	IsIndex bool
+}
+
+// Key returns a path element for an object key.
+func Key(k string) Elem {
+	return Elem{Key: k}
+}
+
+// Index returns a path element for an array index.
+func Index(i int) Elem {
+	return Elem{Index: i, IsIndex: true}
+}
+
+// Path is a location in a JSON document.
+type Path []Elem
+
+// String formats the path.
+func (p Path) String() string {
+	var b strings.Builder
+
+	for i, e := range p {
+		switch {
+		case e.IsIndex && e.Index < 0:
+			b.WriteString("[]")
+		case e.IsIndex:
+			fmt.Fprintf(&b, "[%d]", e.Index)
+		case isIdent(e.Key):
+			if i > 0 {
+				b.WriteByte('.')
+			}
+
+			b.WriteString(e.Key)
+		default:
+			b.WriteByte('[')
+			b.WriteString(strconv.Quote(e.Key))
+			b.WriteByte(']')
+		}
+	}
+
+	return b.String()
+}
+
+// Pattern returns the path with every array index replaced by [], so that
+// paths differing only in indices compare equal.
+func (p Path) Pattern() string {
+	q := make(Path, len(p))
+
+	for i, e := range p {
+		if e.IsIndex {
+			e.Index = -1
+		}
+
+		q[i] = e
+	}
+
+	return q.String()
+}
+
+// Copy returns a copy of the path that is safe to keep.
+func (p Path) Copy() Path {
+	return append(Path(nil), p...)
+}
+
+// isIdent reports whether k can be written without quotes.
+func isIdent(k string) bool {
+	if k == "" {
+		return false
+	}
+
+	for i := 0; i < len(k); i++ {
+		c := k[i]
+
+		if !(c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Parse parses a path. An empty string is the root.
+func Parse(s string) (Path, error) {
+	var p Path
+
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == '.' && i > 0:
+			i++
+
+			j, err := bareKey(s, i)
+			if err != nil {
+				return nil, err
+			}
+
+			p = append(p, Key(s[i:j]))
+			i = j
+		case c == '[':
+			j := strings.IndexByte(s[i:], ']')
+
+			if i+1 < len(s) && s[i+1] == '"' {
+				k, n, err := unquote(s[i+1:])
+				if err != nil {
+					return nil, fmt.Errorf("%s at offset %d in %q", err, i, s)
+				}
+
+				i += 1 + n
+
+				if i >= len(s) || s[i] != ']' {
+					return nil, fmt.Errorf("missing ] at offset %d in %q", i, s)
+				}
+
+				p = append(p, Key(k))
+				i++
+
+				continue
+			}
+
+			if j < 0 {
+				return nil, fmt.Errorf("missing ] at offset %d in %q", i, s)
+			}
+
+			if j == 1 {
+				p = append(p, Index(-1))
+			} else if n, err := strconv.Atoi(s[i+1 : i+j]); err != nil || n < 0 {
+				return nil, fmt.Errorf("bad index %q in %q", s[i+1:i+j], s)
+			} else {
+				p = append(p, Index(n))
+			}
+
+			i += j + 1
+		case i == 0 && c != '.':
+			j, err := bareKey(s, 0)
+			if err != nil {
+				return nil, err
+			}
+
+			p = append(p, Key(s[:j]))
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q at offset %d in %q", c, i, s)
+		}
+	}
+
+	return p, nil
+}
+
+// bareKey returns the end of the unquoted key starting at offset i of s.
+func bareKey(s string, i int) (int, error) {
+	j := i
+
+	for j < len(s) && s[j] != '.' && s[j] != '[' {
+		if s[j] == ']' || s[j] == '"' {
+			return 0, fmt.Errorf("unexpected %q at offset %d in %q", s[j], j, s)
+		}
+
+		j++
+	}
+
+	if j == i {
+		return 0, fmt.Errorf("empty key at offset %d in %q", i, s)
+	}
+
+	return j, nil
+}
+
+// unquote reads a quoted string at the start of s and returns it unquoted
+// with the length of its quoted form.
+func unquote(s string) (string, int, error) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			u, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", 0, fmt.Errorf("bad quoted key")
+			}
+
+			return u, i + 1, nil
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated quoted key")
+}
+
+// WalkFunc is called by Walk for every value in a document. For objects and
+// arrays, tok is the opening json.Delim; otherwise it is the scalar as
+// returned by json.Decoder.Token with numbers as json.Number. The path is
+// reused between calls and must be copied to be kept.
+//
+// Returning SkipValue for an object or an array skips its content.
+type WalkFunc func(p Path, tok json.Token) error
+
+// SkipValue is returned by a WalkFunc to skip the content of an object or an
+// array.
+var SkipValue = fmt.Errorf("skip this value")
+
+// Walk reads a JSON document from r token by token and calls fn for every
+// value.
+func Walk(r io.Reader, fn WalkFunc) error {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+
+	if err := walk(d, nil, fn); err != nil {
+		return err
+	}
+
+	if _, err := d.Token(); err != io.EOF {
+		return fmt.Errorf("trailing data after JSON document")
+	}
+
+	return nil
+}
+
+// walk reads the value at path p.
+func walk(d *json.Decoder, p Path, fn WalkFunc) error {
+	t, err := d.Token()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(p, t); err == SkipValue {
+		return skip(d, t)
+	} else if err != nil {
+		return err
+	}
+
+	switch t {
+	case json.Delim('{'):
+		for d.More() {
+			k, err := d.Token()
+			if err != nil {
+				return err
+			}
+
+			if err := walk(d, append(p, Key(k.(string))), fn); err != nil {
+				return err
+			}
+		}
+
+		_, err = d.Token()
+	case json.Delim('['):
+		for i := 0; d.More(); i++ {
+			if err := walk(d, append(p, Index(i)), fn); err != nil {
+				return err
+			}
+		}
+
+		_, err = d.Token()
+	}
+
+	return err
+}
+
+// skip reads the content of the object or array opened by t.
+func skip(d *json.Decoder, t json.Token) error {
+	if _, isDelim := t.(json.Delim); !isDelim {
+		return nil
+	}
+
+	for depth := 1; depth > 0; {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+
+	return nil
+}
+
+// Fields returns the sorted patterns of every path in a document.
+func Fields(r io.Reader) ([]string, error) {
+	set := make(map[string]bool)
+
+	err := Walk(r, func(p Path, _ json.Token) error {
+		if len(p) > 0 {
+			set[p.Pattern()] = true
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	fs := make([]string, 0, len(set))
+
+	for f := range set {
+		fs = append(fs, f)
+	}
+
+	sort.Strings(fs)
+
+	return fs, nil
+}
+
+// Duplicates returns the paths of keys repeated within an object, once for
+// every repetition. Decoders keep only one of the values of such a key, so the
+// document changes when it is decoded and encoded again.
+func Duplicates(r io.Reader) ([]Path, error) {
+	var ps []Path
+
+	seen := make(map[string]bool)
+
+	err := Walk(r, func(p Path, _ json.Token) error {
+		if len(p) == 0 || p[len(p)-1].IsIndex {
+			return nil
+		}
+
+		s := p.String()
+
+		if seen[s] {
+			ps = append(ps, p.Copy())
+
+			// The paths inside the repeated value would repeat too.
+			return SkipValue
+		}
+
+		seen[s] = true
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ps, nil
+}
+
+// Equal reports whether two paths are equal.
+func (p Path) Equal(q Path) bool {
+	if len(p) != len(q) {
+		return false
+	}
+
+	for i := range p {
+		if p[i] != q[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// errFound stops Lookup once the path is found.
+var errFound = fmt.Errorf("found")
+
+// Lookup reads a document until path p and returns the token of its value,
+// which is the opening json.Delim for objects and arrays. ok is false when the
+// path is not in the document.
+func Lookup(r io.Reader, p Path) (tok json.Token, ok bool, err error) {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+
+	return find(d, p)
+}
+
+// find reads from d until path p and returns the token of its value. When the
+// value is an object or an array, d is left after its opening delimiter.
+func find(d *json.Decoder, p Path) (tok json.Token, ok bool, err error) {
+	err = walk(d, nil, func(q Path, t json.Token) error {
+		if q.Equal(p) {
+			tok, ok = t, true
+			return errFound
+		}
+
+		// Skip values that cannot contain the path.
+		if _, isDelim := t.(json.Delim); isDelim && (len(q) > len(p) || !q.Equal(p[:len(q)])) {
+			return SkipValue
+		}
+
+		return nil
+	})
+
+	if err == errFound {
+		err = nil
+	}
+
+	return tok, ok, err
+}
+
+// Extract reads a document until path p and writes its value to w as compact
+// JSON. Extract stops reading after the value. ok is false when the path is
+// not in the document.
+func Extract(r io.Reader, p Path, w io.Writer) (ok bool, err error) {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+
+	tok, ok, err := find(d, p)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	bw := bufio.NewWriter(w)
+
+	if err := writeToken(bw, tok); err != nil {
+		return true, err
+	}
+
+	if delim, isDelim := tok.(json.Delim); isDelim {
+		if err := copyValue(d, bw, delim); err != nil {
+			return true, err
+		}
+	}
+
+	return true, bw.Flush()
+}
+
+// copyValue writes the rest of an object or array opened by delim.
+func copyValue(d *json.Decoder, w *bufio.Writer, delim json.Delim) error {
+	for i := 0; ; i++ {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		if c, isDelim := t.(json.Delim); isDelim && (c == '}' || c == ']') {
+			return w.WriteByte(byte(c))
+		}
+
+		switch {
+		case delim == '{' && i%2 == 1:
+			w.WriteByte(':')
+		case i > 0:
+			w.WriteByte(',')
+		}
+
+		if err := writeToken(w, t); err != nil {
+			return err
+		}
+
+		if c, isDelim := t.(json.Delim); isDelim {
+			if err := copyValue(d, w, c); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeToken writes a token as returned by json.Decoder.Token.
+func writeToken(w *bufio.Writer, t json.Token) error {
+	switch v := t.(type) {
+	case json.Delim:
+		return w.WriteByte(byte(v))
+	case json.Number:
+		_, err := w.WriteString(string(v))
+		return err
+	case nil:
+		_, err := w.WriteString("null")
+		return err
+	}
+
+	b := new(bytes.Buffer)
+
+	e := json.NewEncoder(b)
+	e.SetEscapeHTML(false)
+
+	if err := e.Encode(t); err != nil {
+		return err
+	}
+
+	_, err := w.Write(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+
+	return err
+}
@@ -0,0 +1,245 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jsonpath_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+)
+
+const doc = `{
+	"name": "team",
+	"drivers": [
+		{"name": "a", "age": 30},
+		{"name": "b", "top speed": 1.5}
+	]
+}`
+
+func TestParseString(t *testing.T) {
+	for _, s := range []string{
+		"name",
+		"drivers[1].name",
+		`drivers[1]["top speed"]`,
+		"drivers[].age",
+		`["a.b"][0]`,
+	} {
+		p, err := jsonpath.Parse(s)
+
+		if assert.NoError(t, err, "Parse should accept %s.", s) {
+			assert.Equal(t, s, p.String(), "String should reverse Parse.")
+		}
+	}
+
+	for _, s := range []string{"a..b", "a[x]", "a[1", `a["b`, "a]"} {
+		_, err := jsonpath.Parse(s)
+
+		assert.Error(t, err, "Parse should reject %s.", s)
+	}
+}
+
+func TestFields(t *testing.T) {
+	fs, err := jsonpath.Fields(strings.NewReader(doc))
+
+	if assert.NoError(t, err) {
+		assert.Equal(
+			t,
+			[]string{
+				"drivers",
+				"drivers[]",
+				"drivers[].age",
+				"drivers[].name",
+				`drivers[]["top speed"]`,
+				"name",
+			},
+			fs,
+		)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	ps, err := jsonpath.Duplicates(strings.NewReader(doc))
+
+	if assert.NoError(t, err) {
+		assert.Empty(t, ps)
+	}
+
+	ps, err = jsonpath.Duplicates(strings.NewReader(
+		`{"a":{"x":1},"b":[{"x":1},{"x":2,"x":3}],"a":{"x":2},"a":0}`,
+	))
+
+	if assert.NoError(t, err) {
+		var ss []string
+
+		for _, p := range ps {
+			ss = append(ss, p.String())
+		}
+
+		assert.Equal(t, []string{"b[1].x", "a", "a"}, ss)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	p, _ := jsonpath.Parse("drivers[1].name")
+
+	tok, ok, err := jsonpath.Lookup(strings.NewReader(doc), p)
+
+	if assert.NoError(t, err) && assert.True(t, ok) {
+		assert.Equal(t, "b", tok)
+	}
+
+	p, _ = jsonpath.Parse("drivers[0].age")
+
+	tok, _, _ = jsonpath.Lookup(strings.NewReader(doc), p)
+
+	assert.Equal(t, json.Number("30"), tok, "Numbers should keep their text.")
+
+	p, _ = jsonpath.Parse("drivers[2]")
+
+	_, ok, err = jsonpath.Lookup(strings.NewReader(doc), p)
+
+	assert.NoError(t, err)
+	assert.False(t, ok, "Missing paths should not be found.")
+}
+
+func TestWalkTrailing(t *testing.T) {
+	err := jsonpath.Walk(
+		strings.NewReader(`{} {}`),
+		func(jsonpath.Path, json.Token) error { return nil },
+	)
+
+	assert.Error(t, err, "Walk should reject trailing data.")
+}
+
+func TestExtract(t *testing.T) {
+	for _, c := range []struct {
+		path, want string
+	}{
+		{"drivers[1]", `{"name":"b","top speed":1.5}`},
+		{"drivers", `[{"name":"a","age":30},{"name":"b","top speed":1.5}]`},
+		{"name", `"team"`},
+		{"drivers[0].age", `30`},
+	} {
+		p, _ := jsonpath.Parse(c.path)
+
+		b := new(strings.Builder)
+
+		ok, err := jsonpath.Extract(strings.NewReader(doc), p, b)
+
+		if assert.NoError(t, err) && assert.True(t, ok, "%s should be found.", c.path) {
+			assert.Equal(t, c.want, b.String(), "Extracting %s.", c.path)
+		}
+	}
+
+	p, _ := jsonpath.Parse("drivers[0].team")
+
+	ok, err := jsonpath.Extract(strings.NewReader(doc), p, new(strings.Builder))
+
+	assert.NoError(t, err)
+	assert.False(t, ok, "Missing paths should not be found.")
+}
+
+func TestExtractStopsEarly(t *testing.T) {
+	p, _ := jsonpath.Parse("a")
+
+	b := new(strings.Builder)
+
+	ok, err := jsonpath.Extract(strings.NewReader(`{"a":"<&>","b":`), p, b)
+
+	if assert.NoError(t, err, "Extract should not read past the value.") && assert.True(t, ok) {
+		assert.Equal(t, `"<&>"`, b.String())
+	}
+}
+
+func TestExtractNumbers(t *testing.T) {
+	in := `{"ids":[9007199254740993,0.1000000000000000055511151231257827,1e400]}`
+
+	p, _ := jsonpath.Parse("ids")
+
+	b := new(strings.Builder)
+
+	if _, err := jsonpath.Extract(strings.NewReader(in), p, b); assert.NoError(t, err) {
+		assert.Equal(
+			t, `[9007199254740993,0.1000000000000000055511151231257827,1e400]`,
+			b.String(), "Extract should keep the text of numbers.",
+		)
+	}
+}
+
+func TestSplice(t *testing.T) {
+	in := "{\n  \"name\": \"team\",\n  \"drivers\": [ {\"name\": \"a\\\"]\", \"age\": 30},\n" +
+		"    {\"name\": \"b\", \"top speed\": 1.5} ]\n}\n"
+
+	for _, c := range []struct {
+		path, value, want string
+	}{
+		{"drivers[1].name", `"c"`, strings.Replace(in, `"b"`, `"c"`, 1)},
+		{"drivers[0].age", `31`, strings.Replace(in, `30`, `31`, 1)},
+		{`drivers[1]["top speed"]`, `2`, strings.Replace(in, `1.5`, `2`, 1)},
+		{"drivers[0]", `{}`, strings.Replace(in, "{\"name\": \"a\\\"]\", \"age\": 30}", `{}`, 1)},
+	} {
+		p, _ := jsonpath.Parse(c.path)
+
+		out, err := jsonpath.Splice([]byte(in), p, []byte(c.value))
+
+		if assert.NoError(t, err, "Splicing %s.", c.path) {
+			assert.Equal(
+				t, c.want, string(out),
+				"Splice should only change the bytes of %s.", c.path,
+			)
+		}
+	}
+
+	p, _ := jsonpath.Parse("drivers[2]")
+
+	_, err := jsonpath.Splice([]byte(in), p, []byte(`1`))
+
+	assert.Error(t, err, "Splice should fail on missing paths.")
+
+	p, _ = jsonpath.Parse("name")
+
+	_, err = jsonpath.Splice([]byte(in), p, []byte(`{`))
+
+	assert.Error(t, err, "Splice should reject invalid values.")
+}
+
+func TestReplaceStrings(t *testing.T) {
+	in := `{"Predator": "Predator", "teams": [ "Predator Racing", "x\"Predator" ],` + "\n" +
+		`"n": 1 }`
+
+	out, n, err := jsonpath.ReplaceStrings([]byte(in), func(s string) (string, bool) {
+		if !strings.Contains(s, "Predator") {
+			return "", false
+		}
+
+		return strings.Replace(s, "Predator", "<Mine>", -1), true
+	})
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, n)
+		assert.Equal(
+			t,
+			`{"Predator": "<Mine>", "teams": [ "<Mine> Racing", "x\"<Mine>" ],`+"\n"+`"n": 1 }`,
+			string(out),
+			"Keys and unmatched bytes should be kept.",
+		)
+	}
+}
@@ -0,0 +1,339 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package jsonpath
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Locate returns the byte range of the value at path p in a JSON document.
+// ok is false when the path is not in the document. Locate only scans the
+// values on the way to the path and does not validate the rest of b.
+func Locate(b []byte, p Path) (start, end int, ok bool, err error) {
+	s := &scanner{b: b}
+
+	s.space()
+
+	return s.find(p)
+}
+
+// Splice returns a copy of a JSON document with the value at path p replaced
+// by the JSON value v. The bytes outside the value are kept unchanged.
+func Splice(b []byte, p Path, v []byte) ([]byte, error) {
+	if !json.Valid(v) {
+		return nil, fmt.Errorf("invalid JSON value %q", v)
+	}
+
+	start, end, ok, err := Locate(b, p)
+
+	switch {
+	case err != nil:
+		return nil, err
+	case !ok:
+		return nil, fmt.Errorf("%s is not in the document", p)
+	}
+
+	out := make([]byte, 0, len(b)-(end-start)+len(v))
+
+	out = append(out, b[:start]...)
+	out = append(out, v...)
+	out = append(out, b[end:]...)
+
+	return out, nil
+}
+
+// ReplaceStrings returns a copy of a JSON document with string values
+// replaced. fn is called with every string value, but not with object keys,
+// and returns the replacement and whether to replace the value. The bytes of
+// values left alone are kept unchanged. ReplaceStrings also returns the number
+// of values replaced.
+func ReplaceStrings(b []byte, fn func(string) (string, bool)) ([]byte, int, error) {
+	s := &scanner{b: b}
+
+	var (
+		out  []byte
+		last int
+		n    int
+	)
+
+	for s.i < len(s.b) {
+		if s.b[s.i] != '"' {
+			s.i++
+			continue
+		}
+
+		start := s.i
+
+		if err := s.skipString(); err != nil {
+			return nil, 0, err
+		}
+
+		end := s.i
+
+		// A string followed by a colon is a key.
+		if s.next(':') {
+			continue
+		}
+
+		var v string
+
+		if err := json.Unmarshal(b[start:end], &v); err != nil {
+			return nil, 0, err
+		}
+
+		r, ok := fn(v)
+		if !ok {
+			continue
+		}
+
+		q, err := marshalString(r)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		out = append(append(out, b[last:start]...), q...)
+		last = end
+		n++
+	}
+
+	if n == 0 {
+		return b, 0, nil
+	}
+
+	return append(out, b[last:]...), n, nil
+}
+
+// marshalString encodes a string without escaping HTML characters.
+func marshalString(s string) ([]byte, error) {
+	b := new(bytes.Buffer)
+
+	e := json.NewEncoder(b)
+	e.SetEscapeHTML(false)
+
+	if err := e.Encode(s); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+// scanner scans the bytes of a JSON document.
+type scanner struct {
+	b []byte
+	i int
+}
+
+// errSyntax returns a syntax error at the current offset.
+func (s *scanner) errSyntax(want string) error {
+	if s.i >= len(s.b) {
+		return fmt.Errorf("unexpected end of document, expecting %s", want)
+	}
+
+	return fmt.Errorf("unexpected %q at offset %d, expecting %s", s.b[s.i], s.i, want)
+}
+
+// space skips white space.
+func (s *scanner) space() {
+	for s.i < len(s.b) {
+		switch s.b[s.i] {
+		case ' ', '\t', '\r', '\n':
+			s.i++
+		default:
+			return
+		}
+	}
+}
+
+// next skips white space and reports whether the next byte is c, consuming
+// it if so.
+func (s *scanner) next(c byte) bool {
+	s.space()
+
+	if s.i < len(s.b) && s.b[s.i] == c {
+		s.i++
+		return true
+	}
+
+	return false
+}
+
+// find finds the value at path p starting at the current value.
+func (s *scanner) find(p Path) (int, int, bool, error) {
+	if len(p) == 0 {
+		start := s.i
+
+		if err := s.skip(); err != nil {
+			return 0, 0, false, err
+		}
+
+		return start, s.i, true, nil
+	}
+
+	if s.i >= len(s.b) {
+		return 0, 0, false, s.errSyntax("a value")
+	}
+
+	switch c := s.b[s.i]; {
+	case c == '{' && !p[0].IsIndex:
+		s.i++
+
+		if s.next('}') {
+			return 0, 0, false, nil
+		}
+
+		for {
+			s.space()
+
+			start := s.i
+
+			if err := s.skipString(); err != nil {
+				return 0, 0, false, err
+			}
+
+			var k string
+
+			if err := json.Unmarshal(s.b[start:s.i], &k); err != nil {
+				return 0, 0, false, err
+			}
+
+			if !s.next(':') {
+				return 0, 0, false, s.errSyntax("':'")
+			}
+
+			s.space()
+
+			if k == p[0].Key {
+				return s.find(p[1:])
+			}
+
+			if err := s.skip(); err != nil {
+				return 0, 0, false, err
+			}
+
+			if s.next('}') {
+				return 0, 0, false, nil
+			}
+
+			if !s.next(',') {
+				return 0, 0, false, s.errSyntax("',' or '}'")
+			}
+		}
+	case c == '[' && p[0].IsIndex:
+		s.i++
+
+		if s.next(']') {
+			return 0, 0, false, nil
+		}
+
+		for n := 0; ; n++ {
+			s.space()
+
+			if n == p[0].Index {
+				return s.find(p[1:])
+			}
+
+			if err := s.skip(); err != nil {
+				return 0, 0, false, err
+			}
+
+			if s.next(']') {
+				return 0, 0, false, nil
+			}
+
+			if !s.next(',') {
+				return 0, 0, false, s.errSyntax("',' or ']'")
+			}
+		}
+	}
+
+	return 0, 0, false, nil
+}
+
+// skip skips the value at the current offset.
+func (s *scanner) skip() error {
+	if s.i >= len(s.b) {
+		return s.errSyntax("a value")
+	}
+
+	switch s.b[s.i] {
+	case '"':
+		return s.skipString()
+	case '{', '[':
+		for depth := 0; ; {
+			if s.i >= len(s.b) {
+				return s.errSyntax("the end of the value")
+			}
+
+			switch s.b[s.i] {
+			case '"':
+				if err := s.skipString(); err != nil {
+					return err
+				}
+
+				continue
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+
+			s.i++
+
+			if depth == 0 {
+				return nil
+			}
+		}
+	}
+
+	start := s.i
+
+	for s.i < len(s.b) {
+		switch s.b[s.i] {
+		case ' ', '\t', '\r', '\n', ',', '}', ']':
+			return nil
+		}
+
+		s.i++
+	}
+
+	if s.i == start {
+		return s.errSyntax("a value")
+	}
+
+	return nil
+}
+
+// skipString skips the string at the current offset.
+func (s *scanner) skipString() error {
+	if s.i >= len(s.b) || s.b[s.i] != '"' {
+		return s.errSyntax("a string")
+	}
+
+	for s.i++; s.i < len(s.b); s.i++ {
+		switch s.b[s.i] {
+		case '\\':
+			s.i++
+		case '"':
+			s.i++
+			return nil
+		}
+	}
+
+	return s.errSyntax("the end of the string")
+}
@@ -0,0 +1,160 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package lock seals files in a password protected container using AES-256-GCM
+// with a key derived by PBKDF2-HMAC-SHA256.
+//
+// A container is the magic "mmselock", a format version byte, the PBKDF2
+// iteration count as a 32-bit big endian integer, a 16-byte salt, a 12-byte
+// nonce, and the sealed content. The header is authenticated with the content.
+package lock
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+const (
+	// Magic starts every container.
+	Magic = "mmselock"
+	// Version is the format version written by Seal.
+	Version = 1
+	// Iterations is the PBKDF2 iteration count used by Seal.
+	Iterations = 600000
+
+	// maxIterations bounds the iteration count read from a container, which
+	// is not authenticated until the key has been derived, so that a crafted
+	// container cannot make Open run for hours.
+	maxIterations = 10 * Iterations
+
+	saltSize  = 16
+	nonceSize = 12
+	keySize   = 32
+	header    = len(Magic) + 1 + 4 + saltSize + nonceSize
+)
+
+// ErrPassword is returned by Open when the password is wrong or the
+// container was modified.
+var ErrPassword = errors.New("wrong password or damaged container")
+
+// IsLocked reports whether b is a container.
+func IsLocked(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(Magic))
+}
+
+// Seal returns a container holding content, protected by password.
+func Seal(content, password []byte) ([]byte, error) {
+	h := make([]byte, header)
+
+	copy(h, Magic)
+	h[len(Magic)] = Version
+	binary.BigEndian.PutUint32(h[len(Magic)+1:], Iterations)
+
+	if _, err := rand.Read(h[len(Magic)+5:]); err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(h, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(h, h[header-nonceSize:], content, h), nil
+}
+
+// Open returns the content of a container.
+func Open(b, password []byte) ([]byte, error) {
+	if !IsLocked(b) || len(b) < header {
+		return nil, fmt.Errorf("not a locked file")
+	}
+
+	if v := b[len(Magic)]; v != Version {
+		return nil, fmt.Errorf("unsupported lock format version %d", v)
+	}
+
+	h := b[:header]
+
+	if n := binary.BigEndian.Uint32(h[len(Magic)+1:]); n == 0 || n > maxIterations {
+		return nil, fmt.Errorf("invalid iteration count %d", n)
+	}
+
+	aead, err := newAEAD(h, password)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := aead.Open(nil, h[header-nonceSize:], b[header:], h)
+	if err != nil {
+		return nil, ErrPassword
+	}
+
+	return content, nil
+}
+
+// newAEAD returns the cipher for the salt and iteration count in header h.
+func newAEAD(h, password []byte) (cipher.AEAD, error) {
+	iter := int(binary.BigEndian.Uint32(h[len(Magic)+1:]))
+	salt := h[len(Magic)+5 : len(Magic)+5+saltSize]
+
+	block, err := aes.NewCipher(pbkdf2(password, salt, iter, keySize, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 derives a key as specified in RFC 8018.
+func pbkdf2(password, salt []byte, iter, size int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+
+	var (
+		key []byte
+		buf [4]byte
+	)
+
+	for block := uint32(1); len(key) < size; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], block)
+		prf.Write(buf[:])
+
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		key = append(key, t...)
+	}
+
+	return key[:size]
+}
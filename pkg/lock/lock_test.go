@@ -0,0 +1,84 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package lock
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// Test vector from RFC 7914, section 11.
+	k := pbkdf2([]byte("passwd"), []byte("salt"), 1, 64, sha256.New)
+
+	assert.Equal(
+		t,
+		"55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"+
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		hex.EncodeToString(k),
+	)
+}
+
+func TestSealOpen(t *testing.T) {
+	content := []byte("mm2s save content")
+
+	b, err := Seal(content, []byte("secret"))
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, IsLocked(b))
+	assert.False(t, IsLocked(content))
+
+	c, err := Open(b, []byte("secret"))
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, content, c)
+	}
+
+	_, err = Open(b, []byte("wrong"))
+
+	assert.Equal(t, ErrPassword, err, "Open should reject wrong passwords.")
+
+	b[len(b)-1] ^= 1
+
+	_, err = Open(b, []byte("secret"))
+
+	assert.Equal(t, ErrPassword, err, "Open should reject modified containers.")
+}
+
+func TestOpenIterations(t *testing.T) {
+	b, err := Seal([]byte("content"), []byte("secret"))
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, n := range []uint32{0, maxIterations + 1, 1<<32 - 1} {
+		binary.BigEndian.PutUint32(b[len(Magic)+1:], n)
+
+		_, err := Open(b, []byte("secret"))
+
+		assert.Error(t, err, "Open should refuse %d iterations without deriving a key.", n)
+		assert.NotEqual(t, ErrPassword, err)
+	}
+}
@@ -0,0 +1,304 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mmse_test
+
+import (
+	"bufio"
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// apiFile records the exported API of the packages under pkg. See the
+// compatibility policy in README.md.
+const apiFile = "../api.txt"
+
+// TestAPI checks the exported API of the packages under pkg against apiFile.
+// A declaration missing from the packages is an incompatible change; a
+// declaration missing from apiFile is an addition to record there.
+func TestAPI(t *testing.T) {
+	f, err := os.Open(apiFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	want := make(map[string]bool)
+
+	s := bufio.NewScanner(f)
+
+	for s.Scan() {
+		if l := s.Text(); l != "" && !strings.HasPrefix(l, "#") {
+			want[l] = true
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]bool)
+
+	for _, l := range exportedAPI(t, "..") {
+		got[l] = true
+
+		if !want[l] {
+			t.Errorf("not recorded in %s: %s", apiFile, l)
+		}
+	}
+
+	for l := range want {
+		if !got[l] {
+			t.Errorf("removed or changed: %s", l)
+		}
+	}
+}
+
+// exportedAPI returns a line for every exported declaration of the packages
+// under root, in the format of apiFile.
+func exportedAPI(t *testing.T, root string) []string {
+	var ls []string
+
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return err
+		}
+
+		if fi.Name() == "testdata" {
+			return filepath.SkipDir
+		}
+
+		fset := token.NewFileSet()
+
+		pkgs, err := parser.ParseDir(fset, p, func(fi os.FileInfo) bool {
+			return !strings.HasSuffix(fi.Name(), "_test.go")
+		}, 0)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		for _, pkg := range pkgs {
+			for _, f := range pkg.Files {
+				for _, l := range declLines(fset, f) {
+					ls = append(ls, "pkg "+filepath.ToSlash(rel)+", "+l)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(ls)
+
+	return ls
+}
+
+// declLines returns the lines of the exported declarations of a file.
+func declLines(fset *token.FileSet, f *ast.File) []string {
+	var ls []string
+
+	for _, d := range f.Decls {
+		switch d := d.(type) {
+		case *ast.FuncDecl:
+			if !d.Name.IsExported() {
+				continue
+			}
+
+			if d.Recv == nil {
+				ls = append(ls, "func "+d.Name.Name+signature(fset, d.Type))
+				continue
+			}
+
+			recv := exprString(fset, d.Recv.List[0].Type)
+
+			if ast.IsExported(strings.TrimPrefix(recv, "*")) {
+				ls = append(ls, "method ("+recv+") "+d.Name.Name+signature(fset, d.Type))
+			}
+		case *ast.GenDecl:
+			for _, s := range d.Specs {
+				switch s := s.(type) {
+				case *ast.TypeSpec:
+					if s.Name.IsExported() {
+						ls = append(ls, typeLines(fset, s)...)
+					}
+				case *ast.ValueSpec:
+					for _, n := range s.Names {
+						if !n.IsExported() {
+							continue
+						}
+
+						l := d.Tok.String() + " " + n.Name
+
+						if s.Type != nil {
+							l += " " + exprString(fset, s.Type)
+						}
+
+						ls = append(ls, l)
+					}
+				}
+			}
+		}
+	}
+
+	return ls
+}
+
+// typeLines returns the lines of an exported type: one for the type and one
+// for every exported field or method of a struct or interface.
+func typeLines(fset *token.FileSet, s *ast.TypeSpec) []string {
+	name := "type " + s.Name.Name
+
+	var fs *ast.FieldList
+
+	switch t := s.Type.(type) {
+	case *ast.StructType:
+		name += " struct"
+		fs = t.Fields
+	case *ast.InterfaceType:
+		name += " interface"
+		fs = t.Methods
+	default:
+		if s.Assign.IsValid() {
+			return []string{name + " = " + exprString(fset, s.Type)}
+		}
+
+		return []string{name + " " + exprString(fset, s.Type)}
+	}
+
+	ls := []string{name}
+
+	for _, f := range fs.List {
+		typ := exprString(fset, f.Type)
+
+		if len(f.Names) == 0 {
+			switch f.Type.(type) {
+			case *ast.BinaryExpr, *ast.UnaryExpr:
+				// A union or an approximation element of a constraint.
+				ls = append(ls, name+", "+typ)
+			default:
+				if ast.IsExported(typ[strings.LastIndex(typ, ".")+1:]) {
+					ls = append(ls, name+", embedded "+typ)
+				}
+			}
+
+			continue
+		}
+
+		for _, n := range f.Names {
+			if !n.IsExported() {
+				continue
+			}
+
+			if ft, ok := f.Type.(*ast.FuncType); ok && fs == s.Type.(*ast.InterfaceType).Methods {
+				ls = append(ls, name+", "+n.Name+signature(fset, ft))
+			} else {
+				ls = append(ls, name+", "+n.Name+" "+typ)
+			}
+		}
+	}
+
+	return ls
+}
+
+// signature returns the type parameters, and the parameter and result types
+// of a function. Type parameters keep their names, which the other types
+// refer to.
+func signature(fset *token.FileSet, t *ast.FuncType) string {
+	s := ""
+
+	if t.TypeParams != nil {
+		var ps []string
+
+		for _, f := range t.TypeParams.List {
+			for _, n := range f.Names {
+				ps = append(ps, n.Name+" "+exprString(fset, f.Type))
+			}
+		}
+
+		s = "[" + strings.Join(ps, ", ") + "]"
+	}
+
+	s += "(" + strings.Join(fieldTypes(fset, t.Params), ", ") + ")"
+
+	rs := fieldTypes(fset, t.Results)
+
+	switch len(rs) {
+	case 0:
+		return s
+	case 1:
+		return s + " " + rs[0]
+	default:
+		return s + " (" + strings.Join(rs, ", ") + ")"
+	}
+}
+
+// fieldTypes returns the types of the fields of a list, once for each name,
+// leaving out the names.
+func fieldTypes(fset *token.FileSet, fs *ast.FieldList) []string {
+	if fs == nil {
+		return nil
+	}
+
+	var ts []string
+
+	for _, f := range fs.List {
+		typ := exprString(fset, f.Type)
+
+		n := len(f.Names)
+		if n == 0 {
+			n = 1
+		}
+
+		for i := 0; i < n; i++ {
+			ts = append(ts, typ)
+		}
+	}
+
+	return ts
+}
+
+// exprString returns the source of a type, with function types written
+// without parameter names.
+func exprString(fset *token.FileSet, e ast.Expr) string {
+	if ft, ok := e.(*ast.FuncType); ok {
+		return "func" + signature(fset, ft)
+	}
+
+	b := new(bytes.Buffer)
+
+	if err := printer.Fprint(b, fset, e); err != nil {
+		panic(err)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,117 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mmse_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+	"github.com/mys721tx/mmse-go/pkg/mmse/mmsetest"
+)
+
+// sizes are the numbers of drivers in the synthetic data documents.
+var sizes = []struct {
+	name    string
+	records int
+}{
+	{"small", 100},
+	{"medium", 10000},
+	{"large", 200000},
+}
+
+// synth returns the options of a synthetic save with n drivers. The content
+// is deterministic so that runs are comparable.
+func synth(n int) mmsetest.Options {
+	return mmsetest.Options{Drivers: n, Seed: 1}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, s := range sizes {
+		doc := mmsetest.Data(synth(s.records))
+
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(len(doc)))
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				mmse.ReadToFrame(bytes.NewReader(doc), 0)
+			}
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, s := range sizes {
+		f := mmse.ReadToFrame(bytes.NewReader(mmsetest.Data(synth(s.records))), 0)
+
+		sz := new(bytes.Buffer)
+		mmse.WriteSize(sz, f)
+
+		size, enc := sz.Bytes(), f.Bytes()
+
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(f.SizeRaw))
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				d := mmse.ReadSizeToFrame(bytes.NewReader(size))
+				mmse.ReadFrame(bytes.NewReader(enc), d)
+			}
+		})
+	}
+}
+
+func BenchmarkPack(b *testing.B) {
+	for _, s := range sizes {
+		info, data := mmsetest.Info(synth(s.records)), mmsetest.Data(synth(s.records))
+
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(len(info) + len(data)))
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				fi := mmse.ReadToFrame(bytes.NewReader(info), 0)
+				fd := mmse.ReadToFrame(bytes.NewReader(data), 0)
+
+				mmse.WriteHeader(io.Discard)
+				mmse.WriteSize(io.Discard, fi)
+				mmse.WriteSize(io.Discard, fd)
+				mmse.WriteFrame(io.Discard, fi)
+				mmse.WriteFrame(io.Discard, fd)
+			}
+		})
+	}
+}
+
+func BenchmarkUnpack(b *testing.B) {
+	for _, s := range sizes {
+		save := mmsetest.Generate(synth(s.records))
+
+		b.Run(s.name, func(b *testing.B) {
+			b.SetBytes(int64(len(save)))
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if _, err := mmse.ReadSaveFile(bytes.NewReader(save)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
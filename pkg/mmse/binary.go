@@ -0,0 +1,94 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mmse
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Integer is the set of fixed size integer types, the types of the fields of
+// a save file.
+type Integer interface {
+	~int8 | ~int16 | ~int32 | ~int64 | ~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+// sizeOf returns the size of an integer type in bytes.
+func sizeOf[T Integer]() int {
+	return binary.Size(T(0))
+}
+
+// DecodeLE returns the little endian integer at the start of b. It panics when
+// b is too short, as binary.LittleEndian does.
+func DecodeLE[T Integer](b []byte) T {
+	n := sizeOf[T]()
+	_ = b[n-1]
+
+	var v uint64
+
+	for i := n - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+
+	return T(v)
+}
+
+// PutLE writes v as a little endian integer at the start of b. It panics when
+// b is too short.
+func PutLE[T Integer](b []byte, v T) {
+	n := sizeOf[T]()
+	_ = b[n-1]
+
+	u := uint64(v)
+
+	for i := 0; i < n; i++ {
+		b[i] = byte(u)
+		u >>= 8
+	}
+}
+
+// ReadLE reads a little endian integer from a file.
+func ReadLE[T Integer](r io.Reader) (T, error) {
+	b := make([]byte, sizeOf[T]())
+
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, err
+	}
+
+	return DecodeLE[T](b), nil
+}
+
+// WriteLE writes a little endian integer to a file.
+func WriteLE[T Integer](w io.Writer, v T) error {
+	b := make([]byte, sizeOf[T]())
+
+	PutLE(b, v)
+
+	_, err := w.Write(b)
+
+	return err
+}
+
+// ReadInt32 reads an int32 from a file.
+func ReadInt32(r io.Reader) (int32, error) {
+	return ReadLE[int32](r)
+}
+
+// WriteInt32 writes an int32 to a file.
+func WriteInt32(w io.Writer, v int32) error {
+	return WriteLE(w, v)
+}
@@ -0,0 +1,127 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mmse
+
+// Carved is a frame found by Scan.
+type Carved struct {
+	// Offset is the offset of the frame in the scanned bytes.
+	Offset int64
+	// Length is the number of encoded bytes of the frame.
+	Length int64
+	// Doc is the decoded content, which may be a truncated document.
+	Doc []byte
+	// Err is the error that stopped decoding, or nil when the frame holds a
+	// complete document.
+	Err error
+}
+
+// Scan searches b for frames without trusting the header or the size fields.
+// A frame is an lz4 block whose first literals start with {" and which
+// decodes to a JSON object. Blocks that decode to less than a complete
+// object are kept only when decoding runs to the end of b, as a truncated
+// final frame does.
+func Scan(b []byte) []Carved {
+	var cs []Carved
+
+	for o := 0; o < len(b); o++ {
+		if !startsObject(b[o:]) {
+			continue
+		}
+
+		e := new(jsonEnd)
+
+		doc, n, err := decodeBlock(b[o:], 0, e.done)
+
+		switch {
+		case e.end:
+			err = nil
+		case n < len(b)-o:
+			continue
+		}
+
+		cs = append(cs, Carved{
+			Offset: int64(o),
+			Length: int64(n),
+			Doc:    doc,
+			Err:    err,
+		})
+
+		o += n - 1
+	}
+
+	return cs
+}
+
+// startsObject reports whether b starts with an lz4 sequence whose literals
+// start with {".
+func startsObject(b []byte) bool {
+	if len(b) < 3 {
+		return false
+	}
+
+	lit, i := int(b[0]>>4), 1
+
+	if lit == 15 {
+		for i < len(b) && b[i] == 255 {
+			lit += 255
+			i++
+		}
+
+		if i >= len(b) {
+			return false
+		}
+
+		lit += int(b[i])
+		i++
+	}
+
+	return lit >= 2 && i+1 < len(b) && b[i] == '{' && b[i+1] == '"'
+}
+
+// jsonEnd finds the end of a JSON object as its bytes are decoded.
+type jsonEnd struct {
+	n     int
+	depth int
+	str   bool
+	esc   bool
+	end   bool
+}
+
+// done reads the bytes of dst not yet seen and reports whether the object
+// is complete.
+func (e *jsonEnd) done(dst []byte) bool {
+	for ; e.n < len(dst) && !e.end; e.n++ {
+		c := dst[e.n]
+
+		switch {
+		case e.esc:
+			e.esc = false
+		case e.str && c == '\\':
+			e.esc = true
+		case c == '"':
+			e.str = !e.str
+		case e.str:
+		case c == '{' || c == '[':
+			e.depth++
+		case c == '}' || c == ']':
+			e.depth--
+			e.end = e.depth == 0
+		}
+	}
+
+	return e.end
+}
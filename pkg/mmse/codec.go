@@ -0,0 +1,79 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mmse
+
+import (
+	"github.com/pierrec/lz4"
+)
+
+// Codec compresses and decompresses the content of frames.
+type Codec interface {
+	// Compress compresses src into dst at a compression level and returns
+	// the compressed size. dst has room for len(src) bytes, or for
+	// CompressBound(len(src)) bytes when the codec is a Bounder. Compress
+	// returns 0 when src does not fit in dst compressed.
+	Compress(dst, src []byte, level int) (int, error)
+	// Decompress decompresses src into dst, which has the size recorded for
+	// the decompressed content, and returns the decompressed size.
+	Decompress(dst, src []byte) (int, error)
+}
+
+// Bounder is implemented by codecs whose output may be larger than their
+// input, such as for incompressible data.
+type Bounder interface {
+	// CompressBound returns the largest compressed size of n bytes.
+	CompressBound(n int) int
+}
+
+// DefaultCodec is the codec of frames without one, the codec of the PC
+// edition of the game.
+var DefaultCodec Codec = LZ4Block{}
+
+// LZ4Block stores frames as single lz4 blocks without the lz4 frame format.
+//
+// Level 0 uses the fast compressor. Levels 1 to 9 use the high compression
+// compressor, searching deeper at higher levels; level 9 searches the whole
+// window.
+type LZ4Block struct{}
+
+// String returns the name of the codec.
+func (LZ4Block) String() string {
+	return "lz4 block"
+}
+
+// CompressBound implements Bounder. An lz4 block stores incompressible data as
+// literals, which takes slightly more room than the data.
+func (LZ4Block) CompressBound(n int) int {
+	return lz4.CompressBlockBound(n)
+}
+
+// Compress implements Codec.
+func (LZ4Block) Compress(dst, src []byte, level int) (int, error) {
+	switch {
+	case level <= 0:
+		return lz4.CompressBlock(src, dst, make([]int, 1<<16))
+	case level >= MaxLevel:
+		return lz4.CompressBlockHC(src, dst, 0)
+	default:
+		return lz4.CompressBlockHC(src, dst, 1<<uint(level+3))
+	}
+}
+
+// Decompress implements Codec.
+func (LZ4Block) Decompress(dst, src []byte) (int, error) {
+	return lz4.UncompressBlock(src, dst)
+}
@@ -0,0 +1,45 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mmse
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned, possibly wrapped, when reading a save file. Use errors.Is to
+// check for them.
+var (
+	// ErrBadMagic reports a file that does not start with Magic, and so is not
+	// a save file.
+	ErrBadMagic = errors.New("incorrect magic number")
+	// ErrVersionMismatch reports a version number other than Ver. ReadSaveFile
+	// only returns it when the save file is read with Strict set.
+	ErrVersionMismatch = errors.New("unknown version number")
+)
+
+// SizeMismatchError reports a frame whose content is shorter or longer than its
+// declared size, as in a truncated save file.
+type SizeMismatchError struct {
+	// Want is the declared size and Got the size found, both in bytes.
+	Want, Got int64
+}
+
+// Error returns the sizes.
+func (e *SizeMismatchError) Error() string {
+	return fmt.Sprintf("expecting %d bytes, read %d", e.Want, e.Got)
+}
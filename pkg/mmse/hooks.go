@@ -0,0 +1,112 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mmse
+
+import (
+	"fmt"
+	"io"
+)
+
+// HookFunc is called with a frame and the name of its region, as named by
+// Layout. Returning an error stops reading or writing the save file with that
+// error.
+type HookFunc func(region string, f *Frame) error
+
+// Hooks are called by SaveFile.ReadFrom and SaveFile.WriteTo around decoding
+// and encoding each frame, for example to log, time, or check the documents.
+// Nil hooks are skipped.
+//
+// BeforeDecode sees the encoded frame and AfterDecode the decoded document.
+// BeforeEncode sees the document of the save file, and may change it, and
+// AfterEncode the encoded copy that is written.
+type Hooks struct {
+	BeforeDecode HookFunc
+	AfterDecode  HookFunc
+	BeforeEncode HookFunc
+	AfterEncode  HookFunc
+}
+
+// Operations with hooks.
+const (
+	decoding = iota
+	encoding
+)
+
+// before returns the hook called before an operation.
+func (h *Hooks) before(op int) HookFunc {
+	switch {
+	case h == nil:
+		return nil
+	case op == decoding:
+		return h.BeforeDecode
+	default:
+		return h.BeforeEncode
+	}
+}
+
+// after returns the hook called after an operation.
+func (h *Hooks) after(op int) HookFunc {
+	switch {
+	case h == nil:
+		return nil
+	case op == decoding:
+		return h.AfterDecode
+	default:
+		return h.AfterEncode
+	}
+}
+
+// callHook calls a hook if it is not nil.
+func callHook(fn HookFunc, region string, f *Frame) error {
+	if fn == nil {
+		return nil
+	}
+
+	if err := fn(region, f); err != nil {
+		return fmt.Errorf("hook: %w", err)
+	}
+
+	return nil
+}
+
+// countReader counts the bytes read from a reader.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read implements io.Reader.
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
+
+// countWriter counts the bytes written to a writer.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer.
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
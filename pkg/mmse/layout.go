@@ -0,0 +1,95 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mmse
+
+// Region is a byte range of a save file.
+type Region struct {
+	Name   string
+	Offset int64
+	// Length is the number of bytes of the region present in the file.
+	Length int64
+	// Missing is the number of bytes declared by the size fields but absent
+	// from the file.
+	Missing int64
+}
+
+// Layout returns the regions of a save file: the magic number, the version
+// number, the sizes of the frames, any padding before the first frame as
+// skipped by SkipPadding, the frames, and any trailing bytes. The number of
+// frames follows the version number, as given by FrameCount.
+// Layout trusts the size fields but not the length of the file, so regions
+// cut short by truncation have Missing set. Negative sizes are taken as 0.
+func Layout(b []byte) []Region {
+	var (
+		rs  []Region
+		off int64
+	)
+
+	add := func(name string, n int64) {
+		if n < 0 {
+			n = 0
+		}
+
+		r := Region{Name: name, Offset: off, Length: n}
+
+		if rest := int64(len(b)) - off; n > rest {
+			r.Length, r.Missing = rest, n-rest
+		}
+
+		rs = append(rs, r)
+		off += r.Length
+	}
+
+	size := func(at int) int64 {
+		if len(b) < at+4 {
+			return 0
+		}
+
+		return int64(DecodeLE[int32](b[at:]))
+	}
+
+	n := FrameCount(int32(size(4)))
+
+	add("magic", 4)
+	add("version", 4)
+
+	for i := 0; i < n; i++ {
+		add(sizesRegion(i), 8)
+	}
+
+	if size(8) > 1 {
+		pad := off
+
+		for pad < int64(len(b)) && b[pad] == 0 {
+			pad++
+		}
+
+		if pad > off {
+			add("padding", pad-off)
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		add(FrameRegion(i), size(8+8*i))
+	}
+
+	if rest := int64(len(b)) - off; rest > 0 {
+		add("trailing", rest)
+	}
+
+	return rs
+}
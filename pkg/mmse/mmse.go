@@ -20,14 +20,10 @@ package mmse
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
-
-	"github.com/pierrec/lz4"
 )
 
 const (
@@ -37,14 +33,102 @@ const (
 	Ver int32 = 0x00000004
 )
 
+// FrameCounts maps version numbers to the number of frames in their saves.
+// The size table after the version number holds the sizes of every frame, in
+// the order of the frames. Saves of versions not listed are read as having two
+// frames, as saves of version 4 do.
+var FrameCounts = map[int32]int{Ver: 2}
+
+// FrameCount returns the number of frames in saves of a version, at least two.
+func FrameCount(v int32) int {
+	if n, ok := FrameCounts[v]; ok && n > 2 {
+		return n
+	}
+
+	return 2
+}
+
+// FrameRegion returns the name of frame i of a save, as used by Layout and in
+// warnings and errors: info frame, data frame, then frame 2, frame 3, and so
+// on.
+func FrameRegion(i int) string {
+	switch i {
+	case 0:
+		return "info frame"
+	case 1:
+		return "data frame"
+	default:
+		return fmt.Sprintf("frame %d", i)
+	}
+}
+
+// sizesRegion returns the name of the size fields of frame i.
+func sizesRegion(i int) string {
+	switch i {
+	case 0:
+		return "info sizes"
+	case 1:
+		return "data sizes"
+	default:
+		return fmt.Sprintf("frame %d sizes", i)
+	}
+}
+
 // Frame provides storage for lz4 by embedding bytes.Buffer.
+//
+// Codec compresses and decompresses the content; DefaultCodec is used when it
+// is nil. Level is the compression level passed to the codec by Encode.
 type Frame struct {
 	SizeRaw   int32
 	SizeCom   int32
+	Level     int
+	Codec     Codec
 	isEncoded bool
 	bytes.Buffer
 }
 
+// codec returns the codec of the frame.
+func (f *Frame) codec() Codec {
+	if f.Codec == nil {
+		return DefaultCodec
+	}
+
+	return f.Codec
+}
+
+// MaxLevel is the highest compression level.
+const MaxLevel = 9
+
+// Limits on the decoded size of frames, which guard against saves declaring
+// sizes large enough to exhaust memory. Decode refuses frames declaring a
+// decoded size beyond MaxDecodedSize bytes or beyond MaxRatio times their
+// encoded size. A limit of 0 disables the check.
+//
+// An lz4 block decodes to at most 255 bytes per encoded byte, so the default
+// ratio only refuses frames that cannot be valid.
+var (
+	MaxDecodedSize int64 = 1 << 30
+	MaxRatio       int64 = 256
+)
+
+// checkSize checks a declared decoded size against the limits.
+func checkSize(raw int32, com int) error {
+	switch {
+	case raw < 0:
+		return fmt.Errorf("negative decoded size %d", raw)
+	case MaxDecodedSize > 0 && int64(raw) > MaxDecodedSize:
+		return fmt.Errorf(
+			"decoded size %d exceeds the limit of %d bytes", raw, MaxDecodedSize,
+		)
+	case MaxRatio > 0 && int64(raw) > MaxRatio*int64(com)+MaxRatio:
+		return fmt.Errorf(
+			"decoded size %d exceeds %d times the encoded size %d", raw, MaxRatio, com,
+		)
+	}
+
+	return nil
+}
+
 // Decode decodes the frame content in place. Decode will return error when
 // isEncoded is false.
 func (f *Frame) Decode() error {
@@ -52,19 +136,20 @@ func (f *Frame) Decode() error {
 		return fmt.Errorf("Frame is not encoded")
 	}
 
+	if err := checkSize(f.SizeRaw, f.Len()); err != nil {
+		return err
+	}
+
 	b := make([]byte, f.SizeRaw)
 
-	n, err := lz4.UncompressBlock(f.Bytes(), b)
+	n, err := f.codec().Decompress(b, f.Bytes())
 
 	if err != nil {
 		return err
 	}
 
 	if int32(n) != f.SizeRaw {
-		return fmt.Errorf(
-			"expecting %d bytes, read %d",
-			f.SizeRaw, int32(n),
-		)
+		return &SizeMismatchError{Want: int64(f.SizeRaw), Got: int64(n)}
 	}
 
 	f.Reset()
@@ -83,15 +168,21 @@ func (f *Frame) Encode() error {
 		return fmt.Errorf("Frame is already encoded")
 	}
 
-	b := make([]byte, f.SizeRaw)
+	size := int(f.SizeRaw)
+
+	if c, ok := f.codec().(Bounder); ok {
+		size = c.CompressBound(size)
+	}
 
-	n, err := lz4.CompressBlock(f.Bytes(), b, make([]int, 1<<16))
+	b := make([]byte, size)
+
+	n, err := f.codec().Compress(b, f.Bytes(), f.Level)
 
 	if err != nil {
 		return err
 	}
 
-	// lz4.CompressBLock returns 0 if the data is not compressible.
+	// Compress returns 0 if the data is not compressible.
 	if n == 0 {
 		f.SizeCom = f.SizeRaw
 	} else {
@@ -109,54 +200,73 @@ func (f *Frame) Encode() error {
 	return err
 }
 
-// ReadInt32 reads an int32 from a file.
-func ReadInt32(r io.Reader) (int32, error) {
-	var v int32
+// Reader returns a reader over the content of the frame, the decoded document
+// once the frame is decoded. Unlike reading the frame itself, the reader does
+// not consume the content, and it shares the content instead of copying it.
+// The reader is invalid once the frame is modified.
+func (f *Frame) Reader() io.ReadSeeker {
+	return bytes.NewReader(f.Bytes())
+}
 
-	if err := binary.Read(r, binary.LittleEndian, &v); err != nil {
-		return 0, err
+// ReadSizeToFrame reads the sizes of lz4 blocks from a file and returns a
+// frame. It panics where ReadSaveFile returns an error.
+func ReadSizeToFrame(r io.Reader) *Frame {
+	f, err := readSize(r)
+	if err != nil {
+		log.Panicf("Unable to read frame sizes: %s", err)
 	}
 
-	return v, nil
+	return f
 }
 
-// WriteInt32 writes an int32 from a file.
-func WriteInt32(w io.Writer, v int32) error {
-	err := binary.Write(w, binary.LittleEndian, v)
+// SkipPadding skips the NUL bytes that some builds of the game write between
+// the size table and the first frame, and returns their number. size is the
+// encoded size of the first frame. An lz4 block longer than one byte never
+// starts with a NUL byte, so the padding is told apart from the frame; before
+// smaller frames, nothing is skipped.
+func SkipPadding(r io.ByteScanner, size int32) (int, error) {
+	if size <= 1 {
+		return 0, nil
+	}
 
-	return err
-}
+	n := 0
 
-// ReadSizeToFrame reads the sizes of lz4 blocks from a file and returns a
-// frame.
-func ReadSizeToFrame(r io.Reader) *Frame {
-	f := new(Frame)
+	for {
+		b, err := r.ReadByte()
 
-	if enc, err := ReadInt32(r); err != nil {
-		log.Panicf("Unable to read encoded size: %s", err)
-	} else {
-		f.SizeCom = enc
-	}
+		switch {
+		case err == io.EOF:
+			return n, nil
+		case err != nil:
+			return n, err
+		case b != 0:
+			return n, r.UnreadByte()
+		}
 
-	if unc, err := ReadInt32(r); err != nil {
-		log.Panicf("Unable to read unencoded size: %s", err)
-	} else {
-		f.SizeRaw = unc
+		n++
 	}
-
-	f.isEncoded = true
-
-	return f
 }
 
 // ReadJSONToFrame reads from a file into a Frame, compresses it, and sets the
 // sizes.
 func ReadJSONToFrame(fn string) *Frame {
-	f := new(Frame)
+	r, err := os.Open(fn)
 
-	if r, err := os.Open(fn); err != nil {
+	if err != nil {
 		log.Panicf("Unable to open json file: %s", err)
-	} else if n, err := io.Copy(f, r); err != nil {
+	}
+
+	defer r.Close()
+
+	return ReadToFrame(r, 0)
+}
+
+// ReadToFrame reads JSON from an io.Reader into a Frame, compresses it at a
+// compression level, and sets the sizes.
+func ReadToFrame(r io.Reader, level int) *Frame {
+	f := &Frame{Level: level}
+
+	if n, err := io.Copy(f, r); err != nil {
 		log.Panicf("Unable to read json file: %s", err)
 	} else {
 		f.SizeRaw = int32(n)
@@ -186,18 +296,20 @@ func CheckHeader(r io.Reader) {
 	}
 }
 
+// ReadFrame reads the encoded content of a Frame from a file and decodes it.
+// It panics where ReadSaveFile returns an error.
+func ReadFrame(r io.Reader, f *Frame) {
+	if err := new(SaveFile).readFrame(r, "frame", f, true); err != nil {
+		log.Panicf("Unable to read frame: %s", err)
+	}
+}
+
 // WriteJSON reads a file to a Frame, decodes it, and writes the decoded
 // Frame to a file.
 func WriteJSON(fn string, r io.Reader, f *Frame) {
-	if _, err := io.CopyN(f, r, int64(f.SizeCom)); err != nil {
-		log.Panicf("Unable to read file: %s", err)
-	}
-
-	if err := f.Decode(); err != nil {
-		log.Panicf("Unable to decode: %s", err)
-	}
+	ReadFrame(r, f)
 
-	if err := ioutil.WriteFile(fn, f.Bytes(), 0644); err != nil {
+	if err := os.WriteFile(fn, f.Bytes(), 0644); err != nil {
 		log.Panicf("Unable to write file: %s", err)
 	}
 }
@@ -19,14 +19,20 @@ package mmse_test
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
+	"math/rand"
 	"os"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 
 	"github.com/mys721tx/mmse-go/pkg/mmse"
+	"github.com/mys721tx/mmse-go/pkg/mmse/mmsetest"
 )
 
 // MockReader is an autogenerated mock type for the Reader type
@@ -155,6 +161,73 @@ func TestWriteInt32WriterError(t *testing.T) {
 	}
 }
 
+func TestReadLE(t *testing.T) {
+
+	b := []byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x80}
+
+	v8, err := mmse.ReadLE[int8](bytes.NewReader(b))
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, v8, int8(-2), "ReadLE should read a little endian int8.")
+	}
+
+	v16, err := mmse.ReadLE[uint16](bytes.NewReader(b))
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, v16, uint16(0xfffe), "ReadLE should read a little endian uint16.")
+	}
+
+	v64, err := mmse.ReadLE[int64](bytes.NewReader(b))
+
+	if assert.NoError(t, err) {
+		assert.Equal(
+			t, v64, int64(-0x7f00000000000002),
+			"ReadLE should read a little endian int64.",
+		)
+	}
+
+	_, err = mmse.ReadLE[int64](bytes.NewReader(b[:7]))
+
+	assert.Equal(
+		t, err, io.ErrUnexpectedEOF,
+		"ReadLE should fail on a short read.",
+	)
+}
+
+func TestWriteLE(t *testing.T) {
+
+	type size int32
+
+	for _, v := range []size{0, 1, -1, 0x7fffffff, -0x80000000} {
+		w := new(bytes.Buffer)
+
+		if assert.NoError(t, mmse.WriteLE(w, v)) {
+			assert.Equal(t, w.Len(), 4, "WriteLE should write 4 bytes for an int32.")
+			assert.Equal(
+				t, mmse.DecodeLE[size](w.Bytes()), v,
+				"DecodeLE should decode the integer written by WriteLE.",
+			)
+		}
+	}
+}
+
+func TestPutLE(t *testing.T) {
+
+	b := make([]byte, 8)
+
+	mmse.PutLE(b, mmse.Magic)
+
+	assert.Equal(
+		t, b, []byte{0x6d, 0x6d, 0x32, 0x73, 0, 0, 0, 0},
+		"PutLE should write a little endian int32.",
+	)
+
+	assert.Panics(
+		t, func() { mmse.PutLE(b[:3], mmse.Magic) },
+		"PutLE should panic when the slice is too short.",
+	)
+}
+
 func TestReadSizeToFrame(t *testing.T) {
 
 	r := new(bytes.Buffer)
@@ -194,3 +267,653 @@ func TestReadSizeToFrameReaderError(t *testing.T) {
 		)
 	}
 }
+
+func TestFrameLevels(t *testing.T) {
+
+	raw := bytes.Repeat([]byte(`{"name":"driver","age":30},`), 1000)
+
+	for l := 0; l <= mmse.MaxLevel; l++ {
+		f := mmse.ReadToFrame(bytes.NewReader(raw), l)
+
+		assert.Equal(
+			t, f.SizeRaw, int32(len(raw)),
+			"SizeRaw should be the length of the input.",
+		)
+
+		assert.Less(
+			t, f.SizeCom, f.SizeRaw,
+			"Compressible input should shrink at level %d.", l,
+		)
+
+		b := new(bytes.Buffer)
+
+		mmse.WriteSize(b, f)
+		mmse.WriteFrame(b, f)
+
+		d := mmse.ReadSizeToFrame(b)
+
+		mmse.ReadFrame(b, d)
+
+		assert.Equal(
+			t, d.Bytes(), raw,
+			"Decoding should restore the input at level %d.", l,
+		)
+	}
+}
+
+func TestReadSaveFile(t *testing.T) {
+
+	info := bytes.Repeat([]byte(`{"name":"info"}`), 100)
+	data := bytes.Repeat([]byte(`{"name":"data"}`), 100)
+
+	fi := mmse.ReadToFrame(bytes.NewReader(info), 0)
+	fd := mmse.ReadToFrame(bytes.NewReader(data), 0)
+
+	b := new(bytes.Buffer)
+
+	mmse.WriteHeader(b)
+	mmse.WriteSize(b, fi)
+	mmse.WriteSize(b, fd)
+	mmse.WriteFrame(b, fi)
+	mmse.WriteFrame(b, fd)
+
+	save := b.Bytes()
+
+	s, err := mmse.ReadSaveFile(bytes.NewReader(save))
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, s.Info.Bytes(), info, "Info should be decoded.")
+		assert.Equal(t, s.Data.Bytes(), data, "Data should be decoded.")
+		assert.Nil(t, s.Trailing, "A save should have no trailing bytes.")
+		assert.Empty(t, s.Warnings, "A save should have no warnings.")
+	}
+
+	s, err = mmse.ReadSaveFile(bytes.NewReader(append(save, "pad"...)))
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, s.Data.Bytes(), data, "Data should be decoded.")
+		assert.Equal(t, []byte("pad"), s.Trailing, "Trailing bytes should be kept.")
+		assert.Equal(
+			t,
+			[]mmse.Warning{{Region: "trailing", Msg: "3 bytes after the data frame"}},
+			s.Warnings,
+			"Trailing bytes should be reported.",
+		)
+	}
+
+	ver := append([]byte(nil), save...)
+	ver[4] = 5
+
+	s, err = mmse.ReadSaveFile(bytes.NewReader(ver))
+
+	if assert.NoError(t, err, "ReadSaveFile should tolerate unknown versions.") {
+		assert.Equal(
+			t,
+			[]string{"version: unknown version number 5, expecting 4"},
+			warnings(s),
+			"Unknown versions should be reported.",
+		)
+	}
+
+	_, err = mmse.ReadSaveFile(bytes.NewReader(save[:len(save)-1]))
+
+	assert.Error(t, err, "ReadSaveFile should fail on truncated saves.")
+
+	bad := append([]byte(nil), save...)
+	bad[0] ^= 0xff
+
+	_, err = mmse.ReadSaveFile(bytes.NewReader(bad))
+
+	assert.Error(t, err, "ReadSaveFile should fail on a wrong magic number.")
+}
+
+func TestReadSaveFileErrors(t *testing.T) {
+	doc := bytes.Repeat([]byte(`{"name":"doc"}`), 100)
+	save := mmsetest.Save(doc, doc, 0)
+
+	bad := append([]byte(nil), save...)
+	bad[0] ^= 0xff
+
+	_, err := mmse.ReadSaveFile(bytes.NewReader(bad))
+
+	assert.True(
+		t, errors.Is(err, mmse.ErrBadMagic),
+		"A wrong magic number should be ErrBadMagic.",
+	)
+
+	ver := append([]byte(nil), save...)
+	ver[4] = 5
+
+	_, err = new(mmse.SaveFile).ReadFrom(bytes.NewReader(ver))
+
+	assert.NoError(t, err, "Unknown versions should be tolerated by default.")
+
+	_, err = (&mmse.SaveFile{Strict: true}).ReadFrom(bytes.NewReader(ver))
+
+	assert.True(
+		t, errors.Is(err, mmse.ErrVersionMismatch),
+		"Unknown versions should be ErrVersionMismatch when strict.",
+	)
+
+	_, err = mmse.ReadSaveFile(bytes.NewReader(save[:len(save)-1]))
+
+	var size *mmse.SizeMismatchError
+
+	if assert.True(t, errors.As(err, &size), "Truncated saves should be a SizeMismatchError.") {
+		assert.Equal(t, size.Want-1, size.Got, "The missing byte should be reported.")
+	}
+
+	_, err = mmse.ReadSaveFile(bytes.NewReader(save[:6]))
+
+	assert.True(
+		t, errors.Is(err, io.ErrUnexpectedEOF),
+		"Errors of the reader should be wrapped.",
+	)
+}
+
+func TestReadSaveFilePadding(t *testing.T) {
+
+	info := bytes.Repeat([]byte(`{"name":"info"}`), 100)
+	data := append(bytes.Repeat([]byte(`{"name":"data"}`), 100), 0, 0, 0)
+
+	s, err := mmse.ReadSaveFile(bytes.NewReader(mmsetest.Save(info, data, 0)))
+
+	if assert.NoError(t, err) {
+		assert.Equal(
+			t,
+			[]string{"data frame: 3 bytes of padding after the document"},
+			warnings(s),
+			"Padding should be reported.",
+		)
+	}
+}
+
+func TestReadSaveFileFramePadding(t *testing.T) {
+
+	save := mmsetest.Save([]byte(`{"a":1}`), []byte(`{"b":2}`), 0)
+
+	padded := append(append(append([]byte(nil), save[:24]...), 0, 0, 0, 0), save[24:]...)
+
+	s, err := mmse.ReadSaveFile(bytes.NewReader(padded))
+
+	if !assert.NoError(t, err, "ReadSaveFile should skip padding before the frames.") {
+		return
+	}
+
+	assert.Equal(t, 4, s.Padding, "The padding should be recorded.")
+	assert.Equal(t, []byte(`{"b":2}`), s.Data.Bytes(), "Data should be decoded.")
+	assert.Equal(
+		t, []string{"padding: 4 bytes before the info frame"}, warnings(s),
+		"Padding should be reported.",
+	)
+
+	assert.Equal(
+		t,
+		mmse.Region{Name: "padding", Offset: 24, Length: 4},
+		mmse.Layout(padded)[4],
+		"Layout should locate the padding.",
+	)
+
+	b := new(bytes.Buffer)
+
+	if _, err := s.WriteTo(b); assert.NoError(t, err) {
+		assert.Equal(t, padded, b.Bytes(), "WriteTo should reproduce the padding.")
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+
+	pad := string(bytes.Repeat([]byte("x"), 100))
+
+	info := []byte(`{"name":"info","pad":"` + pad + `"}`)
+	data := []byte(`{"drivers":[{"id":1,"name":"a","name":"b"}],"pad":"` + pad + `"}`)
+
+	s, err := mmse.ReadSaveFile(bytes.NewReader(mmsetest.Save(info, data, 0)))
+
+	if assert.NoError(t, err) && assert.NoError(t, s.CheckKeys()) {
+		assert.Equal(
+			t,
+			[]string{"data frame: duplicate key drivers[0].name"},
+			warnings(s),
+			"Duplicate keys should be reported.",
+		)
+	}
+}
+
+func TestSaveFileHooks(t *testing.T) {
+
+	info := bytes.Repeat([]byte(`{"name":"info"}`), 100)
+	data := bytes.Repeat([]byte(`{"name":"data"}`), 100)
+
+	fi := mmse.ReadToFrame(bytes.NewReader(info), 0)
+	fd := mmse.ReadToFrame(bytes.NewReader(data), 0)
+
+	b := new(bytes.Buffer)
+
+	mmse.WriteHeader(b)
+	mmse.WriteSize(b, fi)
+	mmse.WriteSize(b, fd)
+	mmse.WriteFrame(b, fi)
+	mmse.WriteFrame(b, fd)
+
+	save := b.Bytes()
+
+	var calls []string
+
+	hook := func(name string) mmse.HookFunc {
+		return func(region string, f *mmse.Frame) error {
+			calls = append(calls, fmt.Sprintf("%s %s %d", name, region, f.Len()))
+			return nil
+		}
+	}
+
+	s := &mmse.SaveFile{Hooks: &mmse.Hooks{
+		BeforeDecode: hook("before decode"),
+		AfterDecode:  hook("after decode"),
+		BeforeEncode: hook("before encode"),
+		AfterEncode:  hook("after encode"),
+	}}
+
+	n, err := s.ReadFrom(bytes.NewReader(save))
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(len(save)), n, "ReadFrom should count the bytes read.")
+	}
+
+	out := new(bytes.Buffer)
+
+	n, err = s.WriteTo(out)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, save, out.Bytes(), "WriteTo should write the save read.")
+		assert.Equal(t, int64(len(save)), n, "WriteTo should count the bytes written.")
+	}
+
+	assert.Equal(
+		t,
+		[]string{
+			fmt.Sprintf("before decode info frame %d", fi.SizeCom),
+			"after decode info frame 1500",
+			fmt.Sprintf("before decode data frame %d", fd.SizeCom),
+			"after decode data frame 1500",
+			"before encode info frame 1500",
+			fmt.Sprintf("after encode info frame %d", fi.SizeCom),
+			"before encode data frame 1500",
+			fmt.Sprintf("after encode data frame %d", fd.SizeCom),
+		},
+		calls,
+		"Hooks should be called in order.",
+	)
+
+	s.Hooks = &mmse.Hooks{AfterDecode: func(region string, _ *mmse.Frame) error {
+		if region == "data frame" {
+			return fmt.Errorf("rejected")
+		}
+
+		return nil
+	}}
+
+	_, err = s.ReadFrom(bytes.NewReader(save))
+
+	assert.EqualError(t, err, "data frame: hook: rejected", "A hook should stop reading.")
+}
+
+func TestDecodeLimits(t *testing.T) {
+
+	doc := bytes.Repeat([]byte(`{"name":"data"}`), 100)
+
+	save := mmsetest.Save(doc, doc, 0)
+
+	_, err := mmse.ReadSaveFile(bytes.NewReader(save))
+
+	assert.NoError(t, err, "A valid save should be within the limits.")
+
+	huge := append([]byte(nil), save...)
+	binary.LittleEndian.PutUint32(huge[12:], 1<<31-1)
+
+	_, err = mmse.ReadSaveFile(bytes.NewReader(huge))
+
+	assert.EqualError(
+		t, err, "info frame: decoded size 2147483647 exceeds the limit of 1073741824 bytes",
+		"Decode should refuse sizes beyond MaxDecodedSize.",
+	)
+
+	defer func(n int64) { mmse.MaxDecodedSize = n }(mmse.MaxDecodedSize)
+
+	mmse.MaxDecodedSize = 0
+
+	_, err = mmse.ReadSaveFile(bytes.NewReader(huge))
+
+	assert.Contains(
+		t, fmt.Sprint(err), "exceeds 256 times the encoded size",
+		"Decode should refuse sizes beyond MaxRatio.",
+	)
+}
+
+// warnings returns the warnings of a save as strings.
+func warnings(s *mmse.SaveFile) []string {
+	var ws []string
+
+	for _, w := range s.Warnings {
+		ws = append(ws, w.String())
+	}
+
+	return ws
+}
+
+func TestLayout(t *testing.T) {
+
+	b := new(bytes.Buffer)
+
+	mmse.WriteHeader(b)
+
+	for _, v := range []int32{3, 10, 5, 20} {
+		mmse.WriteInt32(b, v)
+	}
+
+	b.WriteString("abcdefg")
+
+	rs := mmse.Layout(b.Bytes())
+
+	assert.Equal(
+		t,
+		[]mmse.Region{
+			{Name: "magic", Offset: 0, Length: 4},
+			{Name: "version", Offset: 4, Length: 4},
+			{Name: "info sizes", Offset: 8, Length: 8},
+			{Name: "data sizes", Offset: 16, Length: 8},
+			{Name: "info frame", Offset: 24, Length: 3},
+			{Name: "data frame", Offset: 27, Length: 4, Missing: 1},
+		},
+		rs,
+		"Layout should follow the size fields and report truncation.",
+	)
+
+	b.WriteString("xyz")
+
+	rs = mmse.Layout(b.Bytes())
+
+	assert.Equal(
+		t, mmse.Region{Name: "trailing", Offset: 32, Length: 2}, rs[len(rs)-1],
+		"Layout should report bytes after the data frame.",
+	)
+}
+
+func TestSaveFileFrames(t *testing.T) {
+
+	mmse.FrameCounts[5] = 3
+	defer delete(mmse.FrameCounts, 5)
+
+	s := &mmse.SaveFile{Version: 5, Info: new(mmse.Frame), Data: new(mmse.Frame)}
+
+	s.Info.WriteString(`{"a":1}`)
+	s.Data.WriteString(`{"b":2}`)
+
+	b := new(bytes.Buffer)
+
+	_, err := s.WriteTo(b)
+
+	assert.Error(t, err, "WriteTo should refuse too few frames for the version.")
+
+	s.Extra = []*mmse.Frame{new(mmse.Frame)}
+	s.Extra[0].WriteString(`{"c":3}`)
+
+	if _, err := s.WriteTo(b); !assert.NoError(t, err) {
+		return
+	}
+
+	var names []string
+
+	for _, r := range mmse.Layout(b.Bytes()) {
+		names = append(names, r.Name)
+	}
+
+	assert.Equal(
+		t,
+		[]string{
+			"magic", "version", "info sizes", "data sizes", "frame 2 sizes",
+			"info frame", "data frame", "frame 2",
+		},
+		names,
+		"Layout should follow the number of frames of the version.",
+	)
+
+	r, err := mmse.ReadSaveFile(b)
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, int32(5), r.Version, "The version should be kept.")
+		assert.Empty(t, r.Warnings, "A version in FrameCounts should be known.")
+
+		if assert.Len(t, r.Extra, 1, "The third frame should be read.") {
+			assert.Equal(t, []byte(`{"c":3}`), r.Extra[0].Bytes())
+		}
+	}
+}
+
+func TestReadSaveFileFS(t *testing.T) {
+	fsys := fstest.MapFS{"saves/game.sav": &fstest.MapFile{Data: mmsetest.Generate(mmsetest.Options{})}}
+
+	s, err := mmse.ReadSaveFileFS(fsys, "saves/game.sav")
+
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, string(mmsetest.Info(mmsetest.Options{})), s.Info.String())
+	}
+
+	_, err = mmse.ReadSaveFileFS(fsys, "saves/missing.sav")
+
+	assert.True(t, errors.Is(err, fs.ErrNotExist), "A missing save should be reported as such.")
+}
+
+func TestSaveFileRaw(t *testing.T) {
+	b := mmsetest.Generate(mmsetest.Options{})
+
+	var called []string
+
+	s := &mmse.SaveFile{Raw: true, Hooks: &mmse.Hooks{
+		BeforeDecode: func(region string, _ *mmse.Frame) error {
+			called = append(called, region)
+			return nil
+		},
+	}}
+
+	if _, err := s.ReadFrom(bytes.NewReader(b)); !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Empty(t, called, "Raw should not decode the frames.")
+
+	rs, err := s.RawFrames()
+
+	if !assert.NoError(t, err) || !assert.Len(t, rs, 2) {
+		return
+	}
+
+	off := 24
+
+	for i, r := range rs {
+		assert.Equal(t, int32(binary.LittleEndian.Uint32(b[8+8*i:])), r.SizeCom)
+		assert.Equal(t, int32(binary.LittleEndian.Uint32(b[12+8*i:])), r.SizeRaw)
+		assert.Equal(t, b[off:off+int(r.SizeCom)], r.Bytes, "The bytes should be kept encoded.")
+
+		off += int(r.SizeCom)
+	}
+
+	out := new(bytes.Buffer)
+
+	if _, err := s.WriteTo(out); assert.NoError(t, err) {
+		assert.Equal(t, b, out.Bytes(), "Raw frames should be written as they were read.")
+	}
+
+	if assert.NoError(t, s.Info.Decode()) {
+		assert.JSONEq(t, string(mmsetest.Info(mmsetest.Options{})), s.Info.String())
+	}
+
+	rs, err = s.RawFrames()
+
+	if assert.NoError(t, err) {
+		d, err := mmse.ReadSaveFile(bytes.NewReader(b))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, d.Info.Len(), int(rs[0].SizeRaw), "Decoded frames should be encoded again.")
+		}
+	}
+}
+
+func TestDecodePartial(t *testing.T) {
+
+	raw := bytes.Repeat([]byte(`{"name":"driver","age":30},`), 1000)
+
+	f := mmse.ReadToFrame(bytes.NewReader(raw), 0)
+
+	b, err := mmse.DecodePartial(f.Bytes(), int(f.SizeRaw))
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, raw, b, "Complete blocks should decode fully.")
+	}
+
+	b, err = mmse.DecodePartial(f.Bytes()[:f.Len()/2], int(f.SizeRaw))
+
+	assert.Error(t, err, "Truncated blocks should report an error.")
+	assert.NotEmpty(t, b, "Truncated blocks should decode a prefix.")
+	assert.Equal(t, raw[:len(b)], b, "The prefix should match the input.")
+}
+
+func TestRepairJSON(t *testing.T) {
+
+	for _, c := range []struct {
+		in, out string
+		drop    int
+	}{
+		{`{"a":[1,2,{"b":"c"},`, `{"a":[1,2,{"b":"c"}]}`, 1},
+		{`{"a":[1,2,{"b":"c`, `{"a":[1,2]}`, 8},
+		{`{"a":[[1],[`, `{"a":[[1]]}`, 2},
+		{`{"a":1,"b":12`, `{"a":1}`, 7},
+		{`{"a":{"b":`, `{"a":{}}`, 4},
+		{`{"a":"x\"y"}`, `{"a":"x\"y"}`, 0},
+		{`[true,nul`, `[true]`, 4},
+	} {
+		b, n := mmse.RepairJSON([]byte(c.in))
+
+		assert.Equal(t, c.out, string(b), "Repairing %s.", c.in)
+		assert.Equal(t, c.drop, n, "Bytes dropped from %s.", c.in)
+	}
+
+	b, n := mmse.RepairJSON([]byte(`"abc`))
+
+	assert.Nil(t, b, "A cut scalar document cannot be repaired.")
+	assert.Equal(t, 4, n)
+}
+
+func TestScan(t *testing.T) {
+
+	info := bytes.Repeat([]byte(`{"name":"info"},`), 100)
+	info = append(append([]byte(`{"a":[`), info[:len(info)-1]...), ']', '}')
+
+	data := bytes.Repeat([]byte(`{"name":"data"},`), 100)
+	data = append(append([]byte(`{"b":[`), data[:len(data)-1]...), ']', '}')
+
+	fi := mmse.ReadToFrame(bytes.NewReader(info), 0)
+	fd := mmse.ReadToFrame(bytes.NewReader(data), 0)
+
+	b := new(bytes.Buffer)
+
+	b.WriteString("garbage header")
+	mmse.WriteFrame(b, fi)
+	mmse.WriteFrame(b, fd)
+
+	save := b.Bytes()
+
+	cs := mmse.Scan(save)
+
+	if assert.Len(t, cs, 2, "Scan should find both frames.") {
+		assert.Equal(t, int64(14), cs[0].Offset)
+		assert.Equal(t, info, cs[0].Doc)
+		assert.NoError(t, cs[0].Err)
+		assert.Equal(t, data, cs[1].Doc)
+	}
+
+	cs = mmse.Scan(save[:len(save)-10])
+
+	if assert.Len(t, cs, 2, "Scan should keep a truncated final frame.") {
+		assert.Error(t, cs[1].Err)
+		assert.Equal(t, data[:len(cs[1].Doc)], cs[1].Doc)
+	}
+}
+
+// copyCodec stores frames uncompressed.
+type copyCodec struct{}
+
+func (copyCodec) Compress(dst, src []byte, _ int) (int, error) {
+	return copy(dst, src), nil
+}
+
+func (copyCodec) Decompress(dst, src []byte) (int, error) {
+	return copy(dst, src), nil
+}
+
+func TestFrameCodec(t *testing.T) {
+
+	raw := []byte(`{"name":"driver"}`)
+
+	b := new(bytes.Buffer)
+
+	f := &mmse.Frame{Codec: copyCodec{}, SizeRaw: int32(len(raw))}
+	f.Write(raw)
+
+	if assert.NoError(t, f.Encode()) {
+		assert.Equal(t, raw, f.Bytes(), "Encode should use the codec.")
+	}
+
+	mmse.WriteSize(b, f)
+	mmse.WriteFrame(b, f)
+
+	d := mmse.ReadSizeToFrame(b)
+	d.Codec = copyCodec{}
+
+	mmse.ReadFrame(b, d)
+
+	assert.Equal(t, raw, d.Bytes(), "Decode should use the codec.")
+}
+
+func TestFrameIncompressible(t *testing.T) {
+	raw := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(raw)
+
+	f := &mmse.Frame{SizeRaw: int32(len(raw))}
+	f.Write(raw)
+
+	if assert.NoError(t, f.Encode()) {
+		assert.True(t, f.SizeCom > f.SizeRaw, "Incompressible data should be stored as literals.")
+	}
+
+	b := new(bytes.Buffer)
+
+	mmse.WriteSize(b, f)
+	mmse.WriteFrame(b, f)
+
+	d := mmse.ReadSizeToFrame(b)
+	mmse.ReadFrame(b, d)
+
+	assert.Equal(t, raw, d.Bytes(), "Incompressible data should survive a round trip.")
+}
+
+func TestFrameReader(t *testing.T) {
+
+	f := new(mmse.Frame)
+	f.WriteString(`{"name":"driver"}`)
+
+	r := f.Reader()
+
+	r.Seek(9, io.SeekStart)
+
+	b := make([]byte, 6)
+
+	if _, err := io.ReadFull(r, b); assert.NoError(t, err) {
+		assert.Equal(t, `driver`, string(b))
+	}
+
+	assert.Equal(
+		t, 17, f.Len(),
+		"Reading through Reader should not consume the frame.",
+	)
+}
@@ -0,0 +1,135 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package mmsetest generates synthetic save files for tests, so that tests
+// need no saves of the game.
+//
+// The documents resemble those of the game, with teams and drivers, but follow
+// no schema of the game; the game would not load them. They are valid JSON
+// and the saves are valid for package mmse.
+package mmsetest
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+// Options configure the content of a synthetic save. The zero value makes a
+// small save.
+type Options struct {
+	// Teams and Drivers are the numbers of records in the data document,
+	// 10 and 20 when zero.
+	Teams   int
+	Drivers int
+	// Seed seeds the random content; saves with the same options are equal.
+	Seed int64
+	// Level is the compression level of the frames.
+	Level int
+}
+
+// counts returns the numbers of teams and drivers.
+func (o Options) counts() (int, int) {
+	t, d := o.Teams, o.Drivers
+
+	if t <= 0 {
+		t = 10
+	}
+
+	if d <= 0 {
+		d = 20
+	}
+
+	return t, d
+}
+
+// Info returns a synthetic info document.
+func Info(o Options) []byte {
+	t, d := o.counts()
+
+	return []byte(fmt.Sprintf(
+		`{"gameVersion":"synthetic","saveName":"Synthetic %d","season":2018,`+
+			`"playerTeam":"Team 0","teams":%d,"drivers":%d}`,
+		o.Seed, t, d,
+	))
+}
+
+// Data returns a synthetic data document of teams and drivers. Its size grows
+// linearly with the numbers of records, by about 170 bytes per driver.
+func Data(o Options) []byte {
+	t, d := o.counts()
+	r := rand.New(rand.NewSource(o.Seed))
+
+	b := new(bytes.Buffer)
+
+	b.WriteString(`{"season":2018,"playerTeam":0,"teams":[`)
+
+	for i := 0; i < t; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		fmt.Fprintf(
+			b,
+			`{"id":%d,"name":"Team %d","shortName":"T%02d","budget":%d,"reputation":%.3f}`,
+			i, i, i%100, r.Intn(500000000), r.Float64()*5,
+		)
+	}
+
+	b.WriteString(`],"drivers":[`)
+
+	for i := 0; i < d; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		fmt.Fprintf(
+			b,
+			`{"id":%d,"name":"Driver %d","team":%d,"age":%d,"rating":%.3f,`+
+				`"morale":%.3f,"contract":{"wage":%d,"end":"%d-12-31"}}`,
+			i, i, r.Intn(t), 18+r.Intn(20), r.Float64()*5, r.Float64(),
+			r.Intn(5000000), 2018+r.Intn(5),
+		)
+	}
+
+	b.WriteString("]}")
+
+	return b.Bytes()
+}
+
+// Save returns a save file holding an info and a data document, compressed at
+// a compression level.
+func Save(info, data []byte, level int) []byte {
+	b := new(bytes.Buffer)
+
+	fi := mmse.ReadToFrame(bytes.NewReader(info), level)
+	fd := mmse.ReadToFrame(bytes.NewReader(data), level)
+
+	mmse.WriteHeader(b)
+	mmse.WriteSize(b, fi)
+	mmse.WriteSize(b, fd)
+	mmse.WriteFrame(b, fi)
+	mmse.WriteFrame(b, fd)
+
+	return b.Bytes()
+}
+
+// Generate returns a synthetic save file.
+func Generate(o Options) []byte {
+	return Save(Info(o), Data(o), o.Level)
+}
@@ -0,0 +1,47 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mmsetest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+	"github.com/mys721tx/mmse-go/pkg/mmse/mmsetest"
+)
+
+func TestGenerate(t *testing.T) {
+	for _, o := range []mmsetest.Options{
+		{},
+		{Teams: 3, Drivers: 1000, Seed: 7, Level: mmse.MaxLevel},
+	} {
+		save := mmsetest.Generate(o)
+
+		s, err := mmse.ReadSaveFile(bytes.NewReader(save))
+
+		if assert.NoError(t, err, "Generate should make a valid save.") {
+			assert.True(t, json.Valid(s.Info.Bytes()), "Info should be valid JSON.")
+			assert.Equal(t, mmsetest.Data(o), s.Data.Bytes(), "Data should round trip.")
+			assert.Empty(t, s.Warnings, "Generate should make a clean save.")
+		}
+
+		assert.Equal(t, save, mmsetest.Generate(o), "Generate should be deterministic.")
+	}
+}
@@ -0,0 +1,194 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mmse_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+// blockSize is the size beyond which lz4 matches can no longer reach the
+// start of a document.
+const blockSize = 1 << 16
+
+// boundarySizes are document sizes around multiples of blockSize.
+var boundarySizes = []int{
+	0, 1, 2, blockSize - 1, blockSize, blockSize + 1,
+	2*blockSize - 1, 2 * blockSize, 2*blockSize + 1, 5*blockSize + 7,
+}
+
+// doc is a JSON document generated by testing/quick.
+type doc []byte
+
+// Generate returns a document of a random size, near a multiple of blockSize
+// in a third of the cases, up to five blocks long.
+func (doc) Generate(r *rand.Rand, _ int) reflect.Value {
+	n := r.Intn(5 * blockSize)
+
+	if r.Intn(3) == 0 {
+		n = boundarySizes[r.Intn(len(boundarySizes))]
+	}
+
+	return reflect.ValueOf(doc(jsonDoc(r, n)))
+}
+
+// jsonDoc returns a random JSON object of n bytes, or of the smallest size
+// holding its random value when n is smaller. The object pads the value with a
+// string mixing runs of a byte, which compress, and random letters, which do
+// not.
+func jsonDoc(r *rand.Rand, n int) []byte {
+	v, err := json.Marshal(randomValue(r, 3))
+	if err != nil {
+		panic(err)
+	}
+
+	head, tail := []byte(`{"pad":"`), append(append([]byte(`","v":`), v...), '}')
+
+	b := append([]byte(nil), head...)
+
+	for len(b) < n-len(tail) {
+		if r.Intn(2) == 0 {
+			b = append(b, bytes.Repeat([]byte{'a' + byte(r.Intn(26))}, 1+r.Intn(64))...)
+		} else {
+			b = append(b, 'a'+byte(r.Intn(26)))
+		}
+	}
+
+	if d := len(b) + len(tail) - n; d > 0 && len(b)-d >= len(head) {
+		b = b[:len(b)-d]
+	}
+
+	return append(b, tail...)
+}
+
+// randomValue returns a random JSON value nested at most depth levels.
+func randomValue(r *rand.Rand, depth int) interface{} {
+	k := r.Intn(7)
+
+	if depth == 0 {
+		k %= 4
+	}
+
+	switch k {
+	case 0:
+		return nil
+	case 1:
+		return r.Intn(2) == 0
+	case 2:
+		return r.NormFloat64() * 1e6
+	case 3:
+		rs := make([]rune, r.Intn(16))
+
+		for i := range rs {
+			rs[i] = rune(r.Intn(0x800))
+		}
+
+		return string(rs)
+	case 4, 5:
+		m := make(map[string]interface{})
+
+		for i := r.Intn(8); i > 0; i-- {
+			m[string(rune('a'+r.Intn(26)))] = randomValue(r, depth-1)
+		}
+
+		return m
+	default:
+		a := make([]interface{}, r.Intn(8))
+
+		for i := range a {
+			a[i] = randomValue(r, depth-1)
+		}
+
+		return a
+	}
+}
+
+// pack returns the save holding info and data.
+func pack(t *testing.T, info, data []byte) []byte {
+	s := &mmse.SaveFile{Info: new(mmse.Frame), Data: new(mmse.Frame)}
+
+	s.Info.Write(info)
+	s.Data.Write(data)
+
+	b := new(bytes.Buffer)
+
+	if _, err := s.WriteTo(b); err != nil {
+		t.Fatal(err)
+	}
+
+	return b.Bytes()
+}
+
+// roundTrip reports whether a save of info and data unpacks to them, and
+// whether packing what it unpacks to restores the save byte for byte.
+func roundTrip(t *testing.T, info, data []byte) bool {
+	b := pack(t, info, data)
+
+	s, err := mmse.ReadSaveFile(bytes.NewReader(b))
+	if err != nil {
+		t.Logf("%d and %d byte documents: %s", len(info), len(data), err)
+		return false
+	}
+
+	if !bytes.Equal(s.Info.Bytes(), info) || !bytes.Equal(s.Data.Bytes(), data) {
+		t.Logf("%d and %d byte documents do not unpack to themselves", len(info), len(data))
+		return false
+	}
+
+	if !bytes.Equal(pack(t, s.Info.Bytes(), s.Data.Bytes()), b) {
+		t.Logf("%d and %d byte documents do not pack to the same save", len(info), len(data))
+		return false
+	}
+
+	return true
+}
+
+func TestRoundTripProperty(t *testing.T) {
+	c := &quick.Config{MaxCount: 50}
+
+	if testing.Short() {
+		c.MaxCount = 10
+	}
+
+	f := func(info, data doc) bool { return roundTrip(t, info, data) }
+
+	if err := quick.Check(f, c); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRoundTripBoundaries(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for _, n := range boundarySizes {
+		d := jsonDoc(r, n)
+
+		if !json.Valid(d) {
+			t.Fatalf("jsonDoc made invalid JSON of %d bytes", len(d))
+		}
+
+		if !roundTrip(t, jsonDoc(r, 0), d) {
+			t.Errorf("A %d byte data document should round trip.", len(d))
+		}
+	}
+}
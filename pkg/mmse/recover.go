@@ -0,0 +1,288 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mmse
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// DecodePartial decodes an lz4 block and returns as much output as the block
+// allows, together with the error that stopped decoding, if any. Unlike
+// Frame.Decode, DecodePartial keeps the output decoded before a truncated or
+// corrupt sequence. Output beyond max bytes is dropped when max is positive.
+// Only as much room as MaxRatio allows is reserved for the output up front.
+func DecodePartial(src []byte, max int) ([]byte, error) {
+	dst, _, err := decodeBlock(src, max, nil)
+
+	return dst, err
+}
+
+// decodeBlock decodes an lz4 block as DecodePartial does and also returns the
+// number of bytes of src read. When done is not nil, decoding stops after the
+// first sequence for which done reports true.
+func decodeBlock(src []byte, max int, done func([]byte) bool) ([]byte, int, error) {
+	var dst []byte
+
+	if n := max; n > 0 {
+		if MaxRatio > 0 && int64(n) > MaxRatio*int64(len(src)) {
+			n = int(MaxRatio * int64(len(src)))
+		}
+
+		dst = make([]byte, 0, n)
+	}
+
+	truncated := fmt.Errorf("block truncated at offset %d", len(src))
+
+	// length reads the extension bytes of a literal or match length.
+	length := func(i, n int) (int, int, error) {
+		if n != 15 {
+			return i, n, nil
+		}
+
+		for {
+			if i >= len(src) {
+				return i, n, truncated
+			}
+
+			b := src[i]
+			i++
+			n += int(b)
+
+			if b != 255 {
+				return i, n, nil
+			}
+		}
+	}
+
+	i := 0
+
+	for i < len(src) {
+		if max > 0 && len(dst) >= max {
+			return dst[:max], i, nil
+		}
+
+		tok := src[i]
+
+		var (
+			lit, ml int
+			err     error
+		)
+
+		if i, lit, err = length(i+1, int(tok>>4)); err != nil {
+			return dst, i, err
+		}
+
+		if i+lit > len(src) {
+			return append(dst, src[i:]...), len(src), truncated
+		}
+
+		dst = append(dst, src[i:i+lit]...)
+		i += lit
+
+		if i == len(src) || done != nil && done(dst) {
+			break
+		}
+
+		if i+2 > len(src) {
+			return dst, len(src), truncated
+		}
+
+		off := int(src[i]) | int(src[i+1])<<8
+		i += 2
+
+		if off == 0 || off > len(dst) {
+			return dst, i - 2, fmt.Errorf("bad match offset %d at offset %d", off, i-2)
+		}
+
+		if i, ml, err = length(i, int(tok&15)); err != nil {
+			return dst, i, err
+		}
+
+		for j, s := 0, len(dst)-off; j < ml+4; j++ {
+			dst = append(dst, dst[s+j])
+		}
+
+		if done != nil && done(dst) {
+			break
+		}
+
+		if i >= len(src) {
+			return dst, i, truncated
+		}
+	}
+
+	if max > 0 && len(dst) > max {
+		return dst[:max], i, nil
+	}
+
+	return dst, i, nil
+}
+
+// RepairJSON returns the longest prefix of a JSON document that ends after a
+// complete value, with the open objects and arrays closed, and the number of
+// bytes of b dropped. It returns nil when no prefix can be repaired.
+func RepairJSON(b []byte) ([]byte, int) {
+	const (
+		expectValue = iota
+		expectKey
+		expectColon
+		expectComma
+	)
+
+	var (
+		stack []byte
+		state []int
+		top   = expectValue
+		safe  = -1
+		depth int
+	)
+
+	mark := func(i int) {
+		safe, depth = i, len(stack)
+	}
+
+	done := func(i int) {
+		if len(state) == 0 {
+			mark(i)
+			return
+		}
+
+		state[len(state)-1] = expectComma
+		mark(i)
+	}
+
+	expect := func() int {
+		if len(state) == 0 {
+			return top
+		}
+
+		return state[len(state)-1]
+	}
+
+scan:
+	for i := 0; i < len(b); {
+		switch c := b[i]; c {
+		case ' ', '\t', '\r', '\n':
+			i++
+		case '"':
+			j := i + 1
+
+			for ; j < len(b) && b[j] != '"'; j++ {
+				if b[j] == '\\' {
+					j++
+				}
+			}
+
+			if j >= len(b) {
+				break scan
+			}
+
+			i = j + 1
+
+			if expect() == expectKey {
+				state[len(state)-1] = expectColon
+			} else {
+				done(i)
+			}
+		case '{', '[':
+			// A partial element of an array is dropped rather than kept
+			// empty.
+			inArray := len(stack) > 0 && stack[len(stack)-1] == '['
+
+			stack = append(stack, c)
+
+			if c == '{' {
+				state = append(state, expectKey)
+			} else {
+				state = append(state, expectValue)
+			}
+
+			i++
+
+			if !inArray {
+				mark(i)
+			}
+		case '}', ']':
+			if len(stack) == 0 {
+				break scan
+			}
+
+			stack, state = stack[:len(stack)-1], state[:len(state)-1]
+			i++
+			done(i)
+		case ':':
+			if len(state) > 0 {
+				state[len(state)-1] = expectValue
+			}
+
+			i++
+		case ',':
+			if len(stack) > 0 && stack[len(stack)-1] == '{' {
+				state[len(state)-1] = expectKey
+			} else if len(state) > 0 {
+				state[len(state)-1] = expectValue
+			}
+
+			i++
+		default:
+			j := i
+
+			for j < len(b) && !isDelim(b[j]) {
+				j++
+			}
+
+			if j == len(b) && len(stack) > 0 {
+				// The scalar may be cut short.
+				break scan
+			}
+
+			i = j
+			done(i)
+		}
+	}
+
+	if safe < 0 {
+		return nil, len(b)
+	}
+
+	out := append([]byte(nil), b[:safe]...)
+
+	for i := depth - 1; i >= 0; i-- {
+		if stack[i] == '{' {
+			out = append(out, '}')
+		} else {
+			out = append(out, ']')
+		}
+	}
+
+	if !json.Valid(out) {
+		return nil, len(b)
+	}
+
+	return out, len(b) - safe
+}
+
+// isDelim reports whether c ends a number or literal.
+func isDelim(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', ',', ':', '}', ']', '{', '[', '"':
+		return true
+	}
+
+	return false
+}
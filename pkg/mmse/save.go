@@ -0,0 +1,381 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package mmse
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+)
+
+// SaveFile is a save file with its info and data frames decoded.
+type SaveFile struct {
+	Info *Frame
+	Data *Frame
+	// Extra holds the frames after the data frame in saves of versions with
+	// more than two frames; see FrameCounts.
+	Extra []*Frame
+	// Version is the version number of the save. WriteTo writes Ver when it
+	// is 0.
+	Version int32
+	// Padding is the number of NUL bytes between the size table and the first
+	// frame, which some builds of the game write. WriteTo writes them again.
+	Padding int
+	// Trailing holds any bytes after the data frame. The game does not write
+	// them, but they are kept so that a round trip never drops data.
+	Trailing []byte
+	// Warnings lists findings that did not stop reading but suggest the save
+	// was not written by the game as expected.
+	Warnings []Warning
+	// Hooks are called around decoding and encoding the frames, or not at
+	// all when nil.
+	Hooks *Hooks
+	// Strict makes an unknown version number an error wrapping
+	// ErrVersionMismatch instead of a warning.
+	Strict bool
+	// Raw makes ReadFrom leave the frames encoded, for tools that copy, hash,
+	// or upload saves without reading the documents. The decoding hooks are
+	// not called and the documents are not checked for padding; Decode
+	// decodes a frame later.
+	Raw bool
+}
+
+// RawFrame is a frame as stored in a save file.
+type RawFrame struct {
+	// SizeCom and SizeRaw are the encoded and decoded sizes from the size
+	// table.
+	SizeCom int32
+	SizeRaw int32
+	// Bytes holds the encoded content.
+	Bytes []byte
+}
+
+// Warning is a non-fatal finding about a save file.
+type Warning struct {
+	// Region is the part of the save concerned, named as by Layout.
+	Region string
+	Msg    string
+}
+
+// String returns the warning as region: message.
+func (w Warning) String() string {
+	return w.Region + ": " + w.Msg
+}
+
+// warn adds a warning.
+func (s *SaveFile) warn(region, format string, a ...interface{}) {
+	s.Warnings = append(s.Warnings, Warning{region, fmt.Sprintf(format, a...)})
+}
+
+// ReadSaveFile reads a save file and decodes its frames. Unlike the readers
+// used by the command line tool, ReadSaveFile returns errors instead of
+// panicking. An unknown version number, padding before the first frame or after
+// a document, and trailing bytes are tolerated and reported in Warnings.
+//
+// Errors wrap ErrBadMagic for a file that is not a save, a *SizeMismatchError
+// for a truncated frame, and the underlying error of the reader or codec
+// otherwise.
+func ReadSaveFile(r io.Reader) (*SaveFile, error) {
+	s := new(SaveFile)
+
+	if _, err := s.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// ReadSaveFileFS reads a save file from a file system, such as a directory
+// from os.DirFS, an embed.FS, or an in-memory fstest.MapFS, as ReadSaveFile
+// does. The other functions of the package read and write through io.Reader
+// and io.Writer, so they need no file system.
+func ReadSaveFileFS(fsys fs.FS, name string) (*SaveFile, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return ReadSaveFile(bufio.NewReader(f))
+}
+
+// ReadFrom reads a save file into s as ReadSaveFile does, calling the hooks of
+// s, and returns the number of bytes read. It implements io.ReaderFrom.
+func (s *SaveFile) ReadFrom(r io.Reader) (int64, error) {
+	c := &countReader{r: r}
+	r = c
+
+	s.Info, s.Data, s.Extra, s.Version, s.Padding = nil, nil, nil, 0, 0
+	s.Trailing, s.Warnings = nil, nil
+
+	if m, err := ReadInt32(r); err != nil {
+		return c.n, fmt.Errorf("unable to read magic number: %w", err)
+	} else if m != Magic {
+		return c.n, fmt.Errorf("%w: %x", ErrBadMagic, m)
+	}
+
+	v, err := ReadInt32(r)
+
+	switch {
+	case err != nil:
+		return c.n, fmt.Errorf("unable to read version number: %w", err)
+	case v != Ver && s.Strict:
+		return c.n, fmt.Errorf("%w %d, expecting %d", ErrVersionMismatch, v, Ver)
+	case v != Ver:
+		if _, ok := FrameCounts[v]; !ok {
+			s.warn("version", "unknown version number %d, expecting %d", v, Ver)
+		}
+	}
+
+	fs := make([]*Frame, FrameCount(v))
+
+	for i := range fs {
+		if fs[i], err = readSize(r); err != nil {
+			return c.n, fmt.Errorf("%s: %w", FrameRegion(i), err)
+		}
+	}
+
+	br := bufio.NewReader(r)
+	r = br
+
+	if _, ok := DefaultCodec.(LZ4Block); ok {
+		if s.Padding, err = SkipPadding(br, fs[0].SizeCom); err != nil {
+			return c.n, fmt.Errorf("unable to read padding: %w", err)
+		}
+	}
+
+	if s.Padding > 0 {
+		s.warn("padding", "%d bytes before the info frame", s.Padding)
+	}
+
+	for i, f := range fs {
+		if err := s.readFrame(r, FrameRegion(i), f, !s.Raw); err != nil {
+			return c.n, fmt.Errorf("%s: %w", FrameRegion(i), err)
+		}
+	}
+
+	s.Info, s.Data, s.Version = fs[0], fs[1], v
+
+	if len(fs) > 2 {
+		s.Extra = fs[2:]
+	}
+
+	if s.Trailing, err = io.ReadAll(r); err != nil {
+		return c.n, fmt.Errorf("unable to read trailing bytes: %w", err)
+	}
+
+	if len(s.Trailing) == 0 {
+		s.Trailing = nil
+	} else {
+		s.warn("trailing", "%d bytes after the data frame", len(s.Trailing))
+	}
+
+	for i, f := range fs {
+		if !f.isEncoded {
+			s.checkPadding(FrameRegion(i), f)
+		}
+	}
+
+	return c.n, nil
+}
+
+// WriteTo encodes the frames of s, calling the hooks of s, and writes the save
+// file with any trailing bytes. It returns the number of bytes written and
+// implements io.WriterTo. The frames of s are left decoded; frames that are
+// already encoded are written as they are. WriteTo fails when the number of
+// frames does not match the version of s.
+func (s *SaveFile) WriteTo(w io.Writer) (int64, error) {
+	v, fs, err := s.encodedFrames()
+	if err != nil {
+		return 0, err
+	}
+
+	c := &countWriter{w: w}
+
+	table := []int32{Magic, v}
+
+	for _, f := range fs {
+		table = append(table, f.SizeCom, f.SizeRaw)
+	}
+
+	for _, v := range table {
+		if err := WriteInt32(c, v); err != nil {
+			return c.n, err
+		}
+	}
+
+	if _, err := c.Write(make([]byte, s.Padding)); err != nil {
+		return c.n, err
+	}
+
+	for _, f := range fs {
+		if _, err := c.Write(f.Bytes()); err != nil {
+			return c.n, err
+		}
+	}
+
+	if _, err := c.Write(s.Trailing); err != nil {
+		return c.n, err
+	}
+
+	return c.n, nil
+}
+
+// RawFrames returns the frames of s as WriteTo writes them, in order. Frames
+// read with Raw are returned without decoding or encoding them; decoded frames
+// are encoded as by WriteTo. The bytes of encoded frames are shared with s.
+func (s *SaveFile) RawFrames() ([]RawFrame, error) {
+	_, fs, err := s.encodedFrames()
+	if err != nil {
+		return nil, err
+	}
+
+	rs := make([]RawFrame, len(fs))
+
+	for i, f := range fs {
+		rs[i] = RawFrame{f.SizeCom, f.SizeRaw, f.Bytes()}
+	}
+
+	return rs, nil
+}
+
+// encodedFrames returns the version of s and its frames, encoding copies of
+// the decoded frames and calling the hooks of s.
+func (s *SaveFile) encodedFrames() (int32, []*Frame, error) {
+	v := s.Version
+
+	if v == 0 {
+		v = Ver
+	}
+
+	fs := append([]*Frame{s.Info, s.Data}, s.Extra...)
+
+	if n := FrameCount(v); len(fs) != n {
+		return 0, nil, fmt.Errorf("%d frames, expecting %d for version %d", len(fs), n, v)
+	}
+
+	for i, f := range fs {
+		if f.isEncoded {
+			continue
+		}
+
+		region := FrameRegion(i)
+
+		if err := callHook(s.Hooks.before(encoding), region, f); err != nil {
+			return 0, nil, fmt.Errorf("%s: %w", region, err)
+		}
+
+		e := &Frame{SizeRaw: int32(f.Len()), Level: f.Level, Codec: f.Codec}
+		e.Write(f.Bytes())
+
+		if err := e.Encode(); err != nil {
+			return 0, nil, fmt.Errorf("%s: %w", region, err)
+		}
+
+		if err := callHook(s.Hooks.after(encoding), region, e); err != nil {
+			return 0, nil, fmt.Errorf("%s: %w", region, err)
+		}
+
+		fs[i] = e
+	}
+
+	return v, fs, nil
+}
+
+// CheckKeys adds a warning for every key repeated within an object of the
+// documents. Decoders keep one value of such a key, so the game may have relied
+// on either. CheckKeys reads both documents in full, so ReadSaveFile leaves it
+// to the caller. The info and data frames must be decoded.
+func (s *SaveFile) CheckKeys() error {
+	for _, d := range []struct {
+		region string
+		f      *Frame
+	}{{"info frame", s.Info}, {"data frame", s.Data}} {
+		ps, err := jsonpath.Duplicates(d.f.Reader())
+		if err != nil {
+			return fmt.Errorf("%s: %w", d.region, err)
+		}
+
+		for _, p := range ps {
+			s.warn(d.region, "duplicate key %s", p)
+		}
+	}
+
+	return nil
+}
+
+// checkPadding warns about NUL bytes after the document of a frame, which make
+// its size larger than the document.
+func (s *SaveFile) checkPadding(region string, f *Frame) {
+	b := f.Bytes()
+
+	if n := len(b) - len(bytes.TrimRight(b, "\x00")); n > 0 {
+		s.warn(region, "%d bytes of padding after the document", n)
+	}
+}
+
+// readSize reads the sizes of a frame.
+func readSize(r io.Reader) (*Frame, error) {
+	f := new(Frame)
+
+	enc, err := ReadInt32(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read encoded size: %w", err)
+	}
+
+	unc, err := ReadInt32(r)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read unencoded size: %w", err)
+	}
+
+	if enc < 0 || unc < 0 {
+		return nil, fmt.Errorf("negative size: %d encoded, %d unencoded", enc, unc)
+	}
+
+	f.SizeCom, f.SizeRaw, f.isEncoded = enc, unc, true
+
+	return f, nil
+}
+
+// readFrame reads the encoded content of a frame and, with decode, decodes it,
+// calling the hooks of s.
+func (s *SaveFile) readFrame(r io.Reader, region string, f *Frame, decode bool) error {
+	if n, err := io.CopyN(f, r, int64(f.SizeCom)); err == io.EOF {
+		return &SizeMismatchError{Want: int64(f.SizeCom), Got: n}
+	} else if err != nil {
+		return fmt.Errorf("unable to read encoded bytes: %w", err)
+	}
+
+	if !decode {
+		return nil
+	}
+
+	if err := callHook(s.Hooks.before(decoding), region, f); err != nil {
+		return err
+	}
+
+	if err := f.Decode(); err != nil {
+		return err
+	}
+
+	return callHook(s.Hooks.after(decoding), region, f)
+}
@@ -0,0 +1,186 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+//go:build stress
+// +build stress
+
+package mmse_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"runtime/metrics"
+	"testing"
+	"time"
+
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+	"github.com/mys721tx/mmse-go/pkg/mmse/mmsetest"
+)
+
+// stressDrivers makes a synthetic data document of about 500 MiB.
+const stressDrivers = 3950000
+
+// Ceilings on the peak memory of operations on the stress save, in multiples
+// of the size of its data document. They leave some room above the peaks
+// measured when they were set: about 3 for reading, which keeps the encoded
+// and the decoded frame while growing the buffer, 4 for writing, which
+// encodes a copy of each frame, and 1 for copying a save read with Raw. Lower
+// them when an operation needs less.
+const (
+	readCeiling  = 3.5
+	writeCeiling = 4.5
+	rawCeiling   = 1.5
+)
+
+// memorySamples are the metrics whose difference approximates the resident
+// memory of the runtime: the memory mapped minus the memory returned to the
+// operating system.
+var memorySamples = []metrics.Sample{
+	{Name: "/memory/classes/total:bytes"},
+	{Name: "/memory/classes/heap/released:bytes"},
+}
+
+// resident returns the approximate resident memory of the runtime.
+func resident() uint64 {
+	metrics.Read(memorySamples)
+
+	return memorySamples[0].Value.Uint64() - memorySamples[1].Value.Uint64()
+}
+
+// peakMemory runs fn and returns the peak resident memory while it runs,
+// above the memory in use before it.
+func peakMemory(fn func()) uint64 {
+	debug.FreeOSMemory()
+
+	base := resident()
+	peak := base
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		t := time.NewTicker(time.Millisecond)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if m := resident(); m > peak {
+					peak = m
+				}
+			}
+		}
+	}()
+
+	fn()
+
+	close(done)
+	<-stopped
+
+	if m := resident(); m > peak {
+		peak = m
+	}
+
+	return peak - base
+}
+
+// stressSave writes a save of a large synthetic data document to a temporary
+// file and returns its name and the size of the document.
+func stressSave(t *testing.T) (string, int) {
+	data := mmsetest.Data(mmsetest.Options{Drivers: stressDrivers})
+	n := len(data)
+
+	fn := filepath.Join(t.TempDir(), "stress.sav")
+
+	if err := os.WriteFile(fn, mmsetest.Save(mmsetest.Info(mmsetest.Options{}), data, 0), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return fn, n
+}
+
+// checkCeiling fails the test when the peak memory of an operation exceeds
+// ceiling times the size of the document.
+func checkCeiling(t *testing.T, op string, peak uint64, n int, ceiling float64) {
+	ratio := float64(peak) / float64(n)
+
+	t.Logf("%s: peak %d MiB for a %d MiB document, %.2f times", op, peak>>20, n>>20, ratio)
+
+	if ratio > ceiling {
+		t.Errorf("%s: peak memory %.2f times the document exceeds %.2f", op, ratio, ceiling)
+	}
+}
+
+func TestStressMemory(t *testing.T) {
+	fn, n := stressSave(t)
+
+	var s *mmse.SaveFile
+
+	peak := peakMemory(func() {
+		f, err := os.Open(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer f.Close()
+
+		if s, err = mmse.ReadSaveFile(f); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	checkCeiling(t, "read", peak, n, readCeiling)
+
+	peak = peakMemory(func() {
+		if _, err := s.WriteTo(io.Discard); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	checkCeiling(t, "write", peak, n, writeCeiling)
+
+	peak = peakMemory(func() {
+		raw := &mmse.SaveFile{Raw: true}
+
+		f, err := os.Open(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer f.Close()
+
+		if _, err := raw.ReadFrom(f); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := raw.WriteTo(io.Discard); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	checkCeiling(t, "raw copy", peak, n, rawCeiling)
+
+	if !bytes.HasPrefix(s.Data.Bytes(), []byte(`{"season"`)) {
+		t.Error("The data document should survive the round trip.")
+	}
+}
@@ -0,0 +1,263 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package vdf reads and writes the text KeyValues format used by Steam for
+// files such as remotecache.vdf.
+package vdf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Node is a key with either a string value or child nodes. The order of
+// children is kept.
+type Node struct {
+	Key      string
+	Value    string
+	Children []*Node
+	// IsMap is true when the node holds children rather than a value.
+	IsMap bool
+}
+
+// Child returns the first child with key k, compared case-insensitively as
+// Steam does, or nil.
+func (n *Node) Child(k string) *Node {
+	for _, c := range n.Children {
+		if strings.EqualFold(c.Key, k) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// Get returns the value of child k, or an empty string.
+func (n *Node) Get(k string) string {
+	if c := n.Child(k); c != nil {
+		return c.Value
+	}
+
+	return ""
+}
+
+// Set sets the value of child k, appending it when missing.
+func (n *Node) Set(k, v string) {
+	if c := n.Child(k); c != nil {
+		c.Value = v
+		return
+	}
+
+	n.Children = append(n.Children, &Node{Key: k, Value: v})
+}
+
+// Parse reads a document. The returned node is a map holding the top level
+// keys.
+func Parse(r io.Reader) (*Node, error) {
+	p := &parser{r: bufio.NewReader(r), line: 1}
+
+	root := &Node{IsMap: true}
+
+	if err := p.parseMap(root, true); err != nil {
+		return nil, fmt.Errorf("line %d: %s", p.line, err)
+	}
+
+	return root, nil
+}
+
+// parser reads tokens of a document.
+type parser struct {
+	r    *bufio.Reader
+	line int
+}
+
+// parseMap reads key/value pairs into n until a closing brace, or the end of
+// the document when top is true.
+func (p *parser) parseMap(n *Node, top bool) error {
+	for {
+		k, tok, err := p.token()
+
+		switch {
+		case err == io.EOF && top:
+			return nil
+		case err != nil:
+			return err
+		case tok == '}' && !top:
+			return nil
+		case tok != 0:
+			return fmt.Errorf("unexpected %q", tok)
+		}
+
+		v, tok, err := p.token()
+
+		switch {
+		case err == io.EOF:
+			return io.ErrUnexpectedEOF
+		case err != nil:
+			return err
+		case tok == '{':
+			c := &Node{Key: k, IsMap: true}
+
+			if err := p.parseMap(c, false); err != nil {
+				return err
+			}
+
+			n.Children = append(n.Children, c)
+		case tok != 0:
+			return fmt.Errorf("unexpected %q", tok)
+		default:
+			n.Children = append(n.Children, &Node{Key: k, Value: v})
+		}
+	}
+}
+
+// token returns the next string, or the brace in tok. Comments and
+// conditionals such as [$WIN32] are skipped.
+func (p *parser) token() (string, byte, error) {
+	for {
+		c, err := p.r.ReadByte()
+		if err != nil {
+			return "", 0, err
+		}
+
+		switch c {
+		case '\n':
+			p.line++
+		case ' ', '\t', '\r':
+		case '{', '}':
+			return "", c, nil
+		case '[':
+			if _, err := p.r.ReadString(']'); err != nil {
+				return "", 0, err
+			}
+		case '/':
+			if b, _ := p.r.Peek(1); len(b) == 1 && b[0] == '/' {
+				if _, err := p.r.ReadString('\n'); err != nil && err != io.EOF {
+					return "", 0, err
+				}
+
+				p.line++
+
+				continue
+			}
+
+			fallthrough
+		default:
+			if c == '"' {
+				return p.quoted()
+			}
+
+			return p.bare(c)
+		}
+	}
+}
+
+// quoted reads the rest of a quoted string.
+func (p *parser) quoted() (string, byte, error) {
+	var b strings.Builder
+
+	for {
+		c, err := p.r.ReadByte()
+		if err == io.EOF {
+			return "", 0, fmt.Errorf("unterminated string")
+		} else if err != nil {
+			return "", 0, err
+		}
+
+		switch c {
+		case '"':
+			return b.String(), 0, nil
+		case '\\':
+			e, err := p.r.ReadByte()
+			if err != nil {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+
+			switch e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(e)
+			}
+		case '\n':
+			p.line++
+			fallthrough
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// bare reads an unquoted string starting with c.
+func (p *parser) bare(c byte) (string, byte, error) {
+	var b strings.Builder
+
+	b.WriteByte(c)
+
+	for {
+		c, err := p.r.ReadByte()
+		if err == io.EOF {
+			return b.String(), 0, nil
+		} else if err != nil {
+			return "", 0, err
+		}
+
+		switch c {
+		case ' ', '\t', '\r', '\n', '{', '}', '"':
+			return b.String(), 0, p.r.UnreadByte()
+		}
+
+		b.WriteByte(c)
+	}
+}
+
+// Write writes a document in the layout used by Steam.
+func Write(w io.Writer, n *Node) error {
+	bw := bufio.NewWriter(w)
+
+	for _, c := range n.Children {
+		write(bw, c, 0)
+	}
+
+	return bw.Flush()
+}
+
+// write writes a node at an indentation depth.
+func write(w *bufio.Writer, n *Node, depth int) {
+	ind := strings.Repeat("\t", depth)
+
+	if !n.IsMap {
+		fmt.Fprintf(w, "%s%s\t\t%s\n", ind, quote(n.Key), quote(n.Value))
+		return
+	}
+
+	fmt.Fprintf(w, "%s%s\n%s{\n", ind, quote(n.Key), ind)
+
+	for _, c := range n.Children {
+		write(w, c, depth+1)
+	}
+
+	fmt.Fprintf(w, "%s}\n", ind)
+}
+
+// quote quotes and escapes a string.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
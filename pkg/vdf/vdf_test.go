@@ -0,0 +1,92 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package vdf_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/mys721tx/mmse-go/pkg/vdf"
+)
+
+const remotecache = `"415200"
+{
+	"ChangeNumber"		"42"
+	"Saves/game.sav"
+	{
+		"root"		"2"
+		"size"		"1024"
+		"sha"		"da39a3ee5e6b4b0d3255bfef95601890afd80709"
+	}
+}
+`
+
+func TestParseWrite(t *testing.T) {
+	n, err := vdf.Parse(strings.NewReader(remotecache))
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	app := n.Child("415200")
+
+	if assert.NotNil(t, app) {
+		assert.Equal(t, "42", app.Get("changenumber"), "Keys are case-insensitive.")
+		assert.Equal(t, "1024", app.Child("Saves/game.sav").Get("size"))
+	}
+
+	b := new(bytes.Buffer)
+
+	if assert.NoError(t, vdf.Write(b, n)) {
+		assert.Equal(
+			t, remotecache, b.String(),
+			"Write should reproduce the layout used by Steam.",
+		)
+	}
+}
+
+func TestParseCommentsAndEscapes(t *testing.T) {
+	in := "// comment\n\"a\" { \"b\" \"x\\\"y\" [$WIN32] c d }\n"
+
+	n, err := vdf.Parse(strings.NewReader(in))
+
+	if assert.NoError(t, err) {
+		a := n.Child("a")
+
+		assert.Equal(t, `x"y`, a.Get("b"))
+		assert.Equal(t, "d", a.Get("c"))
+	}
+}
+
+func TestParseUnterminated(t *testing.T) {
+	_, err := vdf.Parse(strings.NewReader("\"a\"\n{\n\"b\" \"c\"\n"))
+
+	assert.Error(t, err, "Parse should fail on unclosed maps.")
+}
+
+func TestSet(t *testing.T) {
+	n := &vdf.Node{IsMap: true}
+
+	n.Set("size", "1")
+	n.Set("SIZE", "2")
+
+	assert.Len(t, n.Children, 1)
+	assert.Equal(t, "2", n.Get("size"))
+}
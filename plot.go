@@ -0,0 +1,281 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// plotMetric is the metric to plot, a name or name=path.
+	plotMetric string
+	// plotOutput is the output format of plot.
+	plotOutput = "svg"
+	// plotFile is the file written by plot.
+	plotFile string
+)
+
+// point is a numeric metric of one save.
+type point struct {
+	Save  int     `json:"save"`
+	File  string  `json:"file"`
+	Day   float64 `json:"day"`
+	Value float64 `json:"value"`
+}
+
+func init() {
+	register(&command{
+		name:  "plot",
+		args:  "-metric <name> [savedir]",
+		short: "chart a metric across the saves of a directory",
+		long: `
+Plot reads a metric from every save in a directory, as stats does, and charts
+it across the saves in the order they were written. The metric is a name from
+the metrics map of the configuration file, or name=path. Saves lacking the
+metric or holding a value that is not a number are left out.
+
+The chart is an SVG image, or with -output vega a Vega-Lite specification with
+the data inlined, which can be opened in the Vega editor or rendered with the
+Vega command line tools. The chart is written to standard output, or to the
+file given by -o.`,
+		example: `
+mmse plot -metric balance -o balance.svg
+mmse plot -metric balance=data.playerTeam.financeBalance -output vega ~/saves`,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&plotMetric, "metric", "", "metric to plot, as `name` or name=path")
+			fs.StringVar(&plotOutput, "output", plotOutput, "output format: svg or vega")
+			fs.StringVar(&plotFile, "o", "", "write the chart to `file`")
+			flagJobs(fs)
+			flagSaveDir(fs)
+		},
+		nargs: atMost(1),
+		run:   runPlot,
+	})
+}
+
+// runPlot runs the plot command.
+func runPlot(args []string) {
+	dir := cfg.SaveDir
+
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	if dir == "" {
+		log.Panicf("No save directory given")
+	}
+
+	name, path := plotMetric, ""
+
+	if i := strings.IndexByte(plotMetric, '='); i >= 0 {
+		name, path = plotMetric[:i], plotMetric[i+1:]
+	} else if p, ok := cfg.Metrics[name]; ok {
+		path = p
+	}
+
+	if name == "" || path == "" {
+		log.Panicf("Unknown metric %q; use -metric name=path or the metrics map", plotMetric)
+	}
+
+	var ps []point
+
+	for i, s := range collect(dir, map[string]string{name: path}) {
+		v, err := strconv.ParseFloat(metricText(s.Metrics[name]), 64)
+		if err != nil {
+			continue
+		}
+
+		ps = append(ps, point{Save: i + 1, File: s.File, Day: s.Day, Value: v})
+	}
+
+	if len(ps) == 0 {
+		log.Panicf("No save in %s has a numeric %s", dir, name)
+	}
+
+	var w io.Writer = os.Stdout
+
+	if plotFile != "" {
+		f, err := os.Create(plotFile)
+		if err != nil {
+			log.Panicf("Unable to create %s: %s", plotFile, err)
+		}
+
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Panicf("Unable to close %s: %s", plotFile, err)
+			}
+		}()
+
+		w = f
+	}
+
+	var err error
+
+	switch plotOutput {
+	case "svg":
+		err = writeSVG(w, name, ps)
+	case "vega":
+		err = writeVega(w, name, ps)
+	default:
+		log.Panicf("Unknown output format: %s", plotOutput)
+	}
+
+	if err != nil {
+		log.Panicf("Unable to write chart: %s", err)
+	}
+}
+
+// writeVega writes a Vega-Lite specification of a line chart.
+func writeVega(w io.Writer, name string, ps []point) error {
+	spec := map[string]interface{}{
+		"$schema": "https://vega.github.io/schema/vega-lite/v4.json",
+		"title":   name,
+		"width":   640,
+		"height":  320,
+		"data":    map[string]interface{}{"values": ps},
+		"mark":    map[string]interface{}{"type": "line", "point": true},
+		"encoding": map[string]interface{}{
+			"x": map[string]interface{}{"field": "save", "type": "quantitative", "title": "save"},
+			"y": map[string]interface{}{"field": "value", "type": "quantitative", "title": name},
+			"tooltip": []map[string]interface{}{
+				{"field": "file", "type": "nominal"},
+				{"field": "value", "type": "quantitative"},
+			},
+		},
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+
+	return e.Encode(spec)
+}
+
+// niceStep returns a round step of about span/n.
+func niceStep(span float64, n int) float64 {
+	if span <= 0 {
+		return 1
+	}
+
+	raw := span / float64(n)
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+
+	for _, m := range []float64{1, 2, 5} {
+		if raw <= m*mag {
+			return m * mag
+		}
+	}
+
+	return 10 * mag
+}
+
+// writeSVG writes an SVG line chart.
+func writeSVG(w io.Writer, name string, ps []point) error {
+	const (
+		width, height = 800, 400
+		left, right   = 90, 20
+		top, bottom   = 40, 50
+	)
+
+	lo, hi := ps[0].Value, ps[0].Value
+
+	for _, p := range ps {
+		lo, hi = math.Min(lo, p.Value), math.Max(hi, p.Value)
+	}
+
+	step := niceStep(hi-lo, 5)
+	lo, hi = math.Floor(lo/step)*step, math.Ceil(hi/step)*step
+
+	if hi == lo {
+		hi = lo + step
+	}
+
+	x := func(i int) float64 {
+		if len(ps) == 1 {
+			return left + (width-left-right)/2
+		}
+
+		return left + float64(i)*(width-left-right)/float64(len(ps)-1)
+	}
+
+	y := func(v float64) float64 {
+		return height - bottom - (v-lo)*(height-top-bottom)/(hi-lo)
+	}
+
+	esc := func(s string) string {
+		b := new(strings.Builder)
+		xml.EscapeText(b, []byte(s))
+		return b.String()
+	}
+
+	b := bufio.NewWriter(w)
+
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" `+
+		`viewBox="0 0 %d %d" font-family="sans-serif" font-size="12">`+"\n",
+		width, height, width, height)
+	fmt.Fprintf(b, `<rect width="%d" height="%d" fill="white"/>`+"\n", width, height)
+	fmt.Fprintf(b, `<text x="%d" y="24" font-size="16">%s</text>`+"\n", left, esc(name))
+
+	// Tick labels show as many decimals as the step needs.
+	prec := int(math.Max(0, -math.Floor(math.Log10(step))))
+
+	for k := 0; k <= int(math.Round((hi-lo)/step)); k++ {
+		v := lo + float64(k)*step
+
+		fmt.Fprintf(b, `<line x1="%d" x2="%d" y1="%.1f" y2="%.1f" stroke="#ddd"/>`+"\n",
+			left, width-right, y(v), y(v))
+		fmt.Fprintf(b, `<text x="%d" y="%.1f" text-anchor="end">%s</text>`+"\n",
+			left-6, y(v)+4, strconv.FormatFloat(v, 'f', prec, 64))
+	}
+
+	fmt.Fprintf(b, `<text x="%d" y="%d" text-anchor="middle">save</text>`+"\n",
+		(width+left-right)/2, height-10)
+
+	for i, p := range ps {
+		if i == 0 || i == len(ps)-1 || len(ps) <= 20 {
+			fmt.Fprintf(b, `<text x="%.1f" y="%d" text-anchor="middle">%d</text>`+"\n",
+				x(i), height-bottom+18, p.Save)
+		}
+	}
+
+	fmt.Fprintf(b, `<polyline fill="none" stroke="#1f77b4" stroke-width="2" points="`)
+
+	for i, p := range ps {
+		fmt.Fprintf(b, "%.1f,%.1f ", x(i), y(p.Value))
+	}
+
+	fmt.Fprintf(b, `"/>`+"\n")
+
+	for i, p := range ps {
+		fmt.Fprintf(b, `<circle cx="%.1f" cy="%.1f" r="3" fill="#1f77b4">`+
+			`<title>%s: %s</title></circle>`+"\n",
+			x(i), y(p.Value), esc(p.File), strconv.FormatFloat(p.Value, 'f', -1, 64))
+	}
+
+	fmt.Fprintf(b, "</svg>\n")
+
+	return b.Flush()
+}
@@ -0,0 +1,187 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonconv"
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+// toClipboard copies the value printed by get to the clipboard.
+var toClipboard bool
+
+// pathHelp describes the paths accepted by the query commands.
+const pathHelp = `
+A path starts with the document, info or data, followed by keys separated by
+dots and array indices in brackets, such as data.drivers[3].name. Keys that
+are not plain identifiers are quoted, such as data.stats["top speed"]. A path
+may also start with an alias from the configuration file, so that with the
+alias money for data.playerTeam.financeBalance, the path money stands for the
+balance. See "mmse help config".`
+
+func init() {
+	register(&command{
+		name:  "get",
+		args:  "<game.sav> <path>",
+		short: "print a value from a save file",
+		long: `
+Get prints the value at a path in a save file as JSON. The document is read
+token by token and reading stops after the value, so looking up a field does
+not parse the whole data document.
+
+With -clipboard, the value is also copied to the clipboard, strings without
+their quotes, for pasting into a spreadsheet or chat. The clipboard is reached
+through pbcopy on macOS, PowerShell on Windows, and wl-copy, xclip, or xsel
+elsewhere.
+` + pathHelp,
+		example: `
+mmse get game.sav data.drivers[0].name
+mmse get -pretty game.sav data.drivers[0]
+mmse get -clipboard game.sav data.drivers[0].name`,
+		flags: func(fs *flag.FlagSet) {
+			flagPretty(fs)
+			flagSaveDir(fs)
+			fs.BoolVar(&toClipboard, "clipboard", false, "also copy the value to the clipboard")
+		},
+		nargs: exactly(2),
+		run: func(args []string) {
+			b := query(args[0], args[1])
+
+			if cfg.Pretty {
+				c := new(bytes.Buffer)
+
+				if err := json.Indent(c, b, "", "  "); err != nil {
+					log.Panicf("Unable to indent value: %s", err)
+				}
+
+				b = c.Bytes()
+			}
+
+			if toClipboard {
+				if err := copyClipboard(b); err != nil {
+					log.Panicf("Unable to copy to the clipboard: %s", err)
+				}
+			}
+
+			os.Stdout.Write(append(b, '\n'))
+		},
+	})
+
+	register(&command{
+		name:  "extract",
+		args:  "<game.sav> <path> <output>",
+		short: "write a part of a save file to a document",
+		long: `
+Extract writes the value at a path in a save file to a JSON, YAML, or TOML
+document, chosen by the extension of the output. Like get, extract stops
+reading after the value.
+` + pathHelp,
+		example: `
+mmse extract game.sav data.drivers drivers.json
+mmse extract game.sav data.drivers[0] driver.yaml`,
+		flags: func(fs *flag.FlagSet) {
+			flagPretty(fs)
+			flagSaveDir(fs)
+		},
+		nargs: exactly(3),
+		run: func(args []string) {
+			f := new(mmse.Frame)
+			f.Write(query(args[0], args[1]))
+
+			writeDoc(args[2], f, jsonconv.FormatOf(args[2]))
+		},
+	})
+}
+
+// openSave reads and decodes a save file.
+func openSave(fn string) *mmse.SaveFile {
+	fn = findSave(fn)
+
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Panicf("Unable to open %s: %s", fn, err)
+	}
+
+	defer f.Close()
+
+	s, err := mmse.ReadSaveFile(bufio.NewReader(f))
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	return s
+}
+
+// docPath parses a path starting with the document name and returns the
+// index of the document, 0 for info and 1 for data, and the rest of the path.
+func docPath(path string) (int, jsonpath.Path) {
+	p, err := jsonpath.Parse(path)
+	if err != nil {
+		log.Panicf("Invalid path: %s", err)
+	}
+
+	if len(p) > 0 && !p[0].IsIndex {
+		if a, ok := cfg.Aliases[p[0].Key]; ok {
+			q, err := jsonpath.Parse(a)
+			if err != nil {
+				log.Panicf("Invalid alias %s: %s", p[0].Key, err)
+			}
+
+			p = append(q, p[1:]...)
+		}
+
+		switch p[0].Key {
+		case "info":
+			return 0, p[1:]
+		case "data":
+			return 1, p[1:]
+		}
+	}
+
+	log.Panicf("Path %s does not start with info or data", path)
+
+	return 0, nil
+}
+
+// query returns the value at a path in a save file as compact JSON.
+func query(fn, path string) []byte {
+	s := openSave(fn)
+	i, p := docPath(path)
+
+	f := []*mmse.Frame{s.Info, s.Data}[i]
+
+	b := new(bytes.Buffer)
+
+	ok, err := jsonpath.Extract(f.Reader(), p, b)
+
+	switch {
+	case err != nil:
+		log.Panicf("Unable to read %s: %s", path, err)
+	case !ok:
+		log.Panicf("%s is not in %s", path, fn)
+	}
+
+	return b.Bytes()
+}
@@ -0,0 +1,211 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonconv"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+// scan finds frames by signature instead of trusting the size fields.
+var scan bool
+
+func init() {
+	register(&command{
+		name:  "recover",
+		args:  "<game.sav>",
+		short: "extract what is left of a damaged save file",
+		long: `
+Recover extracts as much as possible from a truncated or partially corrupted
+save file. Frames cut short are decompressed up to the damage, and each
+document is cut after its last complete value, with the open objects and arrays
+closed, so that the output is valid JSON. Recover reports what was lost.
+
+When the header or the size fields are damaged, -scan searches the file for
+compressed blocks that decode to a JSON object instead, and takes the first two
+found as the info and the data frame.
+
+The documents are named as by unpack. A recovered document is usually missing
+data the game needs; inspect it before packing it into a save. See also
+"mmse help inspect".`,
+		example: `
+mmse recover game.sav
+mmse recover -scan game.sav`,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(
+				&scan, "scan", false,
+				"find frames by signature instead of trusting the header",
+			)
+			flagFormat(fs)
+			flagPretty(fs)
+			flagSaveDir(fs)
+		},
+		nargs: exactly(1),
+		run:   runRecover,
+	})
+}
+
+// runRecover runs the recover command.
+func runRecover(args []string) {
+	fn := findSave(args[0])
+	bn := split(filepath.Base(fn))
+
+	ft, err := jsonconv.ParseFormat(cfg.Format)
+	if err != nil {
+		log.Panicf("%s", err)
+	}
+
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	var docs [][]byte
+
+	if scan {
+		docs = carveFrames(b)
+	} else {
+		docs = headerFrames(fn, b)
+	}
+
+	n := names{Name: bn, Ext: ft.Ext()}
+
+	for i, name := range []string{"info", "data"} {
+		if i >= len(docs) || docs[i] == nil {
+			continue
+		}
+
+		rep, cut := mmse.RepairJSON(docs[i])
+
+		if rep == nil {
+			fmt.Printf("%s: no complete JSON value; nothing to recover\n", name)
+			continue
+		}
+
+		if cut > 0 {
+			fmt.Printf("%s: dropped %d bytes after the last complete value\n", name, cut)
+		}
+
+		f := new(mmse.Frame)
+		f.Write(rep)
+
+		out := outputName([]string{cfg.Info, cfg.Data}[i], n)
+
+		writeDoc(out, f, ft)
+
+		fmt.Printf("%s: wrote %s\n", name, out)
+	}
+}
+
+// headerFrames decodes the frames located by the size fields. A frame is nil
+// when its size fields are missing.
+func headerFrames(fn string, b []byte) [][]byte {
+	if len(b) < 8 {
+		log.Panicf("%s is too short to be a save file; try -scan", fn)
+	}
+
+	if m := mmse.DecodeLE[int32](b); m != mmse.Magic {
+		fmt.Printf("header: incorrect magic number %#x; continuing\n", uint32(m))
+	}
+
+	if v := mmse.DecodeLE[int32](b[4:]); v != mmse.Ver {
+		fmt.Printf("header: incorrect version number %d; continuing\n", v)
+	}
+
+	regions := make(map[string]mmse.Region)
+
+	for _, r := range mmse.Layout(b) {
+		regions[r.Name] = r
+	}
+
+	docs := make([][]byte, 2)
+
+	for i, name := range []string{"info", "data"} {
+		s := regions[name+" sizes"]
+		r := regions[name+" frame"]
+
+		if s.Missing > 0 {
+			fmt.Printf("%s: size fields missing; nothing to recover\n", name)
+			continue
+		}
+
+		raw := int(mmse.DecodeLE[int32](b[s.Offset+4:]))
+
+		if r.Missing > 0 {
+			fmt.Printf(
+				"%s: frame truncated, %d of %d encoded bytes present\n",
+				name, r.Length, r.Length+r.Missing,
+			)
+		}
+
+		doc, err := mmse.DecodePartial(b[r.Offset:r.Offset+r.Length], raw)
+
+		if err != nil {
+			fmt.Printf("%s: decompression stopped: %s\n", name, err)
+		}
+
+		fmt.Printf("%s: decoded %d of %d bytes\n", name, len(doc), raw)
+
+		docs[i] = doc
+	}
+
+	return docs
+}
+
+// carveFrames decodes the frames found by scanning for their signature. The
+// first frame found is taken as the info frame and the second as the data
+// frame.
+func carveFrames(b []byte) [][]byte {
+	cs := mmse.Scan(b)
+
+	if len(cs) == 0 {
+		fmt.Printf("scan: no frames found\n")
+	}
+
+	var docs [][]byte
+
+	for i, c := range cs {
+		fmt.Printf(
+			"scan: frame at offset %d, %d encoded bytes, %d decoded bytes",
+			c.Offset, c.Length, len(c.Doc),
+		)
+
+		if c.Err != nil {
+			fmt.Printf(", stopped: %s", c.Err)
+		}
+
+		if i >= 2 {
+			fmt.Printf(", ignored")
+		}
+
+		fmt.Println()
+
+		docs = append(docs, c.Doc)
+	}
+
+	if len(cs) == 1 {
+		fmt.Printf("scan: one frame found; it is written as the info document\n")
+	}
+
+	return docs
+}
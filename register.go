@@ -0,0 +1,129 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// shellKey is the registry key of the Explorer verbs of file types for the
+// current user. Verbs under SystemFileAssociations do not replace the program
+// opening the files.
+const shellKey = `HKCU\Software\Classes\SystemFileAssociations`
+
+// unregister removes the entries added by register.
+var unregister bool
+
+// verb is an Explorer context menu entry.
+type verb struct {
+	ext, name, label, command string
+}
+
+// verbs returns the context menu entries running mmse at path exe.
+func verbs(exe string) []verb {
+	vs := []verb{{".sav", "mmse.unpack", "Unpack with mmse", "unpack"}}
+
+	for _, ext := range []string{".json", ".yaml", ".yml", ".toml"} {
+		vs = append(vs, verb{ext, "mmse.pack", "Pack with mmse", "pack"})
+	}
+
+	for i := range vs {
+		vs[i].command = fmt.Sprintf(`"%s" %s "%%1"`, exe, vs[i].command)
+	}
+
+	return vs
+}
+
+func init() {
+	register(&command{
+		name:  "register",
+		short: "add Explorer context menu entries on Windows",
+		long: `
+Register adds "Unpack with mmse" to the context menu of .sav files and "Pack
+with mmse" to that of .json, .yaml, .yml, and .toml files in Windows Explorer,
+for the current user. Packing a document finds the other document by the
+output templates; see "mmse help pack". With -remove, the entries are removed.
+
+Saves and documents can also be dropped on mmse.exe: saves are unpacked and one
+or two documents are packed, whatever their names and order. When started from Explorer, mmse keeps its window
+open until Enter is pressed.
+
+Move mmse.exe to its final place before running register, since the entries
+run it from there.`,
+		example: `
+mmse register
+mmse register -remove`,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&unregister, "remove", false, "remove the entries")
+		},
+		nargs: exactly(0),
+		run:   runRegister,
+	})
+}
+
+// reg runs reg.exe.
+func reg(args ...string) error {
+	out, err := exec.Command("reg", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("reg %s: %s: %s", args[0], err, out)
+	}
+
+	return nil
+}
+
+// runRegister runs the register command.
+func runRegister([]string) {
+	if runtime.GOOS != "windows" {
+		log.Panicf("Register is only available on Windows")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Panicf("Unable to locate mmse: %s", err)
+	}
+
+	for _, v := range verbs(exe) {
+		k := shellKey + `\` + v.ext + `\shell\` + v.name
+
+		if unregister {
+			if err := reg("delete", k, "/f"); err != nil {
+				log.Printf("Warning: %s", err)
+			}
+
+			continue
+		}
+
+		if err := reg("add", k, "/ve", "/d", v.label, "/f"); err != nil {
+			log.Panicf("%s", err)
+		}
+
+		if err := reg("add", k+`\command`, "/ve", "/d", v.command, "/f"); err != nil {
+			log.Panicf("%s", err)
+		}
+	}
+
+	if unregister {
+		fmt.Println("Removed the Explorer entries")
+	} else {
+		fmt.Printf("Added the Explorer entries running %s\n", exe)
+	}
+}
@@ -0,0 +1,242 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"regexp"
+	"strconv"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+var (
+	// useRegexp takes the search pattern as a regular expression.
+	useRegexp bool
+	// ignoreCase makes the search case-insensitive.
+	ignoreCase bool
+	// searchContext is the number of characters shown around a match.
+	searchContext = 30
+)
+
+func init() {
+	register(&command{
+		name:  "search",
+		args:  "<game.sav> <pattern>",
+		short: "find where a string occurs in a save file",
+		long: `
+Search reads both documents of a save file token by token and prints the path
+of every key and value containing the pattern, with the text around the match.
+Numbers and booleans are matched by their text, so search also finds IDs.
+
+The pattern is a plain string, or with -regexp a regular expression in the
+syntax of Go's regexp package.`,
+		example: `
+mmse search game.sav Hamilton
+mmse search -i -regexp game.sav 'ham+ilton'`,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&useRegexp, "regexp", false, "take the pattern as a regular expression")
+			fs.BoolVar(&ignoreCase, "i", false, "ignore case")
+			fs.IntVar(&searchContext, "context", searchContext, "show `n` characters around matches")
+			flagSaveDir(fs)
+			flagOutput(fs, outputTable)
+		},
+		nargs: exactly(2),
+		run:   runSearch,
+	})
+
+	register(&command{
+		name:  "xref",
+		args:  "<game.sav> <id>",
+		short: "list every path holding an entity ID",
+		long: `
+Xref lists every path in a save file whose value is exactly an entity ID, as a
+number or as a string. A path whose last key is id, ID, or Id, or the keys in
+id_keys of the configuration file, is listed as the definition of the entity;
+the other paths are references to it. Check the references before deleting or
+replacing an entity.
+
+Unlike search, xref only lists whole values, so ID 12 does not match 123.`,
+		example: `
+mmse xref game.sav 1042`,
+		flags: func(fs *flag.FlagSet) {
+			flagSaveDir(fs)
+			flagOutput(fs, outputTable)
+		},
+		nargs: exactly(2),
+		run:   runXref,
+	})
+}
+
+// runSearch runs the search command.
+func runSearch(args []string) {
+	pat := args[1]
+
+	if !useRegexp {
+		pat = regexp.QuoteMeta(pat)
+	}
+
+	if ignoreCase {
+		pat = "(?i)" + pat
+	}
+
+	re, err := regexp.Compile(pat)
+	if err != nil {
+		log.Panicf("Invalid pattern: %s", err)
+	}
+
+	s := openSave(args[0])
+
+	l := &listing{cols: []string{"path", "in", "match"}}
+
+	for i, f := range []*mmse.Frame{s.Info, s.Data} {
+		doc := []string{"info", "data"}[i]
+
+		err := jsonpath.Walk(f.Reader(), func(p jsonpath.Path, t json.Token) error {
+			full := append(jsonpath.Path{jsonpath.Key(doc)}, p...)
+
+			// Keys are matched when the value under them is visited.
+			if len(p) > 0 && !p[len(p)-1].IsIndex {
+				if k := p[len(p)-1].Key; re.MatchString(k) {
+					l.add(full.String(), "key", snippet(re, k))
+				}
+			}
+
+			var v string
+
+			switch t := t.(type) {
+			case string:
+				v = t
+			case json.Number:
+				v = string(t)
+			case bool:
+				v = strconv.FormatBool(t)
+			default:
+				return nil
+			}
+
+			if re.MatchString(v) {
+				l.add(full.String(), "value", snippet(re, v))
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			log.Panicf("Unable to read %s document: %s", doc, err)
+		}
+	}
+
+	if l.n == 0 {
+		fail()
+	}
+
+	l.write()
+}
+
+// runXref runs the xref command.
+func runXref(args []string) {
+	id := args[1]
+
+	keys := idKeys()
+
+	s := openSave(args[0])
+
+	var defs, refs []string
+
+	for i, f := range []*mmse.Frame{s.Info, s.Data} {
+		doc := []string{"info", "data"}[i]
+
+		err := jsonpath.Walk(f.Reader(), func(p jsonpath.Path, t json.Token) error {
+			var v string
+
+			switch t := t.(type) {
+			case string:
+				v = t
+			case json.Number:
+				v = string(t)
+			default:
+				return nil
+			}
+
+			if v != id {
+				return nil
+			}
+
+			full := append(jsonpath.Path{jsonpath.Key(doc)}, p...).String()
+
+			if l := p[len(p)-1]; !l.IsIndex && keys[l.Key] {
+				defs = append(defs, full)
+			} else {
+				refs = append(refs, full)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			log.Panicf("Unable to read %s document: %s", doc, err)
+		}
+	}
+
+	if len(defs)+len(refs) == 0 {
+		fail()
+	}
+
+	l := &listing{cols: []string{"kind", "path"}}
+
+	for _, p := range defs {
+		l.add("definition", p)
+	}
+
+	for _, p := range refs {
+		l.add("reference", p)
+	}
+
+	l.write()
+}
+
+// snippet returns the first match of re in s with up to searchContext
+// characters on each side.
+func snippet(re *regexp.Regexp, s string) string {
+	m := re.FindStringIndex(s)
+
+	start, end := m[0]-searchContext, m[1]+searchContext
+	pre, post := "…", "…"
+
+	if start <= 0 {
+		start, pre = 0, ""
+	}
+
+	if end >= len(s) {
+		end, post = len(s), ""
+	}
+
+	// Keep the cut on rune boundaries.
+	for start > 0 && s[start]&0xc0 == 0x80 {
+		start--
+	}
+
+	for end < len(s) && s[end]&0xc0 == 0x80 {
+		end++
+	}
+
+	return pre + strconv.Quote(s[start:end]) + post
+}
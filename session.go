@@ -0,0 +1,396 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// sessionIdle is how long a session waits for a request before it closes
+// without writing the save.
+const sessionIdle = time.Hour
+
+// sessionTimeout is how long a session waits for a client to send a request
+// or read the reply, so that a client that stalls does not hold up the others.
+const sessionTimeout = 10 * time.Second
+
+func init() {
+	register(&command{
+		name:  "session",
+		args:  "open|serve|get|set|commit|close <game.sav> [<path> [<value>]]",
+		short: "edit a save through a background process",
+		long: `
+A session keeps a save decoded in a background process, so that many get and
+set operations do not each read and decompress the save. Open starts the
+session, get and set work as the commands of the same name against the
+session, commit writes the save once and ends the session, and close ends it
+without writing the save.
+
+The session listens on a Unix socket in the sessions directory next to the
+configuration file, so -config must name a file when there is no home
+directory. The flags given to open, such as -level and -backup, apply when the
+session commits. Requests are handled one at a time, and a client that does
+not send its request, or read the reply, within ten seconds is dropped. Commit
+refuses to write a save changed by another program since the session opened.
+A session closes without writing the save after an hour without requests.
+
+Serve runs the session of open in the foreground. Started by systemd with
+socket activation, serve listens on the socket passed by systemd instead, so
+that a front-end can connect to a socket set up by a socket unit.
+
+On Windows, sessions also listen on Unix sockets, which Windows supports since
+Windows 10 version 1803; they do not listen on named pipes, so front-ends
+connect to the socket file as on other systems.
+` + pathHelp,
+		example: `
+mmse session open game.sav
+mmse session get game.sav data.teams[0].budget
+mmse session set game.sav data.teams[0].budget 250000000
+mmse session commit game.sav`,
+		flags: func(fs *flag.FlagSet) {
+			flagLevel(fs)
+			flagBackup(fs)
+			flagSaveDir(fs)
+			flagForce(fs)
+			fs.BoolVar(&allowRisky, "allowrisky", false, "change fields documented as risky")
+			flagNoClamp(fs)
+		},
+		nargs: func(n int) bool { return n >= 2 && n <= 4 },
+		run:   runSession,
+	})
+}
+
+// request is a request sent to a session, one per connection.
+type request struct {
+	Op         string          `json:"op"`
+	Path       string          `json:"path,omitempty"`
+	Value      json.RawMessage `json:"value,omitempty"`
+	AllowRisky bool            `json:"allow_risky,omitempty"`
+	NoClamp    bool            `json:"no_clamp,omitempty"`
+}
+
+// reply is the reply of a session to a request.
+type reply struct {
+	// Output is written to the standard output and Log to the standard error.
+	Output string `json:"output,omitempty"`
+	Log    string `json:"log,omitempty"`
+	Failed bool   `json:"failed,omitempty"`
+}
+
+// sessionPath returns the path of the socket of the session of a save. The
+// path is absolute, so that the session is found from any directory.
+func sessionPath(fn string) string {
+	if cfgPath == "" {
+		log.Panicf("No configuration directory for the sessions; use -config")
+	}
+
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		log.Panicf("%s", err)
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(cfgPath))
+	if err != nil {
+		log.Panicf("%s", err)
+	}
+
+	h := sha256.Sum256([]byte(abs))
+
+	return filepath.Join(dir, "sessions", hex.EncodeToString(h[:8])+".sock")
+}
+
+// send sends a request to the session listening on sock.
+func send(sock string, req request) (reply, error) {
+	var rep reply
+
+	c, err := net.Dial("unix", sock)
+	if err != nil {
+		return rep, err
+	}
+
+	defer c.Close()
+
+	if err := json.NewEncoder(c).Encode(req); err != nil {
+		return rep, err
+	}
+
+	err = json.NewDecoder(c).Decode(&rep)
+
+	return rep, err
+}
+
+// openSession starts a session for a save in a new process and waits until it
+// listens on sock.
+func openSession(fn, sock string, args []string) {
+	if _, err := send(sock, request{Op: "ping"}); err == nil {
+		log.Panicf("A session is already open for %s", fn)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(sock), 0700); err != nil {
+		log.Panicf("Unable to create %s: %s", filepath.Dir(sock), err)
+	}
+
+	// A socket left by a session that did not exit cleanly blocks Listen.
+	if err := os.Remove(sock); err != nil && !os.IsNotExist(err) {
+		log.Panicf("Unable to remove %s: %s", sock, err)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		log.Panicf("Unable to find the mmse executable: %s", err)
+	}
+
+	logName := sock + ".log"
+
+	l, err := os.Create(logName)
+	if err != nil {
+		log.Panicf("Unable to create %s: %s", logName, err)
+	}
+
+	defer l.Close()
+
+	// The session runs the same command line with serve in place of open, so
+	// that it reads the same configuration and flags.
+	cmd := exec.Command(exe, append(append([]string(nil), args...), "serve", fn)...)
+	cmd.Stderr = l
+	detach(cmd)
+
+	if err := cmd.Start(); err != nil {
+		log.Panicf("Unable to start the session: %s", err)
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		_ = cmd.Wait()
+		close(done)
+	}()
+
+	for deadline := time.Now().Add(time.Minute); time.Now().Before(deadline); {
+		select {
+		case <-done:
+			b, _ := os.ReadFile(logName)
+			log.Panicf("The session exited:\n%s", b)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		if _, err := send(sock, request{Op: "ping"}); err == nil {
+			fmt.Printf("Opened a session for %s\n", fn)
+			return
+		}
+	}
+
+	log.Panicf("The session did not start; see %s", logName)
+}
+
+// serveSession loads a save and serves requests on sock until the session
+// commits, closes, or is idle for sessionIdle.
+func serveSession(fn, sock string) {
+	st, err := os.Stat(fn)
+	if err != nil {
+		log.Panicf("%s", err)
+	}
+
+	e := openRaw(fn)
+
+	l := listen(sock)
+
+	defer l.Close()
+
+	log.Printf("Session for %s listening on %s", fn, l.Addr())
+
+	for {
+		if err := l.SetDeadline(time.Now().Add(sessionIdle)); err != nil {
+			log.Panicf("%s", err)
+		}
+
+		c, err := l.Accept()
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			log.Printf("Closing the idle session for %s without writing it", fn)
+			return
+		} else if err != nil {
+			log.Panicf("%s", err)
+		}
+
+		var req request
+
+		if err := c.SetReadDeadline(time.Now().Add(sessionTimeout)); err != nil {
+			log.Panicf("%s", err)
+		}
+
+		if err := json.NewDecoder(c).Decode(&req); err != nil {
+			log.Printf("Invalid request: %s", err)
+			c.Close()
+
+			continue
+		}
+
+		rep := e.handle(req, st)
+
+		// The deadline starts after handling, which may write the save.
+		if err := c.SetWriteDeadline(time.Now().Add(sessionTimeout)); err != nil {
+			log.Panicf("%s", err)
+		}
+
+		if err := json.NewEncoder(c).Encode(rep); err != nil {
+			log.Printf("Unable to reply: %s", err)
+		}
+
+		c.Close()
+
+		if !rep.Failed && (req.Op == "commit" || req.Op == "close") {
+			return
+		}
+	}
+}
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const listenFDsStart = 3
+
+// listen returns the socket passed by systemd socket activation, or else
+// listens on sock.
+func listen(sock string) *net.UnixListener {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err == nil && pid == os.Getpid() {
+		if n, err := strconv.Atoi(os.Getenv("LISTEN_FDS")); err != nil || n != 1 {
+			log.Panicf("Expecting one socket from systemd, got %s", os.Getenv("LISTEN_FDS"))
+		}
+
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+
+		l, err := net.FileListener(os.NewFile(listenFDsStart, "LISTEN_FD_3"))
+		if err != nil {
+			log.Panicf("Unable to use the socket from systemd: %s", err)
+		}
+
+		u, ok := l.(*net.UnixListener)
+		if !ok {
+			log.Panicf("The socket from systemd is not a Unix socket")
+		}
+
+		return u
+	}
+
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		log.Panicf("Unable to listen on %s: %s", sock, err)
+	}
+
+	return l.(*net.UnixListener)
+}
+
+// handle handles a request to a session of a save whose file was st when the
+// session opened.
+func (e *rawSave) handle(req request, st os.FileInfo) reply {
+	out, logs := new(bytes.Buffer), new(bytes.Buffer)
+
+	// Requests are handled one at a time, so the log of each can be captured
+	// for the reply.
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	allowRisky, noClamp = req.AllowRisky, req.NoClamp
+
+	ok := try(func() {
+		switch req.Op {
+		case "ping", "close":
+		case "get", "set":
+			e.apply(op{name: req.Op, path: req.Path, value: req.Value}, out, "")
+		case "commit":
+			if now, err := os.Stat(e.fn); err != nil {
+				log.Panicf("%s", err)
+			} else if !now.ModTime().Equal(st.ModTime()) || now.Size() != st.Size() {
+				log.Panicf("%s changed since the session opened", e.fn)
+			}
+
+			e.write()
+		default:
+			log.Panicf("Unknown session action: %s", req.Op)
+		}
+	})
+
+	return reply{Output: out.String(), Log: logs.String(), Failed: !ok}
+}
+
+// runSession runs the session command.
+func runSession(args []string) {
+	fn := findSave(args[1])
+	sock := sessionPath(fn)
+
+	want := map[string]int{"open": 2, "serve": 2, "get": 3, "set": 4, "commit": 2, "close": 2}
+
+	if n, ok := want[args[0]]; !ok {
+		log.Panicf("Unknown session action: %s", args[0])
+	} else if len(args) != n {
+		log.Panicf("Session %s takes %d arguments", args[0], n-1)
+	}
+
+	switch args[0] {
+	case "open":
+		// The arguments of mmse before the action are the command and flags.
+		openSession(fn, sock, os.Args[1:len(os.Args)-len(args)])
+
+		return
+	case "serve":
+		serveSession(fn, sock)
+
+		return
+	}
+
+	req := request{Op: args[0], AllowRisky: allowRisky, NoClamp: noClamp}
+
+	if len(args) > 2 {
+		req.Path = args[2]
+	}
+
+	if len(args) > 3 {
+		req.Value = jsonValue(args[3])
+	}
+
+	rep, err := send(sock, req)
+	if err != nil {
+		log.Panicf("No session is open for %s: %s", fn, err)
+	}
+
+	fmt.Fprint(os.Stderr, rep.Log)
+	fmt.Print(rep.Output)
+
+	if rep.Failed {
+		fail()
+	}
+
+	switch args[0] {
+	case "commit":
+		fmt.Printf("Wrote %s and closed the session\n", fn)
+		warnCloud(fn)
+	case "close":
+		fmt.Printf("Closed the session for %s without writing it\n", fn)
+	}
+}
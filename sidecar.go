@@ -0,0 +1,189 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+// sidecarExt is the extension of the sidecar file written next to the data
+// document by unpack -sidecar.
+const sidecarExt = ".mmse.json"
+
+// flagSidecar registers the flag writing sidecar files on unpack.
+func flagSidecar(fs *flag.FlagSet) {
+	fs.BoolVar(
+		&cfg.Sidecar, "sidecar", cfg.Sidecar,
+		"describe the save unpacked in a sidecar file checked by pack",
+	)
+}
+
+// sidecar describes the save that a pair of documents was unpacked from.
+type sidecar struct {
+	// Save is the absolute path of the save.
+	Save string `json:"save"`
+	// Size and SHA256 are the size and checksum of the save.
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Version is the version number of the save.
+	Version int32 `json:"version"`
+	// Info and Data describe the documents.
+	Info sidecarDoc `json:"info"`
+	Data sidecarDoc `json:"data"`
+	// Mmse is the version of mmse that unpacked the save.
+	Mmse     string    `json:"mmse"`
+	Unpacked time.Time `json:"unpacked"`
+}
+
+// sidecarDoc describes a document unpacked from a frame.
+type sidecarDoc struct {
+	// Name is the file name of the document.
+	Name string `json:"name"`
+	// SizeCom and SizeRaw are the encoded and decoded sizes of the frame.
+	SizeCom int32 `json:"size_encoded"`
+	SizeRaw int32 `json:"size_decoded"`
+	// SHA256 is the checksum of the decoded frame.
+	SHA256 string `json:"sha256"`
+}
+
+// sidecarPath returns the sidecar file of a data document.
+func sidecarPath(dn string) string {
+	return filepath.Join(filepath.Dir(dn), split(filepath.Base(dn))+sidecarExt)
+}
+
+// newSidecar describes the documents in and dn unpacked from save fn. The
+// frames are described as in the save, before extracting blobs.
+func newSidecar(fn, in, dn string, s *mmse.SaveFile) *sidecar {
+	st, err := os.Stat(fn)
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	sum, err := hashFile(fn)
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		abs = fn
+	}
+
+	doc := func(name string, f *mmse.Frame) sidecarDoc {
+		return sidecarDoc{filepath.Base(name), f.SizeCom, f.SizeRaw, hashBytes(f.Bytes())}
+	}
+
+	return &sidecar{
+		Save:     abs,
+		Size:     st.Size(),
+		SHA256:   sum,
+		Version:  s.Version,
+		Info:     doc(in, s.Info),
+		Data:     doc(dn, s.Data),
+		Mmse:     version,
+		Unpacked: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// write writes the sidecar file of data document dn.
+func (sc *sidecar) write(dn string) {
+	p := sidecarPath(dn)
+
+	b, err := json.MarshalIndent(sc, "", "  ")
+	if err != nil {
+		log.Panicf("Unable to encode %s: %s", p, err)
+	}
+
+	if err := os.WriteFile(p, append(b, '\n'), 0644); err != nil {
+		log.Panicf("Unable to write %s: %s", p, err)
+	}
+}
+
+// readSidecar reads the sidecar file of a data document, if any.
+func readSidecar(dn string) (*sidecar, bool) {
+	p := sidecarPath(dn)
+
+	b, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, false
+	} else if err != nil {
+		log.Panicf("Unable to read %s: %s", p, err)
+	}
+
+	sc := new(sidecar)
+
+	if err := json.Unmarshal(b, sc); err != nil {
+		log.Panicf("Unable to read %s: %s", p, err)
+	}
+
+	return sc, true
+}
+
+// checkSidecar checks the documents to pack, as JSON, and the save to write
+// against the sidecar file of the data document. Pack refuses an info document
+// unpacked from another save, and a save that changed since it was unpacked,
+// whose changes it would discard, unless -force is given.
+func checkSidecar(in, dn string, ib, db []byte, sn string) {
+	sc, ok := readSidecar(dn)
+	if !ok {
+		return
+	}
+
+	p := sidecarPath(dn)
+
+	if filepath.Base(in) != sc.Info.Name && hashBytes(ib) != sc.Info.SHA256 && !force {
+		log.Panicf(
+			"%s records %s as the info document of %s, not %s; use -force to pack them together",
+			p, sc.Info.Name, dn, in,
+		)
+	}
+
+	if sameFile(sn, sc.Save) && !force {
+		if sum, err := hashFile(sn); err == nil && sum != sc.SHA256 {
+			log.Panicf(
+				"%s changed since it was unpacked at %s, and packing would discard the changes; use -force to overwrite it",
+				sn, sc.Unpacked.Local().Format("2006-01-02 15:04:05"),
+			)
+		}
+	}
+
+	var edited []string
+
+	for _, d := range []struct {
+		name string
+		b    []byte
+		sum  string
+	}{{in, ib, sc.Info.SHA256}, {dn, db, sc.Data.SHA256}} {
+		if hashBytes(d.b) != d.sum {
+			edited = append(edited, d.name)
+		}
+	}
+
+	switch len(edited) {
+	case 0:
+		log.Printf("Neither document changed since %s was unpacked", filepath.Base(sc.Save))
+	case 1:
+		log.Printf("Packing %s, changed since %s was unpacked", edited[0], filepath.Base(sc.Save))
+	}
+}
@@ -0,0 +1,230 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// sigExt is appended to the name of a save to name its signature.
+const sigExt = ".sig"
+
+// sigAlg names the algorithm in signatures.
+const sigAlg = "hmac-sha256"
+
+// minKeyLen is the shortest key accepted, in bytes.
+const minKeyLen = 16
+
+// newKey makes sign generate the key file.
+var newKey bool
+
+func init() {
+	register(&command{
+		name:  "sign",
+		args:  "<game.sav>...",
+		short: "sign save files with a shared key",
+		long: `
+Sign writes a signature of each save file to a file named after the save with
+the extension .sig, such as game.sav.sig. Verify-signature checks that a save
+has not changed since it was signed, so that a league can tell whether a
+submitted save was edited between checkpoints.
+
+Signatures are HMAC-SHA256 over the whole save file, keyed with the contents of
+the key file given by -keyfile or sign_key in the configuration file. Anyone
+with the key can both sign and verify, so keep it with the league organizers.
+With -newkey, sign first writes a new random key to the key file, which must
+not exist.`,
+		example: `
+mmse sign -newkey -keyfile league.key
+mmse sign -keyfile league.key round1.sav
+mmse verify-signature -keyfile league.key round1.sav`,
+		flags: func(fs *flag.FlagSet) {
+			flagKeyFile(fs)
+			fs.BoolVar(&newKey, "newkey", false, "write a new random key to the key file")
+			flagSaveDir(fs)
+		},
+		nargs: func(n int) bool { return n > 0 || newKey },
+		run:   runSign,
+	})
+
+	register(&command{
+		name:  "verify-signature",
+		args:  "<game.sav>...",
+		short: "check save files against their signatures",
+		long: `
+Verify-signature checks each save file against the signature written by sign,
+with the same key, and prints whether it matches. It exits with status 1 when
+any save is unsigned or changed since it was signed.`,
+		example: `
+mmse verify-signature -keyfile league.key round1.sav`,
+		flags: func(fs *flag.FlagSet) {
+			flagKeyFile(fs)
+			flagSaveDir(fs)
+		},
+		nargs: atLeast(1),
+		run:   runVerifySignature,
+	})
+}
+
+// flagKeyFile registers the flag selecting the signing key.
+func flagKeyFile(fs *flag.FlagSet) {
+	fs.StringVar(&cfg.SignKey, "keyfile", cfg.SignKey, "read the signing key from `file`")
+}
+
+// signingKey reads the signing key.
+func signingKey() []byte {
+	if cfg.SignKey == "" {
+		log.Panicf("No signing key; use -keyfile or sign_key in the configuration file")
+	}
+
+	b, err := os.ReadFile(cfg.SignKey)
+	if err != nil {
+		log.Panicf("Unable to read signing key: %s", err)
+	}
+
+	b = bytes.TrimSpace(b)
+
+	if len(b) < minKeyLen {
+		log.Panicf("Signing key in %s is shorter than %d bytes", cfg.SignKey, minKeyLen)
+	}
+
+	return b
+}
+
+// writeKey writes a new random key to key file fn.
+func writeKey(fn string) {
+	if fn == "" {
+		log.Panicf("No key file; use -keyfile")
+	}
+
+	k := make([]byte, 32)
+
+	if _, err := io.ReadFull(rand.Reader, k); err != nil {
+		log.Panicf("Unable to generate a key: %s", err)
+	}
+
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		log.Panicf("Unable to create key file: %s", err)
+	}
+
+	if _, err := fmt.Fprintln(f, hex.EncodeToString(k)); err != nil {
+		f.Close()
+		log.Panicf("Unable to write key file: %s", err)
+	}
+
+	if err := f.Close(); err != nil {
+		log.Panicf("Unable to write key file: %s", err)
+	}
+
+	fmt.Printf("Wrote a new key to %s\n", fn)
+}
+
+// signature returns the signature of a file.
+func signature(key []byte, fn string) []byte {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Panicf("Unable to open %s: %s", fn, err)
+	}
+
+	defer f.Close()
+
+	m := hmac.New(sha256.New, key)
+
+	if _, err := io.Copy(m, f); err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	return m.Sum(nil)
+}
+
+// runSign runs the sign command.
+func runSign(args []string) {
+	if newKey {
+		writeKey(cfg.SignKey)
+	}
+
+	if len(args) == 0 {
+		return
+	}
+
+	key := signingKey()
+
+	for _, fn := range args {
+		fn = findSave(fn)
+
+		s := fmt.Sprintf("%s %x\n", sigAlg, signature(key, fn))
+
+		if err := os.WriteFile(fn+sigExt, []byte(s), 0644); err != nil {
+			log.Panicf("Unable to write signature: %s", err)
+		}
+
+		fmt.Printf("Signed %s\n", fn)
+	}
+}
+
+// runVerifySignature runs the verify-signature command.
+func runVerifySignature(args []string) {
+	key := signingKey()
+	failed := false
+
+	for _, fn := range args {
+		fn = findSave(fn)
+
+		b, err := os.ReadFile(fn + sigExt)
+		if os.IsNotExist(err) {
+			fmt.Printf("%s: not signed\n", fn)
+			failed = true
+
+			continue
+		} else if err != nil {
+			log.Panicf("Unable to read signature: %s", err)
+		}
+
+		f := strings.Fields(string(b))
+
+		var want []byte
+
+		if len(f) == 2 && f[0] == sigAlg {
+			want, err = hex.DecodeString(f[1])
+		}
+
+		switch {
+		case len(want) == 0 || err != nil:
+			log.Panicf("Invalid signature in %s%s", fn, sigExt)
+		case hmac.Equal(want, signature(key, fn)):
+			fmt.Printf("%s: ok\n", fn)
+		default:
+			fmt.Printf("%s: changed since it was signed\n", fn)
+			failed = true
+		}
+	}
+
+	if failed {
+		fail()
+	}
+}
@@ -0,0 +1,305 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+// metrics are the metrics given with -metric.
+var metrics = metricFlag{}
+
+func init() {
+	register(&command{
+		name:  "stats",
+		args:  "[savedir]",
+		short: "tabulate metrics across the saves of a directory",
+		long: `
+Stats reads every save in a directory, the save directory by default, and
+prints a time series of metrics, one row per save in the order the saves were
+written. Everything is read from the saves; nothing leaves the machine.
+
+A metric is a name and a path into the save, given with -metric name=path or
+in the metrics map of the configuration file, such as
+
+	metrics:
+	  balance: data.playerTeam.financeBalance
+	  position: data.playerTeam.championshipPosition
+
+The field names above are placeholders; the real ones depend on the game
+version and can be found with search and get.
+Each row lists the file, its modification time, the number of days since the
+first save, and the value of every metric, empty when the save lacks it.
+The rows are written as CSV by default; see "mmse help output" for the other
+formats.
+` + pathHelp,
+		example: `
+mmse stats -metric balance=data.playerTeam.financeBalance
+mmse stats -output json ~/saves/career1`,
+		flags: func(fs *flag.FlagSet) {
+			fs.Var(metrics, "metric", "add a metric as `name=path`; may be repeated")
+			flagOutput(fs, outputCSV)
+			flagJobs(fs)
+			flagSaveDir(fs)
+		},
+		nargs: atMost(1),
+		run:   runStats,
+	})
+}
+
+// metricFlag collects metrics given as name=path, separated by commas.
+type metricFlag map[string]string
+
+// String implements flag.Value.
+func (m metricFlag) String() string {
+	var s []string
+
+	for _, n := range sortedKeys(m) {
+		s = append(s, n+"="+m[n])
+	}
+
+	return strings.Join(s, ",")
+}
+
+// Set implements flag.Value.
+func (m metricFlag) Set(v string) error {
+	for _, f := range strings.Split(v, ",") {
+		i := strings.IndexByte(f, '=')
+		if i <= 0 {
+			return fmt.Errorf("metric %q is not name=path", f)
+		}
+
+		m[f[:i]] = f[i+1:]
+	}
+
+	return nil
+}
+
+// sortedKeys returns the keys of a map in order.
+func sortedKeys(m map[string]string) []string {
+	ks := make([]string, 0, len(m))
+
+	for k := range m {
+		ks = append(ks, k)
+	}
+
+	sort.Strings(ks)
+
+	return ks
+}
+
+// sample holds the metrics of one save.
+type sample struct {
+	File     string                     `json:"file"`
+	Modified time.Time                  `json:"modified"`
+	Day      float64                    `json:"day"`
+	Metrics  map[string]json.RawMessage `json:"metrics"`
+}
+
+// saveFiles returns the saves in a directory in the order they were written.
+func saveFiles(dir string) []string {
+	fs, err := filepath.Glob(filepath.Join(dir, "*.sav"))
+	if err != nil {
+		log.Panicf("%s", err)
+	}
+
+	mt := make(map[string]time.Time)
+
+	for _, f := range fs {
+		if st, err := os.Stat(f); err == nil {
+			mt[f] = st.ModTime()
+		}
+	}
+
+	sort.SliceStable(fs, func(i, j int) bool { return mt[fs[i]].Before(mt[fs[j]]) })
+
+	return fs
+}
+
+// collect reads the metrics of the saves in a directory.
+func collect(dir string, ms map[string]string) []sample {
+	paths := make(map[string]jsonpath.Path)
+	docs := make(map[string]int)
+
+	for n, p := range ms {
+		docs[n], paths[n] = docPath(p)
+	}
+
+	fs := saveFiles(dir)
+	read := make([]*sample, len(fs))
+
+	parallel(len(fs), func(i int) {
+		read[i] = readSample(fs[i], docs, paths)
+	})
+
+	var (
+		ss    []sample
+		first time.Time
+	)
+
+	for _, smp := range read {
+		if smp == nil {
+			continue
+		}
+
+		if first.IsZero() {
+			first = smp.Modified
+		}
+
+		smp.Day = smp.Modified.Sub(first).Hours() / 24
+		ss = append(ss, *smp)
+	}
+
+	return ss
+}
+
+// readSample reads the metrics of a save, or returns nil when the save cannot
+// be read.
+func readSample(fn string, docs map[string]int, paths map[string]jsonpath.Path) *sample {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Printf("Warning: %s", err)
+		return nil
+	}
+
+	st, _ := f.Stat()
+	s, err := mmse.ReadSaveFile(bufio.NewReader(f))
+
+	f.Close()
+
+	if err != nil {
+		log.Printf("Warning: skipping %s: %s", fn, err)
+		return nil
+	}
+
+	return sampleOf(fn, st.ModTime(), s, docs, paths)
+}
+
+// sampleOf returns the metrics of a save read from a file.
+func sampleOf(fn string, mod time.Time, s *mmse.SaveFile, docs map[string]int, paths map[string]jsonpath.Path) *sample {
+	smp := &sample{
+		File:     filepath.Base(fn),
+		Modified: mod,
+		Metrics:  make(map[string]json.RawMessage),
+	}
+
+	for n, p := range paths {
+		fr := []*mmse.Frame{s.Info, s.Data}[docs[n]]
+
+		t, ok, err := jsonpath.Lookup(fr.Reader(), p)
+		if err != nil || !ok {
+			continue
+		}
+
+		switch v := t.(type) {
+		case json.Number:
+			smp.Metrics[n] = json.RawMessage(v)
+		case json.Delim:
+			log.Printf("Warning: metric %s of %s is not a single value", n, fn)
+		default:
+			b, _ := json.Marshal(v)
+			smp.Metrics[n] = b
+		}
+	}
+
+	return smp
+}
+
+// statsMetrics returns the metrics from the configuration file and flags.
+func statsMetrics() map[string]string {
+	ms := make(map[string]string)
+
+	for n, p := range cfg.Metrics {
+		ms[n] = p
+	}
+
+	for n, p := range metrics {
+		ms[n] = p
+	}
+
+	if len(ms) == 0 {
+		log.Panicf("No metrics; use -metric or the metrics map of the configuration file")
+	}
+
+	return ms
+}
+
+// runStats runs the stats command.
+func runStats(args []string) {
+	dir := cfg.SaveDir
+
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	if dir == "" {
+		log.Panicf("No save directory given")
+	}
+
+	ms := statsMetrics()
+	ss := collect(dir, ms)
+
+	ns := sortedKeys(ms)
+
+	l := &listing{
+		cols:  append([]string{"file", "modified", "day"}, ns...),
+		right: map[string]bool{"day": true},
+	}
+
+	for _, n := range ns {
+		l.right[n] = true
+	}
+
+	for _, s := range ss {
+		row := []string{
+			s.File,
+			s.Modified.Format(time.RFC3339),
+			fmt.Sprintf("%.2f", s.Day),
+		}
+
+		for _, n := range ns {
+			row = append(row, metricText(s.Metrics[n]))
+		}
+
+		l.addRecord(s, row...)
+	}
+
+	l.write()
+}
+
+// metricText returns a metric value as text, with strings unquoted.
+func metricText(v json.RawMessage) string {
+	var s string
+
+	if len(v) > 0 && v[0] == '"' && json.Unmarshal(v, &s) == nil {
+		return s
+	}
+
+	return string(v)
+}
@@ -0,0 +1,314 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tagsFile is the index of labels, kept next to the saves it labels.
+const tagsFile = ".mmse-tags.json"
+
+var (
+	// untag removes labels instead of adding them.
+	untag bool
+	// tagFilter selects the saves listed by tags.
+	tagFilter string
+)
+
+func init() {
+	register(&command{
+		name:  "tag",
+		args:  "<game.sav> <label>...",
+		short: "label a save and keep a backup of it",
+		long: `
+Tag labels a save, such as "before-engine-cheat", to mark a point to come back
+to before an experimental edit. The labels are kept in .mmse-tags.json next to
+the save. Each label records the checksum of the save and a backup of it in
+the backup store, so the labelled state can be restored with
+"mmse backup restore game.sav before-engine-cheat" after the save has been
+changed. Backups recorded by labels are never pruned.
+
+Labelling a save again with the same label moves the label to the current
+save. With -delete, the labels are removed; their backups stay in the store
+and are pruned as usual.`,
+		example: `
+mmse tag game.sav before-engine-cheat
+mmse tag -delete game.sav before-engine-cheat`,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&untag, "delete", false, "remove the labels")
+			flagSaveDir(fs)
+		},
+		nargs: atLeast(2),
+		run:   runTag,
+	})
+
+	register(&command{
+		name:  "tags",
+		args:  "[savedir]",
+		short: "list the labelled saves of a directory",
+		long: `
+Tags lists the labels of the saves in a directory, the save directory by
+default, oldest first. The state tells whether the save is unchanged since it
+was labelled, changed, or missing. With -label, only the saves with a label are
+listed.`,
+		example: `
+mmse tags
+mmse tags -label before-engine-cheat ~/saves/career1`,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&tagFilter, "label", "", "list the saves labelled `label`")
+			flagSaveDir(fs)
+			flagOutput(fs, outputTable)
+		},
+		nargs: atMost(1),
+		run:   runTags,
+	})
+}
+
+// tagEntry is a label of a save.
+type tagEntry struct {
+	Label string    `json:"label"`
+	Time  time.Time `json:"time"`
+	// Hash is the SHA-256 checksum of the save when it was labelled.
+	Hash string `json:"hash"`
+	// Backup is the path of the backup of the labelled save.
+	Backup string `json:"backup"`
+}
+
+// tagIndex maps the file names of the saves of a directory to their labels.
+type tagIndex map[string][]tagEntry
+
+// readTags reads the index of labels of a directory. A missing index is
+// empty.
+func readTags(dir string) tagIndex {
+	fn := filepath.Join(dir, tagsFile)
+	idx := make(tagIndex)
+
+	b, err := os.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return idx
+	} else if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	if err := json.Unmarshal(b, &idx); err != nil {
+		log.Panicf("Unable to parse %s: %s", fn, err)
+	}
+
+	return idx
+}
+
+// writeTags writes the index of labels of a directory.
+func writeTags(dir string, idx tagIndex) {
+	fn := filepath.Join(dir, tagsFile)
+
+	b, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		log.Panicf("Unable to encode %s: %s", fn, err)
+	}
+
+	tmp := fn + ".tmp"
+
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		log.Panicf("Unable to write %s: %s", fn, err)
+	}
+
+	if err := os.Rename(tmp, fn); err != nil {
+		log.Panicf("Unable to write %s: %s", fn, err)
+	}
+}
+
+// findTag returns the entry of a label of a save.
+func findTag(fn, label string) (tagEntry, bool) {
+	for _, e := range readTags(filepath.Dir(fn))[filepath.Base(fn)] {
+		if e.Label == label {
+			return e, true
+		}
+	}
+
+	return tagEntry{}, false
+}
+
+// taggedBackups returns the paths of the backups recorded by the labels of a
+// save.
+func taggedBackups(fn string) map[string]bool {
+	ps := make(map[string]bool)
+
+	for _, e := range readTags(filepath.Dir(fn))[filepath.Base(fn)] {
+		ps[e.Backup] = true
+	}
+
+	return ps
+}
+
+// newTag labels the current state of a save, storing a backup of it.
+func newTag(fn, label string) tagEntry {
+	h, err := hashFile(fn)
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	return tagEntry{label, time.Now(), h, storeBackup(fn)}
+}
+
+// labelSave labels a save as tag does, moving the label when the save already
+// has it, and returns the label.
+func labelSave(fn, label string) tagEntry {
+	dir, base := filepath.Dir(fn), filepath.Base(fn)
+	idx := readTags(dir)
+	es := idx[base][:0]
+
+	for _, e := range idx[base] {
+		if e.Label != label {
+			es = append(es, e)
+		}
+	}
+
+	t := newTag(fn, label)
+	idx[base] = append(es, t)
+
+	writeTags(dir, idx)
+
+	return t
+}
+
+// runTag runs the tag command.
+func runTag(args []string) {
+	fn := findSave(args[0])
+	dir, base := filepath.Dir(fn), filepath.Base(fn)
+
+	if !untag && !fileExists(fn) {
+		log.Panicf("%s does not exist", fn)
+	}
+
+	idx := readTags(dir)
+
+	// The labels given together share a backup. Storing one for each would
+	// prune the backups of the labels not yet in the index.
+	var tag *tagEntry
+
+	for _, l := range args[1:] {
+		if strings.TrimSpace(l) == "" {
+			log.Panicf("Empty label")
+		}
+
+		found := false
+		es := idx[base][:0]
+
+		for _, e := range idx[base] {
+			if e.Label == l {
+				found = true
+				continue
+			}
+
+			es = append(es, e)
+		}
+
+		switch {
+		case untag && !found:
+			log.Panicf("%s has no label %s", fn, l)
+		case untag:
+			fmt.Printf("Removed label %s from %s\n", l, fn)
+		default:
+			if tag == nil {
+				t := newTag(fn, l)
+				tag = &t
+			}
+
+			t := *tag
+			t.Label = l
+			es = append(es, t)
+
+			fmt.Printf("Labelled %s %s\n", fn, l)
+		}
+
+		idx[base] = es
+
+		if len(es) == 0 {
+			delete(idx, base)
+		}
+	}
+
+	writeTags(dir, idx)
+}
+
+// runTags runs the tags command.
+func runTags(args []string) {
+	dir := cfg.SaveDir
+
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	if dir == "" {
+		dir = "."
+	}
+
+	type record struct {
+		Save string `json:"save"`
+		tagEntry
+		State string `json:"state"`
+	}
+
+	var rs []record
+
+	for save, es := range readTags(dir) {
+		h, err := hashFile(filepath.Join(dir, save))
+
+		for _, e := range es {
+			if tagFilter != "" && e.Label != tagFilter {
+				continue
+			}
+
+			st := "unchanged"
+
+			switch {
+			case os.IsNotExist(err):
+				st = "missing"
+			case err != nil:
+				log.Panicf("Unable to read %s: %s", save, err)
+			case h != e.Hash:
+				st = "changed"
+			}
+
+			rs = append(rs, record{save, e, st})
+		}
+	}
+
+	if len(rs) == 0 && outputFormat == outputTable {
+		fmt.Printf("No labelled saves in %s\n", dir)
+		return
+	}
+
+	sort.SliceStable(rs, func(i, j int) bool { return rs[i].Time.Before(rs[j].Time) })
+
+	l := &listing{cols: []string{"save", "label", "time", "state", "backup"}}
+
+	for _, r := range rs {
+		l.addRecord(r, r.Save, r.Label, r.Time.Format("2006-01-02 15:04:05"), r.State, r.Backup)
+	}
+
+	l.write()
+}
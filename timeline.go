@@ -0,0 +1,259 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+func init() {
+	register(&command{
+		name:  "timeline",
+		args:  "[savedir]",
+		short: "order the saves of a directory into a career timeline",
+		long: `
+Timeline reads the in-game date of every save in a directory, the save
+directory by default, and orders the saves into a tree showing which save
+each one continues. Saves are taken in the order they were written; the
+parent of a save is the save written before it with the latest in-game date
+not after its own, which is the save the game most likely loaded. A save
+whose parent already has a continuation starts a branch: an earlier save was
+reloaded.
+
+The in-game date is read from the info document at date_path in the
+configuration file, or at -datepath. The path depends on the game version and
+can be found with search and get on the info document. Dates are compared as
+numbers, as ISO dates, or otherwise as text. Saves without a date are skipped
+with a warning.
+
+The table lists the saves as they were written, indented by branch, with the
+parent of each branch. The json, yaml, and ndjson formats give the parent and
+depth of every save; see "mmse help output".`,
+		example: `
+mmse timeline -datepath gameDate
+mmse timeline -output json ~/saves/career1`,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&cfg.DatePath, "datepath", cfg.DatePath, "`path` of the in-game date in the info document")
+			flagOutput(fs, outputTable)
+			flagJobs(fs)
+			flagSaveDir(fs)
+		},
+		nargs: atMost(1),
+		run:   runTimeline,
+	})
+}
+
+// gameDate is an in-game date, ordered as a number when it is a number or an
+// ISO date and as text otherwise.
+type gameDate struct {
+	raw   json.RawMessage
+	text  string
+	num   float64
+	isNum bool
+}
+
+// isoLayouts are the layouts of dates compared as times.
+var isoLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// newGameDate returns the date of a JSON token.
+func newGameDate(t json.Token) (gameDate, bool) {
+	switch v := t.(type) {
+	case json.Number:
+		n, err := v.Float64()
+		return gameDate{raw: json.RawMessage(v), text: v.String(), num: n, isNum: true}, err == nil
+	case string:
+		raw, _ := json.Marshal(v)
+		d := gameDate{raw: raw, text: v}
+
+		for _, l := range isoLayouts {
+			if tm, err := time.Parse(l, v); err == nil {
+				d.num, d.isNum = float64(tm.Unix()), true
+				break
+			}
+		}
+
+		return d, true
+	}
+
+	return gameDate{}, false
+}
+
+// after reports whether d is later than e.
+func (d gameDate) after(e gameDate) bool {
+	if d.isNum && e.isNum {
+		return d.num > e.num
+	}
+
+	return d.text > e.text
+}
+
+// event is a save in a timeline.
+type event struct {
+	Save     string          `json:"save"`
+	Date     json.RawMessage `json:"date"`
+	Modified time.Time       `json:"modified"`
+	Parent   string          `json:"parent,omitempty"`
+	Depth    int             `json:"depth"`
+	Branch   bool            `json:"branch"`
+
+	date     gameDate
+	parent   int
+	children int
+}
+
+// readEvent reads the in-game date of a save, decoding only its info frame.
+func readEvent(fn string, p jsonpath.Path) *event {
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Panicf("Unable to open %s: %s", fn, err)
+	}
+
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	s := &mmse.SaveFile{Raw: true}
+
+	if _, err := s.ReadFrom(f); err != nil {
+		log.Panicf("Unable to read %s: %s", fn, err)
+	}
+
+	if err := s.Info.Decode(); err != nil {
+		log.Panicf("Unable to decode the info frame of %s: %s", fn, err)
+	}
+
+	t, ok, err := jsonpath.Lookup(s.Info.Reader(), p)
+	if err != nil {
+		log.Panicf("Unable to read the info document of %s: %s", fn, err)
+	}
+
+	d, isDate := newGameDate(t)
+	if !ok || !isDate {
+		log.Panicf("%s has no in-game date at %s", fn, p)
+	}
+
+	return &event{
+		Save: filepath.Base(fn), Date: d.raw, Modified: st.ModTime(), date: d, parent: -1,
+	}
+}
+
+// timeline links the events, given in the order they were written, to their
+// parents.
+func timeline(es []*event) {
+	for i, e := range es {
+		for j := i - 1; j >= 0; j-- {
+			c := es[j]
+
+			if c.date.after(e.date) {
+				continue
+			}
+
+			if e.parent < 0 || c.date.after(es[e.parent].date) {
+				e.parent = j
+			}
+		}
+
+		if e.parent < 0 {
+			continue
+		}
+
+		p := es[e.parent]
+
+		e.Parent, e.Depth = p.Save, p.Depth
+		e.Branch = p.children > 0
+
+		if e.Branch {
+			e.Depth++
+		}
+
+		p.children++
+	}
+}
+
+// runTimeline runs the timeline command.
+func runTimeline(args []string) {
+	dir := cfg.SaveDir
+
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	if dir == "" {
+		log.Panicf("No save directory given")
+	}
+
+	if cfg.DatePath == "" {
+		log.Panicf("No date path given; set date_path in the configuration file or use -datepath")
+	}
+
+	p, err := jsonpath.Parse(cfg.DatePath)
+	if err != nil {
+		log.Panicf("Invalid date path: %s", err)
+	}
+
+	fs := saveFiles(dir)
+	read := make([]*event, len(fs))
+
+	parallel(len(fs), func(i int) {
+		read[i] = readEvent(fs[i], p)
+	})
+
+	var es []*event
+
+	for _, e := range read {
+		if e != nil {
+			es = append(es, e)
+		}
+	}
+
+	if len(es) == 0 && outputFormat == outputTable {
+		fmt.Printf("No saves with an in-game date found in %s\n", dir)
+		return
+	}
+
+	timeline(es)
+
+	l := &listing{cols: []string{"save", "date", "modified", "branch of"}}
+
+	for _, e := range es {
+		from := ""
+
+		if e.Branch {
+			from = e.Parent
+		}
+
+		l.addRecord(
+			e, strings.Repeat("  ", e.Depth)+e.Save, e.date.text,
+			e.Modified.Format("2006-01-02 15:04"), from,
+		)
+	}
+
+	l.write()
+}
@@ -0,0 +1,392 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+var (
+	// gameVer overrides the game version declared in the info document.
+	gameVer string
+	// verbose lists the warnings of validate.
+	verbose bool
+)
+
+func init() {
+	register(&command{
+		name:  "validate",
+		args:  "<game.sav>",
+		short: "check a save file for problems",
+		long: `
+Validate checks the header, the size table, and both frames of a save file,
+and that each frame holds a valid JSON document.
+
+When the game version of the save is known and a field catalog exists for that
+version, validate also reports fields unknown to the version, such as DLC data
+in a save of an install without the DLC. Pack prints the same warnings. See
+"mmse help catalog".
+
+Validate also notes findings that do not stop the save from being read but
+suggest it was not written by the game as expected, such as an unknown version
+number, padding after a document, keys repeated within an object, or bytes
+after the data frame. With -v, each
+finding is listed as a warning.
+
+Validate exits with status 1 when it finds errors.`,
+		example: `
+mmse validate game.sav
+mmse validate -v -gameversion 1.52 game.sav`,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&verbose, "v", false, "list the warnings about the save layout")
+			flagGameVersion(fs)
+			flagSaveDir(fs)
+		},
+		nargs: exactly(1),
+		run:   runValidate,
+	})
+
+	register(&command{
+		name:  "catalog",
+		args:  "list | capture <version> <game.sav>...",
+		short: "manage the per-version field catalogs",
+		long: `
+A field catalog lists every field found in the saves of one game version, with
+array indices written as [], such as data.drivers[].name. Validate and pack
+warn about fields missing from the catalog of the version of a save.
+
+Capture adds the fields of saves to the catalog of a version, creating it if
+needed. Capture from saves made by a clean install of that version, with and
+without each DLC as appropriate. List prints the versions with a catalog.
+
+Catalogs are kept in the catalogs directory next to the configuration file.
+The game version of a save is read from the info document at version_path in
+the configuration file, or given with -gameversion.`,
+		example: `
+mmse catalog capture 1.52 clean_career.sav
+mmse catalog list`,
+		flags: func(fs *flag.FlagSet) {
+			flagSaveDir(fs)
+			flagOutput(fs, outputTable)
+		},
+		nargs: atLeast(1),
+		run:   runCatalog,
+	})
+}
+
+// flagGameVersion registers the flag overriding the game version of saves.
+func flagGameVersion(fs *flag.FlagSet) {
+	fs.StringVar(
+		&gameVer, "gameversion", gameVer,
+		"game version of the save, overriding version_path",
+	)
+}
+
+// catalogDir returns the directory holding the field catalogs.
+func catalogDir() string {
+	return filepath.Join(filepath.Dir(cfgPath), "catalogs")
+}
+
+// catalogPath returns the path of the catalog of a game version.
+func catalogPath(v string) string {
+	return filepath.Join(catalogDir(), v+".txt")
+}
+
+// readCatalog returns the fields of a catalog, or nil when there is no catalog for the version.
+func readCatalog(v string) map[string]bool {
+	f, err := os.Open(catalogPath(v))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		log.Panicf("Unable to open catalog: %s", err)
+	}
+
+	defer f.Close()
+
+	c := make(map[string]bool)
+
+	s := bufio.NewScanner(f)
+
+	for s.Scan() {
+		if l := strings.TrimSpace(s.Text()); l != "" && !strings.HasPrefix(l, "#") {
+			c[l] = true
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		log.Panicf("Unable to read catalog: %s", err)
+	}
+
+	return c
+}
+
+// writeCatalog writes the fields of a catalog.
+func writeCatalog(v string, c map[string]bool) {
+	fs := make([]string, 0, len(c))
+
+	for f := range c {
+		fs = append(fs, f)
+	}
+
+	sort.Strings(fs)
+
+	b := new(bytes.Buffer)
+
+	fmt.Fprintf(b, "# Fields of Motorsport Manager %s saves.\n", v)
+
+	for _, f := range fs {
+		fmt.Fprintln(b, f)
+	}
+
+	if err := os.MkdirAll(catalogDir(), 0755); err != nil {
+		log.Panicf("Unable to create catalog directory: %s", err)
+	}
+
+	if err := os.WriteFile(catalogPath(v), b.Bytes(), 0644); err != nil {
+		log.Panicf("Unable to write catalog: %s", err)
+	}
+}
+
+// docFields returns the fields of the info and data documents as paths
+// starting with the document name.
+func docFields(info, data []byte) ([]string, error) {
+	var fs []string
+
+	for _, d := range []struct {
+		name string
+		doc  []byte
+	}{{"info", info}, {"data", data}} {
+		ps, err := jsonpath.Fields(bytes.NewReader(d.doc))
+		if err != nil {
+			return nil, fmt.Errorf("%s document: %s", d.name, err)
+		}
+
+		for _, p := range ps {
+			if p[0] == '[' {
+				fs = append(fs, d.name+p)
+			} else {
+				fs = append(fs, d.name+"."+p)
+			}
+		}
+	}
+
+	return fs, nil
+}
+
+// gameVersion returns the game version of a save from -gameversion or the
+// info document, or an empty string.
+func gameVersion(info []byte) string {
+	if gameVer != "" || cfg.VersionPath == "" {
+		return gameVer
+	}
+
+	p, err := jsonpath.Parse(cfg.VersionPath)
+	if err != nil {
+		log.Panicf("Invalid version_path: %s", err)
+	}
+
+	t, ok, err := jsonpath.Lookup(bytes.NewReader(info), p)
+	if err != nil || !ok {
+		return ""
+	}
+
+	switch v := t.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+
+	return ""
+}
+
+// unknownFields returns the fields of a save missing from the catalog of its
+// game version, and the version. The fields are nil when there is no
+// catalog.
+func unknownFields(info, data []byte) ([]string, string, error) {
+	v := gameVersion(info)
+	if v == "" {
+		return nil, "", nil
+	}
+
+	c := readCatalog(v)
+	if c == nil {
+		return nil, v, nil
+	}
+
+	fs, err := docFields(info, data)
+	if err != nil {
+		return nil, v, err
+	}
+
+	var u []string
+
+	for _, f := range fs {
+		if !c[f] {
+			u = append(u, f)
+		}
+	}
+
+	return u, v, nil
+}
+
+// checkFields warns about fields unknown to the game version of a save.
+func checkFields(info, data []byte) {
+	u, v, err := unknownFields(info, data)
+	if err != nil {
+		log.Panicf("%s", err)
+	}
+
+	for _, f := range u {
+		log.Printf("Warning: field unknown to game version %s: %s", v, f)
+	}
+}
+
+// runValidate runs the validate command.
+func runValidate(args []string) {
+	fn := findSave(args[0])
+
+	f, err := os.Open(fn)
+	if err != nil {
+		log.Panicf("Unable to open %s: %s", fn, err)
+	}
+
+	defer f.Close()
+
+	errs := 0
+
+	report := func(level, format string, a ...interface{}) {
+		if level == "error" {
+			errs++
+		}
+
+		fmt.Printf("%s: %s: %s\n", fn, level, fmt.Sprintf(format, a...))
+	}
+
+	s, err := mmse.ReadSaveFile(bufio.NewReader(f))
+
+	if err != nil {
+		report("error", "%s", err)
+	} else {
+		info, data := s.Info.Bytes(), s.Data.Bytes()
+
+		if !json.Valid(info) {
+			report("error", "info frame is not valid JSON")
+		}
+
+		if !json.Valid(data) {
+			report("error", "data frame is not valid JSON")
+		}
+
+		if errs == 0 {
+			if err := s.CheckKeys(); err != nil {
+				report("error", "%s", err)
+			}
+		}
+
+		if verbose {
+			for _, w := range s.Warnings {
+				report("warning", "%s", w)
+			}
+		} else if n := len(s.Warnings); n > 0 {
+			report("note", "save layout warnings: %d; use -v to list them", n)
+		}
+
+		if errs == 0 {
+			u, v, err := unknownFields(info, data)
+
+			switch {
+			case err != nil:
+				report("error", "%s", err)
+			case v == "":
+				report("note", "game version unknown; set version_path or use -gameversion")
+			case u == nil && readCatalog(v) == nil:
+				report("note", "no field catalog for game version %s", v)
+			}
+
+			for _, f := range u {
+				report("warning", "field unknown to game version %s: %s", v, f)
+			}
+		}
+	}
+
+	if errs > 0 {
+		fail()
+	}
+
+	fmt.Printf("%s: ok\n", fn)
+}
+
+// runCatalog runs the catalog command.
+func runCatalog(args []string) {
+	switch args[0] {
+	case "list":
+		fs, _ := filepath.Glob(filepath.Join(catalogDir(), "*.txt"))
+
+		l := &listing{cols: []string{"version"}}
+
+		for _, f := range fs {
+			l.add(strings.TrimSuffix(filepath.Base(f), ".txt"))
+		}
+
+		l.write()
+	case "capture":
+		if len(args) < 3 {
+			log.Panicf("Usage: mmse catalog capture <version> <game.sav>...")
+		}
+
+		v := args[1]
+
+		c := readCatalog(v)
+		if c == nil {
+			c = make(map[string]bool)
+		}
+
+		n := len(c)
+
+		for _, fn := range args[2:] {
+			s := openSave(fn)
+
+			fs, err := docFields(s.Info.Bytes(), s.Data.Bytes())
+			if err != nil {
+				log.Panicf("Unable to read %s: %s", fn, err)
+			}
+
+			for _, f := range fs {
+				c[f] = true
+			}
+		}
+
+		writeCatalog(v, c)
+
+		fmt.Printf("Added %d fields to the catalog of %s\n", len(c)-n, v)
+	default:
+		log.Panicf("Unknown catalog action: %s", args[0])
+	}
+}
@@ -0,0 +1,140 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonconv"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+var (
+	// formats adds the supported formats to the version report.
+	formats bool
+	// asJSON prints the version report as JSON.
+	asJSON bool
+)
+
+func init() {
+	register(&command{
+		name:  "version",
+		short: "print the version and the supported formats",
+		long: `
+Version prints the version of mmse and the Go release it was built with. With
+-formats, it also prints the save format versions, codecs, and compression
+levels that mmse reads and writes, and the document formats of unpack and
+pack. With -json, the report is a JSON object for other tools to check the
+capabilities of mmse; fields may be added, but are not removed or renamed.`,
+		example: `
+mmse version
+mmse version -formats -json`,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&formats, "formats", false, "print the supported formats")
+			fs.BoolVar(&asJSON, "json", false, "print the report as JSON")
+		},
+		nargs: exactly(0),
+		run:   runVersion,
+	})
+}
+
+// report is the output of the version command.
+type report struct {
+	Version string `json:"version"`
+	Go      string `json:"go"`
+
+	Save      *saveFormat `json:"save_format,omitempty"`
+	Codecs    []codecInfo `json:"codecs,omitempty"`
+	Documents []string    `json:"document_formats,omitempty"`
+}
+
+// saveFormat describes the supported save layout.
+type saveFormat struct {
+	Magic    string  `json:"magic"`
+	Versions []int32 `json:"versions"`
+}
+
+// codecInfo describes a codec of frames.
+type codecInfo struct {
+	Name     string `json:"name"`
+	Default  bool   `json:"default"`
+	MinLevel int    `json:"min_level"`
+	MaxLevel int    `json:"max_level"`
+}
+
+// runVersion runs the version command.
+func runVersion([]string) {
+	r := report{Version: version, Go: runtime.Version()}
+
+	if formats {
+		r.Save = &saveFormat{
+			Magic:    fmt.Sprintf("0x%08x", uint32(mmse.Magic)),
+			Versions: []int32{mmse.Ver},
+		}
+
+		r.Codecs = []codecInfo{{
+			Name:     fmt.Sprint(mmse.DefaultCodec),
+			Default:  true,
+			MaxLevel: mmse.MaxLevel,
+		}}
+
+		for _, f := range jsonconv.Formats {
+			r.Documents = append(r.Documents, string(f))
+		}
+	}
+
+	if asJSON {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+
+		if err := e.Encode(r); err != nil {
+			log.Panicf("Unable to write report: %s", err)
+		}
+
+		return
+	}
+
+	fmt.Printf("mmse %s (%s)\n", r.Version, r.Go)
+
+	if !formats {
+		return
+	}
+
+	fmt.Printf("\nSave format:\n\tmagic %s, versions %v\n", r.Save.Magic, r.Save.Versions)
+	fmt.Printf("\nCodecs:\n")
+
+	for _, c := range r.Codecs {
+		d := ""
+
+		if c.Default {
+			d = ", default"
+		}
+
+		fmt.Printf("\t%s, levels %d to %d%s\n", c.Name, c.MinLevel, c.MaxLevel, d)
+	}
+
+	fmt.Printf("\nDocument formats:\n")
+
+	for _, f := range jsonconv.Formats {
+		fmt.Printf("\t%s (%s)\n", f, f.Ext())
+	}
+}
@@ -0,0 +1,591 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mys721tx/mmse-go/pkg/jsonpath"
+	"github.com/mys721tx/mmse-go/pkg/mmse"
+)
+
+var (
+	// watchInterval is how often watch looks for new saves.
+	watchInterval time.Duration
+	// watchListen is the address of the HTTP server of watch.
+	watchListen string
+	// watchOverlay is the file the overlay document is written to.
+	watchOverlay string
+)
+
+func init() {
+	register(&command{
+		name:  "watch",
+		args:  "[savedir]",
+		short: "report the metrics of new saves as the game writes them",
+		long: `
+Watch looks for new and changed saves in a directory, the save directory by
+default, every -interval. A save is read once its size and modification time
+have not changed for one interval, so that saves being written are not read.
+Saves present when watch starts are not reported; the newest of them is the
+base of the first change.
+
+For every save read, watch writes an event as a line of JSON to standard
+output. The event holds the file, its modification time, the metrics of the
+save, and the metrics that changed since the previous save, with their old and
+new values. The metrics are those of stats: the metrics map of the
+configuration file and -metric.
+
+With -listen, watch also serves the events over HTTP at the address, such as
+localhost:8080. Connecting a WebSocket to /feed receives every event as a text
+message, starting with the last one, so that a stream overlay or a dashboard
+updates while the game is played.
+
+For stream overlays, such as OBS browser sources, /overlay serves a small
+document describing the newest save: its file, modification time, and every
+metric, null when the save lacks it. The keys stay the same from save to save,
+so an overlay can rely on them. With -overlay, the document is also written to
+a file whenever it changes.
+
+For monitoring, /metrics serves counters of watch itself in the text format of
+Prometheus: the saves read, their bytes, the time spent reading them, the
+saves that could not be read by the type of error, and the clients of /feed.
+
+So that other web sites open in a browser cannot read the career, pages from
+other origins cannot read /overlay or connect to /feed. Browser sources
+showing the URL itself are not affected; a local page should read the file
+written with -overlay instead.
+
+	{"save":"autosave3.sav","modified":"2026-10-17T20:15:00Z",
+	 "metrics":{"balance":12500000,"position":3}}
+
+Saves that cannot be read, or whose documents are not valid JSON, such as a
+save repacked by hand, are reported with an error instead of metrics. With
+-webhook or discord_webhook in the configuration file, watch posts a message
+to a Discord webhook for every event, naming the save and the changed metrics
+or the error, so that the admin of a league collecting saves hears of them.
+` + pathHelp,
+		example: `
+mmse watch -metric balance=data.playerTeam.financeBalance
+mmse watch -listen localhost:8080 -interval 2s
+mmse watch -overlay ~/obs/career.json
+mmse watch -webhook https://discord.com/api/webhooks/<id>/<token>`,
+		flags: func(fs *flag.FlagSet) {
+			fs.Var(metrics, "metric", "add a metric as `name=path`; may be repeated")
+			fs.DurationVar(&watchInterval, "interval", 5*time.Second, "how often to look for new saves")
+			fs.StringVar(&watchListen, "listen", "", "serve the events over HTTP at `address`")
+			fs.StringVar(&watchOverlay, "overlay", "", "write the overlay document to `file`")
+			fs.StringVar(&cfg.DiscordWebhook, "webhook", cfg.DiscordWebhook, "post the events to the Discord webhook at `URL`")
+			flagSaveDir(fs)
+		},
+		nargs: atMost(1),
+		run:   runWatch,
+	})
+}
+
+// change is a metric that changed between two saves.
+type change struct {
+	From json.RawMessage `json:"from"`
+	To   json.RawMessage `json:"to"`
+}
+
+// watchEvent is an event of watch.
+type watchEvent struct {
+	File     string                     `json:"file"`
+	Modified time.Time                  `json:"modified"`
+	Metrics  map[string]json.RawMessage `json:"metrics,omitempty"`
+	Changes  map[string]change          `json:"changes,omitempty"`
+	// Error tells why a save could not be read.
+	Error string `json:"error,omitempty"`
+}
+
+// overlay is the overlay document.
+type overlay struct {
+	Save     string                     `json:"save"`
+	Modified time.Time                  `json:"modified"`
+	Metrics  map[string]json.RawMessage `json:"metrics"`
+}
+
+// overlayServer serves the overlay document.
+type overlayServer struct {
+	mu  sync.Mutex
+	doc []byte
+}
+
+// set sets the overlay document to that of a save and writes it to the file
+// of -overlay, if any.
+func (o *overlayServer) set(s *sample, names []string) {
+	ov := overlay{s.File, s.Modified, make(map[string]json.RawMessage)}
+
+	for _, n := range names {
+		if v, ok := s.Metrics[n]; ok {
+			ov.Metrics[n] = v
+		} else {
+			ov.Metrics[n] = json.RawMessage("null")
+		}
+	}
+
+	b, err := json.Marshal(ov)
+	if err != nil {
+		log.Panicf("Unable to encode the overlay: %s", err)
+	}
+
+	o.mu.Lock()
+	o.doc = b
+	o.mu.Unlock()
+
+	if watchOverlay == "" {
+		return
+	}
+
+	tmp := watchOverlay + ".tmp"
+
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		log.Panicf("Unable to write %s: %s", watchOverlay, err)
+	}
+
+	if err := os.Rename(tmp, watchOverlay); err != nil {
+		log.Panicf("Unable to write %s: %s", watchOverlay, err)
+	}
+}
+
+// ServeHTTP serves the overlay document.
+func (o *overlayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	b := o.doc
+	o.mu.Unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	if b == nil {
+		http.Error(w, "no save read yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// errNotJSON reports a frame that does not decode to valid JSON.
+var errNotJSON = errors.New("not valid JSON")
+
+// watchStats counts the work of watch and serves the counts to Prometheus.
+type watchStats struct {
+	mu      sync.Mutex
+	reads   int
+	bytes   int64
+	seconds float64
+	// errors counts the saves that could not be read by the type of error:
+	// io, format, or json.
+	errors map[string]int
+	feed   *feed
+}
+
+// newWatchStats returns counts of zero, with the clients counted from f.
+func newWatchStats(f *feed) *watchStats {
+	return &watchStats{errors: make(map[string]int), feed: f}
+}
+
+// add counts a save read in d, of n bytes, and the error reading it, if any.
+func (ws *watchStats) add(n int64, d time.Duration, err error) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	var pe *fs.PathError
+
+	switch {
+	case err == nil:
+		ws.reads++
+		ws.bytes += n
+		ws.seconds += d.Seconds()
+	case errors.Is(err, errNotJSON):
+		ws.errors["json"]++
+	case errors.As(err, &pe):
+		ws.errors["io"]++
+	default:
+		ws.errors["format"]++
+	}
+}
+
+// ServeHTTP serves the counts in the text format of Prometheus.
+func (ws *watchStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws.feed.mu.Lock()
+	clients := len(ws.feed.clients)
+	ws.feed.mu.Unlock()
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("mmse_watch_saves_read_total", "counter", "Saves read.")
+	fmt.Fprintf(w, "mmse_watch_saves_read_total %d\n", ws.reads)
+
+	metric("mmse_watch_read_bytes_total", "counter", "Bytes of the saves read.")
+	fmt.Fprintf(w, "mmse_watch_read_bytes_total %d\n", ws.bytes)
+
+	metric("mmse_watch_read_seconds_total", "counter", "Time spent reading saves.")
+	fmt.Fprintf(w, "mmse_watch_read_seconds_total %g\n", ws.seconds)
+
+	metric("mmse_watch_read_errors_total", "counter", "Saves that could not be read, by the type of error.")
+
+	for _, t := range []string{"format", "io", "json"} {
+		fmt.Fprintf(w, "mmse_watch_read_errors_total{type=%q} %d\n", t, ws.errors[t])
+	}
+
+	metric("mmse_watch_feed_clients", "gauge", "WebSocket clients of /feed.")
+	fmt.Fprintf(w, "mmse_watch_feed_clients %d\n", clients)
+}
+
+// fileState is the size and modification time of a save.
+type fileState struct {
+	size int64
+	mod  time.Time
+}
+
+// watcher tracks the saves of a directory.
+type watcher struct {
+	dir   string
+	docs  map[string]int
+	paths map[string]jsonpath.Path
+	// seen holds the states of the saves at the last poll, and done the states
+	// of the saves when they were last read.
+	seen map[string]fileState
+	done map[string]fileState
+	// last holds the metrics of the save read last.
+	last map[string]json.RawMessage
+	// names are the names of the metrics, and ov the overlay of the newest
+	// save.
+	names []string
+	ov    *overlayServer
+	// stats counts the saves read.
+	stats *watchStats
+}
+
+// newWatcher returns a watcher of a directory, taking the saves present as
+// read already.
+func newWatcher(dir string, ms map[string]string, ov *overlayServer, stats *watchStats) *watcher {
+	w := &watcher{
+		dir:   dir,
+		docs:  make(map[string]int),
+		paths: make(map[string]jsonpath.Path),
+		done:  make(map[string]fileState),
+		names: sortedKeys(ms),
+		ov:    ov,
+		stats: stats,
+	}
+
+	for n, p := range ms {
+		w.docs[n], w.paths[n] = docPath(p)
+	}
+
+	w.seen = w.states()
+
+	var newest string
+
+	for fn, st := range w.seen {
+		w.done[fn] = st
+
+		if newest == "" || st.mod.After(w.seen[newest].mod) {
+			newest = fn
+		}
+	}
+
+	if newest != "" {
+		if s := readSample(newest, w.docs, w.paths); s != nil {
+			w.last = s.Metrics
+			w.ov.set(s, w.names)
+		}
+	}
+
+	return w
+}
+
+// states returns the states of the saves in the directory.
+func (w *watcher) states() map[string]fileState {
+	sts := make(map[string]fileState)
+
+	for _, fn := range saveFiles(w.dir) {
+		if st, err := os.Stat(fn); err == nil {
+			sts[fn] = fileState{st.Size(), st.ModTime()}
+		}
+	}
+
+	return sts
+}
+
+// read reads the metrics of a save, checking that the save decodes to valid
+// documents.
+func (w *watcher) read(fn string, mod time.Time) (*sample, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	s, err := mmse.ReadSaveFile(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+
+	if !json.Valid(s.Info.Bytes()) {
+		return nil, fmt.Errorf("info frame is %w", errNotJSON)
+	}
+
+	if !json.Valid(s.Data.Bytes()) {
+		return nil, fmt.Errorf("data frame is %w", errNotJSON)
+	}
+
+	return sampleOf(fn, mod, s, w.docs, w.paths), nil
+}
+
+// poll returns the events of the saves that settled since the last poll, in
+// the order they were written.
+func (w *watcher) poll() []watchEvent {
+	sts := w.states()
+
+	var es []watchEvent
+
+	// saveFiles orders the saves by modification time.
+	for _, fn := range saveFiles(w.dir) {
+		st, ok := sts[fn]
+		if !ok || st != w.seen[fn] || st == w.done[fn] {
+			continue
+		}
+
+		w.done[fn] = st
+
+		start := time.Now()
+		s, err := w.read(fn, st.mod)
+
+		w.stats.add(st.size, time.Since(start), err)
+
+		if err != nil {
+			log.Printf("Warning: %s: %s", fn, err)
+			es = append(es, watchEvent{File: filepath.Base(fn), Modified: st.mod, Error: err.Error()})
+
+			continue
+		}
+
+		e := watchEvent{
+			File: filepath.Base(fn), Modified: s.Modified, Metrics: s.Metrics,
+			Changes: make(map[string]change),
+		}
+
+		for n, v := range s.Metrics {
+			if old, ok := w.last[n]; !ok || !bytes.Equal(old, v) {
+				e.Changes[n] = change{old, v}
+			}
+		}
+
+		for n, old := range w.last {
+			if _, ok := s.Metrics[n]; !ok {
+				e.Changes[n] = change{old, nil}
+			}
+		}
+
+		w.last = s.Metrics
+		w.ov.set(s, w.names)
+		es = append(es, e)
+	}
+
+	w.seen = sts
+
+	return es
+}
+
+// maxMessage is the longest message Discord accepts, in characters.
+const maxMessage = 2000
+
+// Timeouts of the HTTP server of watch. The feed connections are not limited
+// once upgraded.
+const (
+	watchReadTimeout = 10 * time.Second
+	watchIdleTimeout = time.Minute
+)
+
+// webhookClient posts to webhooks.
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// message returns the text of an event for a webhook.
+func (e watchEvent) message() string {
+	if e.Error != "" {
+		return fmt.Sprintf("**%s** could not be read: %s", e.File, e.Error)
+	}
+
+	b := new(strings.Builder)
+
+	fmt.Fprintf(b, "New save **%s**", e.File)
+
+	for _, n := range sortedChanges(e.Changes) {
+		c := e.Changes[n]
+		fmt.Fprintf(b, "\n%s: %s -> %s", n, rawText(c.From), rawText(c.To))
+	}
+
+	if r := []rune(b.String()); len(r) > maxMessage {
+		return string(r[:maxMessage-1]) + "…"
+	}
+
+	return b.String()
+}
+
+// sortedChanges returns the names of changed metrics in order.
+func sortedChanges(cs map[string]change) []string {
+	ns := make([]string, 0, len(cs))
+
+	for n := range cs {
+		ns = append(ns, n)
+	}
+
+	sort.Strings(ns)
+
+	return ns
+}
+
+// rawText returns a JSON value as text, with none for a missing value.
+func rawText(v json.RawMessage) string {
+	if v == nil {
+		return "none"
+	}
+
+	return string(v)
+}
+
+// postWebhook posts a message to a Discord webhook. Failures are logged, so
+// that watch goes on.
+func postWebhook(url, msg string) {
+	b, err := json.Marshal(map[string]string{"content": msg})
+	if err != nil {
+		log.Panicf("Unable to encode the message: %s", err)
+	}
+
+	resp, err := webhookClient.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		log.Printf("Warning: unable to post to the webhook: %s", err)
+		return
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Printf("Warning: the webhook refused the message: %s", resp.Status)
+	}
+}
+
+// runWatch runs the watch command.
+func runWatch(args []string) {
+	dir := cfg.SaveDir
+
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	if dir == "" {
+		log.Panicf("No save directory given")
+	}
+
+	if watchInterval <= 0 {
+		log.Panicf("Invalid interval: %s", watchInterval)
+	}
+
+	ms := statsMetrics()
+
+	if len(ms) == 0 {
+		log.Printf("Warning: no metrics given; events will only name the saves")
+	}
+
+	mux := http.NewServeMux()
+	f := newFeed()
+
+	ov := new(overlayServer)
+	stats := newWatchStats(f)
+
+	mux.Handle("/feed", f)
+	mux.Handle("/overlay", ov)
+	mux.Handle("/metrics", stats)
+
+	errc := make(chan error, 1)
+
+	if watchListen != "" {
+		l, err := net.Listen("tcp", watchListen)
+		if err != nil {
+			log.Panicf("Unable to listen on %s: %s", watchListen, err)
+		}
+
+		log.Printf("Serving events on http://%s/feed, the overlay on /overlay, and metrics on /metrics", l.Addr())
+
+		srv := &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: watchReadTimeout,
+			IdleTimeout:       watchIdleTimeout,
+		}
+
+		go func() {
+			errc <- srv.Serve(l)
+		}()
+	}
+
+	w := newWatcher(dir, ms, ov, stats)
+
+	log.Printf("Watching %s", dir)
+
+	tick := time.NewTicker(watchInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case err := <-errc:
+			log.Panicf("Unable to serve on %s: %s", watchListen, err)
+		case <-tick.C:
+		}
+
+		for _, e := range w.poll() {
+			b, err := json.Marshal(e)
+			if err != nil {
+				log.Panicf("Unable to encode the event: %s", err)
+			}
+
+			fmt.Printf("%s\n", b)
+
+			f.send(b)
+
+			if cfg.DiscordWebhook != "" {
+				postWebhook(cfg.DiscordWebhook, e.message())
+			}
+		}
+	}
+}
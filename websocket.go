@@ -0,0 +1,297 @@
+// mmso-go: Motorsport Manager save edit suite
+// Copyright (C) 2018  Yishen Miao
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// wsGUID is the key suffix of the WebSocket handshake, from RFC 6455.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsVersion is the version of the WebSocket protocol of RFC 6455.
+const wsVersion = "13"
+
+// WebSocket opcodes used by the feed.
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+// wsMaxFrame is the largest frame accepted from a client. Clients only
+// listen, so their frames are small; a larger one closes the connection.
+const wsMaxFrame = 1 << 16
+
+// wsTooBig is the close status of a frame over wsMaxFrame, from RFC 6455.
+const wsTooBig = 1009
+
+// wsWriteTimeout is how long a message may take to reach a client before the
+// client is dropped.
+const wsWriteTimeout = 10 * time.Second
+
+// feed broadcasts text messages to WebSocket clients. Clients only listen;
+// their messages, other than close and ping, are ignored. A new client is sent
+// the last message at once.
+type feed struct {
+	mu      sync.Mutex
+	clients map[*wsConn]bool
+	last    []byte
+}
+
+// wsConn is a WebSocket connection.
+type wsConn struct {
+	mu sync.Mutex
+	c  net.Conn
+}
+
+// newFeed returns a feed without clients.
+func newFeed() *feed {
+	return &feed{clients: make(map[*wsConn]bool)}
+}
+
+// sameOrigin reports whether the origin of a request, if any, is the host the
+// request was sent to. Browsers send the origin of the page opening a
+// WebSocket, so pages of other sites cannot read the feed; other clients do
+// not send one.
+func sameOrigin(r *http.Request) bool {
+	o := r.Header.Get("Origin")
+
+	if o == "" {
+		return true
+	}
+
+	u, err := url.Parse(o)
+
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// ServeHTTP upgrades a request to a WebSocket connection and adds it to the
+// feed.
+func (f *feed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "expecting a WebSocket handshake", http.StatusBadRequest)
+		return
+	}
+
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin WebSocket connections are not allowed", http.StatusForbidden)
+		return
+	}
+
+	// Only the version of RFC 6455 is spoken.
+	if r.Header.Get("Sec-WebSocket-Version") != wsVersion {
+		w.Header().Set("Sec-WebSocket-Version", wsVersion)
+		http.Error(w, "unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "unable to upgrade the connection", http.StatusInternalServerError)
+		return
+	}
+
+	c, rw, err := hj.Hijack()
+	if err != nil {
+		log.Printf("Unable to upgrade the connection: %s", err)
+		return
+	}
+
+	h := sha1.Sum([]byte(key + wsGUID))
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h[:]) + "\r\n\r\n")
+
+	if err := rw.Flush(); err != nil {
+		c.Close()
+		return
+	}
+
+	ws := &wsConn{c: c}
+
+	f.mu.Lock()
+	f.clients[ws] = true
+	last := f.last
+	f.mu.Unlock()
+
+	if last != nil && ws.write(wsText, last) != nil {
+		f.drop(ws)
+		return
+	}
+
+	go func() {
+		ws.read(rw.Reader)
+		f.drop(ws)
+	}()
+}
+
+// send sends a message to every client.
+func (f *feed) send(msg []byte) {
+	f.mu.Lock()
+	f.last = msg
+
+	cs := make([]*wsConn, 0, len(f.clients))
+
+	for c := range f.clients {
+		cs = append(cs, c)
+	}
+
+	f.mu.Unlock()
+
+	for _, c := range cs {
+		if err := c.write(wsText, msg); err != nil {
+			f.drop(c)
+		}
+	}
+}
+
+// drop closes and removes a client.
+func (f *feed) drop(c *wsConn) {
+	f.mu.Lock()
+	delete(f.clients, c)
+	f.mu.Unlock()
+
+	c.c.Close()
+}
+
+// write writes an unfragmented frame. Frames from a server are not masked.
+func (c *wsConn) write(opcode byte, payload []byte) error {
+	h := []byte{0x80 | opcode}
+
+	switch n := len(payload); {
+	case n < 126:
+		h = append(h, byte(n))
+	case n <= 0xffff:
+		h = append(h, 126, 0, 0)
+		binary.BigEndian.PutUint16(h[2:], uint16(n))
+	default:
+		h = append(h, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(h[2:], uint64(n))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.c.SetWriteDeadline(time.Now().Add(wsWriteTimeout)); err != nil {
+		return err
+	}
+
+	if _, err := c.c.Write(h); err != nil {
+		return err
+	}
+
+	_, err := c.c.Write(payload)
+
+	return err
+}
+
+// read reads frames from the client until it closes the connection, answering
+// pings and close frames.
+func (c *wsConn) read(r *bufio.Reader) {
+	for {
+		var h [2]byte
+
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			return
+		}
+
+		opcode := h[0] & 0x0f
+		n := uint64(h[1] & 0x7f)
+
+		switch n {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return
+			}
+
+			n = uint64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return
+			}
+
+			n = binary.BigEndian.Uint64(b[:])
+		}
+
+		if n > wsMaxFrame {
+			var b [2]byte
+			binary.BigEndian.PutUint16(b[:], wsTooBig)
+			c.write(wsClose, b[:])
+
+			return
+		}
+
+		var mask [4]byte
+
+		if h[1]&0x80 != 0 {
+			if _, err := io.ReadFull(r, mask[:]); err != nil {
+				return
+			}
+		}
+
+		// Control frames carry at most 125 bytes; other frames are ignored.
+		if opcode < wsClose {
+			if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil {
+				return
+			}
+
+			continue
+		}
+
+		if n > 125 {
+			return
+		}
+
+		p := make([]byte, n)
+
+		if _, err := io.ReadFull(r, p); err != nil {
+			return
+		}
+
+		for i := range p {
+			p[i] ^= mask[i%4]
+		}
+
+		switch opcode {
+		case wsClose:
+			c.write(wsClose, p)
+			return
+		case wsPing:
+			if c.write(wsPong, p) != nil {
+				return
+			}
+		}
+	}
+}